@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker around the loader of the cache, see [WithLoaderBreaker]. It is closed while the loader
+// works, opens after threshold consecutive failures within the window, and fails the loads fast while it is open.
+// After the cool-down it lets a single probe load through: the breaker closes if it succeeds, and opens again
+// otherwise.
+type breaker struct {
+	threshold int
+	window    time.Duration
+	coolDown  time.Duration
+
+	mutex sync.Mutex
+	// failures is the number of consecutive failures since firstFailure, while the breaker is closed.
+	failures     int
+	firstFailure time.Time
+	// openedAt is when the breaker opened, zero while it is closed. probing is set while the probe of the half-open
+	// breaker is in flight.
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(threshold int, window, coolDown time.Duration) *breaker {
+	return &breaker{threshold: max(threshold, 1), window: window, coolDown: coolDown}
+}
+
+// allow reports whether a load can call the loader at the given time. Once the cool-down has passed, it lets the
+// first load through as the probe, and the next ones only once the breaker closed.
+func (b *breaker) allow(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch {
+	case b.openedAt.IsZero():
+		return true
+	case now.Sub(b.openedAt) < b.coolDown, b.probing:
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record records the outcome of an allowed load at the given time. A key missing from the backing store is a success,
+// since the store answered.
+func (b *breaker) record(err error, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err == nil || errors.Is(err, ErrKeyNotFound) {
+		b.failures, b.openedAt, b.probing = 0, time.Time{}, false
+		return
+	}
+
+	if b.probing {
+		b.openedAt, b.probing = now, false // The store is still down, wait for another cool-down.
+		return
+	}
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures, b.firstFailure = 0, now
+	}
+	if b.failures++; b.failures >= b.threshold {
+		b.failures, b.openedAt = 0, now
+	}
+}
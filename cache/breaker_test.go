@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(3, time.Second, time.Minute)
+	now := time.Now()
+	errDown := errors.New("database is down")
+
+	// The failures must be consecutive and within the window to open the breaker.
+	b.record(errDown, now)
+	b.record(errDown, now)
+	b.record(nil, now)
+	b.record(errDown, now)
+	b.record(errDown, now)
+	b.record(errDown, now.Add(2*time.Second)) // Past the window of the first failure, counted as the first one again.
+	assert.True(t, b.allow(now.Add(2*time.Second)), "expected the breaker to stay closed")
+	b.record(ErrKeyNotFound, now) // The store answered.
+	for i := 0; i < 3; i++ {
+		b.record(errDown, now)
+	}
+	assert.False(t, b.allow(now), "expected the breaker to open")
+	assert.False(t, b.allow(now.Add(59*time.Second)), "expected the breaker to stay open for the cool-down")
+
+	// Half-open: a single probe, which opens the breaker again if it fails.
+	later := now.Add(time.Minute)
+	assert.True(t, b.allow(later), "expected a probe after the cool-down")
+	assert.False(t, b.allow(later), "expected a single probe at once")
+	b.record(errDown, later)
+	assert.False(t, b.allow(later.Add(time.Second)), "expected a failed probe to open the breaker again")
+
+	later = later.Add(time.Minute)
+	assert.True(t, b.allow(later))
+	b.record(nil, later)
+	assert.True(t, b.allow(later), "expected a successful probe to close the breaker")
+	assert.True(t, b.allow(later))
+}
+
+// failingLoader is a Loader failing while down is set, counting the Load calls.
+type failingLoader struct {
+	down  atomic.Bool
+	calls atomic.Int32
+}
+
+var errStoreDown = errors.New("store is down")
+
+func (l *failingLoader) Load(bucket, key string) ([]byte, time.Duration, error) {
+	l.calls.Add(1)
+	if l.down.Load() {
+		return nil, 0, errStoreDown
+	}
+	return []byte("loaded"), 0, nil
+}
+
+func TestMinervaCache_LoaderBreaker(t *testing.T) {
+	loader := &failingLoader{}
+	loader.down.Store(true)
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithLoader(loader), WithLoaderBreaker(3, time.Second, 50*time.Millisecond))
+	defer mc.Stop()
+
+	for i := 0; i < 3; i++ {
+		_, err := mc.Get("bkt1", "key1", Options{})
+		assert.ErrorIs(t, err, errStoreDown)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := mc.Get("bkt1", "key1", Options{})
+		assert.ErrorIs(t, err, ErrLoaderUnavailable, "expected the open circuit to fail fast")
+	}
+	assert.Equal(t, int32(3), loader.calls.Load(), "expected the loader not to be called while the circuit is open")
+
+	// The store recovers, and the probe after the cool-down closes the circuit.
+	loader.down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	value, err := mc.Get("bkt1", "key1", Options{})
+	assert.NoError(t, err, "expected the probe to load the key")
+	assert.Equal(t, []byte("loaded"), value)
+	_, err = mc.Get("bkt1", "key2", Options{})
+	assert.NoError(t, err, "expected the circuit to be closed")
+	assert.Equal(t, int32(5), loader.calls.Load())
+}
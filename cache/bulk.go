@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+)
+
+// BulkLoad sets the entries in the cache in their order, e.g. to warm it up from a seed or another cache, with their
+// absolute expiration and creation times. Unlike a Set per entry, nothing is evicted while they are inserted, so
+// loading near or over the capacity doesn't evict at each insert: the cache is trimmed once afterwards with its default
+// policy (the oldest keys without one), which keeps the last entries with the oldest or LRU policies.
+// Expired entries are skipped, and existing keys are overwritten and moved to the back of the order, as if just
+// inserted. The entries are not mirrored to the writer of the cache.
+// All the shards are locked while the entries are inserted. ErrValueTooLarge is returned, before anything is loaded,
+// if a value doesn't fit, and ErrTooManyBuckets if an entry would exceed the maximum number of buckets, in which case
+// the entries before it are loaded.
+func (mc *MinervaCache) BulkLoad(entries []Entry) error {
+	if mc.capacity.Load() <= 0 {
+		return ErrCacheFull
+	}
+	for _, entry := range entries {
+		if (mc.maxValueBytes > 0 && len(entry.Value) > mc.maxValueBytes) || (mc.maxBytes > 0 && int64(len(entry.Value)) > mc.maxBytes) {
+			return fmt.Errorf("loading %s/%s: %w", entry.Bucket, entry.Key, ErrValueTooLarge)
+		}
+	}
+
+	err := mc.bulkInsert(entries)
+	mc.trim(mc.policy(Options{}))
+	return err
+}
+
+// bulkInsert inserts or updates the entries with all the shards locked, without evicting, see BulkLoad.
+func (mc *MinervaCache) bulkInsert(entries []Entry) error {
+	defer mc.lockWAL()()
+	mc.lockShards()
+	defer mc.unlockShards()
+
+	now := time.Now()
+	for _, entry := range entries {
+		expiresAt, createdAt := entry.Meta.ExpiresAt, entry.Meta.CreatedAt
+		if !expiresAt.IsZero() && now.After(expiresAt) {
+			continue
+		}
+		if createdAt.IsZero() {
+			createdAt = now
+		}
+		value := entry.Value
+		if value == nil {
+			value = []byte{} // Get returns an empty slice for an empty value, as nil usually means a miss.
+		}
+
+		s := mc.shardFor(entry.Bucket, entry.Key)
+		if el, ok := s.buckets[entry.Bucket][entry.Key]; ok && el.Value.(*cacheItem).expired(now) {
+			mc.expireInline(s, el)
+		}
+		if el, ok := s.buckets[entry.Bucket][entry.Key]; ok {
+			item := el.Value.(*cacheItem)
+			mc.setValue(item, value)
+			item.expiresAt = expiresAt
+			mc.trackExpiry(s, el)
+			s.moveToBack(el)
+			mc.metrics.AddSetExists(entry.Bucket)
+		} else {
+			if err := mc.checkNewBucket(entry.Bucket); err != nil {
+				return fmt.Errorf("loading %s/%s: %w", entry.Bucket, entry.Key, err)
+			}
+			// The slot and bytes are taken without reserving them, the excess is trimmed once all are inserted.
+			mc.count.Add(1)
+			mc.bytes.Add(int64(len(value)))
+			mc.insert(s, &cacheItem{
+				bucket:    entry.Bucket,
+				key:       entry.Key,
+				value:     value,
+				expiresAt: expiresAt,
+				createdAt: createdAt,
+				version:   1,
+				checksum:  mc.checksum(value),
+				heapIndex: -1,
+			})
+			mc.metrics.AddSet(entry.Bucket)
+		}
+		mc.logWAL(walRecord{Op: walSet, Bucket: entry.Bucket, Key: entry.Key, Value: value, ExpiresAt: expiresAt, CreatedAt: createdAt})
+		mc.publish(Event{Type: EventSet, Bucket: entry.Bucket, Key: entry.Key, Value: value})
+		mc.stats.sets.Add(1)
+	}
+	return nil
+}
+
+// trim evicts based on the policy until each bucket fits its capacity, see [NewMinervaCacheWithBucketLimits], and the
+// cache its capacity and maxBytes. Must be called without any shard mutex locked.
+func (mc *MinervaCache) trim(policy EvictionPolicy) {
+	if mc.bucketCapacity > 0 {
+		for bucket := range mc.BucketSizes() {
+			inBucket := func(item *cacheItem) bool { return item.bucket == bucket }
+			for mc.bucketLen(bucket) > mc.bucketCapacity && mc.evict(policy, inBucket) {
+			}
+		}
+	}
+	for mc.size() > mc.Capacity() && mc.evict(policy, nil) {
+	}
+	mc.evictToMaxBytes(policy)
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bulkEntries returns n entries of bkt1, key0 to key<n-1>.
+func bulkEntries(n int) []Entry {
+	entries := make([]Entry, n)
+	for i := range entries {
+		entries[i] = Entry{Bucket: "bkt1", Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("val%d", i))}
+	}
+	return entries
+}
+
+func TestMinervaCache_BulkLoad(t *testing.T) {
+	for _, policy := range []EvictionPolicy{OldestEvictionPolicy, LRUEvictionPolicy, NoEvictionPolicy} {
+		t.Run(policy.String(), func(t *testing.T) {
+			metrics := &mockMetrics{}
+			mc := NewMinervaCache(10, 0, metrics, WithDefaultPolicy(policy))
+			defer mc.Stop()
+
+			require.NoError(t, mc.BulkLoad(bulkEntries(25)))
+			assert.Equal(t, 10, mc.Len())
+			keys, _, err := mc.ScanKeys("bkt1", "", 0)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"key15", "key16", "key17", "key18", "key19", "key20", "key21", "key22", "key23", "key24"}, keys,
+				"expected the last entries to be kept")
+			assert.Equal(t, 15, int(mc.Stats().Evicts), "expected only the excess to be evicted")
+
+			value, err := mc.Get("bkt1", "key24", Options{})
+			require.NoError(t, err)
+			assert.Equal(t, []byte("val24"), value)
+		})
+	}
+}
+
+func TestMinervaCache_BulkLoad_Existing(t *testing.T) {
+	mc := NewMinervaCache(3, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	require.NoError(t, mc.Set("bkt1", "key0", []byte("old"), Options{}))
+	require.NoError(t, mc.Set("bkt1", "other", []byte("other"), Options{}))
+
+	expiresAt := time.Now().Add(time.Hour)
+	createdAt := time.Now().Add(-time.Hour)
+	entries := []Entry{
+		{Bucket: "bkt1", Key: "key1", Value: []byte("val1"), Meta: ItemMeta{ExpiresAt: expiresAt, CreatedAt: createdAt}},
+		{Bucket: "bkt1", Key: "key0", Value: []byte("new")}, // Overwritten, and now the most recent.
+		{Bucket: "bkt1", Key: "gone", Value: []byte("gone"), Meta: ItemMeta{ExpiresAt: time.Now().Add(-time.Second)}},
+	}
+	require.NoError(t, mc.BulkLoad(entries))
+
+	value, meta, err := mc.GetWithMeta("bkt1", "key0", Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new"), value)
+	assert.Equal(t, uint64(2), meta.Version)
+
+	_, meta, err = mc.GetWithMeta("bkt1", "key1", Options{})
+	require.NoError(t, err)
+	assert.WithinDuration(t, expiresAt, meta.ExpiresAt, time.Millisecond)
+	assert.WithinDuration(t, createdAt, meta.CreatedAt, time.Millisecond)
+
+	_, err = mc.Get("bkt1", "gone", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound, "expected the expired entry to be skipped")
+	assert.Equal(t, 3, mc.Len(), "expected nothing to be evicted")
+}
+
+func TestMinervaCache_BulkLoad_Limits(t *testing.T) {
+	t.Run("bucket capacity", func(t *testing.T) {
+		mc := NewMinervaCacheWithBucketLimits(10, 2, 0, &mockMetrics{})
+		defer mc.Stop()
+
+		entries := append(bulkEntries(4), Entry{Bucket: "bkt2", Key: "key0", Value: []byte("val0")})
+		require.NoError(t, mc.BulkLoad(entries))
+		keys, _, err := mc.ScanKeys("bkt1", "", 0)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"key2", "key3"}, keys)
+		n, err := mc.BucketLen("bkt2")
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("value too large", func(t *testing.T) {
+		mc := NewMinervaCache(10, 0, &mockMetrics{}, WithMaxValueBytes(4))
+		defer mc.Stop()
+
+		err := mc.BulkLoad([]Entry{{Bucket: "bkt1", Key: "key1", Value: []byte("val1")}, {Bucket: "bkt1", Key: "key2", Value: []byte("value2")}})
+		assert.ErrorIs(t, err, ErrValueTooLarge)
+		assert.Equal(t, 0, mc.Len(), "expected nothing to be loaded")
+	})
+
+	t.Run("too many buckets", func(t *testing.T) {
+		mc := NewMinervaCache(10, 0, &mockMetrics{}, WithMaxBuckets(1))
+		defer mc.Stop()
+
+		err := mc.BulkLoad([]Entry{{Bucket: "bkt1", Key: "key1", Value: []byte("val1")}, {Bucket: "bkt2", Key: "key1", Value: []byte("val1")}})
+		assert.ErrorIs(t, err, ErrTooManyBuckets)
+		assert.Equal(t, 1, mc.Len(), "expected the entries before the error to be loaded")
+	})
+}
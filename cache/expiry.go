@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"time"
+)
+
+// expiryHeap is a min-heap of the cache items with a TTL, ordered by removeAt (expiresAt plus the stale grace period),
+// so the background cleanup only visits the items that have actually expired instead of scanning every bucket. Items
+// without a TTL are never tracked.
+// Each tracked item keeps its index in the heap, so it can be fixed or removed in O(log n) when it is updated or deleted.
+// No locking is done here, all methods must be called with the cache mutex locked.
+type expiryHeap []*list.Element // elements of the cache order list.
+
+// Len, Less, Swap, Push and Pop implement heap.Interface. Use track, untrack and next instead.
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool {
+	return h[i].Value.(*cacheItem).removeAt().Before(h[j].Value.(*cacheItem).removeAt())
+}
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].Value.(*cacheItem).heapIndex = i
+	h[j].Value.(*cacheItem).heapIndex = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	el := x.(*list.Element)
+	el.Value.(*cacheItem).heapIndex = len(*h)
+	*h = append(*h, el)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	el := old[n-1]
+	old[n-1] = nil // Don't keep a reference to the removed element.
+	el.Value.(*cacheItem).heapIndex = -1
+	*h = old[:n-1]
+	return el
+}
+
+// track adds, moves or removes the item depending on its current expiresAt. Call it whenever the TTL is set or changed.
+func (h *expiryHeap) track(el *list.Element) {
+	item := el.Value.(*cacheItem)
+	switch {
+	case item.expiresAt.IsZero():
+		h.untrack(el) // The item no longer expires.
+	case item.heapIndex < 0:
+		heap.Push(h, el)
+	default:
+		heap.Fix(h, item.heapIndex)
+	}
+}
+
+// untrack removes the item from the heap, if it is tracked.
+func (h *expiryHeap) untrack(el *list.Element) {
+	if i := el.Value.(*cacheItem).heapIndex; i >= 0 {
+		heap.Remove(h, i)
+	}
+}
+
+// next returns the element of the item expiring first or nil if no item has a TTL.
+func (h expiryHeap) next() *list.Element {
+	if len(h) == 0 {
+		return nil
+	}
+	return h[0]
+}
+
+// trackExpiry tracks the item in the expiries heap of its shard, and schedules its timer if its TTL is within the
+// threshold of the expiry timers, see [WithExpiryTimers]. Call it whenever the TTL is set or changed.
+// Must be called with the shard mutex locked.
+func (mc *MinervaCache) trackExpiry(s *shard, el *list.Element) {
+	s.expiries.track(el)
+
+	item := el.Value.(*cacheItem)
+	item.stopTimer()
+	if mc.expiryTimerThreshold > 0 && !item.expiresAt.IsZero() {
+		if ttl := time.Until(item.removeAt()); ttl <= mc.expiryTimerThreshold {
+			mc.scheduleExpiry(item, ttl)
+		}
+	}
+}
+
+// untrackExpiry removes the item from the expiries heap of its shard and stops its timer, if any.
+// Must be called with the shard mutex locked.
+func (mc *MinervaCache) untrackExpiry(s *shard, el *list.Element) {
+	s.expiries.untrack(el)
+	el.Value.(*cacheItem).stopTimer()
+}
+
+// scheduleExpiry starts the timer removing the item after the given duration.
+// Must be called with the shard mutex locked.
+func (mc *MinervaCache) scheduleExpiry(item *cacheItem, after time.Duration) {
+	item.timerGen++
+	gen := item.timerGen
+	item.timer = time.AfterFunc(after, func() { mc.expireOnTimer(item, gen) })
+}
+
+// expireOnTimer removes the item when its timer fires, unless the timer was stopped or rescheduled since.
+func (mc *MinervaCache) expireOnTimer(item *cacheItem, gen uint64) {
+	s := mc.shardFor(item.bucket, item.key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if item.timerGen != gen {
+		return // Stale, the item was deleted or its TTL changed.
+	}
+	item.timer = nil
+
+	el, ok := s.buckets[item.bucket][item.key]
+	if !ok || el.Value.(*cacheItem) != item {
+		return
+	}
+	if now := time.Now(); !item.removable(now) {
+		mc.scheduleExpiry(item, item.removeAt().Sub(now)) // Fired at the very instant it expires.
+		return
+	}
+	mc.removeExpired(s, el)
+}
+
+// stopTimer stops the timer of the item, if any, and marks a timer already firing as stale.
+// Must be called with the shard mutex locked.
+func (item *cacheItem) stopTimer() {
+	item.timerGen++
+	if item.timer != nil {
+		item.timer.Stop()
+		item.timer = nil
+	}
+}
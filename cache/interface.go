@@ -1,17 +1,34 @@
 package cache
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 )
 
 var (
-	ErrCacheFull      = errors.New("cache is full")
-	ErrKeyNotFound    = errors.New("key not found")
-	ErrKeyExpired     = errors.New("key expired")
-	ErrBucketNotFound = errors.New("bucket not found")
-	ErrInvalidPolicy  = errors.New("invalid eviction policy")
+	ErrCacheFull         = errors.New("cache is full")
+	ErrKeyNotFound       = errors.New("key not found")
+	ErrKeyExpired        = errors.New("key expired")
+	ErrBucketNotFound    = errors.New("bucket not found")
+	ErrInvalidPolicy     = errors.New("invalid eviction policy")
+	ErrKeyExists         = errors.New("key already exists")
+	ErrInvalidSetMode    = errors.New("invalid set mode")
+	ErrNotInteger        = errors.New("value is not an integer")
+	ErrOverflow          = errors.New("increment or decrement would overflow")
+	ErrValueTooLarge     = errors.New("value is too large")
+	ErrVersionMismatch   = errors.New("version mismatch")
+	ErrInvalidCapacity   = errors.New("capacity must be positive")
+	ErrNegativeCached    = errors.New("key is cached as missing")
+	ErrInvalidTTL        = errors.New("ttl must be positive")
+	ErrInvalidName       = errors.New("invalid bucket or key name")
+	ErrTooManyBuckets    = errors.New("too many buckets")
+	ErrChecksumMismatch  = errors.New("value checksum mismatch")
+	ErrLoaderUnavailable = errors.New("loader is unavailable")
 )
 
 type EvictionPolicy int
@@ -22,16 +39,139 @@ const (
 	NewestEvictionPolicy
 	LRUEvictionPolicy
 	MRUEvictionPolicy
+	LFUEvictionPolicy
 
 	MaxCacheSize = 255 // Maximum number of keys the cache can hold
 
 	DefaultTTL             = "0"              // Default TTL ("0" means no expiration)
-	DefaultCleanupInterval = 30 * time.Second // Default Cleanup Interval (should we have this?)
+	DefaultCleanupInterval = 30 * time.Second // Default interval of the background removal of expired keys
+	DefaultNegativeTTL     = 5 * time.Second  // Default TTL of the misses of the loader cached, see WithNegativeTTL
 )
 
+// String returns the name of the policy, as parsed by ParseEvictionPolicy.
+func (p EvictionPolicy) String() string {
+	switch p {
+	case NoEvictionPolicy:
+		return "none"
+	case OldestEvictionPolicy:
+		return "oldest"
+	case NewestEvictionPolicy:
+		return "newest"
+	case LRUEvictionPolicy:
+		return "lru"
+	case MRUEvictionPolicy:
+		return "mru"
+	case LFUEvictionPolicy:
+		return "lfu"
+	default:
+		return "unknown"
+	}
+}
+
+// SetMode controls whether a Set applies depending on the existence of the key.
+type SetMode int
+
+const (
+	SetAlways    SetMode = iota // Set the key regardless of whether it exists (default).
+	SetIfAbsent                 // Only set the key if it does not exist (NX). Useful for distributed locking.
+	SetIfPresent                // Only set the key if it already exists (XX).
+)
+
+// String returns the name of the mode, as parsed by ParseSetMode.
+func (m SetMode) String() string {
+	switch m {
+	case SetAlways:
+		return "always"
+	case SetIfAbsent:
+		return "nx"
+	case SetIfPresent:
+		return "xx"
+	default:
+		return "unknown"
+	}
+}
+
 type Options struct {
 	TTL            time.Duration  // Time to live for the cache entries. Default is 0 (no expiration).
-	EvictionPolicy EvictionPolicy // Controls how keys should be removed from cache. Options are: Oldest, Newest, LRU(default), MRU
+	TTLJitter      time.Duration  // Shortens the TTL by a random duration in [0, TTLJitter) so keys set together don't expire together.
+	EvictionPolicy EvictionPolicy // Controls how keys should be removed from cache. Options are: Oldest, Newest, LRU, MRU, LFU. Default is the cache default policy.
+	SetMode        SetMode        // Controls whether a Set applies to absent or present keys. Default is SetAlways.
+	// NoEviction rejects a new key with ErrCacheFull when the cache is full instead of evicting, whatever the cache
+	// default policy. It is needed since a zero EvictionPolicy means the cache default, see [PolicyOptions].
+	NoEviction bool
+	// StaleWhileRevalidate keeps a key set with a TTL for this grace period after it expires, during which a Get
+	// returns its stale value at once and refreshes it with the read-through loader in the background. It is ignored
+	// in a cache without a loader, see [WithLoader].
+	StaleWhileRevalidate time.Duration
+}
+
+// SetResult describes the outcome of a set, see [MinervaCache.SetWithResult].
+type SetResult struct {
+	Created bool // Whether the key was created, false if an existing key was updated.
+}
+
+// ItemMeta describes a cached item alongside its value.
+type ItemMeta struct {
+	ExpiresAt    time.Time     // When the item expires. Zero if it has no TTL.
+	TTLRemaining time.Duration // Time left before the item expires. Zero if it has no TTL.
+	CreatedAt    time.Time     // When the item was first stored.
+	Version      uint64        // Incremented each time the value is set, starting at 1. See SetWithVersion.
+}
+
+// Entry is a key of a bucket with its value and metadata, as exported by the cache or loaded by BulkLoad.
+type Entry struct {
+	Bucket string
+	Key    string
+	Value  []byte
+	Meta   ItemMeta // Only ExpiresAt and CreatedAt are used by BulkLoad.
+}
+
+// Stats is a snapshot of the cache counters since it was created, independent of the MetricsHandler.
+type Stats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Sets        uint64 `json:"sets"` // Both new and existing keys.
+	Deletes     uint64 `json:"deletes"`
+	Evicts      uint64 `json:"evicts"`
+	Expires     uint64 `json:"expires"` // Both inline and background expirations.
+	Size        int    `json:"size"`    // Number of items currently held.
+	BucketCount int    `json:"bucket_count"`
+	// Capacity is the maximum number of items, 0 for a cache limited by MaxBytes instead.
+	Capacity int `json:"capacity"`
+	// MaxBytes is the maximum total size of the values and UsedBytes their current size, both 0 for a cache limited by
+	// Capacity instead.
+	MaxBytes  int64 `json:"max_bytes,omitempty"`
+	UsedBytes int64 `json:"used_bytes,omitempty"`
+	// Utilization is the ratio of the limit of the cache in use, Size over Capacity or UsedBytes over MaxBytes, from 0
+	// to 1. Setting new keys evicts once it reaches 1.
+	Utilization float64 `json:"utilization"`
+}
+
+// Loader loads the keys missing from the cache from a backing store, e.g. a database, see [WithLoader].
+type Loader interface {
+	// Load returns the value of the key and the TTL to cache it with, 0 for no expiration.
+	// ErrKeyNotFound should be returned if the key doesn't exist in the backing store either.
+	Load(bucket, key string) ([]byte, time.Duration, error)
+}
+
+// Writer mirrors the writes of the cache to a backing store, e.g. a database, see [WithWriter].
+type Writer interface {
+	// Write stores the value of the key in the backing store.
+	Write(bucket, key string, value []byte) error
+}
+
+// EvictionSink receives the keys evicted from the cache, e.g. to spill them to a slower store, see [WithEvictionSink].
+type EvictionSink interface {
+	// Spill stores the evicted value of the key, and when it expires, zero for no expiration.
+	Spill(bucket, key string, value []byte, expiresAt time.Time) error
+}
+
+// Health is the state of the cache reported by the health checks.
+type Health struct {
+	Stopped     bool      // The cache no longer serves the operations once stopped.
+	StartedAt   time.Time // When the cache was created.
+	Size        int       // Number of items currently held.
+	BucketCount int
 }
 
 // Option function type as specified in the problem
@@ -63,61 +203,234 @@ type Option func(o *Options) error
 
 // Cache interface used in my solution. It is a simplified version of the original one which is implemented by the MinervaCache.
 type Cache interface {
-	// Set sets the value to the provided key in the given bucket.
+	// Set sets the value to the provided key in the given bucket. The value may be empty (or nil).
 	// An error is returned if operation fails.
 	Set(bucket string, key string, value []byte, opts Options) error
-	// Get returns the value associated with the given key in the bucket.
+	// Get returns the value associated with the given key in the bucket. An empty value is returned as an empty,
+	// non-nil slice with a nil error, a miss is always an error.
 	// An error is returned if operation fails.
 	Get(bucket, key string, opts Options) ([]byte, error)
+	// GetWithMeta returns the value associated with the given key in the bucket along with its metadata.
+	// An error is returned if operation fails.
+	GetWithMeta(bucket, key string, opts Options) ([]byte, ItemMeta, error)
+	// Exists reports whether the key is in the bucket and not expired, without tracking it as accessed.
+	// An error is returned if the bucket does not exist.
+	Exists(bucket, key string) (bool, error)
+	// SetCtx, GetCtx, GetWithMetaCtx and DeleteCtx are the variants of the operations above that return the context
+	// error without applying the operation if the context is done before it starts (or while it makes room).
+	SetCtx(ctx context.Context, bucket string, key string, value []byte, opts Options) error
+	GetCtx(ctx context.Context, bucket, key string, opts Options) ([]byte, error)
+	GetWithMetaCtx(ctx context.Context, bucket, key string, opts Options) ([]byte, ItemMeta, error)
+	DeleteCtx(ctx context.Context, bucket, key string) error
+	// SetWithResult is like SetCtx, but also reports whether the key was created or an existing one updated.
+	SetWithResult(ctx context.Context, bucket string, key string, value []byte, opts Options) (SetResult, error)
+	// GetOrSet returns the value associated with the given key in the bucket, or sets it to the value returned by the
+	// loader if it is missing. The loader is called once for concurrent callers of the same key, and errors are not cached.
+	GetOrSet(bucket, key string, opts Options, loader func() ([]byte, error)) ([]byte, error)
+	// GetOrDefault returns the value associated with the given key in the bucket, or sets it to def and returns def if
+	// it is missing. Concurrent callers of the same missing key all return the value of the first one to set it.
+	GetOrDefault(bucket, key string, def []byte, opts Options) ([]byte, error)
+	// SetNegative caches the key as missing for the ttl, replacing its value if any, so the reads return
+	// ErrNegativeCached instead of loading it again until it expires or the key is set.
+	SetNegative(bucket, key string, ttl time.Duration) error
+	// SetMulti sets all the given key-value pairs in the bucket in a single operation.
+	// An error is returned if operation fails.
+	SetMulti(bucket string, items map[string][]byte, opts Options) error
+	// GetMulti returns the values associated with the given keys in the bucket in a single operation.
+	// Missing keys are absent from the returned map. An error is returned if operation fails.
+	GetMulti(bucket string, keys []string, opts Options) (map[string][]byte, error)
+	// Increment atomically adds delta to the integer value of the key in the bucket and returns the new value.
+	// A missing key is initialized to delta. An error is returned if the value is not an integer.
+	Increment(bucket, key string, delta int64, opts Options) (int64, error)
+	// Decrement atomically subtracts delta from the integer value of the key in the bucket and returns the new value.
+	// A missing key is initialized to -delta. An error is returned if the value is not an integer.
+	Decrement(bucket, key string, delta int64, opts Options) (int64, error)
+	// CompareAndSwap replaces the value of the key in the bucket with newValue only if its current value is equal to
+	// oldValue, and reports whether it did. An error is returned if the key does not exist.
+	CompareAndSwap(bucket, key string, oldValue, newValue []byte, opts Options) (bool, error)
+	// SetWithVersion sets the value of the key in the bucket only if its current version is expectedVersion, 0 for a
+	// missing key. ErrVersionMismatch is returned otherwise.
+	SetWithVersion(bucket, key string, value []byte, expectedVersion uint64, opts Options) error
+	// Persist removes the TTL of the key in the bucket, so it no longer expires.
+	// An error is returned if the key does not exist or already expired.
+	Persist(bucket, key string) error
+	// Touch sets the TTL of the key in the bucket, replacing any current one, without changing its value.
+	// An error is returned if the key does not exist or already expired, or if the ttl is not positive.
+	Touch(bucket, key string, ttl time.Duration) error
+	// Move relocates the value of a key to another key, possibly in another bucket, keeping its remaining TTL.
+	// An error is returned if the source doesn't exist, or if the destination exists and overwrite is not set.
+	Move(srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error
 	// Delete removes the key and value from the bucket. (Do we need the extra opts Options argument here?)
 	// An error is returned if operation fails.
 	Delete(bucket, key string) error
+	// DeleteMulti removes the given keys from the bucket in a single operation, and returns how many existed.
+	// Missing keys are skipped. An error is returned if operation fails.
+	DeleteMulti(bucket string, keys []string) (int, error)
+	// DeletePrefix removes all the keys of the bucket starting with the prefix in a single operation, and returns how
+	// many were deleted. An error is returned if the bucket does not exist.
+	DeletePrefix(bucket, prefix string) (int, error)
+	// Clear removes all the keys in the bucket.
+	// An error is returned if the bucket does not exist.
+	Clear(bucket string) error
+	// FlushAll removes all the keys in all the buckets.
+	FlushAll()
+	// Len returns the total number of items currently held across all buckets.
+	Len() int
+	// Capacity returns the maximum number of items the cache holds before evicting.
+	Capacity() int
+	// Resize changes the capacity of the cache, evicting the excess keys if it shrinks below their number.
+	// An error is returned if the capacity is not positive.
+	Resize(newCapacity int) error
+	// CollectExpired removes the expired keys now instead of waiting for the background TTL check, and returns how
+	// many were removed.
+	CollectExpired() int
+	// BucketLen returns the number of keys in the given bucket.
+	// An error is returned if the bucket does not exist.
+	BucketLen(bucket string) (int, error)
+	// BucketSizes returns the number of unexpired keys of each bucket.
+	BucketSizes() map[string]int
+	// Buckets returns the names of the buckets holding unexpired keys, sorted.
+	Buckets() []string
+	// ScanKeys returns a page of at most limit keys of the bucket sorted after the cursor, and the cursor of the next
+	// page, "" on the last one. An error is returned if the bucket does not exist.
+	ScanKeys(bucket, cursor string, limit int) ([]string, string, error)
 
-	// Stats returns statistics about the cache. Should I do this or use prometheus to get performance metrics?
-	// Or maybe this just stores the stats in the cache, and then we can use prometheus to get them?
-	// Do I even need to add this to the interface?
-	//Stats() Stats
+	// Export returns all the unexpired keys of the bucket with their values and metadata, without tracking them as
+	// accessed. An error is returned if the bucket does not exist.
+	Export(bucket string) (map[string]Entry, error)
+	// Stats returns a snapshot of the cache counters, for admin endpoints that can't scrape prometheus.
+	Stats() Stats
+	// Health returns the state of the cache, for the health checks.
+	Health() Health
+	// Watch subscribes to the changes of the keys in the bucket. The returned function unsubscribes.
+	Watch(bucket string) (<-chan Event, func())
 
 	// Stop terminates any background processes and cleans up resources. Should I add this to the interface?
 	//Stop()
 }
 
-// ParseOptionsFromRequest parses the options from the HTTP request.
+// ParseOptionsFromRequest parses the options from the HTTP request. The ttl and jitter parameters are parsed with
+// ParseTTL, and ttl defaults to DefaultTTL.
 func ParseOptionsFromRequest(r *http.Request) (Options, error) {
 	ttl := r.URL.Query().Get("ttl")
 	if ttl == "" {
 		ttl = DefaultTTL
 	}
 
-	ttlCleanupInterval, err := time.ParseDuration(ttl) // See func doc for formats.
+	ttlCleanupInterval, err := ParseTTL(ttl)
+	if err != nil {
+		return Options{}, err
+	}
+
+	jitter, err := parseDuration("jitter", r.URL.Query().Get("jitter"))
+	if err != nil {
+		return Options{}, err
+	}
+
+	staleWhileRevalidate, err := parseDuration("swr", r.URL.Query().Get("swr"))
+	if err != nil {
+		return Options{}, err
+	}
+
+	evictionPolicy, err := ParseEvictionPolicy(r.URL.Query().Get("policy"))
+	if err != nil {
+		return Options{}, err
+	}
+
+	// The If-None-Match: * and If-Match: * headers are the HTTP way of asking for set-if-absent and set-if-present.
+	// The mode param takes precedence.
+	mode := r.URL.Query().Get("mode")
+	switch {
+	case mode != "":
+	case r.Header.Get("If-None-Match") == "*":
+		mode = "nx"
+	case r.Header.Get("If-Match") == "*":
+		mode = "xx"
+	}
+
+	setMode, err := ParseSetMode(mode)
 	if err != nil {
 		return Options{}, err
 	}
-	if ttlCleanupInterval < 0 {
-		return Options{}, errors.New("ttl cannot be negative: " + ttl)
+
+	opts := PolicyOptions(evictionPolicy)
+	opts.TTL = ttlCleanupInterval
+	opts.TTLJitter = jitter
+	opts.SetMode = setMode
+	opts.StaleWhileRevalidate = staleWhileRevalidate
+	return opts, nil
+}
+
+// PolicyOptions returns the options of an operation with a policy parsed by ParseEvictionPolicy. The NoEvictionPolicy
+// of the "none" name is an explicit request not to evict, so it also sets NoEviction.
+func PolicyOptions(policy EvictionPolicy) Options {
+	return Options{EvictionPolicy: policy, NoEviction: policy == NoEvictionPolicy}
+}
+
+// ParseTTL parses a TTL given as a bare integer of milliseconds, e.g. 60000 like the ttl_ms of the gRPC API, or
+// otherwise as a Go duration string, e.g. 60s or 500ms. An empty TTL is 0, i.e. no expiration. Negative TTLs are
+// rejected in both forms.
+func ParseTTL(ttl string) (time.Duration, error) {
+	return parseDuration("ttl", ttl)
+}
+
+// parseDuration parses the named duration parameter like ParseTTL.
+func parseDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	// A bare integer takes precedence: only "0" is also a duration string, and it is the same in both forms.
+	d, err := time.ParseDuration(value)
+	if ms, msErr := strconv.ParseInt(value, 10, 64); msErr == nil {
+		// The milliseconds beyond the range of a Duration would overflow, e.g. to a wrong positive TTL.
+		if ms > math.MaxInt64/int64(time.Millisecond) || ms < math.MinInt64/int64(time.Millisecond) {
+			return 0, fmt.Errorf("%w: %s %s is out of range", ErrInvalidTTL, name, value)
+		}
+		d, err = time.Duration(ms)*time.Millisecond, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: expected milliseconds or a duration like 60s", name, value)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s cannot be negative: %s", name, value)
 	}
+	return d, nil
+}
 
-	policy := r.URL.Query().Get("policy")
-	if policy == "" {
-		policy = "lru" // Default to LRU
+// ParseSetMode maps a set mode name (always, nx, xx) to its SetMode. An empty name defaults to SetAlways.
+// An error wrapping ErrInvalidSetMode is returned for unknown names.
+func ParseSetMode(mode string) (SetMode, error) {
+	switch mode {
+	case "", "always":
+		return SetAlways, nil
+	case "nx":
+		return SetIfAbsent, nil
+	case "xx":
+		return SetIfPresent, nil
+	default:
+		return SetAlways, fmt.Errorf("%w: %s", ErrInvalidSetMode, mode)
 	}
+}
 
-	var evictionPolicy EvictionPolicy
+// ParseEvictionPolicy maps a policy name (lru, mru, lfu, oldest, newest, none) to its EvictionPolicy.
+// An empty name defaults to LRU, and none maps to NoEvictionPolicy. An error wrapping ErrInvalidPolicy is returned for
+// unknown names.
+func ParseEvictionPolicy(policy string) (EvictionPolicy, error) {
 	switch policy {
-	case "lru":
-		evictionPolicy = LRUEvictionPolicy
+	case "", "lru": // Default to LRU
+		return LRUEvictionPolicy, nil
 	case "mru":
-		evictionPolicy = MRUEvictionPolicy
+		return MRUEvictionPolicy, nil
+	case "lfu":
+		return LFUEvictionPolicy, nil
 	case "oldest":
-		evictionPolicy = OldestEvictionPolicy
+		return OldestEvictionPolicy, nil
 	case "newest":
-		evictionPolicy = NewestEvictionPolicy
+		return NewestEvictionPolicy, nil
+	case "none":
+		return NoEvictionPolicy, nil
 	default:
-		return Options{}, errors.New("invalid policy: " + policy)
+		return NoEvictionPolicy, fmt.Errorf("%w: %s", ErrInvalidPolicy, policy)
 	}
-
-	return Options{
-		TTL:            ttlCleanupInterval,
-		EvictionPolicy: evictionPolicy,
-	}, nil
 }
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEvictionPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expected EvictionPolicy
+		wantErr  bool
+	}{
+		{"", LRUEvictionPolicy, false},
+		{"lru", LRUEvictionPolicy, false},
+		{"mru", MRUEvictionPolicy, false},
+		{"lfu", LFUEvictionPolicy, false},
+		{"oldest", OldestEvictionPolicy, false},
+		{"newest", NewestEvictionPolicy, false},
+		{"none", NoEvictionPolicy, false},
+		{"random", NoEvictionPolicy, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			policy, err := ParseEvictionPolicy(tt.policy)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidPolicy)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, policy)
+			if tt.policy != "" {
+				assert.Equal(t, tt.policy, policy.String(), "expected the name to round-trip")
+			}
+		})
+	}
+}
+
+func TestParseOptionsFromRequest_TTL(t *testing.T) {
+	tests := []struct {
+		ttl      string
+		expected time.Duration
+		wantErr  string
+	}{
+		{"60s", time.Minute, ""},
+		{"500ms", 500 * time.Millisecond, ""},
+		{"60000", time.Minute, ""},
+		{"0", 0, ""},
+		{"", 0, ""},
+		{"-1s", 0, "ttl cannot be negative"},
+		{"-5", 0, "ttl cannot be negative"},
+		{"abc", 0, "invalid ttl"},
+		{"1.5", 0, "invalid ttl"},
+		{"9223372036854", 9223372036854 * time.Millisecond, ""},
+		{"9223372036855", 0, "ttl 9223372036855 is out of range"},
+		{"-9223372036855", 0, "ttl -9223372036855 is out of range"},
+		{"9223372036854775807", 0, "ttl 9223372036854775807 is out of range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ttl, func(t *testing.T) {
+			opts, err := ParseOptionsFromRequest(httptest.NewRequest("PUT", "/cache/bkt/key?ttl="+tt.ttl, nil))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, opts.TTL)
+		})
+	}
+
+	_, err := ParseTTL("9223372036855")
+	assert.ErrorIs(t, err, ErrInvalidTTL, "expected an overflowing ttl to be invalid")
+}
+
+func TestParseOptionsFromRequest_NoEviction(t *testing.T) {
+	opts, err := ParseOptionsFromRequest(httptest.NewRequest("PUT", "/cache/bkt/key?policy=none", nil))
+	assert.NoError(t, err)
+	assert.True(t, opts.NoEviction, "expected none to be set as NoEviction, since a zero policy is the cache default")
+
+	opts, err = ParseOptionsFromRequest(httptest.NewRequest("PUT", "/cache/bkt/key", nil))
+	assert.NoError(t, err)
+	assert.False(t, opts.NoEviction)
+	assert.Equal(t, LRUEvictionPolicy, opts.EvictionPolicy)
+}
+
+func TestParseOptionsFromRequest_Jitter(t *testing.T) {
+	opts, err := ParseOptionsFromRequest(httptest.NewRequest("PUT", "/cache/bkt/key?ttl=1m&jitter=10s", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, opts.TTL)
+	assert.Equal(t, 10*time.Second, opts.TTLJitter)
+
+	_, err = ParseOptionsFromRequest(httptest.NewRequest("PUT", "/cache/bkt/key?ttl=1m&jitter=-1s", nil))
+	assert.Error(t, err, "expected an error for a negative jitter")
+	_, err = ParseOptionsFromRequest(httptest.NewRequest("PUT", "/cache/bkt/key?ttl=1m&jitter=abc", nil))
+	assert.Error(t, err, "expected an error for an invalid jitter")
+}
+
+func TestParseOptionsFromRequest_StaleWhileRevalidate(t *testing.T) {
+	opts, err := ParseOptionsFromRequest(httptest.NewRequest("GET", "/cache/bkt/key?swr=30s", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, opts.StaleWhileRevalidate)
+
+	_, err = ParseOptionsFromRequest(httptest.NewRequest("GET", "/cache/bkt/key?swr=-1s", nil))
+	assert.Error(t, err, "expected an error for a negative grace period")
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"container/list"
+	"sync/atomic"
+)
+
+// freqList groups the cache items by access frequency so the [LFUEvictionPolicy] can find the least frequently used
+// item in O(1) instead of scanning the whole order list on every eviction.
+// It is a list of frequency nodes sorted by ascending frequency, where each node holds the items accessed exactly that
+// many times in the order they reached that frequency. The victim is the front item of the front (lowest) node, which
+// breaks ties by the item that has been at the lowest frequency the longest (for never accessed items, the oldest one).
+// Items get a sequence number when they reach a frequency, so ties can also be broken across the lists of the shards.
+// No locking is done here, all methods must be called with the cache mutex locked.
+type freqList struct {
+	freqs *list.List     // list of *freqNode, sorted by ascending freq.
+	seq   *atomic.Uint64 // global sequence used to number the items as they reach a frequency.
+}
+
+// freqNode holds all the items with the same access frequency.
+type freqNode struct {
+	freq  int
+	items *list.List // list of *list.Element pointing to the items in the cache order list.
+}
+
+func newFreqList(seq *atomic.Uint64) *freqList {
+	return &freqList{freqs: list.New(), seq: seq}
+}
+
+// add tracks a newly inserted item with a frequency of 1.
+func (fl *freqList) add(el *list.Element) {
+	front := fl.freqs.Front()
+	if front == nil || front.Value.(*freqNode).freq != 1 {
+		front = fl.freqs.PushFront(&freqNode{freq: 1, items: list.New()})
+	}
+	fl.push(front, el)
+}
+
+// touch increments the access frequency of the item, moving it to the next frequency node.
+func (fl *freqList) touch(el *list.Element) {
+	item := el.Value.(*cacheItem)
+	node := item.freqNode
+	freq := node.Value.(*freqNode).freq + 1
+
+	next := node.Next()
+	if next == nil || next.Value.(*freqNode).freq != freq {
+		next = fl.freqs.InsertAfter(&freqNode{freq: freq, items: list.New()}, node)
+	}
+
+	fl.remove(el)
+	fl.push(next, el)
+}
+
+// remove stops tracking the item, dropping its frequency node if it becomes empty.
+func (fl *freqList) remove(el *list.Element) {
+	item := el.Value.(*cacheItem)
+	if item.freqNode == nil {
+		return
+	}
+
+	fn := item.freqNode.Value.(*freqNode)
+	fn.items.Remove(item.freqEl)
+	if fn.items.Len() == 0 {
+		fl.freqs.Remove(item.freqNode)
+	}
+	item.freqNode, item.freqEl = nil, nil
+}
+
+// victim returns the element of the least frequently used item or nil if there are no items.
+func (fl *freqList) victim() *list.Element {
+	front := fl.freqs.Front()
+	if front == nil {
+		return nil
+	}
+	return front.Value.(*freqNode).items.Front().Value.(*list.Element)
+}
+
+// init clears all the tracked items.
+func (fl *freqList) init() {
+	fl.freqs.Init()
+}
+
+// push appends the item to the given frequency node.
+func (fl *freqList) push(node *list.Element, el *list.Element) {
+	item := el.Value.(*cacheItem)
+	item.freqNode = node
+	item.freqEl = node.Value.(*freqNode).items.PushBack(el)
+	item.freqSeq = fl.seq.Add(1)
+}
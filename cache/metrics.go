@@ -2,8 +2,11 @@ package cache
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -11,16 +14,23 @@ var _ MetricsHandler = &mockMetrics{}
 
 // MetricsHandler allows MinervaCache to track and report metrics for monitoring.
 // We would use Prometheus for actual implementation and do nothing for testing by using the mockMetrics.
+// The hits, misses, sets, deletes, evictions and not found keys are reported with the bucket of the key, so they can be broken down by bucket.
 type MetricsHandler interface {
 	SetSize(size int)
-	AddHit()
-	AddMiss()
-	AddSet()
-	AddSetExists()
-	AddDelete()
-	AddEvict()
+	// SetBucketCount and SetAvgBucketSize report the number of buckets and their average number of keys, along with
+	// the size.
+	SetBucketCount(count int)
+	SetAvgBucketSize(size float64)
+	AddHit(bucket string)
+	AddMiss(bucket string)
+	AddSet(bucket string)
+	AddSetExists(bucket string)
+	AddDelete(bucket string)
+	AddEvict(bucket string)
 	AddExpire(inlineCheck bool)
-	AddNotFound()
+	AddNotFound(bucket string)
+	// ObserveLatency records the duration of an operation, i.e. "get", "set" or "delete".
+	ObserveLatency(op string, d time.Duration)
 }
 
 type MetricsExporter interface {
@@ -30,33 +40,64 @@ type MetricsExporter interface {
 // mockMetrics is a no-op implementation of the MetricsHandler interface. For testing purpose.
 type mockMetrics struct{}
 
-func (n *mockMetrics) SetSize(size int)           {}
-func (n *mockMetrics) AddHit()                    {}
-func (n *mockMetrics) AddMiss()                   {}
-func (n *mockMetrics) AddSet()                    {}
-func (n *mockMetrics) AddSetExists()              {}
-func (n *mockMetrics) AddDelete()                 {}
-func (n *mockMetrics) AddEvict()                  {}
-func (n *mockMetrics) AddExpire(inlineCheck bool) {}
-func (n *mockMetrics) AddNotFound()               {}
+func (n *mockMetrics) SetSize(size int)                          {}
+func (n *mockMetrics) SetBucketCount(count int)                  {}
+func (n *mockMetrics) SetAvgBucketSize(size float64)             {}
+func (n *mockMetrics) AddHit(bucket string)                      {}
+func (n *mockMetrics) AddMiss(bucket string)                     {}
+func (n *mockMetrics) AddSet(bucket string)                      {}
+func (n *mockMetrics) AddSetExists(bucket string)                {}
+func (n *mockMetrics) AddDelete(bucket string)                   {}
+func (n *mockMetrics) AddEvict(bucket string)                    {}
+func (n *mockMetrics) AddExpire(inlineCheck bool)                {}
+func (n *mockMetrics) AddNotFound(bucket string)                 {}
+func (n *mockMetrics) ObserveLatency(op string, d time.Duration) {}
 
 // PmMetrics is a Prometheus implementation of the MetricsHandler interface.
+// Each instance registers its metrics with its own registry, so multiple caches (e.g. in tests) can coexist in a process.
 type PmMetrics struct {
-	size      *prometheus.GaugeVec
-	hit       *prometheus.CounterVec
-	miss      *prometheus.CounterVec // Can be broken down into more granular metrics. Broken down below.
-	set       *prometheus.CounterVec
-	setExists *prometheus.CounterVec
-	delete    *prometheus.CounterVec
-	evict     *prometheus.CounterVec
-	expire    *prometheus.CounterVec
-	notFound  *prometheus.CounterVec
+	registry *prometheus.Registry
+	// bucketLabels is set if the hit, miss, set, delete, evict and not found counters are labeled by bucket, see
+	// [NewPmMetricsWithBucketLabels].
+	bucketLabels bool
+
+	size          *prometheus.GaugeVec
+	bucketCount   *prometheus.GaugeVec
+	avgBucketSize *prometheus.GaugeVec
+	hit           *prometheus.CounterVec
+	miss          *prometheus.CounterVec // Can be broken down into more granular metrics. Broken down below.
+	set           *prometheus.CounterVec
+	setExists     *prometheus.CounterVec
+	delete        *prometheus.CounterVec
+	evict         *prometheus.CounterVec
+	expire        *prometheus.CounterVec
+	notFound      *prometheus.CounterVec
+	latency       *prometheus.HistogramVec
 }
 
 // NewPmMetrics creates a new instance of pmMetrics with Prometheus metrics.
-// It registers the metrics with the Prometheus registry.
+// It registers the metrics with a new private Prometheus registry, along with the Go runtime and process metrics
+// the default registry would expose.
 func NewPmMetrics() *PmMetrics {
+	return newPmMetrics(false)
+}
+
+// NewPmMetricsWithBucketLabels is like NewPmMetrics, but labels the hit, miss, set, delete, evict and not found counters
+// by bucket, to tell which buckets are hot. Each bucket adds a series to each of these counters, so it should only be used with a
+// bounded number of buckets.
+func NewPmMetricsWithBucketLabels() *PmMetrics {
+	return newPmMetrics(true)
+}
+
+func newPmMetrics(bucketLabels bool) *PmMetrics {
+	var labels []string
+	if bucketLabels {
+		labels = []string{"bucket"}
+	}
+
 	pm := &PmMetrics{
+		registry:     prometheus.NewRegistry(),
+		bucketLabels: bucketLabels,
 		size: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "cache_size",
@@ -64,47 +105,61 @@ func NewPmMetrics() *PmMetrics {
 			},
 			nil,
 		),
+		bucketCount: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cache_buckets",
+				Help: "Number of buckets in the cache",
+			},
+			nil,
+		),
+		avgBucketSize: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "cache_avg_bucket_size",
+				Help: "Average number of keys per bucket",
+			},
+			nil,
+		),
 		hit: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "cache_hit",
 				Help: "Number of cache hits",
 			},
-			nil,
+			labels,
 		),
 		miss: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "cache_miss",
 				Help: "Number of cache misses",
 			},
-			nil,
+			labels,
 		),
 		set: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "cache_set",
 				Help: "Number of cache sets",
 			},
-			nil,
+			labels,
 		),
 		setExists: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "cache_set_exists",
 				Help: "Number of cache sets that already exist",
 			},
-			nil,
+			labels,
 		),
 		delete: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "cache_delete",
 				Help: "Number of cache deletes",
 			},
-			nil,
+			labels,
 		),
 		evict: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "cache_evict",
 				Help: "Number of cache evictions",
 			},
-			nil,
+			labels,
 		),
 		expire: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -113,16 +168,26 @@ func NewPmMetrics() *PmMetrics {
 			},
 			[]string{"inline"},
 		),
+		latency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name: "cache_latency_seconds",
+				Help: "Duration of the cache operations",
+				// From 1µs to 262ms, the in-memory operations take microseconds unless they wait for a lock.
+				Buckets: prometheus.ExponentialBuckets(1e-6, 4, 10),
+			},
+			[]string{"op"},
+		),
 		notFound: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "cache_not_found",
 				Help: "Number of cache not found",
 			},
-			nil,
+			labels,
 		),
 	}
 
-	prometheus.MustRegister(pm.size, pm.hit, pm.miss)
+	pm.registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	pm.registry.MustRegister(pm.size, pm.bucketCount, pm.avgBucketSize, pm.hit, pm.miss, pm.set, pm.setExists, pm.delete, pm.evict, pm.expire, pm.notFound, pm.latency)
 	return pm
 }
 
@@ -131,47 +196,71 @@ func (pm *PmMetrics) SetSize(size int) {
 	pm.size.WithLabelValues().Set(float64(size))
 }
 
-// AddHit increments the hit counter for the cache.
-func (pm *PmMetrics) AddHit() {
-	pm.hit.WithLabelValues().Inc()
+// SetBucketCount sets the number of buckets of the cache.
+func (pm *PmMetrics) SetBucketCount(count int) {
+	pm.bucketCount.WithLabelValues().Set(float64(count))
+}
+
+// SetAvgBucketSize sets the average number of keys per bucket of the cache.
+func (pm *PmMetrics) SetAvgBucketSize(size float64) {
+	pm.avgBucketSize.WithLabelValues().Set(size)
+}
+
+// AddHit increments the hit counter for the cache, labeled by bucket if enabled.
+func (pm *PmMetrics) AddHit(bucket string) {
+	pm.hit.WithLabelValues(pm.labelValues(bucket)...).Inc()
 }
 
-// AddMiss increments the miss counter for the cache.
-func (pm *PmMetrics) AddMiss() {
-	pm.miss.WithLabelValues().Inc()
+// AddMiss increments the miss counter for the cache, labeled by bucket if enabled.
+func (pm *PmMetrics) AddMiss(bucket string) {
+	pm.miss.WithLabelValues(pm.labelValues(bucket)...).Inc()
 }
 
-// AddSet increments the set counter for the cache.
-func (pm *PmMetrics) AddSet() {
-	pm.size.WithLabelValues().Inc()
+// AddSet increments the set counter for the cache, labeled by bucket if enabled.
+func (pm *PmMetrics) AddSet(bucket string) {
+	pm.set.WithLabelValues(pm.labelValues(bucket)...).Inc()
 }
 
-// AddSetExists increments the set exists counter for the cache.
-func (pm *PmMetrics) AddSetExists() {
-	pm.size.WithLabelValues().Inc()
+// AddSetExists increments the set exists counter for the cache, labeled by bucket if enabled.
+func (pm *PmMetrics) AddSetExists(bucket string) {
+	pm.setExists.WithLabelValues(pm.labelValues(bucket)...).Inc()
 }
 
-// AddDelete increments the delete counter for the cache.
-func (pm *PmMetrics) AddDelete() {
-	pm.size.WithLabelValues().Dec()
+// AddDelete increments the delete counter for the cache, labeled by bucket if enabled.
+func (pm *PmMetrics) AddDelete(bucket string) {
+	pm.delete.WithLabelValues(pm.labelValues(bucket)...).Inc()
 }
 
-// AddEvict increments the evict counter for the cache.
-func (pm *PmMetrics) AddEvict() {
-	pm.size.WithLabelValues().Dec()
+// AddEvict increments the evict counter for the cache, labeled by bucket if enabled.
+func (pm *PmMetrics) AddEvict(bucket string) {
+	pm.evict.WithLabelValues(pm.labelValues(bucket)...).Inc()
 }
 
 // AddExpire increments the expire counter for the cache.
-func (pm *PmMetrics) AddExpire(lazy bool) {
-	pm.size.WithLabelValues().Dec()
+// The inline label tells apart expirations caught by Get from the ones collected by the background TTL check.
+func (pm *PmMetrics) AddExpire(inlineCheck bool) {
+	pm.expire.WithLabelValues(strconv.FormatBool(inlineCheck)).Inc()
+}
+
+// AddNotFound increments the not found counter for the cache, labeled by bucket if enabled.
+func (pm *PmMetrics) AddNotFound(bucket string) {
+	pm.notFound.WithLabelValues(pm.labelValues(bucket)...).Inc()
+}
+
+// ObserveLatency records the duration of the operation in the latency histogram, labeled by operation.
+func (pm *PmMetrics) ObserveLatency(op string, d time.Duration) {
+	pm.latency.WithLabelValues(op).Observe(d.Seconds())
 }
 
-// AddNotFound increments the not found counter for the cache.
-func (pm *PmMetrics) AddNotFound() {
-	pm.miss.WithLabelValues().Inc()
+// labelValues returns the label values of the counters labeled by bucket, empty if they are not.
+func (pm *PmMetrics) labelValues(bucket string) []string {
+	if !pm.bucketLabels {
+		return nil
+	}
+	return []string{bucket}
 }
 
-// HTTPHandler returns an HTTP handler for exposing the metrics.
+// HTTPHandler returns an HTTP handler for exposing the metrics of this instance's registry.
 func (pm *PmMetrics) HTTPHandler() http.Handler {
-	return promhttp.Handler()
+	return promhttp.HandlerFor(pm.registry, promhttp.HandlerOpts{})
 }
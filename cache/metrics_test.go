@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scrapeCounter gathers the metrics from the registry of pm and returns the value of the counter
+// with the given name and labels. Zero is returned if the counter has not been observed yet.
+func scrapeCounter(t *testing.T, pm *PmMetrics, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	mfs, err := pm.registry.Gather()
+	assert.NoError(t, err, "expected no error gathering metrics")
+
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			matched := 0
+			for _, lp := range m.GetLabel() {
+				if v, ok := labels[lp.GetName()]; ok && v == lp.GetValue() {
+					matched++
+				}
+			}
+			if matched == len(labels) {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// scrapeGauge returns the value of the unlabeled gauge with the given name, or 0 if it is not found.
+func scrapeGauge(t *testing.T, pm *PmMetrics, name string) float64 {
+	t.Helper()
+
+	mfs, err := pm.registry.Gather()
+	assert.NoError(t, err, "expected no error gathering metrics")
+
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestPmMetrics_Counters(t *testing.T) {
+	pm := NewPmMetrics()
+	mc := NewMinervaCache(2, 0, pm)
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_set", nil), "expected set counter to increment")
+	mc.Set("bkt1", "key1", []byte("val1-updated"), Options{})
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_set_exists", nil), "expected set exists counter to increment")
+
+	mc.Get("bkt1", "key1", Options{})
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_hit", nil), "expected hit counter to increment")
+
+	mc.Get("bkt1", "missing", Options{})
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_miss", nil), "expected miss counter to increment")
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_not_found", nil), "expected not found counter to increment")
+
+	mc.Delete("bkt1", "key1")
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_delete", nil), "expected delete counter to increment")
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{}) // Evicts key1 since the capacity is 2.
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_evict", nil), "expected evict counter to increment")
+
+	mc.Delete("bkt1", "key3")
+	mc.Set("bkt1", "key4", []byte("val4"), Options{TTL: time.Millisecond})
+	time.Sleep(5 * time.Millisecond)
+	mc.Get("bkt1", "key4", Options{})
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_expire", map[string]string{"inline": "true"}), "expected inline expire counter to increment")
+
+	// The size gauge is only touched by SetSize.
+	pm.SetSize(7)
+	mfs, err := pm.registry.Gather()
+	assert.NoError(t, err)
+	for _, mf := range mfs {
+		if mf.GetName() == "cache_size" {
+			assert.Equal(t, 7.0, mf.GetMetric()[0].GetGauge().GetValue(), "expected size gauge to be set")
+		}
+	}
+}
+
+func TestPmMetrics_BucketLabels(t *testing.T) {
+	pm := NewPmMetricsWithBucketLabels()
+	mc := NewMinervaCache(3, 0, pm)
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key1", []byte("val1-updated"), Options{})
+	mc.Set("bkt2", "key1", []byte("val1"), Options{})
+	mc.Set("bkt2", "key2", []byte("val2"), Options{})
+	mc.Get("bkt1", "key1", Options{})
+	mc.Get("bkt2", "key1", Options{})
+	mc.Get("bkt2", "key2", Options{})
+	mc.Get("bkt2", "missing", Options{})
+	mc.Set("bkt2", "key3", []byte("val3"), Options{EvictionPolicy: OldestEvictionPolicy}) // Evicts bkt1/key1 since the capacity is 3.
+	mc.Delete("bkt2", "key2")
+	mc.Delete("bkt1", "missing")
+
+	bkt1, bkt2 := map[string]string{"bucket": "bkt1"}, map[string]string{"bucket": "bkt2"}
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_set", bkt1))
+	assert.Equal(t, 3.0, scrapeCounter(t, pm, "cache_set", bkt2))
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_set_exists", bkt1))
+	assert.Equal(t, 0.0, scrapeCounter(t, pm, "cache_set_exists", bkt2))
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_hit", bkt1))
+	assert.Equal(t, 2.0, scrapeCounter(t, pm, "cache_hit", bkt2))
+	assert.Equal(t, 0.0, scrapeCounter(t, pm, "cache_miss", bkt1))
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_miss", bkt2))
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_evict", bkt1), "expected the eviction to be counted in the bucket of the victim")
+	assert.Equal(t, 0.0, scrapeCounter(t, pm, "cache_evict", bkt2))
+	assert.Equal(t, 0.0, scrapeCounter(t, pm, "cache_delete", bkt1))
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_delete", bkt2))
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_not_found", bkt1), "expected the delete of a missing key to be counted")
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_not_found", bkt2), "expected the get of a missing key to be counted")
+
+	// The counters are not labeled by default.
+	pm = NewPmMetrics()
+	pm.AddHit("bkt1")
+	assert.Equal(t, 0.0, scrapeCounter(t, pm, "cache_hit", bkt1))
+	assert.Equal(t, 1.0, scrapeCounter(t, pm, "cache_hit", nil))
+}
+
+func TestPmMetrics_Latency(t *testing.T) {
+	pm := NewPmMetrics()
+	mc := NewMinervaCache(10, 0, pm)
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Get("bkt1", "key1", Options{})
+	mc.Get("bkt1", "missing", Options{})
+	mc.GetWithMeta("bkt1", "key2", Options{})
+	mc.Delete("bkt1", "key1")
+
+	mfs, err := pm.registry.Gather()
+	assert.NoError(t, err)
+	counts := make(map[string]uint64)
+	for _, mf := range mfs {
+		if mf.GetName() != "cache_latency_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			h := m.GetHistogram()
+			buckets := h.GetBucket()
+			assert.Equal(t, h.GetSampleCount(), buckets[len(buckets)-1].GetCumulativeCount(),
+				"expected the in-memory operations to fall in the buckets")
+			counts[m.GetLabel()[0].GetValue()] = h.GetSampleCount()
+		}
+	}
+	assert.Equal(t, map[string]uint64{"get": 3, "set": 2, "delete": 1}, counts)
+}
+
+func TestPmMetrics_Instances(t *testing.T) {
+	pm1 := NewPmMetrics()
+	pm2 := NewPmMetrics() // Doesn't panic on duplicate registration.
+
+	pm1.AddHit("bkt1")
+	pm1.AddHit("bkt1")
+	pm2.AddHit("bkt1")
+
+	scrape := func(pm *PmMetrics) string {
+		w := httptest.NewRecorder()
+		pm.HTTPHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+	assert.Contains(t, scrape(pm1), "cache_hit 2", "expected the first instance to only expose its own hits")
+	assert.Contains(t, scrape(pm2), "cache_hit 1", "expected the second instance to only expose its own hits")
+}
+
+func TestPmMetrics_BucketGauges(t *testing.T) {
+	pm := NewPmMetrics()
+	mc := NewMinervaCache(10, 0, pm)
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	for i := 1; i <= 2; i++ {
+		mc.Set("bkt2", fmt.Sprintf("key%d", i), []byte("val"), Options{})
+	}
+	for i := 1; i <= 6; i++ {
+		mc.Set("bkt3", fmt.Sprintf("key%d", i), []byte("val"), Options{TTL: time.Millisecond})
+	}
+	assert.Equal(t, 3.0, scrapeGauge(t, pm, "cache_buckets"))
+	assert.Equal(t, 3.0, scrapeGauge(t, pm, "cache_avg_bucket_size"), "expected (1+2+6)/3 keys per bucket")
+
+	mc.Delete("bkt1", "key1") // Removes bkt1.
+	assert.Equal(t, 2.0, scrapeGauge(t, pm, "cache_buckets"))
+	assert.Equal(t, 4.0, scrapeGauge(t, pm, "cache_avg_bucket_size"))
+
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, 6, mc.CollectExpired()) // The sweep removes bkt3.
+	assert.Equal(t, 1.0, scrapeGauge(t, pm, "cache_buckets"))
+	assert.Equal(t, 2.0, scrapeGauge(t, pm, "cache_avg_bucket_size"))
+
+	mc.FlushAll()
+	assert.Equal(t, 0.0, scrapeGauge(t, pm, "cache_buckets"))
+	assert.Equal(t, 0.0, scrapeGauge(t, pm, "cache_avg_bucket_size"))
+}
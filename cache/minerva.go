@@ -1,8 +1,21 @@
 package cache
 
 import (
+	"bytes"
 	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,24 +24,98 @@ var _ Cache = &MinervaCache{} // MinervaCache implements Cache interface. This i
 // MinervaCache implements a key-value cache with various eviction policies [EvictionPolicy] and TTL support.
 // It is designed to be used in a distributed system where multiple processes can access the cache.
 type MinervaCache struct {
-	capacity         int
+	// capacity is the maximum number of keys in the cache. It can be changed at runtime, see [MinervaCache.Resize].
+	capacity atomic.Int64
+	// bucketCapacity is the maximum number of keys a single bucket can hold. 0 means buckets are only limited by capacity.
+	bucketCapacity int
+	// maxValueBytes is the maximum size of a value. Larger values are rejected. 0 means unlimited.
+	maxValueBytes int
+	// maxBuckets is the maximum number of buckets. Setting a key in a new bucket past it is rejected. 0 means unlimited.
+	maxBuckets int
+	// defaultPolicy is the eviction policy of the operations without one, see [WithDefaultPolicy].
+	defaultPolicy EvictionPolicy
+	// loader loads the keys missed by the reads, nil to disable the read-through, see [WithLoader]. loaderBreaker fails
+	// the loads fast while the loader keeps failing, nil to disable, see [WithLoaderBreaker].
+	loader        Loader
+	loaderBreaker *breaker
+	// expiryTimerThreshold is the longest TTL of the keys removed by a timer as they expire instead of by the
+	// background TTL check, 0 to disable, see [WithExpiryTimers].
+	expiryTimerThreshold time.Duration
+	// negativeTTL is how long the keys the loader doesn't find are cached as missing, 0 to disable, see
+	// [WithNegativeTTL].
+	negativeTTL time.Duration
+	// checksums is set to store a checksum of each value and verify it on the reads, see [WithChecksums].
+	checksums bool
+	// validateName checks the bucket and key names of the operations, nil to accept any, see [WithNameValidator].
+	validateName func(name string) error
+	// writer mirrors the writes in the writeMode, nil to disable, see [WithWriter]. writeBehind is the queue of the
+	// writes for the writer in the WriteBehind mode, nil otherwise.
+	writer      Writer
+	writeMode   WriteMode
+	writeBehind *writeBehind
+	// evictionSink receives the evicted keys, nil to drop them, see [WithEvictionSink].
+	evictionSink     EvictionSink
 	ttlCheckInterval time.Duration
 	stop             chan struct{}
-	// metrics is used for tracking cache actions. Only hit, miss and size for now.
+	// startedAt is when the cache was created and stopped is set once it is stopped, see [MinervaCache.Health].
+	startedAt time.Time
+	stopped   atomic.Bool
+	// metrics is used for tracking cache actions like hits, misses, sets, deletes, evictions and expirations.
 	metrics MetricsHandler
-	// mutex locks all the buckets and the order list in the cache.
-	// We could use a RWMutex, but since we are using a single mutex for all operations,
-	// we don't need to worry about read/write locks. Especially since we perform write update operations like eviction
-	// and usage/insertion order updates in Get operations as well. It would be over-complicated to use a RWMutex
-	// and have to Rlock, RUnlock, Lock, and Unlock for every operation that needs both read and writes.
-	mutex sync.Mutex
-	// buckets is a map of buckets where each bucket is a map of key-value pairs.
-	// The value is set in a Value field of a list.Element and stored in the bucket as a pointer to the element in the insertion order list.
-	buckets map[string]map[string]*list.Element
-	// order is a doubly linked list that maintains the order of keys based on the eviction policy.
-	// The order is by default the insertion order. Used to evict the oldest or newest keys.
-	// For [EvictionPolicyLRU] or [EvictionPolicyMRU] policies, the order is also updated during Get and Set operations manually.
-	order *list.List
+	// logger is where the failures of the background work, e.g. the write-ahead log, are logged, see [WithLogger].
+	logger *slog.Logger
+	// shards split the items by a hash of their bucket and key, each behind its own mutex, so operations on different
+	// keys don't all serialize on a single lock. See [shard].
+	// We could use a RWMutex per shard, but since we perform write update operations like eviction and usage/insertion
+	// order updates in Get operations as well, it would be over-complicated for little gain.
+	shards []*shard
+	// shardHash picks the shard of a key, see [WithShardHash].
+	shardHash ShardHash
+	// seq numbers the items as they are inserted or accessed, so the order of the items in different shards can be
+	// compared to find the global eviction victim.
+	seq atomic.Uint64
+	// count is the number of items in all the shards plus the slots reserved by in-flight inserts. It is used to keep
+	// the whole cache within capacity without locking all the shards.
+	count atomic.Int64
+	// maxBytes is the maximum total size of the values in the cache. 0 means the cache is only limited by capacity.
+	maxBytes int64
+	// bytes is the total size of the values in all the shards plus the bytes reserved by in-flight inserts.
+	bytes atomic.Int64
+	// items is the number of items in all the shards, without the reserved slots, so Len and Stats can read it
+	// without locking.
+	items atomic.Int64
+	// bucketSizes is the number of keys of each bucket across all the shards, locked by bucketsMutex. bucketCount is
+	// the number of its entries, updated with it but readable without locking.
+	// The bucketsMutex may be locked while holding a shard mutex, but never the other way around.
+	bucketsMutex sync.Mutex
+	bucketSizes  map[string]int
+	bucketCount  atomic.Int64
+	// loads are the GetOrSet loaders in flight by bucket and key, locked by loadsMutex.
+	loadsMutex sync.Mutex
+	loads      map[string]*load
+	// stats counts the cache actions alongside the metrics, so a snapshot can be taken with Stats.
+	stats cacheStats
+	// walPath is where the write-ahead log is kept, empty if disabled. wal is nil if it is disabled or failed to open.
+	walPath string
+	wal     *wal
+	// watchers are the subscribers to the events of each bucket, locked by watchMutex. It is nil once the cache is
+	// stopped. The watchMutex may be locked while holding a shard mutex. watcherCount is the number of watchers, to
+	// skip publishing without locking when there are none.
+	watchMutex   sync.Mutex
+	watchers     map[string]map[*watcher]struct{}
+	watcherCount atomic.Int32
+}
+
+// cacheStats holds the counters of [Stats], updated atomically without any mutex.
+type cacheStats struct {
+	hits, misses, sets, deletes, evicts, expires atomic.Uint64
+}
+
+// load is a GetOrSet loader call in flight. Concurrent callers for the same key wait for it instead of loading again.
+type load struct {
+	done  sync.WaitGroup
+	value []byte
+	err   error
 }
 
 type cacheItem struct {
@@ -36,186 +123,1832 @@ type cacheItem struct {
 	key       string
 	value     []byte
 	expiresAt time.Time
+	createdAt time.Time // When the key was first stored. Updating the value in place keeps it.
+	seq       uint64    // Global sequence number of the last insert or move to the back of the order list.
+	version   uint64    // Starts at 1 when the key is stored, and is incremented each time its value is set.
+	checksum  uint32    // CRC-32 of the value, 0 unless the cache has checksums, see [WithChecksums].
+	// grace is how long the item is kept stale after it expires, see [Options.StaleWhileRevalidate], and refreshing
+	// whether a background refresh of its stale value is running.
+	grace      time.Duration
+	refreshing bool
+	// freqNode and freqEl locate the item in the freqs list: its frequency node and its element within that node.
+	freqNode *list.Element
+	freqEl   *list.Element
+	freqSeq  uint64 // Global sequence number of when the item reached its current frequency.
+	// heapIndex is the index of the item in the expiries heap, -1 if it is not tracked (no TTL).
+	heapIndex int
+	// timer removes the item when it expires, nil if it has none, see [WithExpiryTimers]. timerGen is incremented
+	// each time the timer is scheduled or stopped, so a timer firing after that knows it is stale.
+	timer    *time.Timer
+	timerGen uint64
+}
+
+// expired reports whether the item has a TTL that has passed at the given time.
+func (item *cacheItem) expired(now time.Time) bool {
+	return !item.expiresAt.IsZero() && now.After(item.expiresAt)
+}
+
+// stale reports whether the item has expired at the given time but is still within its grace period, so its value
+// can be served while it is refreshed.
+func (item *cacheItem) stale(now time.Time) bool {
+	return item.expired(now) && now.Before(item.removeAt())
+}
+
+// removeAt returns when the expired item is removed in the background: its expiration time plus its grace period.
+func (item *cacheItem) removeAt() time.Time {
+	return item.expiresAt.Add(item.grace)
+}
+
+// removable reports whether the item has expired past its grace period at the given time.
+func (item *cacheItem) removable(now time.Time) bool {
+	return !item.expiresAt.IsZero() && now.After(item.removeAt())
+}
+
+// meta returns the metadata of the item at the given time.
+func (item *cacheItem) meta(now time.Time) ItemMeta {
+	meta := ItemMeta{ExpiresAt: item.expiresAt, CreatedAt: item.createdAt, Version: item.version}
+	if !item.expiresAt.IsZero() {
+		meta.TTLRemaining = item.expiresAt.Sub(now)
+	}
+	return meta
+}
+
+// CacheOption configures a MinervaCache on creation.
+type CacheOption func(mc *MinervaCache)
+
+// WithShards sets the number of shards the cache is split into. Values lower than 1 are ignored.
+// More shards reduce the contention between concurrent operations, the default is [DefaultShards].
+func WithShards(n int) CacheOption {
+	return func(mc *MinervaCache) {
+		if n > 0 {
+			mc.shards = make([]*shard, n)
+		}
+	}
+}
+
+// WithShardHash sets the hash function picking the shard of each key. A nil hash is ignored.
+// The default is [FNVShardHash], see [NewSeededShardHash] for clients that may choose their keys adversarially.
+func WithShardHash(hash ShardHash) CacheOption {
+	return func(mc *MinervaCache) {
+		if hash != nil {
+			mc.shardHash = hash
+		}
+	}
+}
+
+// WithMaxValueBytes rejects the values larger than n bytes with ErrValueTooLarge. 0 (the default) means unlimited.
+func WithMaxValueBytes(n int) CacheOption {
+	return func(mc *MinervaCache) {
+		mc.maxValueBytes = n
+	}
+}
+
+// WithExpiryTimers removes the keys set with a TTL up to threshold with a timer as soon as they expire, instead of
+// when they are next read or by the background TTL check, which may run long after a short TTL. The longer TTLs are
+// still left to the background TTL check, so there is no timer per key. 0 (the default) disables the timers.
+func WithExpiryTimers(threshold time.Duration) CacheOption {
+	return func(mc *MinervaCache) {
+		mc.expiryTimerThreshold = threshold
+	}
+}
+
+// WithLogger sets the logger of the failures of the background work, e.g. the write-ahead log and the write-behind,
+// and of the background TTL check at the debug level. The default is the default slog logger.
+func WithLogger(logger *slog.Logger) CacheOption {
+	return func(mc *MinervaCache) {
+		mc.logger = logger
+	}
+}
+
+// WithMaxBuckets rejects the keys that would create a new bucket once the cache holds n buckets with
+// ErrTooManyBuckets, while the existing buckets can still be written. 0 (the default) means unlimited. A bucket is
+// removed, and no longer counts, once its last key is deleted, evicted or expired.
+func WithMaxBuckets(n int) CacheOption {
+	return func(mc *MinervaCache) {
+		mc.maxBuckets = n
+	}
+}
+
+// WithDefaultPolicy sets the eviction policy of the operations with no Options.EvictionPolicy, e.g. LRU to track the
+// accesses of all the reads. The default is NoEvictionPolicy, which evicts the oldest key and doesn't track recency.
+// Only a set with Options.NoEviction is rejected with ErrCacheFull instead of evicting.
+func WithDefaultPolicy(policy EvictionPolicy) CacheOption {
+	return func(mc *MinervaCache) {
+		mc.defaultPolicy = policy
+	}
+}
+
+// WithLoader makes the cache read-through: the keys missed by Get and GetWithMeta (and their Ctx variants) are loaded
+// with the loader and set with the returned TTL before being returned. Concurrent misses of the same key share a single
+// Load call. A Load error, e.g. ErrKeyNotFound for a key missing from the backing store too, is returned as the miss
+// and nothing is cached.
+func WithLoader(loader Loader) CacheOption {
+	return func(mc *MinervaCache) {
+		mc.loader = loader
+	}
+}
+
+// WithLoaderBreaker wraps the loader of [WithLoader] in a circuit breaker: after failures consecutive Load errors within
+// the window, the reads missing a key fail fast with ErrLoaderUnavailable without calling the loader, for the
+// cool-down. Then a single read probes the loader again, closing the circuit if it succeeds, or opening it for another
+// cool-down if it fails. ErrKeyNotFound is not a failure, since the backing store answered. Disabled by default.
+func WithLoaderBreaker(failures int, window, coolDown time.Duration) CacheOption {
+	return func(mc *MinervaCache) {
+		mc.loaderBreaker = newBreaker(failures, window, coolDown)
+	}
+}
+
+// WithNegativeTTL sets how long the keys missing from the backing store of the loader, i.e. for which it returns
+// ErrKeyNotFound, are cached as missing, see [MinervaCache.SetNegative], so the reads don't call the loader again for
+// them until then. 0 disables the negative caching. The default is [DefaultNegativeTTL].
+func WithNegativeTTL(ttl time.Duration) CacheOption {
+	return func(mc *MinervaCache) {
+		mc.negativeTTL = ttl
+	}
+}
+
+// WithNameValidator makes Set, Get and Delete (and their variants, including the batch ones, Move and Clear) check the
+// bucket and key names with validate, and fail with an error wrapping ErrInvalidName if it returns an error for any of
+// them, e.g. to stop malformed input from creating buckets. By default any name is accepted.
+func WithNameValidator(validate func(name string) error) CacheOption {
+	return func(mc *MinervaCache) {
+		mc.validateName = validate
+	}
+}
+
+// WithChecksums stores a CRC-32 checksum of each value when it is set, and verifies it when the value is read by Get,
+// GetWithMeta (and their Ctx variants) and GetMulti, which fail with ErrChecksumMismatch instead of returning a value
+// changed since, e.g. by a memory bug. It costs a pass over each value on the writes and the reads.
+func WithChecksums() CacheOption {
+	return func(mc *MinervaCache) {
+		mc.checksums = true
+	}
+}
+
+// NewMinervaCache creates a cache that holds at most capacity keys across all the buckets, removing the expired keys in
+// the background every ttlCheckInterval (0 to only remove them when read).
+// Setting a new key in a full cache evicts a key based on the policy of the set first, while updating an existing key
+// never evicts. A capacity of 0 (or less) disables the cache: every write that would store a key, i.e. Set, SetMulti,
+// GetOrSet and Increment, fails with ErrCacheFull, and the reads always miss.
+func NewMinervaCache(capacity int, ttlCheckInterval time.Duration, metrics MetricsHandler, opts ...CacheOption) *MinervaCache {
+	return NewMinervaCacheWithBucketLimits(capacity, 0, ttlCheckInterval, metrics, opts...)
+}
+
+// NewMinervaCacheBytes creates a cache limited by the total size of its values rather than by the number of keys.
+// Setting a key evicts based on the policy until its value fits within maxBytes, and values larger than maxBytes are
+// rejected with ErrValueTooLarge.
+func NewMinervaCacheBytes(maxBytes int64, ttlCheckInterval time.Duration, metrics MetricsHandler, opts ...CacheOption) *MinervaCache {
+	opts = append(opts, func(mc *MinervaCache) { mc.maxBytes = maxBytes })
+	return NewMinervaCacheWithBucketLimits(math.MaxInt, 0, ttlCheckInterval, metrics, opts...)
+}
+
+// NewMinervaCacheWithBucketLimits creates a cache that holds at most globalCap keys in total and at most perBucketCap
+// keys in each bucket, so a single noisy bucket can't evict the keys of every other bucket.
+// When a bucket is at its limit, eviction targets that bucket before touching the rest of the cache.
+// A perBucketCap of 0 disables the per-bucket limit, which is the same as NewMinervaCache.
+func NewMinervaCacheWithBucketLimits(globalCap, perBucketCap int, ttlCheckInterval time.Duration, metrics MetricsHandler, opts ...CacheOption) *MinervaCache {
+	mc := &MinervaCache{
+		bucketCapacity:   perBucketCap,
+		negativeTTL:      DefaultNegativeTTL,
+		logger:           slog.Default(),
+		ttlCheckInterval: ttlCheckInterval,
+		stop:             make(chan struct{}),
+		startedAt:        time.Now(),
+		shards:           make([]*shard, DefaultShards),
+		shardHash:        FNVShardHash,
+		bucketSizes:      make(map[string]int),
+		loads:            make(map[string]*load),
+		watchers:         make(map[string]map[*watcher]struct{}),
+		metrics:          metrics,
+	}
+	mc.capacity.Store(int64(globalCap))
+	for _, opt := range opts {
+		opt(mc)
+	}
+	for i := range mc.shards {
+		mc.shards[i] = newShard(&mc.seq)
+	}
+	if restorer, ok := mc.evictionSink.(Loader); ok && mc.loader == nil {
+		mc.loader = restorer
+	}
+	if mc.walPath != "" {
+		if err := mc.openWAL(mc.walPath); err != nil {
+			mc.logger.Error("minervacache: running without the write-ahead log", "path", mc.walPath, "err", err)
+			mc.closeWAL()
+			mc.wal = nil
+		} else {
+			mc.startWALCompaction()
+		}
+	}
+	// Start the TTL check (maybe in a separate goroutine?)
+	mc.startTTLCheck()
+	mc.startWriteBehind()
+
+	return mc
+}
+
+// shardFor returns the shard holding the given key of the bucket.
+func (mc *MinervaCache) shardFor(bucket, key string) *shard {
+	return mc.shards[mc.shardIndex(bucket, key)]
+}
+
+// shardIndex returns the index of the shard holding the given key of the bucket.
+func (mc *MinervaCache) shardIndex(bucket, key string) int {
+	return int(mc.shardHash(bucket, key) % uint64(len(mc.shards)))
+}
+
+// lockShards locks all the shard mutexes, in order, for the operations that need a consistent view of the whole cache.
+func (mc *MinervaCache) lockShards() {
+	for _, s := range mc.shards {
+		s.mutex.Lock()
+	}
+}
+
+// unlockShards unlocks all the shard mutexes locked by lockShards.
+func (mc *MinervaCache) unlockShards() {
+	for _, s := range mc.shards {
+		s.mutex.Unlock()
+	}
+}
+
+// Set sets the value for the given key in the specified bucket.
+// An error is returned if the operation fails.
+func (mc *MinervaCache) Set(bucket string, key string, value []byte, opts Options) error {
+	_, err := mc.set(context.Background(), bucket, key, value, opts)
+	return err
+}
+
+// SetCtx is like Set, but returns the context error instead of setting the key if the context is done before the
+// shard is locked or while evicting to make room for the key.
+func (mc *MinervaCache) SetCtx(ctx context.Context, bucket string, key string, value []byte, opts Options) error {
+	_, err := mc.set(ctx, bucket, key, value, opts)
+	return err
+}
+
+// SetWithResult is like SetCtx, but also reports whether the key was created or an existing one updated.
+func (mc *MinervaCache) SetWithResult(ctx context.Context, bucket string, key string, value []byte, opts Options) (SetResult, error) {
+	created, err := mc.set(ctx, bucket, key, value, opts)
+	return SetResult{Created: created}, err
+}
+
+// SetMulti sets all the given key-value pairs in the specified bucket.
+// The keys are set in lexicographic order so evictions triggered by the batch are deterministic.
+// An error is returned if setting any of the items fails. Items set before the failure are kept.
+func (mc *MinervaCache) SetMulti(bucket string, items map[string][]byte, opts Options) error {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, err := mc.set(context.Background(), bucket, key, items[key], opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// set sets the value for the given key in the specified bucket, and reports whether the key was created. Used in Set,
+// SetMulti and Increment.
+// It locks the shard of the key itself, and releases it while making room for a new key, since evicting may need to
+// lock any other shard.
+func (mc *MinervaCache) set(ctx context.Context, bucket string, key string, value []byte, opts Options) (bool, error) {
+	defer mc.observeLatency("set", time.Now())
+
+	if err := mc.checkNames(bucket, key); err != nil {
+		return false, err
+	}
+
+	// NB: If we were using options per method, maybe we should apply the options here and use some default values?
+	//options := Options{ EvictionPolicy: LRUEvictionPolicy }
+	//for _, opt := range opts {
+	//	if err := opt(&options); err != nil { return err }
+	//}
+
+	now := time.Now()
+	return mc.put(ctx, bucket, key, value, expiration(now, opts), now, opts, true)
+}
+
+// expiration returns the expiration time of a key set at now with the TTL of the options, zero for no TTL.
+func expiration(now time.Time, opts Options) time.Time {
+	if opts.TTL <= 0 {
+		return time.Time{}
+	}
+	return now.Add(opts.TTL - jitter(opts.TTL, opts.TTLJitter))
+}
+
+// put stores the value for the given key with an absolute expiration time, keeping the creation time of an existing
+// key or using createdAt for a new one. The value is mirrored to the writer of the cache if mirror is set, see
+// [WithWriter]. It reports whether the key was created, rather than an existing one updated.
+// Used in set, the read-through, LoadSnapshot and the replay of the write-ahead log.
+func (mc *MinervaCache) put(ctx context.Context, bucket, key string, value []byte, expiresAt, createdAt time.Time, opts Options, mirror bool) (bool, error) {
+	if mc.capacity.Load() <= 0 {
+		return false, ErrCacheFull // Nothing could ever be evicted to make room.
+	}
+	// Reject oversized values before anything is evicted or created for them.
+	if (mc.maxValueBytes > 0 && len(value) > mc.maxValueBytes) || (mc.maxBytes > 0 && int64(len(value)) > mc.maxBytes) {
+		return false, ErrValueTooLarge
+	}
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	defer mc.lockWAL()()
+	rec := walRecord{Op: walSet, Bucket: bucket, Key: key, Value: value, ExpiresAt: expiresAt, CreatedAt: createdAt}
+
+	s := mc.shardFor(bucket, key)
+	s.mutex.Lock()
+	done, err := mc.update(s, bucket, key, value, expiresAt, opts, mirror)
+	if done && err == nil {
+		mc.logWAL(rec) // Logged under the shard mutex, so the writes to a key are logged in the order they are applied.
+	}
+	s.mutex.Unlock()
+	if done {
+		mc.evictToMaxBytes(mc.policy(opts)) // The updated value may be larger.
+		return false, err
+	}
+
+	// The key is new, evict before inserting it if the bucket or the cache is full.
+	if err := mc.checkNewBucket(bucket); err != nil {
+		return false, err
+	}
+	if err := mc.reserve(ctx, bucket, int64(len(value)), mc.policy(opts)); err != nil {
+		return false, err
+	}
+
+	s.mutex.Lock()
+
+	// The key may have been set by another caller while the shard was unlocked.
+	done, err = mc.update(s, bucket, key, value, expiresAt, opts, mirror)
+	if !done && mirror {
+		err = mc.mirror(bucket, key, value)
+	}
+	if done || err != nil {
+		if done && err == nil {
+			mc.logWAL(rec) // Like the first update, so the replay ends with this value rather than the other caller's.
+		}
+		s.mutex.Unlock()
+		// Release the reserved slot and bytes.
+		mc.count.Add(-1)
+		mc.bytes.Add(-int64(len(value)))
+		if done {
+			mc.evictToMaxBytes(mc.policy(opts))
+		}
+		return false, err
+	}
+	defer s.mutex.Unlock()
+
+	// Create a new bucket item
+	if value == nil {
+		value = []byte{} // Get returns an empty slice for an empty value, as nil usually means a miss.
+	}
+	item := &cacheItem{
+		bucket:    bucket,
+		key:       key,
+		value:     value,
+		expiresAt: expiresAt,
+		createdAt: createdAt,
+		version:   1,
+		checksum:  mc.checksum(value),
+		grace:     mc.grace(opts),
+		heapIndex: -1,
+	}
+	mc.insert(s, item)
+	mc.logWAL(rec)
+	mc.publish(Event{Type: EventSet, Bucket: bucket, Key: key, Value: value})
+
+	mc.metrics.AddSet(bucket) // Track the set for new key action for metrics.
+	mc.stats.sets.Add(1)
+
+	return true, nil
+}
+
+// jitter returns a random duration in [0, maxJitter) to shorten the ttl by. It is capped below the ttl, so a key set
+// with a TTL always gets a positive one.
+func jitter(ttl, maxJitter time.Duration) time.Duration {
+	maxJitter = min(maxJitter, ttl)
+	if maxJitter <= 0 {
+		return 0
+	}
+	return rand.N(maxJitter)
+}
+
+// update applies the set to the key if it already exists in the shard, or rejects it based on the set mode.
+// It reports whether the set was handled. If not, the key is new and must be inserted. The value is mirrored to the
+// writer before it is applied if mirror is set, and not applied if that fails.
+// Must be called with the shard mutex locked in the caller.
+func (mc *MinervaCache) update(s *shard, bucket, key string, value []byte, expiresAt time.Time, opts Options, mirror bool) (bool, error) {
+	// An expired key that has not been collected yet is treated as absent, so a set-if-absent can take it over.
+	if el, ok := s.buckets[bucket][key]; ok && el.Value.(*cacheItem).expired(time.Now()) {
+		mc.expireInline(s, el)
+	}
+
+	// Check if the key already exists
+	el, exists := s.buckets[bucket][key]
+	switch {
+	case exists && opts.SetMode == SetIfAbsent:
+		return true, ErrKeyExists
+	case !exists && opts.SetMode == SetIfPresent:
+		return true, ErrKeyNotFound
+	case !exists:
+		return false, nil
+	}
+
+	if mirror {
+		if err := mc.mirror(bucket, key, value); err != nil {
+			return true, err
+		}
+	}
+
+	// Update existing key in place, so its access frequency is kept.
+	item := el.Value.(*cacheItem)
+	mc.setValue(item, value)
+	item.expiresAt = expiresAt
+	item.grace = mc.grace(opts)
+	mc.trackExpiry(s, el) // The TTL may have been added, changed or removed.
+	mc.touch(s, el, mc.policy(opts))
+	mc.publish(Event{Type: EventSet, Bucket: bucket, Key: key, Value: value})
+
+	mc.metrics.AddSetExists(bucket) // Track the set for existing key action for metrics.
+	mc.stats.sets.Add(1)
+
+	return true, nil
+}
+
+// setValue replaces the value of an existing item and increments its version, keeping the total size of the values
+// up to date. Must be called with the shard mutex locked in the caller.
+func (mc *MinervaCache) setValue(item *cacheItem, value []byte) {
+	if value == nil {
+		value = []byte{}
+	}
+	mc.bytes.Add(int64(len(value) - len(item.value)))
+	item.value = value
+	item.checksum = mc.checksum(value)
+	item.version++
+}
+
+// checksum returns the checksum of the value to store on its item, or 0 if the cache has no checksums.
+func (mc *MinervaCache) checksum(value []byte) uint32 {
+	if !mc.checksums {
+		return 0
+	}
+	return crc32.ChecksumIEEE(value)
+}
+
+// checkNewBucket returns ErrTooManyBuckets if the bucket doesn't exist and the cache already holds the maximum number
+// of buckets, see [WithMaxBuckets]. Like the bucket capacity, it is checked before inserting, so concurrent sets
+// creating different buckets may briefly exceed it.
+func (mc *MinervaCache) checkNewBucket(bucket string) error {
+	if mc.maxBuckets <= 0 {
+		return nil
+	}
+
+	mc.bucketsMutex.Lock()
+	defer mc.bucketsMutex.Unlock()
+	if _, ok := mc.bucketSizes[bucket]; !ok && len(mc.bucketSizes) >= mc.maxBuckets {
+		return ErrTooManyBuckets
+	}
+	return nil
+}
+
+// reserve makes room for a new key in the bucket and reserves a slot and the size of its value for it.
+// It evicts within the bucket first if it is full, so other buckets are left untouched, then from the whole cache if
+// it is full or out of bytes. The context is checked before each eviction, and nothing is reserved if it is done.
+// With NoEvictionPolicy nothing is evicted, and ErrCacheFull is returned instead if there is no room.
+// Must be called without any shard mutex locked.
+func (mc *MinervaCache) reserve(ctx context.Context, bucket string, size int64, policy EvictionPolicy) error {
+	if mc.bucketCapacity > 0 {
+		inBucket := func(item *cacheItem) bool { return item.bucket == bucket }
+		for mc.bucketLen(bucket) >= mc.bucketCapacity {
+			if policy == NoEvictionPolicy {
+				return ErrCacheFull
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !mc.evict(policy, inBucket) {
+				break
+			}
+		}
+	}
+
+	for {
+		n := mc.count.Load()
+		if n < mc.capacity.Load() {
+			if mc.count.CompareAndSwap(n, n+1) {
+				break
+			}
+			continue
+		}
+		if policy == NoEvictionPolicy {
+			return ErrCacheFull
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Evict based on policy. Nothing is evictable if the cache is only full of slots reserved by other inserts,
+		// so let them complete and evict their items instead.
+		if !mc.evict(policy, nil) {
+			runtime.Gosched()
+		}
+	}
+
+	for {
+		n := mc.bytes.Load()
+		if mc.maxBytes == 0 || n+size <= mc.maxBytes {
+			if mc.bytes.CompareAndSwap(n, n+size) {
+				return nil
+			}
+			continue
+		}
+		if policy == NoEvictionPolicy {
+			mc.count.Add(-1) // Release the reserved slot.
+			return ErrCacheFull
+		}
+		if err := ctx.Err(); err != nil {
+			mc.count.Add(-1) // Release the reserved slot.
+			return err
+		}
+		// Evict repeatedly until the value fits.
+		if !mc.evict(policy, nil) {
+			runtime.Gosched()
+		}
+	}
+}
+
+// evictToMaxBytes evicts based on the policy until the total size of the values fits within maxBytes again, after an
+// existing value grew. Must be called without any shard mutex locked.
+func (mc *MinervaCache) evictToMaxBytes(policy EvictionPolicy) {
+	for mc.maxBytes > 0 && mc.bytes.Load() > mc.maxBytes && mc.evict(policy, nil) {
+	}
+}
+
+// insert adds the new item to its shard and bucket. Its slot must have been reserved in the cache count.
+// Must be called with the shard mutex locked in the caller.
+func (mc *MinervaCache) insert(s *shard, item *cacheItem) {
+	// Get or Create bucket if it doesn't exist.
+	mcb := s.getBucket(item.bucket)
+
+	// Add the new item to the bucket and update insertion order list
+	el := s.pushBack(item)
+	mcb[item.key] = el // Store the element in the bucket map
+	delete(s.negatives, negativeID(item.bucket, item.key))
+	s.freqs.add(el)
+	mc.trackExpiry(s, el)
+
+	mc.bucketsMutex.Lock()
+	if mc.bucketSizes[item.bucket]++; mc.bucketSizes[item.bucket] == 1 {
+		mc.bucketCount.Add(1)
+	}
+	mc.bucketsMutex.Unlock()
+	mc.items.Add(1)
+
+	mc.reportSize() // Keep the size metrics up to date on every insert.
+}
+
+// policy returns the eviction policy of the operation, NoEvictionPolicy only if it is set not to evict, otherwise its
+// own policy or the default policy of the cache, falling back to evicting the oldest key if neither is set.
+func (mc *MinervaCache) policy(opts Options) EvictionPolicy {
+	switch {
+	case opts.NoEviction:
+		return NoEvictionPolicy
+	case opts.EvictionPolicy != NoEvictionPolicy:
+		return opts.EvictionPolicy
+	case mc.defaultPolicy != NoEvictionPolicy:
+		return mc.defaultPolicy
+	default:
+		return OldestEvictionPolicy
+	}
+}
+
+// touch records an access to the item: it is moved to the back of the order list for LRU/MRU policies,
+// and its access frequency is incremented for the LFU policy.
+// Must be called with the shard mutex locked in the caller.
+func (mc *MinervaCache) touch(s *shard, el *list.Element, policy EvictionPolicy) {
+	// Update the last access time for LRU/MRU policies.
+	if policy == LRUEvictionPolicy || policy == MRUEvictionPolicy {
+		s.moveToBack(el) // Move the element to the back of the list since it was accessed.
+	}
+	// Count the access for the LFU policy. It is updated on every access regardless of the policy of the operation,
+	// since any later Set may evict with LFU.
+	s.freqs.touch(el)
+}
+
+// Get retrieves the value for the given key in the specified bucket.
+// An error is returned if the operation fails.
+func (mc *MinervaCache) Get(bucket string, key string, opts Options) ([]byte, error) {
+	return mc.GetCtx(context.Background(), bucket, key, opts)
+}
+
+// GetCtx is like Get, but returns the context error instead of getting the key if the context is done before the
+// shard is locked.
+func (mc *MinervaCache) GetCtx(ctx context.Context, bucket string, key string, opts Options) ([]byte, error) {
+	value, _, err := mc.GetWithMetaCtx(ctx, bucket, key, opts)
+	return value, err
+}
+
+// GetWithMeta retrieves the value for the given key in the specified bucket along with its metadata.
+// It behaves like Get, including the access tracking for the eviction policies.
+func (mc *MinervaCache) GetWithMeta(bucket string, key string, opts Options) ([]byte, ItemMeta, error) {
+	return mc.GetWithMetaCtx(context.Background(), bucket, key, opts)
+}
+
+// GetWithMetaCtx is like GetWithMeta, but returns the context error instead of getting the key if the context is
+// done before the shard is locked.
+func (mc *MinervaCache) GetWithMetaCtx(ctx context.Context, bucket string, key string, opts Options) ([]byte, ItemMeta, error) {
+	defer mc.observeLatency("get", time.Now())
+
+	if err := ctx.Err(); err != nil {
+		return nil, ItemMeta{}, err
+	}
+	if err := mc.checkNames(bucket, key); err != nil {
+		return nil, ItemMeta{}, err
+	}
+
+	value, meta, err := mc.getWithMeta(bucket, key, opts)
+	if mc.loader != nil && isMiss(err) {
+		return mc.readThrough(bucket, key, opts)
+	}
+	return value, meta, err
+}
+
+// getWithMeta retrieves the value and metadata of the key without reading through the loader.
+func (mc *MinervaCache) getWithMeta(bucket string, key string, opts Options) ([]byte, ItemMeta, error) {
+	s := mc.shardFor(bucket, key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	item, err := mc.get(s, bucket, key, opts)
+	if err != nil {
+		return nil, ItemMeta{}, err
+	}
+
+	return item.value, item.meta(time.Now()), nil
+}
+
+// checkNames checks the bucket and key names with the validator of the cache, if any, see [WithNameValidator].
+// Any number of keys can be checked, e.g. none for an operation on the whole bucket or all those of a batch.
+func (mc *MinervaCache) checkNames(bucket string, keys ...string) error {
+	if mc.validateName == nil {
+		return nil
+	}
+	if err := mc.validateName(bucket); err != nil {
+		return fmt.Errorf("%w: bucket %q: %v", ErrInvalidName, bucket, err)
+	}
+	for _, key := range keys {
+		if err := mc.validateName(key); err != nil {
+			return fmt.Errorf("%w: key %q: %v", ErrInvalidName, key, err)
+		}
+	}
+	return nil
+}
+
+// observeLatency reports the time since start as the latency of the operation, deferred at the start of it.
+func (mc *MinervaCache) observeLatency(op string, start time.Time) {
+	mc.metrics.ObserveLatency(op, time.Since(start))
+}
+
+// isMiss reports whether the error of a read is a miss, i.e. the key is missing or expired.
+func isMiss(err error) bool {
+	return errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrBucketNotFound) || errors.Is(err, ErrKeyExpired)
+}
+
+// readThrough loads the missed key with the loader of the cache and sets it with the TTL returned by the loader.
+// The metadata is empty if the key was evicted right after being set.
+func (mc *MinervaCache) readThrough(bucket string, key string, opts Options) ([]byte, ItemMeta, error) {
+	value, err := mc.loadOnce(bucket, key, opts, false, func() ([]byte, time.Duration, error) {
+		return mc.load(bucket, key)
+	})
+	if err != nil {
+		if mc.negativeTTL > 0 && errors.Is(err, ErrKeyNotFound) {
+			mc.addNegative(bucket, key, time.Now().Add(mc.negativeTTL))
+		}
+		return nil, ItemMeta{}, err
+	}
+
+	s := mc.shardFor(bucket, key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if el, ok := s.buckets[bucket][key]; ok {
+		return value, el.Value.(*cacheItem).meta(time.Now()), nil
+	}
+	return value, ItemMeta{}, nil
+}
+
+// load loads the key with the loader of the cache, through its circuit breaker if any, see [WithLoaderBreaker].
+func (mc *MinervaCache) load(bucket, key string) ([]byte, time.Duration, error) {
+	if mc.loaderBreaker == nil {
+		return mc.loader.Load(bucket, key)
+	}
+	if !mc.loaderBreaker.allow(time.Now()) {
+		return nil, 0, ErrLoaderUnavailable
+	}
+	value, ttl, err := mc.loader.Load(bucket, key)
+	mc.loaderBreaker.record(err, time.Now())
+	return value, ttl, err
+}
+
+// grace returns the grace period of the stale values of a key set with the options, 0 without a loader to refresh
+// them, see [Options.StaleWhileRevalidate].
+func (mc *MinervaCache) grace(opts Options) time.Duration {
+	if mc.loader == nil || opts.StaleWhileRevalidate < 0 {
+		return 0
+	}
+	return opts.StaleWhileRevalidate
+}
+
+// revalidate refreshes the stale item with the loader in the background, unless a refresh is already running. The
+// refreshed value replaces the item with the same grace period. If the loader doesn't find the key anymore, the stale
+// item is removed, and if it fails, the next Get retries.
+// Must be called with the shard mutex locked in the caller.
+func (mc *MinervaCache) revalidate(s *shard, item *cacheItem) {
+	if item.refreshing {
+		return
+	}
+	item.refreshing = true
+
+	bucket, key := item.bucket, item.key
+	opts := Options{StaleWhileRevalidate: item.grace}
+	go func() {
+		_, err := mc.loadOnce(bucket, key, opts, false, func() ([]byte, time.Duration, error) {
+			return mc.load(bucket, key)
+		})
+		if err == nil {
+			return
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		el, ok := s.buckets[bucket][key]
+		if !ok || el.Value.(*cacheItem) != item {
+			return // Already replaced or removed.
+		}
+		if errors.Is(err, ErrKeyNotFound) {
+			mc.expireInline(s, el)
+			return
+		}
+		item.refreshing = false
+		mc.logger.Error("minervacache: failed to refresh the stale key", "bucket", bucket, "key", key, "err", err)
+	}()
+}
+
+// SetNegative caches the key of the bucket as missing for the ttl: Get, GetWithMeta (and their Ctx variants) and
+// GetOrSet return ErrNegativeCached for it instead of calling a loader, until the ttl passes or the key is set. An
+// existing value of the key is deleted. The tombstones are not counted in the capacity, and are not persisted in the
+// snapshots or the write-ahead log. ErrInvalidTTL is returned if the ttl is not positive.
+func (mc *MinervaCache) SetNegative(bucket, key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	if err := mc.checkNames(bucket, key); err != nil {
+		return err
+	}
+	defer mc.lockWAL()()
+
+	s := mc.shardFor(bucket, key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if el, ok := s.buckets[bucket][key]; ok {
+		mc.metrics.AddDelete(bucket)
+		mc.stats.deletes.Add(1)
+		mc.deleteAndRemoveFromInsertOrder(s, el)
+		mc.logWAL(walRecord{Op: walDelete, Bucket: bucket, Key: key})
+		mc.publish(Event{Type: EventDelete, Bucket: bucket, Key: key})
+	}
+	s.negatives[negativeID(bucket, key)] = time.Now().Add(ttl)
+	return nil
+}
+
+// addNegative caches the key as missing until expiresAt if it is still missing, after the loader didn't find it.
+func (mc *MinervaCache) addNegative(bucket, key string, expiresAt time.Time) {
+	s := mc.shardFor(bucket, key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.buckets[bucket][key]; !ok {
+		s.negatives[negativeID(bucket, key)] = expiresAt
+	}
+}
+
+// Exists reports whether the key is in the bucket and not expired. Unlike Get, the key is not tracked as accessed and
+// the check is not counted as a hit or a miss, so it doesn't change the eviction order. An expired key is left for
+// the background TTL check to remove.
+// ErrBucketNotFound is returned if the bucket does not exist.
+func (mc *MinervaCache) Exists(bucket string, key string) (bool, error) {
+	if err := mc.checkNames(bucket, key); err != nil {
+		return false, err
+	}
+	if _, ok := mc.lookup(bucket, key); ok {
+		return true, nil
+	}
+	if !mc.hasBucket(bucket) {
+		return false, ErrBucketNotFound
+	}
+	return false, nil
+}
+
+// GetOrSet retrieves the value for the given key in the specified bucket, or calls the loader and sets its value
+// if the key is missing or expired. Concurrent callers for the same missing key share a single loader call and its
+// result. Loader errors are returned to all of them without being cached, so the next call loads again.
+// The loader of the cache, see [WithLoader], is not used.
+func (mc *MinervaCache) GetOrSet(bucket string, key string, opts Options, loader func() ([]byte, error)) ([]byte, error) {
+	if err := mc.checkNames(bucket, key); err != nil {
+		return nil, err
+	}
+	if value, _, err := mc.getWithMeta(bucket, key, opts); err == nil || errors.Is(err, ErrNegativeCached) {
+		return value, err
+	}
+
+	return mc.loadOnce(bucket, key, opts, true, func() ([]byte, time.Duration, error) {
+		value, err := loader()
+		return value, opts.TTL, err
+	})
+}
+
+// GetOrDefault retrieves the value for the given key in the specified bucket, or sets it to def with the options if the
+// key is missing or expired, and returns def. The default is set only if the key is still absent, like a set with
+// SetIfAbsent, so concurrent callers of the same missing key all return the value of the first one to set it, and it
+// never overwrites a key set in between. Unlike GetOrSet, a key cached as missing is set to the default.
+func (mc *MinervaCache) GetOrDefault(bucket string, key string, def []byte, opts Options) ([]byte, error) {
+	if err := mc.checkNames(bucket, key); err != nil {
+		return nil, err
+	}
+
+	opts.SetMode = SetIfAbsent
+	for {
+		value, _, err := mc.getWithMeta(bucket, key, opts)
+		if !isMiss(err) && !errors.Is(err, ErrNegativeCached) {
+			return value, err
+		}
+
+		now := time.Now()
+		_, err = mc.put(context.Background(), bucket, key, def, expiration(now, opts), now, opts, true)
+		switch {
+		case err == nil:
+			if def == nil {
+				def = []byte{} // As stored, see put.
+			}
+			return def, nil
+		case !errors.Is(err, ErrKeyExists):
+			return nil, err
+		}
+		// Set by another caller since the miss, get its value instead. It may be gone again by then, in which case
+		// the default is set again.
+	}
+}
+
+// loadOnce calls the loader and sets the loaded value with the returned TTL, unless another call is already loading
+// the key, in which case it waits for its result instead. The value is mirrored to the writer if mirror is set.
+// Used in GetOrSet and the read-through of the missed keys.
+func (mc *MinervaCache) loadOnce(bucket string, key string, opts Options, mirror bool, loader func() ([]byte, time.Duration, error)) ([]byte, error) {
+	id := bucket + "\x00" + key
+	mc.loadsMutex.Lock()
+	if l, ok := mc.loads[id]; ok {
+		mc.loadsMutex.Unlock()
+		l.done.Wait()
+		return l.value, l.err
+	}
+	l := &load{}
+	l.done.Add(1)
+	mc.loads[id] = l
+	mc.loadsMutex.Unlock()
+
+	defer func() {
+		mc.loadsMutex.Lock()
+		delete(mc.loads, id)
+		mc.loadsMutex.Unlock()
+		l.done.Done()
+	}()
+
+	// Another load may have completed between the miss and registering this one.
+	if value, ok := mc.lookup(bucket, key); ok {
+		l.value = value
+		return value, nil
+	}
+
+	l.value, opts.TTL, l.err = loader()
+	if l.err != nil {
+		l.value = nil
+		return nil, l.err
+	}
+	if mirror {
+		_, l.err = mc.set(context.Background(), bucket, key, l.value, opts)
+	} else {
+		now := time.Now()
+		_, l.err = mc.put(context.Background(), bucket, key, l.value, expiration(now, opts), now, opts, false)
+	}
+	if l.err != nil {
+		l.value = nil
+		return nil, l.err
+	}
+
+	return l.value, nil
+}
+
+// lookup returns the value of the key if it is in the cache and not expired, without tracking the access.
+func (mc *MinervaCache) lookup(bucket string, key string) ([]byte, bool) {
+	s := mc.shardFor(bucket, key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	el, ok := s.buckets[bucket][key]
+	if !ok || el.Value.(*cacheItem).expired(time.Now()) {
+		return nil, false
+	}
+	return el.Value.(*cacheItem).value, true
+}
+
+// GetMulti retrieves the values for the given keys in the specified bucket, acquiring the mutex of each shard once
+// for all the keys of the batch it holds.
+// Missing or expired keys are simply absent from the returned map rather than failing the whole batch.
+func (mc *MinervaCache) GetMulti(bucket string, keys []string, opts Options) (map[string][]byte, error) {
+	if err := mc.checkNames(bucket, keys...); err != nil {
+		return nil, err
+	}
+
+	// Group the keys by shard.
+	byShard := make(map[int][]string)
+	for _, key := range keys {
+		i := mc.shardIndex(bucket, key)
+		byShard[i] = append(byShard[i], key)
+	}
+
+	values := make(map[string][]byte, len(keys))
+	for i, shardKeys := range byShard {
+		s := mc.shards[i]
+		s.mutex.Lock()
+		for _, key := range shardKeys {
+			item, err := mc.get(s, bucket, key, opts)
+			if err != nil {
+				continue // Misses are tracked in get and left out of the result.
+			}
+			values[key] = item.value
+		}
+		s.mutex.Unlock()
+	}
+
+	return values, nil
+}
+
+// get retrieves the item for the given key in the specified bucket. Used in Get, GetWithMeta and GetMulti.
+// Must be called with the shard mutex locked in the caller.
+func (mc *MinervaCache) get(s *shard, bucket string, key string, opts Options) (*cacheItem, error) {
+	// Check if the key exists in the bucket
+	el, ok := s.buckets[bucket][key]
+	if !ok {
+		mc.metrics.AddMiss(bucket)
+		mc.metrics.AddNotFound(bucket)
+		mc.stats.misses.Add(1)
+		if expiresAt, ok := s.negatives[negativeID(bucket, key)]; ok {
+			if time.Now().Before(expiresAt) {
+				return nil, ErrNegativeCached
+			}
+			delete(s.negatives, negativeID(bucket, key))
+		}
+		// Check if the bucket exists, it may have keys in other shards.
+		if !mc.hasBucket(bucket) {
+			return nil, ErrBucketNotFound
+		}
+		return nil, ErrKeyNotFound
+	}
+
+	// Check if the item is expired. This is an inline check for expired items. Always check for expired items in Get.
+	item := el.Value.(*cacheItem)
+	if now := time.Now(); item.stale(now) {
+		mc.revalidate(s, item) // Serve the stale value while it is refreshed.
+	} else if item.expired(now) {
+		mc.expireInline(s, el)
+		mc.metrics.AddMiss(bucket)
+		mc.stats.misses.Add(1)
+		return nil, ErrKeyExpired
+	}
+
+	if mc.checksums && crc32.ChecksumIEEE(item.value) != item.checksum {
+		return nil, fmt.Errorf("%w: %s/%s", ErrChecksumMismatch, bucket, key)
+	}
+
+	mc.touch(s, el, mc.policy(opts))
+
+	mc.metrics.AddHit(bucket) // Track the hit action for metrics.
+	mc.stats.hits.Add(1)
+	return item, nil
 }
 
-func NewMinervaCache(capacity int, ttlCheckInterval time.Duration, metrics MetricsHandler) *MinervaCache {
-	mc := &MinervaCache{
-		capacity:         capacity,
-		ttlCheckInterval: ttlCheckInterval,
-		stop:             make(chan struct{}),
-		buckets:          make(map[string]map[string]*list.Element),
-		order:            list.New(),
-		metrics:          metrics,
+// expireInline removes an expired item found by an operation before the background TTL check collected it.
+// Must be called with the shard mutex locked in the caller.
+func (mc *MinervaCache) expireInline(s *shard, el *list.Element) {
+	item := el.Value.(*cacheItem)
+	mc.deleteAndRemoveFromInsertOrder(s, el)
+	mc.metrics.AddExpire(true) // Track the expiration of item and its inline check for metrics.
+	mc.stats.expires.Add(1)
+	mc.publish(Event{Type: EventExpire, Bucket: item.bucket, Key: item.key})
+}
+
+// Increment adds delta to the integer value stored for the given key in the specified bucket and returns the new value.
+// The read-modify-write happens under the shard mutex, so concurrent increments don't lose updates.
+// A missing (or expired) key is initialized to delta using the options. An existing key keeps its TTL.
+// ErrNotInteger is returned if the stored value is not a base-10 integer, and ErrOverflow if the result overflows.
+func (mc *MinervaCache) Increment(bucket string, key string, delta int64, opts Options) (int64, error) {
+	if err := mc.checkNames(bucket, key); err != nil {
+		return 0, err
 	}
-	// Start the TTL check (maybe in a separate goroutine?)
-	mc.startTTLCheck()
 
-	return mc
+	for {
+		value, ok, err := mc.increment(bucket, key, delta, opts)
+		if ok {
+			mc.evictToMaxBytes(mc.policy(opts)) // The new value may have more digits.
+		}
+		if ok || err != nil {
+			return value, err
+		}
+
+		// Initialize the counter. An expired item is replaced by set. The set only applies if the key is still
+		// absent, otherwise a concurrent increment initialized it first and we retry to add to its value.
+		initOpts := opts
+		initOpts.SetMode = SetIfAbsent
+		_, err = mc.set(context.Background(), bucket, key, []byte(strconv.FormatInt(delta, 10)), initOpts)
+		switch {
+		case err == nil:
+			return delta, nil
+		case !errors.Is(err, ErrKeyExists):
+			return 0, err
+		}
+	}
 }
 
-// Set sets the value for the given key in the specified bucket.
-// An error is returned if the operation fails.
-func (mc *MinervaCache) Set(bucket string, key string, value []byte, opts Options) error {
-	mc.mutex.Lock()
-	defer mc.mutex.Unlock()
+// increment adds delta to the value of the key if it exists, reporting whether it did.
+func (mc *MinervaCache) increment(bucket string, key string, delta int64, opts Options) (int64, bool, error) {
+	defer mc.lockWAL()()
 
-	// NB: If we were using options per method, maybe we should apply the options here and use some default values?
-	//options := Options{ EvictionPolicy: LRUEvictionPolicy }
-	//for _, opt := range opts {
-	//	if err := opt(&options); err != nil { return err }
-	//}
+	s := mc.shardFor(bucket, key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	// Get or Create bucket if it doesn't exist
-	mcb := mc.getBucket(bucket)
+	el, ok := s.buckets[bucket][key]
+	if !ok || el.Value.(*cacheItem).expired(time.Now()) {
+		if opts.SetMode == SetIfPresent {
+			return 0, false, ErrKeyNotFound
+		}
+		return 0, false, nil
+	}
 
-	// Create a new bucket item
-	expiresAt := time.Time{}
-	if opts.TTL > 0 { // If TTL is set, calculate the expiration time.
-		expiresAt = time.Now().Add(opts.TTL)
+	item := el.Value.(*cacheItem)
+	current, err := strconv.ParseInt(string(item.value), 10, 64)
+	if err != nil {
+		return 0, false, ErrNotInteger
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, false, ErrOverflow
 	}
 
-	item := &cacheItem{
-		bucket:    bucket,
-		key:       key,
-		value:     value,
-		expiresAt: expiresAt,
+	// Update the value in place to keep the existing TTL.
+	current += delta
+	value := []byte(strconv.FormatInt(current, 10))
+	if err := mc.mirror(bucket, key, value); err != nil {
+		return 0, false, err
 	}
+	mc.setValue(item, value)
+	mc.logWAL(walRecord{Op: walSet, Bucket: bucket, Key: key, Value: item.value, ExpiresAt: item.expiresAt, CreatedAt: item.createdAt})
+	mc.publish(Event{Type: EventSet, Bucket: bucket, Key: key, Value: item.value})
+	mc.touch(s, el, mc.policy(opts))
+	mc.metrics.AddSetExists(bucket)
+	mc.stats.sets.Add(1)
 
-	// Check if the key already exists
-	if el, ok := mcb[key]; ok {
-		// Update existing key
-		el.Value = item
+	return current, true, nil
+}
+
+// CompareAndSwap replaces the value of the key in the bucket with newValue only if its current value is equal to
+// oldValue, and reports whether it did. The key keeps its TTL unless opts.TTL is set, like with Set.
+// ErrKeyNotFound is returned if the key doesn't exist, ErrBucketNotFound if the bucket doesn't, and ErrKeyExpired if
+// the key expired.
+func (mc *MinervaCache) CompareAndSwap(bucket, key string, oldValue, newValue []byte, opts Options) (bool, error) {
+	return mc.swap(bucket, key, newValue, opts, func(item *cacheItem) bool { return bytes.Equal(item.value, oldValue) })
+}
 
-		// Update the access time for LRU/MRU policies.
-		if opts.EvictionPolicy == LRUEvictionPolicy || opts.EvictionPolicy == MRUEvictionPolicy {
-			mc.order.MoveToBack(el) // Move the element to the back of the list since it was accessed.
+// SetWithVersion sets the value of the key in the bucket only if its current version, as returned in ItemMeta, is
+// expectedVersion. An expectedVersion of 0 only sets the key if it doesn't exist, like SetIfAbsent. An existing key
+// keeps its TTL unless opts.TTL is set, like with CompareAndSwap.
+// ErrVersionMismatch is returned if the key has another version, or doesn't exist while a version is expected.
+func (mc *MinervaCache) SetWithVersion(bucket, key string, value []byte, expectedVersion uint64, opts Options) error {
+	if expectedVersion == 0 {
+		opts.SetMode = SetIfAbsent
+		if _, err := mc.set(context.Background(), bucket, key, value, opts); !errors.Is(err, ErrKeyExists) {
+			return err
 		}
+		return ErrVersionMismatch
+	}
 
-		mc.metrics.AddSetExists() // Track the set for existing key action for metrics.
+	swapped, err := mc.swap(bucket, key, value, opts, func(item *cacheItem) bool { return item.version == expectedVersion })
+	switch {
+	case errors.Is(err, ErrKeyNotFound), errors.Is(err, ErrBucketNotFound), errors.Is(err, ErrKeyExpired):
+		return ErrVersionMismatch // A missing key has no version to match.
+	case err != nil:
+		return err
+	case !swapped:
+		return ErrVersionMismatch
+	}
+	return nil
+}
 
-		return nil
+// swap replaces the value of the existing key with value if match reports true for its item, and reports whether it
+// did. The key keeps its TTL unless opts.TTL is set. Used in CompareAndSwap and SetWithVersion.
+func (mc *MinervaCache) swap(bucket, key string, value []byte, opts Options, match func(item *cacheItem) bool) (bool, error) {
+	if err := mc.checkNames(bucket, key); err != nil {
+		return false, err
 	}
+	if (mc.maxValueBytes > 0 && len(value) > mc.maxValueBytes) || (mc.maxBytes > 0 && int64(len(value)) > mc.maxBytes) {
+		return false, ErrValueTooLarge
+	}
+	defer mc.lockWAL()()
 
-	// Evict before inserting new key if the cache is full
-	if mc.order.Len() >= mc.capacity {
-		// Evict based on policy
-		mc.evict(opts.EvictionPolicy)
+	s := mc.shardFor(bucket, key)
+	s.mutex.Lock()
+	el, ok := s.buckets[bucket][key]
+	if !ok {
+		s.mutex.Unlock()
+		if !mc.hasBucket(bucket) {
+			return false, ErrBucketNotFound
+		}
+		return false, ErrKeyNotFound
+	}
+	item := el.Value.(*cacheItem)
+	if item.expired(time.Now()) {
+		mc.expireInline(s, el)
+		s.mutex.Unlock()
+		return false, ErrKeyExpired
+	}
+	if !match(item) {
+		s.mutex.Unlock()
+		return false, nil
 	}
 
-	// Add the new item to the bucket and update insertion order list
-	el := mc.order.PushBack(item)
-	mcb[key] = el // Store the element in the bucket map
+	expiresAt := item.expiresAt
+	if opts.TTL > 0 {
+		expiresAt = expiration(time.Now(), opts)
+	}
+	opts.SetMode = SetIfPresent
+	if _, err := mc.update(s, bucket, key, value, expiresAt, opts, true); err != nil {
+		s.mutex.Unlock()
+		return false, err
+	}
+	mc.logWAL(walRecord{Op: walSet, Bucket: bucket, Key: key, Value: value, ExpiresAt: expiresAt, CreatedAt: item.createdAt})
+	s.mutex.Unlock()
 
-	return nil
+	mc.evictToMaxBytes(mc.policy(opts)) // The new value may be larger.
+	return true, nil
 }
 
-// Get retrieves the value for the given key in the specified bucket.
-// An error is returned if the operation fails.
-func (mc *MinervaCache) Get(bucket string, key string, opts Options) ([]byte, error) {
-	mc.mutex.Lock()
-	defer mc.mutex.Unlock()
+// Decrement subtracts delta from the integer value stored for the given key in the specified bucket and returns the
+// new value. It behaves like Increment with a negated delta.
+func (mc *MinervaCache) Decrement(bucket string, key string, delta int64, opts Options) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, ErrOverflow // Can't be negated.
+	}
+	return mc.Increment(bucket, key, -delta, opts)
+}
 
-	// The Get method is expected to use the Oldest eviction policy if the cache is full.
-	// TODO: Should we really be overriding the eviction policy in the options here when the capacity is full?
-	if mc.order.Len() >= mc.capacity {
-		mc.evict(OldestEvictionPolicy)
+// Persist removes the TTL of the key in the specified bucket, so it no longer expires. The value is left untouched,
+// and the key is not tracked as accessed. Persisting a key without a TTL does nothing.
+// ErrBucketNotFound or ErrKeyNotFound is returned if the key does not exist, and ErrKeyExpired if it already expired.
+func (mc *MinervaCache) Persist(bucket string, key string) error {
+	if err := mc.checkNames(bucket, key); err != nil {
+		return err
 	}
+	defer mc.lockWAL()()
+
+	s := mc.shardFor(bucket, key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	// Check if the bucket exists
-	mcb, ok := mc.buckets[bucket]
+	el, ok := s.buckets[bucket][key]
 	if !ok {
-		mc.metrics.AddMiss()
-		mc.metrics.AddNotFound()
-		return nil, ErrBucketNotFound
+		if !mc.hasBucket(bucket) {
+			return ErrBucketNotFound
+		}
+		return ErrKeyNotFound
 	}
 
-	// Check if the key exists in the bucket
-	el, ok := mcb[key]
+	item := el.Value.(*cacheItem)
+	if item.expired(time.Now()) {
+		mc.expireInline(s, el)
+		return ErrKeyExpired
+	}
+	if item.expiresAt.IsZero() {
+		return nil
+	}
+
+	item.expiresAt = time.Time{}
+	mc.trackExpiry(s, el) // Removes it from the heap.
+	mc.logWAL(walRecord{Op: walSet, Bucket: bucket, Key: key, Value: item.value, CreatedAt: item.createdAt})
+
+	return nil
+}
+
+// Touch sets the TTL of the key in the specified bucket, so it expires after ttl from now, replacing any current TTL.
+// Like Persist, the value and version are left untouched, and the key is not tracked as accessed, so its position for
+// the eviction policies doesn't change. ErrInvalidTTL is returned if the ttl is not positive, ErrBucketNotFound or
+// ErrKeyNotFound if the key does not exist, and ErrKeyExpired if it already expired.
+func (mc *MinervaCache) Touch(bucket string, key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	if err := mc.checkNames(bucket, key); err != nil {
+		return err
+	}
+	defer mc.lockWAL()()
+
+	s := mc.shardFor(bucket, key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	el, ok := s.buckets[bucket][key]
 	if !ok {
-		mc.metrics.AddMiss()
-		mc.metrics.AddNotFound()
-		return nil, ErrKeyNotFound
+		if !mc.hasBucket(bucket) {
+			return ErrBucketNotFound
+		}
+		return ErrKeyNotFound
 	}
 
-	// Check if the item is expired. This is an inline check for expired items. Always check for expired items in Get.
 	item := el.Value.(*cacheItem)
-	if !item.expiresAt.IsZero() && time.Now().After(item.expiresAt) {
-		mc.deleteAndRemoveFromInsertOrder(el)
-		mc.metrics.AddMiss()
-		mc.metrics.AddExpire(true) // Track the expiration of item and its inline check for metrics.
-		return nil, ErrKeyExpired
+	now := time.Now()
+	if item.expired(now) {
+		mc.expireInline(s, el)
+		return ErrKeyExpired
 	}
 
-	// Update the last access time for LRU/MRU policies.
-	if opts.EvictionPolicy == LRUEvictionPolicy || opts.EvictionPolicy == MRUEvictionPolicy {
-		mc.order.MoveToBack(el) // Move the element to the back of the list since it was accessed.
+	item.expiresAt = now.Add(ttl)
+	mc.trackExpiry(s, el)
+	mc.logWAL(walRecord{Op: walSet, Bucket: bucket, Key: key, Value: item.value, ExpiresAt: item.expiresAt, CreatedAt: item.createdAt})
+
+	return nil
+}
+
+// Move relocates the value of srcKey in srcBucket to dstKey in dstBucket, keeping its remaining TTL and creation time.
+// The shards of both keys are locked together, so no other operation can see the value under both keys or neither.
+// The moved key is ranked as newly inserted for the eviction policies. The source bucket is removed if it is empty.
+// ErrKeyExists is returned if the destination exists, unless overwrite is set to replace it, and ErrCacheFull if
+// the destination bucket is at its capacity, since nothing can be evicted while the shards are locked.
+// ErrTooManyBuckets is returned if the destination bucket would be created past the maximum number of buckets.
+// ErrBucketNotFound, ErrKeyNotFound or ErrKeyExpired is returned if the source key doesn't exist.
+func (mc *MinervaCache) Move(srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error {
+	if err := mc.checkNames(srcBucket, srcKey); err != nil {
+		return err
+	}
+	if err := mc.checkNames(dstBucket, dstKey); err != nil {
+		return err
+	}
+	if srcBucket == dstBucket && srcKey == dstKey {
+		// Nothing to move, only report whether the key exists.
+		if exists, err := mc.Exists(srcBucket, srcKey); err != nil || exists {
+			return err
+		}
+		return ErrKeyNotFound
+	}
+	defer mc.lockWAL()()
+
+	// Lock the shards in index order, like lockShards, so concurrent moves can't deadlock.
+	i, j := mc.shardIndex(srcBucket, srcKey), mc.shardIndex(dstBucket, dstKey)
+	src, dst := mc.shards[i], mc.shards[j]
+	first, second := src, dst
+	if j < i {
+		first, second = dst, src
+	}
+	first.mutex.Lock()
+	defer first.mutex.Unlock()
+	if second != first {
+		second.mutex.Lock()
+		defer second.mutex.Unlock()
+	}
+
+	now := time.Now()
+	el, ok := src.buckets[srcBucket][srcKey]
+	if !ok {
+		if !mc.hasBucket(srcBucket) {
+			return ErrBucketNotFound
+		}
+		return ErrKeyNotFound
+	}
+	if el.Value.(*cacheItem).expired(now) {
+		mc.expireInline(src, el)
+		return ErrKeyExpired
+	}
+
+	if srcBucket != dstBucket {
+		if err := mc.checkNewBucket(dstBucket); err != nil {
+			return err
+		}
+	}
+
+	if dstEl, ok := dst.buckets[dstBucket][dstKey]; ok {
+		switch {
+		case dstEl.Value.(*cacheItem).expired(now):
+			mc.expireInline(dst, dstEl)
+		case !overwrite:
+			return ErrKeyExists
+		default:
+			mc.deleteAndRemoveFromInsertOrder(dst, dstEl)
+			mc.logWAL(walRecord{Op: walDelete, Bucket: dstBucket, Key: dstKey})
+			mc.publish(Event{Type: EventDelete, Bucket: dstBucket, Key: dstKey})
+		}
+	} else if mc.bucketCapacity > 0 && srcBucket != dstBucket && mc.bucketLen(dstBucket) >= mc.bucketCapacity {
+		return ErrCacheFull
+	}
+
+	item := el.Value.(*cacheItem)
+	mc.deleteAndRemoveFromInsertOrder(src, el)
+	mc.logWAL(walRecord{Op: walDelete, Bucket: srcBucket, Key: srcKey})
+	mc.publish(Event{Type: EventDelete, Bucket: srcBucket, Key: srcKey})
+
+	// Take back the slot and bytes released by the removal for the new item.
+	mc.count.Add(1)
+	mc.bytes.Add(int64(len(item.value)))
+	moved := &cacheItem{
+		bucket:    dstBucket,
+		key:       dstKey,
+		value:     item.value,
+		expiresAt: item.expiresAt,
+		createdAt: item.createdAt,
+		version:   1,
+		checksum:  item.checksum,
+		grace:     item.grace,
+		heapIndex: -1,
 	}
+	mc.insert(dst, moved)
+	mc.logWAL(walRecord{Op: walSet, Bucket: dstBucket, Key: dstKey, Value: moved.value, ExpiresAt: moved.expiresAt, CreatedAt: moved.createdAt})
+	mc.publish(Event{Type: EventSet, Bucket: dstBucket, Key: dstKey, Value: moved.value})
 
-	mc.metrics.AddHit() // Track the hit action for metrics.
-	return item.value, nil
+	return nil
 }
 
 // Delete removes the key and value from the specified bucket. If the bucket is empty, it is deleted.
 // An error is returned if the operation fails. (Do we need the extra opts Options argument here?)
 func (mc *MinervaCache) Delete(bucket string, key string) error {
-	mc.mutex.Lock()
-	defer mc.mutex.Unlock()
+	return mc.DeleteCtx(context.Background(), bucket, key)
+}
 
-	// Check if the bucket exists
-	mcb, ok := mc.buckets[bucket]
-	if !ok {
-		mc.metrics.AddMiss()
-		mc.metrics.AddNotFound()
-		return ErrBucketNotFound
+// DeleteCtx is like Delete, but returns the context error instead of deleting the key if the context is done before
+// the shard is locked.
+func (mc *MinervaCache) DeleteCtx(ctx context.Context, bucket string, key string) error {
+	defer mc.observeLatency("delete", time.Now())
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := mc.checkNames(bucket, key); err != nil {
+		return err
 	}
+	defer mc.lockWAL()()
+
+	s := mc.shardFor(bucket, key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
 	// Check if the key exists in the bucket
-	el, ok := mcb[key]
+	el, ok := s.buckets[bucket][key]
 	if ok {
 		// TODO: maybe we track this regardless of the existence of the bucket or key?
-		mc.metrics.AddDelete() // Track the delete action for metrics.
+		mc.metrics.AddDelete(bucket) // Track the delete action for metrics.
+		mc.stats.deletes.Add(1)
 		// Remove the key from the bucket and update insertion order list. Remove bucket if empty as well.
-		mc.deleteAndRemoveFromInsertOrder(el)
+		mc.deleteAndRemoveFromInsertOrder(s, el)
+		mc.logWAL(walRecord{Op: walDelete, Bucket: bucket, Key: key})
+		mc.publish(Event{Type: EventDelete, Bucket: bucket, Key: key})
 
 		return nil
 	}
 
-	mc.metrics.AddMiss()
-	mc.metrics.AddNotFound()
+	// A delete of a missing key is not a cache miss, so only track it as not found.
+	mc.metrics.AddNotFound(bucket)
+	if !mc.hasBucket(bucket) {
+		return ErrBucketNotFound
+	}
 	return ErrKeyNotFound
 }
 
-// evict removes the oldest or newest or lru or mru item from the cache based on the eviction policy.
-// It is called when the cache reaches its capacity and needs to evict an item.
-// The eviction policy is passed as an argument to determine which item to evict.
-// No locking is needed here, as the caller already locks the mutex.
-func (mc *MinervaCache) evict(policy EvictionPolicy) {
-	var el *list.Element
+// DeleteMulti removes the given keys from the specified bucket at once, locking the shards of all the keys together
+// so no other operation sees the batch half applied. The bucket is deleted along with its last key.
+// It returns the number of keys that existed. Missing keys, including those of a missing bucket, are skipped.
+func (mc *MinervaCache) DeleteMulti(bucket string, keys []string) (int, error) {
+	if err := mc.checkNames(bucket, keys...); err != nil {
+		return 0, err
+	}
+	defer mc.lockWAL()()
 
-	switch policy {
-	case MRUEvictionPolicy, NewestEvictionPolicy:
-		el = mc.order.Back() // MRU or Newest item
-	default:
-		el = mc.order.Front() // LRU or Oldest item or When no policy is set (None).
+	// Lock the shards of the keys in index order, like lockShards, so concurrent batches can't deadlock.
+	locked := make([]bool, len(mc.shards))
+	for _, key := range keys {
+		locked[mc.shardIndex(bucket, key)] = true
+	}
+	for i, s := range mc.shards {
+		if locked[i] {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+		}
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		s := mc.shardFor(bucket, key)
+		el, ok := s.buckets[bucket][key]
+		if !ok {
+			mc.metrics.AddNotFound(bucket)
+			continue // Missing, or a duplicate of a key already deleted.
+		}
+
+		mc.metrics.AddDelete(bucket)
+		mc.stats.deletes.Add(1)
+		mc.deleteAndRemoveFromInsertOrder(s, el)
+		mc.logWAL(walRecord{Op: walDelete, Bucket: bucket, Key: key})
+		mc.publish(Event{Type: EventDelete, Bucket: bucket, Key: key})
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// DeletePrefix removes all the keys of the specified bucket starting with the prefix at once, locking all the shards
+// together so no other operation sees the group half deleted. The bucket is deleted along with its last key.
+// It returns the number of keys deleted. An error is returned if the bucket does not exist.
+func (mc *MinervaCache) DeletePrefix(bucket, prefix string) (int, error) {
+	if err := mc.checkNames(bucket); err != nil {
+		return 0, err
+	}
+	if !mc.hasBucket(bucket) {
+		return 0, ErrBucketNotFound
+	}
+	defer mc.lockWAL()()
+
+	mc.lockShards()
+	defer mc.unlockShards()
+
+	deleted := 0
+	for _, s := range mc.shards {
+		// Collect the elements first, since deleting them mutates the bucket map being iterated.
+		var matches []*list.Element
+		for key, el := range s.buckets[bucket] {
+			if strings.HasPrefix(key, prefix) {
+				matches = append(matches, el)
+			}
+		}
+
+		for _, el := range matches {
+			key := el.Value.(*cacheItem).key
+			mc.metrics.AddDelete(bucket)
+			mc.stats.deletes.Add(1)
+			mc.deleteAndRemoveFromInsertOrder(s, el)
+			mc.logWAL(walRecord{Op: walDelete, Bucket: bucket, Key: key})
+			mc.publish(Event{Type: EventDelete, Bucket: bucket, Key: key})
+		}
+		deleted += len(matches)
+	}
+
+	return deleted, nil
+}
+
+// Clear removes all the keys in the specified bucket, and the bucket itself. Like DeletePrefix, all the shards are locked
+// together and the keys are counted as deletes. An error is returned if the bucket does not exist.
+func (mc *MinervaCache) Clear(bucket string) error {
+	if err := mc.checkNames(bucket); err != nil {
+		return err
+	}
+	if !mc.hasBucket(bucket) {
+		return ErrBucketNotFound
+	}
+	defer mc.lockWAL()()
+
+	// The keys of the bucket are spread across the shards, which are all locked until the clear is logged, so a key
+	// set concurrently in a cleared shard is logged after the clear and isn't wiped by it on replay.
+	mc.lockShards()
+	defer mc.unlockShards()
+
+	for _, s := range mc.shards {
+		// Collect the elements first, since deleting them mutates the bucket map being iterated.
+		els := make([]*list.Element, 0, len(s.buckets[bucket]))
+		for _, el := range s.buckets[bucket] {
+			els = append(els, el)
+		}
+
+		// Splice each element out of the order list. The bucket is removed along with its last key.
+		for _, el := range els {
+			mc.metrics.AddDelete(bucket)
+			mc.stats.deletes.Add(1)
+			mc.deleteAndRemoveFromInsertOrder(s, el)
+			mc.publish(Event{Type: EventDelete, Bucket: bucket, Key: el.Value.(*cacheItem).key})
+		}
+	}
+	mc.logWAL(walRecord{Op: walClear, Bucket: bucket})
+
+	return nil
+}
+
+// FlushAll removes all the keys in all the buckets. Unlike Stop, the TTL check keeps running afterward.
+func (mc *MinervaCache) FlushAll() {
+	defer mc.lockWAL()()
+
+	mc.flush()
+	mc.logWAL(walRecord{Op: walFlush})
+}
+
+// Len returns the total number of items in the cache across all buckets, without locking.
+// Expired items that have not been collected yet are still counted.
+func (mc *MinervaCache) Len() int {
+	return int(mc.items.Load())
+}
+
+// Capacity returns the maximum number of items the cache holds before evicting, math.MaxInt for a cache created with
+// NewMinervaCacheBytes, which is limited by the size of its values instead.
+func (mc *MinervaCache) Capacity() int {
+	return int(mc.capacity.Load())
+}
+
+// Resize changes the capacity of the cache at runtime. Shrinking it below the number of keys held evicts the excess
+// with the default policy of the cache, see [WithDefaultPolicy]. ErrInvalidCapacity is returned if newCapacity is not
+// positive.
+func (mc *MinervaCache) Resize(newCapacity int) error {
+	if newCapacity <= 0 {
+		return ErrInvalidCapacity
+	}
+	mc.capacity.Store(int64(newCapacity))
+
+	for mc.size() > newCapacity && mc.evict(mc.policy(Options{}), nil) {
+	}
+	return nil
+}
+
+// SizeBytes returns the total size of the values in the cache, including the bytes reserved by in-flight inserts.
+func (mc *MinervaCache) SizeBytes() int64 {
+	return mc.bytes.Load()
+}
+
+// Stats returns a snapshot of the cache counters. The counters, including the size and the bucket count, are atomics
+// read one by one without locking the cache, so the snapshot may be slightly inconsistent while other operations are
+// in flight. The operation counters never decrease between snapshots.
+func (mc *MinervaCache) Stats() Stats {
+	stats := Stats{
+		Hits:        mc.stats.hits.Load(),
+		Misses:      mc.stats.misses.Load(),
+		Sets:        mc.stats.sets.Load(),
+		Deletes:     mc.stats.deletes.Load(),
+		Evicts:      mc.stats.evicts.Load(),
+		Expires:     mc.stats.expires.Load(),
+		Size:        mc.Len(),
+		BucketCount: int(mc.bucketCount.Load()),
+	}
+	switch {
+	case mc.maxBytes > 0:
+		stats.MaxBytes, stats.UsedBytes = mc.maxBytes, mc.SizeBytes()
+		stats.Utilization = float64(stats.UsedBytes) / float64(stats.MaxBytes)
+	case mc.capacity.Load() > 0:
+		stats.Capacity = mc.Capacity()
+		stats.Utilization = float64(stats.Size) / float64(stats.Capacity)
+	}
+	return stats
+}
+
+// Health returns whether the cache is stopped, when it started and the number of items and buckets it holds.
+func (mc *MinervaCache) Health() Health {
+	return Health{
+		Stopped:     mc.stopped.Load(),
+		StartedAt:   mc.startedAt,
+		Size:        mc.Len(),
+		BucketCount: int(mc.bucketCount.Load()),
+	}
+}
+
+// BucketLen returns the number of keys in the specified bucket.
+// An error is returned if the bucket does not exist.
+func (mc *MinervaCache) BucketLen(bucket string) (int, error) {
+	n := mc.bucketLen(bucket)
+	if n == 0 {
+		return 0, ErrBucketNotFound
+	}
+	return n, nil
+}
+
+// bucketLen returns the number of keys in the specified bucket across all the shards, 0 if it does not exist.
+func (mc *MinervaCache) bucketLen(bucket string) int {
+	mc.bucketsMutex.Lock()
+	defer mc.bucketsMutex.Unlock()
+
+	return mc.bucketSizes[bucket]
+}
+
+// hasBucket reports whether the bucket has keys in any shard.
+func (mc *MinervaCache) hasBucket(bucket string) bool {
+	return mc.bucketLen(bucket) > 0
+}
+
+// Keys returns the keys in the specified bucket sorted lexicographically.
+// Expired items that have not been collected yet are skipped.
+// An error is returned if the bucket does not exist.
+func (mc *MinervaCache) Keys(bucket string) ([]string, error) {
+	if !mc.hasBucket(bucket) {
+		return nil, ErrBucketNotFound
+	}
+
+	now := time.Now()
+	keys := make([]string, 0)
+	for _, s := range mc.shards {
+		s.mutex.Lock()
+		for key, el := range s.buckets[bucket] {
+			if el.Value.(*cacheItem).expired(now) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		s.mutex.Unlock()
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// ScanKeys returns a page of at most limit keys (0 or less for no limit) of the specified bucket, the ones sorted
+// lexicographically after the cursor ("" for the first page), and the cursor of the next page, "" on the last one.
+// Since the cursor is the last key returned, the pages don't skip or repeat the keys that exist during the whole scan,
+// even if others are written.
+// Expired items that have not been collected yet are skipped.
+// An error is returned if the bucket does not exist.
+func (mc *MinervaCache) ScanKeys(bucket, cursor string, limit int) ([]string, string, error) {
+	if !mc.hasBucket(bucket) {
+		return nil, "", ErrBucketNotFound
+	}
+
+	now := time.Now()
+	keys := make([]string, 0)
+	for _, s := range mc.shards {
+		s.mutex.Lock()
+		for key, el := range s.buckets[bucket] {
+			if key <= cursor || el.Value.(*cacheItem).expired(now) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		s.mutex.Unlock()
+	}
+	sort.Strings(keys)
+
+	if limit <= 0 || len(keys) <= limit {
+		return keys, "", nil
+	}
+	keys = keys[:limit]
+	return keys, keys[limit-1], nil
+}
+
+// Export returns all the unexpired keys of the bucket with their values and metadata.
+// Unlike Get, the keys are not tracked as accessed, so exporting a bucket doesn't change the eviction order.
+func (mc *MinervaCache) Export(bucket string) (map[string]Entry, error) {
+	if !mc.hasBucket(bucket) {
+		return nil, ErrBucketNotFound
+	}
+
+	now := time.Now()
+	entries := make(map[string]Entry)
+	for _, s := range mc.shards {
+		s.mutex.Lock()
+		for key, el := range s.buckets[bucket] {
+			item := el.Value.(*cacheItem)
+			if item.expired(now) {
+				continue
+			}
+			entries[key] = Entry{Bucket: bucket, Key: key, Value: item.value, Meta: item.meta(now)}
+		}
+		s.mutex.Unlock()
+	}
+
+	return entries, nil
+}
+
+// Buckets returns the names of all buckets in the cache sorted lexicographically.
+// Buckets holding only expired items that have not been collected yet are skipped.
+func (mc *MinervaCache) Buckets() []string {
+	sizes := mc.BucketSizes()
+	buckets := make([]string, 0, len(sizes))
+	for bucket := range sizes {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	return buckets
+}
+
+// BucketSizes returns the number of unexpired keys of each bucket in the cache.
+// Buckets holding only expired items that have not been collected yet are skipped.
+func (mc *MinervaCache) BucketSizes() map[string]int {
+	now := time.Now()
+	sizes := make(map[string]int)
+	for _, s := range mc.shards {
+		s.mutex.Lock()
+		for bucket, mcb := range s.buckets {
+			for _, el := range mcb {
+				if !el.Value.(*cacheItem).expired(now) {
+					sizes[bucket]++
+				}
+			}
+		}
+		s.mutex.Unlock()
+	}
+
+	return sizes
+}
+
+// evict removes the oldest or newest or lru or mru or lfu item from the cache based on the eviction policy, only
+// considering the items accepted by the filter (all of them if nil). It reports whether an item was evicted.
+// It is called when the cache (or a bucket) reaches its capacity and needs to evict an item.
+// The victim of each shard is ranked with its shard locked in turn, then the best one is evicted, so no two shard
+// mutexes are ever held at once. Must be called without any shard mutex locked.
+// NB: Under concurrent accesses the ranking may be stale by the time the item is evicted, so the victim is then the
+// best one of its shard at that time rather than strictly of the whole cache.
+func (mc *MinervaCache) evict(policy EvictionPolicy, filter func(item *cacheItem) bool) bool {
+	if mc.size() == 0 {
+		return false // Nothing to evict, e.g. in a zero capacity cache.
+	}
+
+	var best *shard
+	var bestRank rank
+	for _, s := range mc.shards {
+		s.mutex.Lock()
+		if el := s.victim(policy, filter); el != nil {
+			if r := rankOf(el); best == nil || r.evictsBefore(bestRank, policy) {
+				best, bestRank = s, r
+			}
+		}
+		s.mutex.Unlock()
+	}
+	if best == nil {
+		return false
+	}
+
+	best.mutex.Lock()
+	defer best.mutex.Unlock()
+
+	el := best.victim(policy, filter)
+	if el == nil {
+		return false // Emptied concurrently.
 	}
+	item := el.Value.(*cacheItem)
+	mc.deleteAndRemoveFromInsertOrder(best, el)
+	mc.metrics.AddEvict(item.bucket) // Track the eviction action for metrics.
+	mc.stats.evicts.Add(1)
+	mc.publish(Event{Type: EventDelete, Bucket: item.bucket, Key: item.key})
+	mc.spill(item)
 
-	mc.deleteAndRemoveFromInsertOrder(el)
-	mc.metrics.AddEvict() // Track the eviction action for metrics.
+	return true
 }
 
 // deleteAndRemoveFromInsertOrder removes the key from the bucket and updates the insertion order list.
-// Used in Delete and evict and must be called with the mutex locked in the caller.
-func (mc *MinervaCache) deleteAndRemoveFromInsertOrder(el *list.Element) {
-	mc.order.Remove(el)
+// Used in Delete and evict and must be called with the shard mutex locked in the caller. A nil element is ignored.
+func (mc *MinervaCache) deleteAndRemoveFromInsertOrder(s *shard, el *list.Element) {
+	if el == nil {
+		return
+	}
+
+	s.order.Remove(el)
+	s.freqs.remove(el)
+	mc.untrackExpiry(s, el)
 
 	item := el.Value.(*cacheItem)
-	mcb := mc.buckets[item.bucket]
+	mcb := s.buckets[item.bucket]
 	delete(mcb, item.key)
 
 	// Check if the bucket is empty after deletion
 	if len(mcb) == 0 {
-		delete(mc.buckets, item.bucket)
+		delete(s.buckets, item.bucket)
+	}
+
+	mc.bucketsMutex.Lock()
+	if mc.bucketSizes[item.bucket]--; mc.bucketSizes[item.bucket] == 0 {
+		delete(mc.bucketSizes, item.bucket)
+		mc.bucketCount.Add(-1)
+	}
+	mc.bucketsMutex.Unlock()
+
+	mc.items.Add(-1)
+	mc.count.Add(-1)
+	mc.bytes.Add(-int64(len(item.value)))
+	mc.reportSize() // Keep the size metrics up to date on every removal (delete, evict or expire).
+}
+
+// reportSize sets the size, bucket count and average bucket size metrics from the counters of the cache. It is called
+// on every insert and removal, so the bucket metrics follow the buckets as they are created and removed, including by
+// the expiration sweeps.
+func (mc *MinervaCache) reportSize() {
+	mc.metrics.SetSize(mc.size())
+	buckets := mc.bucketCount.Load()
+	mc.metrics.SetBucketCount(int(buckets))
+	var avg float64
+	if buckets > 0 {
+		avg = float64(mc.items.Load()) / float64(buckets)
 	}
+	mc.metrics.SetAvgBucketSize(avg)
+}
+
+// size returns the number of items in the cache, including the slots reserved by in-flight inserts.
+func (mc *MinervaCache) size() int {
+	return int(mc.count.Load())
 }
 
 // startTTLCheck starts a goroutine that periodically checks for expired items in the cache.
@@ -229,7 +1962,6 @@ func (mc *MinervaCache) startTTLCheck() {
 		for {
 			select {
 			case <-ticker.C:
-				mc.metrics.SetSize(mc.order.Len()) // Update the size metric
 				mc.checkExpiredItems()
 			case <-mc.stop:
 				ticker.Stop() // TODO: should I defer this at the top of the routine?
@@ -241,46 +1973,95 @@ func (mc *MinervaCache) startTTLCheck() {
 
 // Stop terminates the TTL check goroutine and cleans up resources. NB: Get action always checks for expired items anyway.
 func (mc *MinervaCache) Stop() {
-	close(mc.stop) // Stop the TTL check goroutine
+	mc.stopped.Store(true)
+	close(mc.stop)       // Stop the TTL check goroutine
+	mc.stopWriteBehind() // Flush the queued writes to the sink.
+	mc.closeWAL()        // Keep the logged writes, the cleanups below are not persisted.
+	mc.closeWatchers()   // Nor published.
 
 	// TODO: Do I really want to do all this below cleanups? Maybe just stop the goroutine and let it clean up?
-	mc.mutex.Lock()
-	defer mc.mutex.Unlock()
+	mc.flush()
+}
+
+// flush removes all the items from the buckets, order lists, frequency lists and expiries heaps of all the shards.
+// Used in Stop and FlushAll and must be called without any shard mutex locked.
+func (mc *MinervaCache) flush() {
+	for _, s := range mc.shards {
+		s.mutex.Lock()
+		mc.bucketsMutex.Lock()
+		for bucket, mcb := range s.buckets {
+			if mc.bucketSizes[bucket] -= len(mcb); mc.bucketSizes[bucket] == 0 {
+				delete(mc.bucketSizes, bucket)
+				mc.bucketCount.Add(-1)
+			}
+		}
+		mc.bucketsMutex.Unlock()
 
-	// Clean up buckets and order list
-	for _, mcb := range mc.buckets {
-		for _, el := range mcb {
-			mc.order.Remove(el)
+		mc.items.Add(-int64(s.order.Len()))
+		mc.count.Add(-int64(s.order.Len()))
+		for el := s.order.Front(); el != nil; el = el.Next() {
+			item := el.Value.(*cacheItem)
+			item.stopTimer()
+			mc.bytes.Add(-int64(len(item.value)))
+			mc.publish(Event{Type: EventDelete, Bucket: item.bucket, Key: item.key})
 		}
+		s.buckets = make(map[string]map[string]*list.Element)
+		s.order.Init()    // Reset the order list
+		s.freqs.init()    // Reset the frequency list
+		*s.expiries = nil // Reset the expiries heap
+		clear(s.negatives)
+		s.mutex.Unlock()
 	}
-	mc.buckets = make(map[string]map[string]*list.Element)
-	mc.order.Init() // Reset the order list
+
+	mc.reportSize()
 }
 
-// checkExpiredItems checks for expired items in the cache and removes them.
-func (mc *MinervaCache) checkExpiredItems() {
-	mc.mutex.Lock()
-	defer mc.mutex.Unlock()
+// CollectExpired removes the expired items from the cache now, instead of waiting for the next background TTL
+// check, and returns how many were removed.
+func (mc *MinervaCache) CollectExpired() int {
+	return mc.checkExpiredItems()
+}
 
-	// Iterate over all buckets and check for expired items.
-	// Although this is ran in a separate goroutine, it is still O(b*i). TODO: How to optimize this?
-	for _, mcb := range mc.buckets {
-		for _, el := range mcb {
-			item := el.Value.(*cacheItem)
-			if !item.expiresAt.IsZero() && time.Now().After(item.expiresAt) {
-				// Item is expired, remove it.
-				mc.deleteAndRemoveFromInsertOrder(el)
-			}
-		}
+// checkExpiredItems checks for expired items in the cache, removes them and returns how many were removed.
+// The shards are swept one at a time, so the other shards stay available during the sweep.
+func (mc *MinervaCache) checkExpiredItems() int {
+	removed := 0
+	for _, s := range mc.shards {
+		removed += mc.checkExpiredShardItems(s)
+	}
+	if removed > 0 {
+		mc.logger.Debug("minervacache: collected the expired keys", "removed", removed)
 	}
+	return removed
 }
 
-// getBucket returns the bucket for the given key. If the bucket doesn't exist, it creates a new one.
-func (mc *MinervaCache) getBucket(bucket string) map[string]*list.Element {
-	mcb, ok := mc.buckets[bucket]
-	if !ok {
-		mcb = make(map[string]*list.Element)
-		mc.buckets[bucket] = mcb
+// removeExpired removes the expired item found in the background, by the TTL check or its timer.
+// Must be called with the shard mutex locked.
+func (mc *MinervaCache) removeExpired(s *shard, el *list.Element) {
+	item := el.Value.(*cacheItem)
+	mc.deleteAndRemoveFromInsertOrder(s, el) // Also removes the item from the heap.
+	mc.metrics.AddExpire(false)              // Track the expiration of item found by the background check for metrics.
+	mc.stats.expires.Add(1)
+	mc.publish(Event{Type: EventExpire, Bucket: item.bucket, Key: item.key})
+}
+
+func (mc *MinervaCache) checkExpiredShardItems(s *shard) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Pop the items from the expiries heap until the next one has not expired yet.
+	// This is O(e*log(n)) for e expired items, items without a TTL are never visited.
+	now := time.Now()
+	removed := 0
+	for el := s.expiries.next(); el != nil && el.Value.(*cacheItem).removable(now); el = s.expiries.next() {
+		mc.removeExpired(s, el)
+		removed++
+	}
+
+	for id, expiresAt := range s.negatives {
+		if !now.Before(expiresAt) {
+			delete(s.negatives, id)
+		}
 	}
-	return mcb
+	return removed
 }
@@ -1,12 +1,55 @@
 package cache
 
 import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// countingMetrics is a MetricsHandler that counts how many times each hook was called.
+type countingMetrics struct {
+	size         int
+	hits         int
+	misses       int
+	sets         int
+	setExists    int
+	deletes      int
+	evicts       int
+	inlineExpire int
+	bgExpire     int
+	notFound     int
+}
+
+func (c *countingMetrics) SetSize(size int)                          { c.size = size }
+func (c *countingMetrics) SetBucketCount(count int)                  {}
+func (c *countingMetrics) SetAvgBucketSize(size float64)             {}
+func (c *countingMetrics) AddHit(bucket string)                      { c.hits++ }
+func (c *countingMetrics) AddMiss(bucket string)                     { c.misses++ }
+func (c *countingMetrics) AddSet(bucket string)                      { c.sets++ }
+func (c *countingMetrics) AddSetExists(bucket string)                { c.setExists++ }
+func (c *countingMetrics) AddDelete(bucket string)                   { c.deletes++ }
+func (c *countingMetrics) AddEvict(bucket string)                    { c.evicts++ }
+func (c *countingMetrics) AddNotFound(bucket string)                 { c.notFound++ }
+func (c *countingMetrics) ObserveLatency(op string, d time.Duration) {}
+func (c *countingMetrics) AddExpire(inlineCheck bool) {
+	if inlineCheck {
+		c.inlineExpire++
+	} else {
+		c.bgExpire++
+	}
+}
+
 func TestNewMinervaCache(t *testing.T) {
 	mc := NewMinervaCache(10, 0, &mockMetrics{})
 	defer mc.Stop()
@@ -24,7 +67,7 @@ func TestMinervaCache_Set(t *testing.T) {
 	assert.NoError(t, err, "expected no error on Set")
 
 	// Check if the value is set correctly by checking the Cache buckets and keys.
-	bucket, ok := mc.buckets["bkt1"]
+	bucket, ok := lookupBucket(mc, "bkt1")
 	assert.True(t, ok, "expected bucket to exist")
 	assert.Equal(t, 1, len(bucket), "expected bucket to have 1 key")
 }
@@ -44,6 +87,53 @@ func TestMinervaCache_Get(t *testing.T) {
 	assert.Equal(t, []byte("val1"), value, "expected value to be 'val1'")
 }
 
+func TestMinervaCache_MaxBuckets(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithMaxBuckets(2))
+	defer mc.Stop()
+
+	assert.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	assert.NoError(t, mc.Set("bkt2", "key1", []byte("val1"), Options{}))
+	assert.ErrorIs(t, mc.Set("bkt3", "key1", []byte("val1"), Options{}), ErrTooManyBuckets)
+	assert.ErrorIs(t, mc.Move("bkt1", "key1", "bkt3", "key1", false), ErrTooManyBuckets)
+	_, err := mc.Get("bkt1", "key1", Options{})
+	assert.NoError(t, err, "expected the rejected move to keep the source key")
+
+	// The existing buckets can still be written.
+	assert.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{}))
+	assert.NoError(t, mc.Set("bkt2", "key1", []byte("val2"), Options{}))
+	assert.Equal(t, 3, mc.Len())
+
+	// Removing the last key of a bucket frees its place.
+	assert.NoError(t, mc.Delete("bkt2", "key1"))
+	assert.NoError(t, mc.Set("bkt3", "key1", []byte("val1"), Options{}))
+	assert.ErrorIs(t, mc.Set("bkt4", "key1", []byte("val1"), Options{}), ErrTooManyBuckets)
+}
+
+func TestMinervaCache_EmptyValue(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	assert.NoError(t, mc.Set("bkt1", "empty", []byte{}, Options{}))
+	assert.NoError(t, mc.Set("bkt1", "nil", nil, Options{}))
+	for _, key := range []string{"empty", "nil"} {
+		value, err := mc.Get("bkt1", key, Options{})
+		assert.NoError(t, err, "expected an empty value to be a hit")
+		assert.NotNil(t, value, "expected an empty slice for %s, not nil", key)
+		assert.Empty(t, value)
+	}
+
+	// Overwriting a value with nil keeps it distinct from a miss too.
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key1", nil, Options{})
+	value, err := mc.Get("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{}, value)
+
+	value, err = mc.Get("bkt1", "missing", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Nil(t, value)
+}
+
 func TestMinervaCache_Delete(t *testing.T) {
 	// Test the Delete method of MinervaCache.
 	mc := NewMinervaCache(10, 0, &mockMetrics{})
@@ -63,7 +153,7 @@ func TestMinervaCache_Delete(t *testing.T) {
 	assert.Error(t, err, "expected error on Get after Delete")
 
 	// Check if the bucket is empty after deletion.
-	bucket, ok := mc.buckets["bkt1"]
+	bucket, ok := lookupBucket(mc, "bkt1")
 	assert.True(t, ok, "expected bucket to exist")
 	assert.Equal(t, 1, len(bucket), "expected bucket to have 1 key")
 
@@ -74,99 +164,2338 @@ func TestMinervaCache_Delete(t *testing.T) {
 	_, err = mc.Get("bkt1", "key2", Options{})
 	assert.Error(t, err, "expected error on Get after Delete")
 	// Ensure the bucket was deleted as well because it is empty.
-	_, ok = mc.buckets["bkt1"]
+	_, ok = lookupBucket(mc, "bkt1")
 	assert.False(t, ok, "expected bucket to be deleted")
 }
 
-func TestNoTTL(t *testing.T) {
+func TestMinervaCache_SetMulti(t *testing.T) {
 	mc := NewMinervaCache(10, 0, &mockMetrics{})
 	defer mc.Stop()
 
-	err := mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	err := mc.SetMulti("bkt1", map[string][]byte{
+		"key1": []byte("val1"),
+		"key2": []byte("val2"),
+		"key3": []byte("val3"),
+	}, Options{})
+	assert.NoError(t, err, "expected no error on SetMulti")
+
+	for i := 1; i <= 3; i++ {
+		value, err := mc.Get("bkt1", fmt.Sprintf("key%d", i), Options{})
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("val%d", i)), value)
+	}
+}
+
+func TestMinervaCache_GetMulti(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt1", "expired", []byte("gone"), Options{TTL: time.Millisecond})
+	time.Sleep(5 * time.Millisecond)
+
+	// Partial hit: missing and expired keys are left out of the result.
+	values, err := mc.GetMulti("bkt1", []string{"key1", "missing", "key2", "expired"}, Options{})
+	assert.NoError(t, err, "expected no error on GetMulti with partial hits")
+	assert.Equal(t, map[string][]byte{"key1": []byte("val1"), "key2": []byte("val2")}, values)
+
+	// A missing bucket is a miss for every key, not an error.
+	values, err = mc.GetMulti("missing", []string{"key1"}, Options{})
 	assert.NoError(t, err)
+	assert.Empty(t, values)
+}
 
-	value, err := mc.Get("bkt1", "key1", Options{})
+// assertOrderIntegrity checks that the order and frequency lists of each shard hold exactly the items in its bucket
+// maps, that the expiries heaps hold exactly the items with a TTL, and that the counts across shards are up to date.
+func assertOrderIntegrity(t *testing.T, mc *MinervaCache) {
+	t.Helper()
+
+	total := 0
+	var totalBytes int64
+	bucketSizes := make(map[string]int)
+	for _, s := range mc.shards {
+		n := 0
+		for bucket, mcb := range s.buckets {
+			assert.NotEmpty(t, mcb, "expected no empty bucket %q to be left behind", bucket)
+			n += len(mcb)
+			bucketSizes[bucket] += len(mcb)
+		}
+		assert.Equal(t, n, s.order.Len(), "expected the order list to match the buckets")
+		total += n
+
+		var prevSeq uint64
+		for el := s.order.Front(); el != nil; el = el.Next() {
+			item := el.Value.(*cacheItem)
+			assert.Same(t, el, s.buckets[item.bucket][item.key], "expected order list element to be in its bucket")
+			assert.Greater(t, item.seq, prevSeq, "expected the order list to be sorted by sequence")
+			prevSeq = item.seq
+			totalBytes += int64(len(item.value))
+		}
+
+		freqItems := 0
+		for node := s.freqs.freqs.Front(); node != nil; node = node.Next() {
+			freqItems += node.Value.(*freqNode).items.Len()
+		}
+		assert.Equal(t, n, freqItems, "expected the frequency list to match the buckets")
+
+		withTTL := 0
+		for el := s.order.Front(); el != nil; el = el.Next() {
+			if !el.Value.(*cacheItem).expiresAt.IsZero() {
+				withTTL++
+			}
+		}
+		assert.Equal(t, withTTL, s.expiries.Len(), "expected the expiries heap to hold the items with a TTL")
+		for i, el := range *s.expiries {
+			assert.Equal(t, i, el.Value.(*cacheItem).heapIndex, "expected the heap index of the item to be up to date")
+		}
+	}
+
+	assert.Equal(t, int64(total), mc.count.Load(), "expected the count to match the shards")
+	assert.Equal(t, int64(total), mc.items.Load(), "expected the item count to match the shards")
+	assert.Equal(t, totalBytes, mc.SizeBytes(), "expected the size in bytes to match the shards")
+	assert.Equal(t, bucketSizes, mc.bucketSizes, "expected the bucket sizes to match the shards")
+	assert.Equal(t, int64(len(bucketSizes)), mc.bucketCount.Load(), "expected the bucket count to match the shards")
+}
+
+// lookupBucket returns the keys of the bucket across all the shards and whether the bucket exists.
+func lookupBucket(mc *MinervaCache, bucket string) (map[string]*list.Element, bool) {
+	mcb := make(map[string]*list.Element)
+	for _, s := range mc.shards {
+		for key, el := range s.buckets[bucket] {
+			mcb[key] = el
+		}
+	}
+	return mcb, len(mcb) > 0
+}
+
+// mostRecentItem returns the item at the back of the order across all the shards.
+func mostRecentItem(mc *MinervaCache) *cacheItem {
+	var recent *cacheItem
+	for _, s := range mc.shards {
+		if el := s.order.Back(); el != nil && (recent == nil || el.Value.(*cacheItem).seq > recent.seq) {
+			recent = el.Value.(*cacheItem)
+		}
+	}
+	return recent
+}
+
+// expiriesLen returns the number of items tracked in the expiries heaps of all the shards.
+func expiriesLen(mc *MinervaCache) int {
+	n := 0
+	for _, s := range mc.shards {
+		n += s.expiries.Len()
+	}
+	return n
+}
+
+func TestMinervaCache_CompareAndSwap(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+	mc.Set("bkt1", "key1", []byte("v1"), Options{TTL: time.Minute})
+
+	swapped, err := mc.CompareAndSwap("bkt1", "key1", []byte("v1"), []byte("v2"), Options{})
 	assert.NoError(t, err)
-	assert.Equal(t, []byte("val1"), value)
+	assert.True(t, swapped)
+	value, meta, _ := mc.GetWithMeta("bkt1", "key1", Options{})
+	assert.Equal(t, []byte("v2"), value)
+	assert.InDelta(t, time.Minute, meta.TTLRemaining, float64(time.Second), "expected the TTL to be kept")
 
-	_, err = mc.Get("bkt1", "key2", Options{})
-	assert.Error(t, err)
+	// A stale old value doesn't change anything.
+	swapped, err = mc.CompareAndSwap("bkt1", "key1", []byte("v1"), []byte("v3"), Options{})
+	assert.NoError(t, err)
+	assert.False(t, swapped)
+	value, _ = mc.Get("bkt1", "key1", Options{})
+	assert.Equal(t, []byte("v2"), value)
+
+	_, err = mc.CompareAndSwap("bkt1", "missing", nil, []byte("v1"), Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = mc.CompareAndSwap("missing", "key1", nil, []byte("v1"), Options{})
+	assert.ErrorIs(t, err, ErrBucketNotFound)
+	assert.Equal(t, int64(len("v2")), mc.SizeBytes())
+	assertOrderIntegrity(t, mc)
 }
 
-func TestTTL(t *testing.T) {
+func TestMinervaCache_CompareAndSwapConcurrent(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	for i := 0; i < 100; i++ {
+		mc.Set("bkt1", "key1", []byte("v0"), Options{})
+
+		var wg sync.WaitGroup
+		var swaps atomic.Int32
+		for _, value := range []string{"a", "b"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if swapped, _ := mc.CompareAndSwap("bkt1", "key1", []byte("v0"), []byte(value), Options{}); swapped {
+					swaps.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), swaps.Load(), "expected exactly one of the racing swaps to succeed")
+	}
+}
+
+func TestMinervaCache_SetWithVersion(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	assert.NoError(t, mc.SetWithVersion("bkt1", "key1", []byte("v1"), 0, Options{}), "expected version 0 to create the key")
+	assert.ErrorIs(t, mc.SetWithVersion("bkt1", "key1", []byte("v1"), 0, Options{}), ErrVersionMismatch)
+	_, meta, _ := mc.GetWithMeta("bkt1", "key1", Options{})
+	assert.Equal(t, uint64(1), meta.Version)
+
+	// Every write to the value increments the version.
+	mc.Set("bkt1", "key1", []byte("v2"), Options{TTL: time.Minute})
+	mc.CompareAndSwap("bkt1", "key1", []byte("v2"), []byte("1"), Options{})
+	mc.Increment("bkt1", "key1", 1, Options{})
+	_, meta, _ = mc.GetWithMeta("bkt1", "key1", Options{})
+	assert.Equal(t, uint64(4), meta.Version)
+
+	assert.ErrorIs(t, mc.SetWithVersion("bkt1", "key1", []byte("v5"), 3, Options{}), ErrVersionMismatch)
+	assert.NoError(t, mc.SetWithVersion("bkt1", "key1", []byte("v5"), 4, Options{}))
+	value, meta, _ := mc.GetWithMeta("bkt1", "key1", Options{})
+	assert.Equal(t, []byte("v5"), value)
+	assert.Equal(t, uint64(5), meta.Version)
+	assert.InDelta(t, time.Minute, meta.TTLRemaining, float64(time.Second), "expected the TTL to be kept")
+
+	assert.ErrorIs(t, mc.SetWithVersion("bkt1", "missing", []byte("v1"), 1, Options{}), ErrVersionMismatch)
+	assert.ErrorIs(t, mc.SetWithVersion("missing", "key1", []byte("v1"), 1, Options{}), ErrVersionMismatch)
+
+	// A recreated key starts over.
+	mc.Delete("bkt1", "key1")
+	mc.Set("bkt1", "key1", []byte("v1"), Options{})
+	_, meta, _ = mc.GetWithMeta("bkt1", "key1", Options{})
+	assert.Equal(t, uint64(1), meta.Version)
+	assertOrderIntegrity(t, mc)
+}
+
+func TestMinervaCache_CollectExpired(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{}) // No background TTL check.
+	defer mc.Stop()
+	mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: time.Millisecond})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{TTL: time.Millisecond})
+	mc.Set("bkt2", "key1", []byte("val1"), Options{TTL: time.Millisecond})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{TTL: time.Hour})
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Equal(t, 3, mc.CollectExpired())
+	assert.Equal(t, 1, mc.Len(), "expected the expired keys to be removed")
+	_, err := mc.Get("bkt2", "key1", Options{})
+	assert.ErrorIs(t, err, ErrBucketNotFound, "expected the emptied bucket to be removed")
+	assert.Equal(t, uint64(3), mc.Stats().Expires)
+	assert.Zero(t, mc.CollectExpired(), "expected nothing left to collect")
+	assertOrderIntegrity(t, mc)
+}
+
+func TestMinervaCache_ExpiryTimers(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithExpiryTimers(time.Second)) // No background TTL check.
+	defer mc.Stop()
+	events, cancel := mc.Watch("bkt1")
+	defer cancel()
+
+	mc.Set("bkt1", "short", []byte("val1"), Options{TTL: 50 * time.Millisecond})
+	mc.Set("bkt1", "long", []byte("val2"), Options{TTL: time.Minute}) // Left to the background TTL check.
+	mc.Set("bkt1", "deleted", []byte("val3"), Options{TTL: 50 * time.Millisecond})
+	mc.Set("bkt1", "persisted", []byte("val4"), Options{TTL: 50 * time.Millisecond})
+	mc.Set("bkt1", "overwritten", []byte("val5"), Options{TTL: 50 * time.Millisecond})
+	assert.NoError(t, mc.Delete("bkt1", "deleted"))
+	assert.NoError(t, mc.Persist("bkt1", "persisted"))
+	mc.Set("bkt1", "overwritten", []byte("val6"), Options{})
+	assert.Nil(t, mc.shardFor("bkt1", "long").buckets["bkt1"]["long"].Value.(*cacheItem).timer, "expected no timer for a long TTL")
+
+	timeout := time.After(100 * time.Millisecond)
+	for expired := false; !expired; {
+		select {
+		case event := <-events:
+			if event.Type == EventExpire {
+				assert.Equal(t, "short", event.Key)
+				expired = true
+			}
+		case <-timeout:
+			t.Fatal("expected the key to be removed by its timer without being read")
+		}
+	}
+	assert.Equal(t, 3, mc.Len(), "expected only the short key to be removed")
+	assert.Equal(t, uint64(1), mc.Stats().Expires)
+
+	// The timers of the deleted, persisted and overwritten keys were stopped.
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, mc.Len())
+	assert.Zero(t, mc.CollectExpired())
+	assertOrderIntegrity(t, mc)
+}
+
+func TestMinervaCache_Logger(t *testing.T) {
+	for _, tt := range []struct {
+		level slog.Level
+		debug bool
+	}{
+		{slog.LevelDebug, true},
+		{slog.LevelInfo, false},
+	} {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: tt.level}))
+		mc := NewMinervaCache(10, 0, &mockMetrics{}, WithLogger(logger))
+		mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: time.Millisecond})
+		time.Sleep(5 * time.Millisecond)
+		mc.CollectExpired()
+		mc.Stop()
+
+		if tt.debug {
+			assert.Contains(t, buf.String(), "level=DEBUG msg=\"minervacache: collected the expired keys\" removed=1")
+		} else {
+			assert.Empty(t, buf.String(), "expected no debug lines at the %s level", tt.level)
+		}
+	}
+}
+
+func TestMinervaCache_Resize(t *testing.T) {
+	mc := NewMinervaCache(4, 0, &mockMetrics{}, WithDefaultPolicy(LRUEvictionPolicy))
+	defer mc.Stop()
+	for i := 0; i < 4; i++ {
+		mc.Set("bkt1", fmt.Sprintf("key%d", i), []byte("val"), Options{})
+	}
+
+	assert.NoError(t, mc.Resize(6))
+	assert.Equal(t, 6, mc.Capacity())
+	assert.Equal(t, 4, mc.Len(), "expected growing not to evict")
+	mc.Set("bkt1", "key4", []byte("val"), Options{})
+	assert.Equal(t, 5, mc.Len(), "expected a new key to fit in the new capacity")
+
+	// Shrinking evicts the least recently used keys.
+	mc.Get("bkt1", "key0", Options{})
+	assert.NoError(t, mc.Resize(2))
+	assert.Equal(t, 2, mc.Len())
+	keys, _ := mc.Keys("bkt1")
+	assert.ElementsMatch(t, []string{"key0", "key4"}, keys)
+	assertOrderIntegrity(t, mc)
+
+	for _, capacity := range []int{0, -1} {
+		assert.ErrorIs(t, mc.Resize(capacity), ErrInvalidCapacity)
+	}
+	assert.Equal(t, 2, mc.Capacity(), "expected an invalid capacity to be ignored")
+}
+
+func TestMinervaCache_ResizeNoDefaultPolicy(t *testing.T) {
+	mc := NewMinervaCache(4, 0, &mockMetrics{})
+	defer mc.Stop()
+	for i := 0; i < 4; i++ {
+		mc.Set("bkt1", fmt.Sprintf("key%d", i), []byte("val"), Options{})
+	}
+
+	assert.NoError(t, mc.Resize(2))
+	keys, _ := mc.Keys("bkt1")
+	assert.Equal(t, []string{"key2", "key3"}, keys, "expected the oldest keys to be evicted without a default policy")
+	assert.ErrorIs(t, mc.Set("bkt1", "key4", []byte("val"), Options{NoEviction: true}), ErrCacheFull)
+}
+
+func TestMinervaCache_DeleteMulti(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{})
+	mc.Set("bkt2", "key1", []byte("val1"), Options{})
+
+	deleted, err := mc.DeleteMulti("bkt1", []string{"key1", "missing", "key2", "key1"})
+	assert.NoError(t, err, "expected no error on DeleteMulti with missing keys")
+	assert.Equal(t, 2, deleted, "expected only the existing keys to be counted")
+	_, err = mc.Get("bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Equal(t, 1, mc.BucketSizes()["bkt1"])
+	assertOrderIntegrity(t, mc)
+
+	deleted, err = mc.DeleteMulti("bkt1", []string{"key3"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, []string{"bkt2"}, mc.Buckets(), "expected the emptied bucket to be deleted")
+	assertOrderIntegrity(t, mc)
+
+	deleted, err = mc.DeleteMulti("missing", []string{"key1"})
+	assert.NoError(t, err, "expected a missing bucket to be skipped like its keys")
+	assert.Zero(t, deleted)
+	assert.Equal(t, 1, mc.Len())
+}
+
+func TestMinervaCache_DeletePrefix(t *testing.T) {
+	mc := NewMinervaCache(20, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	for _, key := range []string{"user:1:name", "user:1:email", "user:12:name", "user:2:name", "session:1"} {
+		mc.Set("bkt1", key, []byte("val"), Options{})
+	}
+	mc.Set("bkt2", "user:1:name", []byte("val"), Options{})
+
+	// "user:1:" doesn't overlap "user:12:", but "user:1" does.
+	deleted, err := mc.DeletePrefix("bkt1", "user:1:")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	keys, _ := mc.Keys("bkt1")
+	assert.ElementsMatch(t, []string{"user:12:name", "user:2:name", "session:1"}, keys)
+	assertOrderIntegrity(t, mc)
+
+	deleted, err = mc.DeletePrefix("bkt1", "user:1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	deleted, err = mc.DeletePrefix("bkt1", "order:")
+	assert.NoError(t, err, "expected no error when nothing matches")
+	assert.Zero(t, deleted)
+
+	deleted, err = mc.DeletePrefix("bkt1", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted, "expected the empty prefix to match all the keys")
+	assert.Equal(t, []string{"bkt2"}, mc.Buckets(), "expected the emptied bucket to be deleted")
+	assertOrderIntegrity(t, mc)
+
+	_, err = mc.DeletePrefix("bkt1", "user:")
+	assert.ErrorIs(t, err, ErrBucketNotFound)
+}
+
+func TestMinervaCache_Clear(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt2", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt2", "key2", []byte("val2"), Options{})
+	mc.Get("bkt1", "key1", Options{})
+
+	err := mc.Clear("bkt1")
+	assert.NoError(t, err, "expected no error on Clear")
+	assert.Equal(t, []string{"bkt2"}, mc.Buckets(), "expected only bkt2 to remain")
+	assert.Equal(t, 2, mc.Len())
+	assert.Equal(t, uint64(2), mc.Stats().Deletes, "expected the cleared keys to be counted as deletes")
+	assertOrderIntegrity(t, mc)
+
+	err = mc.Clear("bkt1")
+	assert.ErrorIs(t, err, ErrBucketNotFound)
+}
+
+func TestMinervaCache_FlushAll(t *testing.T) {
 	ttlCheckInterval := 10 * time.Millisecond
 	mc := NewMinervaCache(10, ttlCheckInterval, &mockMetrics{})
 	defer mc.Stop()
 
-	err := mc.Set("bkt1", "key1", []byte("val1"), Options{
-		TTL: 300 * time.Millisecond,
-	})
-	assert.NoError(t, err)
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt2", "key1", []byte("val1"), Options{})
 
-	time.Sleep(150 * time.Millisecond) // Half of the TTL duration.
+	mc.FlushAll()
+	assert.Equal(t, 0, mc.Len())
+	assert.Empty(t, mc.Buckets())
+	assertOrderIntegrity(t, mc)
 
-	val, err := mc.Get("bkt1", "key1", Options{})
+	// The cache is still usable and the TTL check still runs after a flush.
+	mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: time.Millisecond})
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, mc.Len(), "expected the background TTL check to remove the expired key")
+}
+
+func TestMinervaCache_Exists(t *testing.T) {
+	mc := NewMinervaCache(4, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	lru := Options{EvictionPolicy: LRUEvictionPolicy}
+	mc.Set("bkt1", "key1", []byte("val1"), lru)
+	mc.Set("bkt1", "key2", []byte("val2"), lru)
+	mc.Set("bkt1", "key3", []byte("val3"), Options{TTL: time.Millisecond})
+	time.Sleep(5 * time.Millisecond)
+
+	exists, err := mc.Exists("bkt1", "key1")
 	assert.NoError(t, err)
-	assert.Equal(t, []byte("val1"), val)
+	assert.True(t, exists)
+	exists, err = mc.Exists("bkt1", "key3")
+	assert.NoError(t, err)
+	assert.False(t, exists, "expected an expired key not to exist")
+	exists, err = mc.Exists("bkt1", "missing")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	_, err = mc.Exists("missing", "key1")
+	assert.ErrorIs(t, err, ErrBucketNotFound)
 
-	time.Sleep(300 * time.Millisecond) // Wait for the TTL to expire.
+	assert.Equal(t, "key3", mostRecentItem(mc).key, "expected exists not to move key1 to the back")
+	assert.Equal(t, Stats{Sets: 3, Size: 3, BucketCount: 1, Capacity: 4, Utilization: 0.75}, mc.Stats(), "expected exists not to count hits or misses")
 
-	_, err = mc.Get("bkt1", "key1", Options{})
-	assert.Error(t, err, "expected error after TTL expiration")
+	// Unlike Exists, Get moves the key to the back, so the LRU eviction picks key2 instead of key1.
+	mc.Delete("bkt1", "key3")
+	mc.Exists("bkt1", "key1")
+	mc.Set("bkt1", "key3", []byte("val3"), lru)
+	mc.Set("bkt1", "key4", []byte("val4"), lru)
+	mc.Set("bkt1", "key5", []byte("val5"), lru)
+	exists, _ = mc.Exists("bkt1", "key1")
+	assert.False(t, exists, "expected key1 to be evicted as the least recently used key")
+
+	mc.Get("bkt1", "key2", lru)
+	assert.Equal(t, "key2", mostRecentItem(mc).key, "expected get to move key2 to the back")
+	mc.Set("bkt1", "key6", []byte("val6"), lru)
+	exists, _ = mc.Exists("bkt1", "key2")
+	assert.True(t, exists, "expected the get to protect key2 from the eviction")
+	exists, _ = mc.Exists("bkt1", "key3")
+	assert.False(t, exists, "expected key3 to be evicted as the least recently used key")
 }
 
-func TestCapacity(t *testing.T) {
-	mc := NewMinervaCache(3, 0, &mockMetrics{})
+func TestMinervaCache_Persist(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
 	defer mc.Stop()
-	err := mc.Set("bkt1", "key1", []byte("val1"), Options{})
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: 100 * time.Millisecond})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{TTL: time.Millisecond})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{})
+
+	assert.NoError(t, mc.Persist("bkt1", "key1"))
+	assert.NoError(t, mc.Persist("bkt1", "key3"), "expected persisting a key without a TTL to do nothing")
+	assert.Equal(t, 1, expiriesLen(mc), "expected key1 to be removed from the expiries")
+
+	time.Sleep(150 * time.Millisecond)
+	value, meta, err := mc.GetWithMeta("bkt1", "key1", Options{})
+	assert.NoError(t, err, "expected the persisted key to outlive its TTL")
+	assert.Equal(t, []byte("val1"), value)
+	assert.True(t, meta.ExpiresAt.IsZero())
+
+	assert.ErrorIs(t, mc.Persist("bkt1", "key2"), ErrKeyExpired)
+	assert.ErrorIs(t, mc.Persist("bkt1", "key2"), ErrKeyNotFound, "expected the expired key to be removed")
+	assert.ErrorIs(t, mc.Persist("missing", "key1"), ErrBucketNotFound)
+	assert.Equal(t, 0, expiriesLen(mc))
+	assertOrderIntegrity(t, mc)
+}
+
+func TestMinervaCache_Touch(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: 50 * time.Millisecond})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{TTL: time.Millisecond})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{})
+
+	assert.NoError(t, mc.Touch("bkt1", "key1", time.Hour))
+	assert.NoError(t, mc.Touch("bkt1", "key3", time.Hour), "expected a TTL to be added to a key without one")
+	assert.ErrorIs(t, mc.Touch("bkt1", "key1", 0), ErrInvalidTTL)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, 1, mc.CollectExpired(), "expected only key2 to expire")
+	for _, key := range []string{"key1", "key3"} {
+		_, meta, err := mc.GetWithMeta("bkt1", key, Options{})
+		assert.NoError(t, err, "expected the touched key to outlive its TTL")
+		assert.InDelta(t, time.Hour, meta.TTLRemaining, float64(time.Minute))
+		assert.Equal(t, uint64(1), meta.Version, "expected the value to be untouched")
+	}
+
+	assert.ErrorIs(t, mc.Touch("bkt1", "key2", time.Hour), ErrKeyNotFound)
+	assert.ErrorIs(t, mc.Touch("missing", "key1", time.Hour), ErrBucketNotFound)
+	assert.Equal(t, 2, expiriesLen(mc))
+	assertOrderIntegrity(t, mc)
+}
+
+func TestMinervaCache_Move(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: time.Minute})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	_, before, _ := mc.GetWithMeta("bkt1", "key1", Options{})
+
+	// Same bucket rename.
+	assert.NoError(t, mc.Move("bkt1", "key1", "bkt1", "renamed", false))
+	_, err := mc.Get("bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	value, after, err := mc.GetWithMeta("bkt1", "renamed", Options{})
 	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), value)
+	assert.Equal(t, before.ExpiresAt, after.ExpiresAt, "expected the remaining TTL to be kept")
+	assert.Equal(t, before.CreatedAt, after.CreatedAt)
 
-	err = mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	// Cross bucket move.
+	assert.NoError(t, mc.Move("bkt1", "renamed", "bkt2", "key1", false))
+	value, after, err = mc.GetWithMeta("bkt2", "key1", Options{})
 	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), value)
+	assert.Equal(t, before.ExpiresAt, after.ExpiresAt)
+	assert.Equal(t, 1, expiriesLen(mc))
 
-	err = mc.Set("bkt1", "key3", []byte("val3"), Options{})
+	// Destination exists.
+	assert.ErrorIs(t, mc.Move("bkt1", "key2", "bkt2", "key1", false), ErrKeyExists)
+	assert.NoError(t, mc.Move("bkt1", "key2", "bkt2", "key1", true))
+	value, after, err = mc.GetWithMeta("bkt2", "key1", Options{})
 	assert.NoError(t, err)
+	assert.Equal(t, []byte("val2"), value, "expected the destination to be overwritten")
+	assert.True(t, after.ExpiresAt.IsZero())
+	assert.Equal(t, 1, mc.Len())
+	assert.Equal(t, []string{"bkt2"}, mc.Buckets(), "expected the empty source bucket to be removed")
 
-	_, gErr := mc.Get("bkt1", "key1", Options{})
-	assert.Error(t, gErr)
+	// Missing and expired sources.
+	assert.ErrorIs(t, mc.Move("bkt1", "key2", "bkt2", "key3", false), ErrBucketNotFound)
+	assert.ErrorIs(t, mc.Move("bkt2", "missing", "bkt2", "key3", false), ErrKeyNotFound)
+	assert.ErrorIs(t, mc.Move("bkt2", "missing", "bkt2", "missing", false), ErrKeyNotFound)
+	assert.NoError(t, mc.Move("bkt2", "key1", "bkt2", "key1", false), "expected a move onto itself to do nothing")
+	mc.Set("bkt2", "key3", []byte("val3"), Options{TTL: time.Millisecond})
+	time.Sleep(5 * time.Millisecond)
+	assert.ErrorIs(t, mc.Move("bkt2", "key3", "bkt2", "key4", false), ErrKeyExpired)
 
-	val, gErr := mc.Get("bkt1", "key2", Options{})
-	assert.NoError(t, gErr)
-	assert.Equal(t, []byte("val2"), val)
+	assert.Equal(t, int64(len("val2")), mc.SizeBytes())
+	assertOrderIntegrity(t, mc)
 }
 
-func TestEviction(t *testing.T) {
-	// Test the default eviction policy by filling the cache and checking if the least recently used entry is evicted.
-	mc := NewMinervaCache(3, 0, &mockMetrics{})
+func TestMinervaCache_MoveBucketLimit(t *testing.T) {
+	mc := NewMinervaCacheWithBucketLimits(10, 1, 0, &mockMetrics{})
 	defer mc.Stop()
 
 	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt2", "key1", []byte("val1"), Options{})
+	assert.ErrorIs(t, mc.Move("bkt1", "key1", "bkt2", "key2", false), ErrCacheFull)
+	assert.NoError(t, mc.Move("bkt1", "key1", "bkt2", "key1", true), "expected overwriting to fit in the full bucket")
+	assert.Equal(t, 1, mc.Len())
+}
+
+func TestMinervaCache_CleanupInterval(t *testing.T) {
+	mc := NewMinervaCache(10, time.Millisecond, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: time.Millisecond})
 	mc.Set("bkt1", "key2", []byte("val2"), Options{})
-	mc.Set("bkt1", "key3", []byte("val3"), Options{})
+	assert.Eventually(t, func() bool { return mc.Len() == 1 }, time.Second, time.Millisecond,
+		"expected the background sweep to remove the expired key without a get")
+	assert.Equal(t, []string{"bkt1"}, mc.Buckets())
 
-	mc.Set("bkt1", "key4", []byte("val4"), Options{}) // This should evict "key1" as the least recently used key.
+	// A zero interval disables the sweep, the expired key is only removed when read.
+	mc2 := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc2.Stop()
 
-	_, err := mc.Get("bkt1", "key1", Options{})
-	assert.Error(t, err, "expected error for evicted key")
+	mc2.Set("bkt1", "key1", []byte("val1"), Options{TTL: time.Millisecond})
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, mc2.Len(), "expected the expired key to be kept without a sweep")
+	_, err := mc2.Get("bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, ErrKeyExpired)
+	assert.Equal(t, 0, mc2.Len(), "expected the get to remove the expired key")
+}
 
-	// Before adding the support for evicting with the Oldest policy when the cache is at max capacity,
-	//val, err := mc.Get("bkt1", "key2", Options{})
-	//assert.NoError(t, err)
-	//assert.Equal(t, []byte("val2"), val, "expected key2 to be available")
-	//
-	//mc.Set("bkt1", "key5", []byte("val5"), Options{}) // This should evict "key2" as the least recently used key.
-	//_, err = mc.Get("bkt1", "key2", Options{})
-	//assert.Error(t, err, "expected error for evicted key")
-
-	// After adding the support for evicting with the Oldest policy when the cache is at max capacity,
-	// this should be an error because key2 should have been evicted.
-	_, err = mc.Get("bkt1", "key2", Options{})
-	assert.Error(t, err, "expected error for evicted key")
+func TestMinervaCache_SetIfAbsent(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
 
-	// Check if the other keys are still available.
-	val, err := mc.Get("bkt1", "key3", Options{})
-	assert.NoError(t, err, "expected no error for key3")
-	assert.Equal(t, []byte("val3"), val, "expected key3 to be available")
+	err := mc.Set("bkt1", "lock", []byte("owner1"), Options{SetMode: SetIfAbsent})
+	assert.NoError(t, err, "expected set-if-absent to succeed on a new key")
+
+	err = mc.Set("bkt1", "lock", []byte("owner2"), Options{SetMode: SetIfAbsent})
+	assert.ErrorIs(t, err, ErrKeyExists, "expected set-if-absent to fail on an existing key")
+
+	value, _ := mc.Get("bkt1", "lock", Options{})
+	assert.Equal(t, []byte("owner1"), value, "expected the value to be unchanged")
+
+	// An expired key counts as absent.
+	mc.Set("bkt1", "expiring", []byte("owner1"), Options{TTL: time.Millisecond})
+	time.Sleep(5 * time.Millisecond)
+	err = mc.Set("bkt1", "expiring", []byte("owner2"), Options{SetMode: SetIfAbsent})
+	assert.NoError(t, err, "expected set-if-absent to succeed on an expired key")
 }
 
-// TODO: Add more tests for different eviction policies and edge cases.
+func TestMinervaCache_SetIfPresent(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	err := mc.Set("bkt1", "key1", []byte("val1"), Options{SetMode: SetIfPresent})
+	assert.ErrorIs(t, err, ErrKeyNotFound, "expected set-if-present to fail on a missing key")
+	_, err = mc.BucketLen("bkt1")
+	assert.ErrorIs(t, err, ErrBucketNotFound, "expected no bucket to be created by a rejected set")
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+
+	// Updating an existing key with LRU moves it to the back of the order list.
+	err = mc.Set("bkt1", "key1", []byte("val1-updated"), Options{SetMode: SetIfPresent, EvictionPolicy: LRUEvictionPolicy})
+	assert.NoError(t, err, "expected set-if-present to succeed on an existing key")
+	assert.Equal(t, "key1", mostRecentItem(mc).key, "expected key1 to be the most recently used")
+
+	value, _ := mc.Get("bkt1", "key1", Options{})
+	assert.Equal(t, []byte("val1-updated"), value)
+}
+
+func TestMinervaCache_SetAlways(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	assert.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{SetMode: SetAlways}))
+	assert.NoError(t, mc.Set("bkt1", "key1", []byte("val2"), Options{SetMode: SetAlways}))
+
+	value, _ := mc.Get("bkt1", "key1", Options{})
+	assert.Equal(t, []byte("val2"), value)
+}
+
+func TestMinervaCache_GetWithMeta(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	before := time.Now()
+	mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: time.Second})
+
+	value, meta, err := mc.GetWithMeta("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), value)
+	assert.False(t, meta.CreatedAt.Before(before), "expected the creation time to be set on insert")
+	assert.WithinDuration(t, meta.CreatedAt.Add(time.Second), meta.ExpiresAt, time.Millisecond)
+	assert.True(t, meta.TTLRemaining > 0 && meta.TTLRemaining <= time.Second, "expected a positive remaining TTL, got %v", meta.TTLRemaining)
+
+	time.Sleep(20 * time.Millisecond)
+	_, later, err := mc.GetWithMeta("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Less(t, later.TTLRemaining, meta.TTLRemaining, "expected the remaining TTL to shrink")
+	assert.Equal(t, meta.CreatedAt, later.CreatedAt, "expected the creation time to be stable")
+
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	_, meta, err = mc.GetWithMeta("bkt1", "key2", Options{})
+	assert.NoError(t, err)
+	assert.True(t, meta.ExpiresAt.IsZero(), "expected no expiration without a TTL")
+	assert.Zero(t, meta.TTLRemaining)
+
+	_, _, err = mc.GetWithMeta("bkt1", "missing", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestMaxValueBytes(t *testing.T) {
+	mc := NewMinervaCache(2, 0, &mockMetrics{}, WithMaxValueBytes(4))
+	defer mc.Stop()
+
+	assert.NoError(t, mc.Set("bkt1", "under", []byte("abc"), Options{}), "expected a value under the limit to be set")
+	assert.NoError(t, mc.Set("bkt1", "at", []byte("abcd"), Options{}), "expected a value at the limit to be set")
+
+	// The cache is full, so a set of a new key would evict if it was accepted.
+	err := mc.Set("bkt2", "over", []byte("abcde"), Options{})
+	assert.ErrorIs(t, err, ErrValueTooLarge, "expected a value over the limit to be rejected")
+	err = mc.Set("bkt1", "at", []byte("abcde"), Options{})
+	assert.ErrorIs(t, err, ErrValueTooLarge, "expected an update over the limit to be rejected")
+
+	assert.Equal(t, []string{"bkt1"}, mc.Buckets(), "expected no bucket to be created by a rejected set")
+	keys, _ := mc.Keys("bkt1")
+	assert.Equal(t, []string{"at", "under"}, keys, "expected a rejected set not to evict existing entries")
+	value, _ := mc.Get("bkt1", "at", Options{})
+	assert.Equal(t, []byte("abcd"), value, "expected a rejected update to keep the previous value")
+
+	unlimited := NewMinervaCache(2, 0, &mockMetrics{})
+	defer unlimited.Stop()
+	assert.NoError(t, unlimited.Set("bkt1", "big", make([]byte, 1<<20), Options{}), "expected no limit by default")
+}
+
+func TestTTLJitter(t *testing.T) {
+	mc := NewMinervaCache(300, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	before := time.Now()
+	for i := 0; i < 200; i++ {
+		mc.Set("bkt1", fmt.Sprintf("key%d", i), []byte("val"), Options{TTL: time.Hour, TTLJitter: 10 * time.Minute})
+	}
+	after := time.Now()
+
+	expiries := make(map[time.Time]bool)
+	earliest, latest := after.Add(time.Hour), before
+	for i := 0; i < 200; i++ {
+		_, meta, err := mc.GetWithMeta("bkt1", fmt.Sprintf("key%d", i), Options{})
+		assert.NoError(t, err)
+		assert.False(t, meta.ExpiresAt.Before(before.Add(50*time.Minute)), "expected the TTL to be shortened by less than the jitter")
+		assert.False(t, meta.ExpiresAt.After(after.Add(time.Hour)), "expected the TTL never to be extended")
+		expiries[meta.ExpiresAt] = true
+		if meta.ExpiresAt.Before(earliest) {
+			earliest = meta.ExpiresAt
+		}
+		if meta.ExpiresAt.After(latest) {
+			latest = meta.ExpiresAt
+		}
+	}
+	assert.Greater(t, len(expiries), 190, "expected the expiry times to be spread rather than identical")
+	assert.Greater(t, latest.Sub(earliest), 5*time.Minute, "expected the expiry times to cover most of the jitter window")
+
+	// A jitter larger than the TTL still leaves a positive TTL.
+	mc.Set("bkt1", "short", []byte("val"), Options{TTL: time.Hour, TTLJitter: 2 * time.Hour})
+	_, meta, _ := mc.GetWithMeta("bkt1", "short", Options{})
+	assert.Positive(t, meta.TTLRemaining)
+}
+
+func TestMinervaCache_GetOrSet(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	const goroutines = 50
+	var calls atomic.Int32
+	start := make(chan struct{})
+	loader := func() ([]byte, error) {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond) // Keep the load in flight while the other callers arrive.
+		return []byte("loaded"), nil
+	}
+
+	var wg sync.WaitGroup
+	values := make([][]byte, goroutines)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			value, err := mc.GetOrSet("bkt1", "key1", Options{}, loader)
+			assert.NoError(t, err)
+			values[i] = value
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load(), "expected the loader to run exactly once")
+	for _, value := range values {
+		assert.Equal(t, []byte("loaded"), value, "expected every caller to get the loaded value")
+	}
+
+	value, err := mc.GetOrSet("bkt1", "key1", Options{}, loader)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("loaded"), value)
+	assert.Equal(t, int32(1), calls.Load(), "expected a hit not to call the loader")
+}
+
+func TestMinervaCache_GetOrSetError(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	errLoad := fmt.Errorf("database is down")
+	_, err := mc.GetOrSet("bkt1", "key1", Options{}, func() ([]byte, error) { return nil, errLoad })
+	assert.ErrorIs(t, err, errLoad)
+	_, err = mc.Get("bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, ErrBucketNotFound, "expected the loader error not to be cached")
+
+	value, err := mc.GetOrSet("bkt1", "key1", Options{}, func() ([]byte, error) { return []byte("val1"), nil })
+	assert.NoError(t, err, "expected the next call to load again")
+	assert.Equal(t, []byte("val1"), value)
+}
+
+func TestMinervaCache_SetWithResult(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+	ctx := context.Background()
+
+	result, err := mc.SetWithResult(ctx, "bkt1", "key1", []byte("val1"), Options{})
+	assert.NoError(t, err)
+	assert.True(t, result.Created, "expected the first set to create the key")
+
+	result, err = mc.SetWithResult(ctx, "bkt1", "key1", []byte("val2"), Options{})
+	assert.NoError(t, err)
+	assert.False(t, result.Created, "expected the second set to update the key")
+
+	// An expired key is replaced as a new one.
+	assert.NoError(t, mc.Set("bkt1", "key2", []byte("val1"), Options{TTL: time.Millisecond}))
+	time.Sleep(5 * time.Millisecond)
+	result, err = mc.SetWithResult(ctx, "bkt1", "key2", []byte("val2"), Options{})
+	assert.NoError(t, err)
+	assert.True(t, result.Created)
+
+	result, err = mc.SetWithResult(ctx, "bkt1", "key1", []byte("val3"), Options{SetMode: SetIfAbsent})
+	assert.ErrorIs(t, err, ErrKeyExists)
+	assert.False(t, result.Created)
+}
+
+func TestMinervaCache_GetOrDefault(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	// Miss: the default is stored, with the TTL of the options, and returned.
+	value, err := mc.GetOrDefault("bkt1", "key1", []byte("def"), Options{TTL: time.Minute})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("def"), value)
+	value, meta, err := mc.GetWithMeta("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("def"), value, "expected the default to be stored")
+	assert.InDelta(t, time.Minute, meta.TTLRemaining, float64(time.Second))
+
+	// Hit: the default is ignored.
+	assert.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{}))
+	value, err = mc.GetOrDefault("bkt1", "key2", []byte("def"), Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val2"), value)
+	value, _ = mc.Get("bkt1", "key2", Options{})
+	assert.Equal(t, []byte("val2"), value, "expected the existing value to be kept")
+
+	// A key cached as missing is set to the default.
+	assert.NoError(t, mc.SetNegative("bkt1", "key3", time.Minute))
+	value, err = mc.GetOrDefault("bkt1", "key3", []byte("def"), Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("def"), value)
+
+	// Concurrent misses all return the first default set.
+	const goroutines = 50
+	var wg sync.WaitGroup
+	values := make([][]byte, goroutines)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value, err := mc.GetOrDefault("bkt1", "key4", []byte(fmt.Sprintf("def%d", i)), Options{})
+			assert.NoError(t, err)
+			values[i] = value
+		}(i)
+	}
+	wg.Wait()
+	stored, _ := mc.Get("bkt1", "key4", Options{})
+	for _, value := range values {
+		assert.Equal(t, stored, value, "expected every caller to get the stored default")
+	}
+
+	_, err = NewMinervaCache(0, 0, &mockMetrics{}).GetOrDefault("bkt1", "key1", []byte("def"), Options{})
+	assert.ErrorIs(t, err, ErrCacheFull)
+}
+
+// fakeLoader is a Loader backed by a map of "bucket/key" to value, counting the Load calls.
+type fakeLoader struct {
+	values map[string]string
+	ttl    time.Duration
+	calls  atomic.Int32
+}
+
+func (l *fakeLoader) Load(bucket, key string) ([]byte, time.Duration, error) {
+	l.calls.Add(1)
+	value, ok := l.values[bucket+"/"+key]
+	if !ok {
+		return nil, 0, ErrKeyNotFound
+	}
+	return []byte(value), l.ttl, nil
+}
+
+func TestMinervaCache_Loader(t *testing.T) {
+	loader := &fakeLoader{values: map[string]string{"bkt1/key1": "val1"}, ttl: time.Minute}
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithLoader(loader), WithNegativeTTL(0))
+	defer mc.Stop()
+
+	value, meta, err := mc.GetWithMeta("bkt1", "key1", Options{})
+	assert.NoError(t, err, "expected the miss to be loaded")
+	assert.Equal(t, []byte("val1"), value)
+	assert.InDelta(t, time.Minute, meta.TTLRemaining, float64(time.Second), "expected the TTL of the loader")
+	assert.Equal(t, int32(1), loader.calls.Load())
+
+	value, err = mc.Get("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), value)
+	assert.Equal(t, int32(1), loader.calls.Load(), "expected the next Get to hit the cache")
+
+	_, err = mc.Get("bkt1", "missing", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound, "expected the loader miss to be returned")
+	assert.Equal(t, 1, mc.Len(), "expected the loader miss not to be cached")
+	_, err = mc.Get("bkt1", "missing", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Equal(t, int32(3), loader.calls.Load(), "expected each miss to call the loader")
+
+	// GetOrSet uses its own loader.
+	value, err = mc.GetOrSet("bkt1", "key2", Options{}, func() ([]byte, error) { return []byte("val2"), nil })
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val2"), value)
+	assert.Equal(t, int32(3), loader.calls.Load())
+}
+
+func TestMinervaCache_LoaderNegativeCache(t *testing.T) {
+	loader := &fakeLoader{values: map[string]string{}}
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithLoader(loader), WithNegativeTTL(50*time.Millisecond))
+	defer mc.Stop()
+
+	_, err := mc.Get("bkt1", "missing", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound, "expected the first miss to call the loader")
+	for i := 0; i < 3; i++ {
+		_, err = mc.Get("bkt1", "missing", Options{})
+		assert.ErrorIs(t, err, ErrNegativeCached)
+	}
+	assert.Equal(t, int32(1), loader.calls.Load(), "expected the tombstone to suppress the loader calls")
+	assert.Zero(t, mc.Len(), "expected the tombstone not to be counted")
+
+	time.Sleep(60 * time.Millisecond) // Wait for the tombstone to expire.
+	_, err = mc.Get("bkt1", "missing", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Equal(t, int32(2), loader.calls.Load(), "expected the loader to be called again once the tombstone expired")
+}
+
+// versionLoader is a Loader returning the current version of every key, counting the Load calls.
+type versionLoader struct {
+	version atomic.Int32
+	calls   atomic.Int32
+}
+
+func (l *versionLoader) Load(bucket, key string) ([]byte, time.Duration, error) {
+	l.calls.Add(1)
+	return []byte(fmt.Sprintf("v%d", l.version.Load())), 50 * time.Millisecond, nil
+}
+
+func TestMinervaCache_StaleWhileRevalidate(t *testing.T) {
+	loader := &versionLoader{}
+	loader.version.Store(1)
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithLoader(loader))
+	defer mc.Stop()
+	opts := Options{StaleWhileRevalidate: time.Hour}
+
+	value, err := mc.Get("bkt1", "key1", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), value)
+
+	// Once expired, the stale value is returned at once, and refreshed in the background.
+	loader.version.Store(2)
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, 0, mc.CollectExpired(), "expected the stale key to be kept within the grace period")
+	value, err = mc.Get("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), value, "expected the stale value")
+	assert.Eventually(t, func() bool {
+		value, err := mc.Get("bkt1", "key1", Options{})
+		return err == nil && string(value) == "v2"
+	}, time.Second, 5*time.Millisecond, "expected the refreshed value")
+	assert.Equal(t, int32(2), loader.calls.Load(), "expected a single refresh")
+
+	// The refreshed key keeps the grace period.
+	loader.version.Store(3)
+	time.Sleep(60 * time.Millisecond)
+	value, err = mc.Get("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v2"), value, "expected the stale value")
+
+	// Past the grace period, the key is expired.
+	assert.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{TTL: time.Millisecond, StaleWhileRevalidate: time.Millisecond}))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, 1, mc.CollectExpired())
+}
+
+func TestMinervaCache_StaleWhileRevalidate_NoLoader(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	assert.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: time.Millisecond, StaleWhileRevalidate: time.Hour}))
+	time.Sleep(5 * time.Millisecond)
+	_, err := mc.Get("bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, ErrKeyExpired, "expected no stale value without a loader to refresh it")
+}
+
+func TestMinervaCache_NameValidator(t *testing.T) {
+	validName := regexp.MustCompile(`^[a-z0-9_:-]+$`)
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithNameValidator(func(name string) error {
+		if len(name) > 8 {
+			return errors.New("longer than 8 bytes")
+		}
+		if !validName.MatchString(name) {
+			return errors.New("disallowed characters")
+		}
+		return nil
+	}))
+	defer mc.Stop()
+
+	assert.NoError(t, mc.Set("bkt1", "user:1", []byte("val1"), Options{}))
+	value, err := mc.Get("bkt1", "user:1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), value)
+
+	err = mc.Set("bucket_too_long", "key1", []byte("val1"), Options{})
+	assert.ErrorIs(t, err, ErrInvalidName, "expected a long bucket to be rejected")
+	assert.ErrorContains(t, err, "longer than 8 bytes")
+	assert.ErrorIs(t, mc.Set("bkt1", "key 1", []byte("val1"), Options{}), ErrInvalidName, "expected a space to be rejected")
+	assert.ErrorIs(t, mc.SetMulti("bkt1", map[string][]byte{"KEY1": []byte("val1")}, Options{}), ErrInvalidName)
+	_, err = mc.Get("bkt1", "key/1", Options{})
+	assert.ErrorIs(t, err, ErrInvalidName)
+	assert.ErrorIs(t, mc.Delete("bkt1", "key_that_is_long"), ErrInvalidName)
+	assert.Equal(t, 1, mc.size(), "expected the invalid names not to be set")
+
+	// The batch and move operations check all their names.
+	_, err = mc.GetMulti("bkt1", []string{"user:1", "key 1"}, Options{})
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, err = mc.DeleteMulti("bkt1", []string{"user:1", "key 1"})
+	assert.ErrorIs(t, err, ErrInvalidName)
+	assert.ErrorIs(t, mc.Move("bkt1", "user:1", "BKT2", "user:1", false), ErrInvalidName, "expected no invalid bucket created")
+	assert.ErrorIs(t, mc.Clear("bkt 1"), ErrInvalidName)
+	assert.Equal(t, []string{"bkt1"}, mc.Buckets())
+
+	assert.NoError(t, mc.Delete("bkt1", "user:1"))
+}
+
+// corruptValue flips a bit of the stored value of the key in place, as a memory bug would.
+func corruptValue(mc *MinervaCache, bucket, key string) {
+	s := mc.shardFor(bucket, key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.buckets[bucket][key].Value.(*cacheItem).value[0] ^= 1
+}
+
+func TestMinervaCache_Checksums(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithChecksums())
+	defer mc.Stop()
+
+	assert.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	assert.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{}))
+	assert.NoError(t, mc.Set("bkt1", "key2", []byte("val3"), Options{})) // The checksum follows the updates.
+	value, err := mc.Get("bkt1", "key2", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val3"), value)
+
+	corruptValue(mc, "bkt1", "key1")
+	_, err = mc.Get("bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	_, _, err = mc.GetWithMeta("bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	values, err := mc.GetMulti("bkt1", []string{"key1", "key2"}, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"key2": []byte("val3")}, values, "expected the corrupted key to be left out")
+
+	// Setting the key again stores a new checksum, and a moved key keeps its own.
+	assert.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	assert.NoError(t, mc.Move("bkt1", "key1", "bkt2", "key1", false))
+	value, err = mc.Get("bkt2", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), value)
+
+	// Without checksums, the corruption goes unnoticed.
+	plain := NewMinervaCache(10, 0, &mockMetrics{})
+	defer plain.Stop()
+	assert.NoError(t, plain.Set("bkt1", "key1", []byte("val1"), Options{}))
+	corruptValue(plain, "bkt1", "key1")
+	value, err = plain.Get("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("wal1"), value)
+}
+
+func TestMinervaCache_SetNegative(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+
+	assert.NoError(t, mc.SetNegative("bkt1", "key1", time.Minute))
+	_, err := mc.Get("bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, ErrNegativeCached, "expected the tombstone to replace the value")
+	calls := 0
+	_, err = mc.GetOrSet("bkt1", "key1", Options{}, func() ([]byte, error) { calls++; return []byte("val1"), nil })
+	assert.ErrorIs(t, err, ErrNegativeCached)
+	assert.Zero(t, calls, "expected the tombstone to suppress the loader of GetOrSet too")
+
+	// Setting the key removes the tombstone.
+	mc.Set("bkt1", "key1", []byte("val2"), Options{})
+	value, err := mc.Get("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val2"), value)
+	mc.Delete("bkt1", "key1")
+	_, err = mc.Get("bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, ErrBucketNotFound)
+
+	// The background TTL check removes the expired tombstones.
+	assert.NoError(t, mc.SetNegative("bkt1", "key2", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	mc.checkExpiredItems()
+	assert.Empty(t, mc.shardFor("bkt1", "key2").negatives)
+
+	assert.ErrorIs(t, mc.SetNegative("bkt1", "key3", 0), ErrInvalidTTL)
+}
+
+func TestMinervaCache_LoaderConcurrentMisses(t *testing.T) {
+	loader := &fakeLoader{values: map[string]string{"bkt1/key1": "val1"}}
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithLoader(slowLoader{loader}))
+	defer mc.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := mc.Get("bkt1", "key1", Options{})
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("val1"), value)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), loader.calls.Load(), "expected the concurrent misses to share a single load")
+}
+
+// slowLoader delays the loads, so the concurrent misses overlap.
+type slowLoader struct {
+	*fakeLoader
+}
+
+func (l slowLoader) Load(bucket, key string) ([]byte, time.Duration, error) {
+	time.Sleep(20 * time.Millisecond)
+	return l.fakeLoader.Load(bucket, key)
+}
+
+func TestMinervaCache_Increment(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	value, err := mc.Increment("bkt1", "counter", 5, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), value, "expected a missing key to be initialized to the delta")
+
+	value, err = mc.Increment("bkt1", "counter", 3, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), value)
+
+	value, err = mc.Decrement("bkt1", "counter", 10, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-2), value)
+
+	stored, _ := mc.Get("bkt1", "counter", Options{})
+	assert.Equal(t, []byte("-2"), stored, "expected the counter to be stored as a base-10 string")
+
+	value, err = mc.Decrement("bkt1", "new", 4, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-4), value, "expected a missing key to be initialized to the negated delta")
+}
+
+func TestMinervaCache_IncrementErrors(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "text", []byte("abc"), Options{})
+	_, err := mc.Increment("bkt1", "text", 1, Options{})
+	assert.ErrorIs(t, err, ErrNotInteger)
+
+	value, _ := mc.Get("bkt1", "text", Options{})
+	assert.Equal(t, []byte("abc"), value, "expected a non-integer value to be left unchanged")
+
+	mc.Set("bkt1", "max", []byte(fmt.Sprint(int64(math.MaxInt64))), Options{})
+	_, err = mc.Increment("bkt1", "max", 1, Options{})
+	assert.ErrorIs(t, err, ErrOverflow)
+}
+
+func TestMinervaCache_IncrementKeepsTTL(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Increment("bkt1", "counter", 1, Options{TTL: 20 * time.Millisecond})
+	mc.Increment("bkt1", "counter", 1, Options{}) // Does not reset the TTL of an existing counter.
+
+	time.Sleep(30 * time.Millisecond)
+	_, err := mc.Get("bkt1", "counter", Options{})
+	assert.ErrorIs(t, err, ErrKeyExpired)
+}
+
+func TestMinervaCache_IncrementConcurrent(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	const goroutines, increments = 50, 100
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				mc.Increment("bkt1", "counter", 1, Options{})
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, err := mc.Increment("bkt1", "counter", 0, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(goroutines*increments), value, "expected no lost updates under concurrent increments")
+}
+
+func TestMinervaCache_Len(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	assert.Equal(t, 0, mc.Len(), "expected empty cache to have length 0")
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt2", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key1", []byte("val1-updated"), Options{}) // Updating an existing key should not change the length.
+
+	assert.Equal(t, 3, mc.Len(), "expected cache to have 3 items")
+
+	mc.Delete("bkt1", "key1")
+	assert.Equal(t, 2, mc.Len(), "expected cache to have 2 items after delete")
+}
+
+func TestMinervaCache_BucketLen(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt2", "key1", []byte("val1"), Options{})
+
+	n, err := mc.BucketLen("bkt1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n, "expected bkt1 to have 2 keys")
+
+	n, err = mc.BucketLen("bkt2")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n, "expected bkt2 to have 1 key")
+
+	// A bucket is removed once its last key is deleted.
+	mc.Delete("bkt2", "key1")
+	_, err = mc.BucketLen("bkt2")
+	assert.ErrorIs(t, err, ErrBucketNotFound)
+
+	_, err = mc.BucketLen("missing")
+	assert.ErrorIs(t, err, ErrBucketNotFound)
+}
+
+func TestMinervaCache_Keys(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key3", []byte("val3"), Options{})
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt1", "expired", []byte("gone"), Options{TTL: time.Millisecond})
+
+	time.Sleep(5 * time.Millisecond) // Let the TTL pass. No background sweep runs since the interval is 0.
+
+	keys, err := mc.Keys("bkt1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key1", "key2", "key3"}, keys, "expected sorted keys without the expired one")
+
+	_, err = mc.Keys("missing")
+	assert.ErrorIs(t, err, ErrBucketNotFound)
+}
+
+func TestMinervaCache_Buckets(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	assert.Empty(t, mc.Buckets(), "expected no buckets in an empty cache")
+
+	mc.Set("bkt2", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt3", "key1", []byte("val1"), Options{TTL: time.Millisecond})
+
+	time.Sleep(5 * time.Millisecond) // bkt3 only holds an expired item now.
+
+	assert.Equal(t, []string{"bkt1", "bkt2"}, mc.Buckets())
+	assert.Equal(t, map[string]int{"bkt1": 1, "bkt2": 1}, mc.BucketSizes())
+}
+
+func TestMinervaCache_ScanKeys(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	_, _, err := mc.ScanKeys("bkt", "", 2)
+	assert.ErrorIs(t, err, ErrBucketNotFound)
+
+	for _, key := range []string{"c", "a", "e", "b", "d"} {
+		mc.Set("bkt", key, []byte("val"), Options{})
+	}
+	mc.Set("bkt", "bb", []byte("val"), Options{TTL: time.Millisecond})
+	time.Sleep(5 * time.Millisecond) // bb is expired but not collected yet.
+
+	keys, next, err := mc.ScanKeys("bkt", "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, keys)
+	assert.Equal(t, "b", next)
+
+	// A key written before the cursor during the scan doesn't shift the next pages.
+	mc.Set("bkt", "aa", []byte("val"), Options{})
+	keys, next, err = mc.ScanKeys("bkt", next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "d"}, keys)
+
+	keys, next, err = mc.ScanKeys("bkt", next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e"}, keys)
+	assert.Empty(t, next, "expected no cursor on the last page")
+
+	keys, _, err = mc.ScanKeys("bkt", "", 0)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 6, "expected all the keys without a limit")
+}
+
+func TestMinervaCache_Health(t *testing.T) {
+	start := time.Now()
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+
+	health := mc.Health()
+	assert.False(t, health.Stopped)
+	assert.False(t, health.StartedAt.Before(start))
+	assert.Equal(t, 1, health.Size)
+	assert.Equal(t, 1, health.BucketCount)
+
+	mc.Stop()
+	assert.True(t, mc.Health().Stopped)
+}
+
+func TestNoTTL(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	err := mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	assert.NoError(t, err)
+
+	value, err := mc.Get("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), value)
+
+	_, err = mc.Get("bkt1", "key2", Options{})
+	assert.Error(t, err)
+}
+
+func TestTTL(t *testing.T) {
+	ttlCheckInterval := 10 * time.Millisecond
+	mc := NewMinervaCache(10, ttlCheckInterval, &mockMetrics{})
+	defer mc.Stop()
+
+	err := mc.Set("bkt1", "key1", []byte("val1"), Options{
+		TTL: 300 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	time.Sleep(150 * time.Millisecond) // Half of the TTL duration.
+
+	val, err := mc.Get("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), val)
+
+	time.Sleep(300 * time.Millisecond) // Wait for the TTL to expire.
+
+	_, err = mc.Get("bkt1", "key1", Options{})
+	assert.Error(t, err, "expected error after TTL expiration")
+}
+
+func TestCapacity(t *testing.T) {
+	mc := NewMinervaCache(3, 0, &mockMetrics{})
+	defer mc.Stop()
+	err := mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	assert.NoError(t, err)
+
+	err = mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	assert.NoError(t, err)
+
+	err = mc.Set("bkt1", "key3", []byte("val3"), Options{})
+	assert.NoError(t, err)
+
+	// Reading from a full cache doesn't evict anything.
+	for _, key := range []string{"key1", "key2", "key3"} {
+		_, gErr := mc.Get("bkt1", key, Options{})
+		assert.NoError(t, gErr, "expected %s to be available in the full cache", key)
+	}
+	assert.Equal(t, 3, mc.Len())
+
+	err = mc.Set("bkt1", "key4", []byte("val4"), Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, mc.Len(), "expected the set to evict a key of the full cache")
+}
+
+func TestCapacity_Zero(t *testing.T) {
+	mc := NewMinervaCache(0, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	assert.NotPanics(t, func() {
+		err := mc.Set("bkt1", "key1", []byte("val1"), Options{EvictionPolicy: LRUEvictionPolicy})
+		assert.ErrorIs(t, err, ErrCacheFull, "expected a zero capacity cache to reject the set")
+
+		_, err = mc.Get("bkt1", "key1", Options{})
+		assert.ErrorIs(t, err, ErrBucketNotFound)
+
+		assert.False(t, mc.evict(OldestEvictionPolicy, nil), "expected nothing to evict in an empty cache")
+		mc.deleteAndRemoveFromInsertOrder(mc.shards[0], nil)
+	})
+	assert.Equal(t, 0, mc.Len())
+	assertOrderIntegrity(t, mc)
+}
+
+func TestCapacity_ZeroRejectsWrites(t *testing.T) {
+	mc := NewMinervaCache(0, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	assert.ErrorIs(t, mc.SetMulti("bkt1", map[string][]byte{"key1": []byte("val1")}, Options{}), ErrCacheFull)
+	_, err := mc.GetOrSet("bkt1", "key1", Options{}, func() ([]byte, error) { return []byte("val1"), nil })
+	assert.ErrorIs(t, err, ErrCacheFull)
+	_, err = mc.Increment("bkt1", "counter", 1, Options{})
+	assert.ErrorIs(t, err, ErrCacheFull)
+	assert.Equal(t, 0, mc.Len())
+	assert.Empty(t, mc.Buckets())
+}
+
+func TestCapacity_One(t *testing.T) {
+	for _, policy := range []EvictionPolicy{NoEvictionPolicy, OldestEvictionPolicy, NewestEvictionPolicy, LRUEvictionPolicy, MRUEvictionPolicy, LFUEvictionPolicy} {
+		mc := NewMinervaCache(1, 0, &mockMetrics{})
+		opts := Options{EvictionPolicy: policy}
+
+		assert.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), opts))
+		// Updating the only key doesn't evict it.
+		assert.NoError(t, mc.Set("bkt1", "key1", []byte("val1-updated"), opts))
+		value, err := mc.Get("bkt1", "key1", opts)
+		assert.NoError(t, err, "policy %d", policy)
+		assert.Equal(t, []byte("val1-updated"), value, "policy %d", policy)
+
+		// A second key evicts the first one, whatever the policy, and never itself.
+		assert.NoError(t, mc.Set("bkt2", "key2", []byte("val2"), opts))
+		_, err = mc.Get("bkt1", "key1", opts)
+		assert.ErrorIs(t, err, ErrBucketNotFound, "policy %d", policy)
+		value, err = mc.Get("bkt2", "key2", opts)
+		assert.NoError(t, err, "policy %d", policy)
+		assert.Equal(t, []byte("val2"), value, "policy %d", policy)
+		assert.Equal(t, uint64(1), mc.Stats().Evicts, "policy %d", policy)
+		assertOrderIntegrity(t, mc)
+
+		mc.Stop()
+	}
+}
+
+func TestEviction(t *testing.T) {
+	// Test the default eviction policy by filling the cache and checking if the least recently used entry is evicted.
+	mc := NewMinervaCache(3, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{})
+
+	mc.Set("bkt1", "key4", []byte("val4"), Options{}) // This should evict "key1" as the least recently used key.
+
+	_, err := mc.Get("bkt1", "key1", Options{})
+	assert.Error(t, err, "expected error for evicted key")
+
+	// Reading from the full cache doesn't evict anything.
+	val, err := mc.Get("bkt1", "key2", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val2"), val, "expected key2 to be available")
+
+	mc.Set("bkt1", "key5", []byte("val5"), Options{}) // This should evict "key2" as the least recently used key.
+	_, err = mc.Get("bkt1", "key2", Options{})
+	assert.Error(t, err, "expected error for evicted key")
+
+	// Check if the other keys are still available.
+	val, err = mc.Get("bkt1", "key3", Options{})
+	assert.NoError(t, err, "expected no error for key3")
+	assert.Equal(t, []byte("val3"), val, "expected key3 to be available")
+}
+
+func TestNoEviction(t *testing.T) {
+	mc := NewMinervaCacheWithBucketLimits(4, 2, 0, &mockMetrics{}, WithDefaultPolicy(LRUEvictionPolicy))
+	defer mc.Stop()
+	noEviction := Options{NoEviction: true}
+
+	assert.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), noEviction))
+	assert.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), noEviction))
+	assert.ErrorIs(t, mc.Set("bkt1", "key3", []byte("val3"), noEviction), ErrCacheFull, "expected the full bucket to reject a new key")
+
+	assert.NoError(t, mc.Set("bkt2", "key1", []byte("val1"), noEviction))
+	assert.NoError(t, mc.Set("bkt2", "key2", []byte("val2"), noEviction))
+	assert.ErrorIs(t, mc.Set("bkt3", "key1", []byte("val1"), noEviction), ErrCacheFull, "expected the full cache to reject a new key")
+	_, err := mc.Increment("bkt3", "counter", 1, noEviction)
+	assert.ErrorIs(t, err, ErrCacheFull)
+
+	// Updating an existing key still succeeds, and a set without the flag evicts with the default policy.
+	assert.NoError(t, mc.Set("bkt1", "key1", []byte("val1-updated"), noEviction))
+	assert.NoError(t, mc.Set("bkt3", "key1", []byte("val1"), Options{}))
+	assert.Equal(t, uint64(1), mc.Stats().Evicts)
+	assert.Equal(t, 4, mc.Len())
+	assertOrderIntegrity(t, mc)
+
+	bytes := NewMinervaCacheBytes(10, 0, &mockMetrics{})
+	defer bytes.Stop()
+	assert.NoError(t, bytes.Set("bkt1", "key1", make([]byte, 6), noEviction))
+	assert.ErrorIs(t, bytes.Set("bkt1", "key2", make([]byte, 6), noEviction), ErrCacheFull, "expected the value not to fit")
+	assert.Equal(t, 1, bytes.Len(), "expected the reserved slot to be released")
+	assert.Equal(t, int64(6), bytes.SizeBytes())
+}
+
+func TestGet_FullCacheHonorsPolicy(t *testing.T) {
+	mc := NewMinervaCache(3, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mru := Options{EvictionPolicy: MRUEvictionPolicy}
+	mc.Set("bkt1", "key1", []byte("val1"), mru)
+	mc.Set("bkt1", "key2", []byte("val2"), mru)
+	mc.Set("bkt1", "key3", []byte("val3"), mru)
+
+	// Reading key1 from the full cache doesn't evict an unrelated key, and makes it the most recently used one.
+	val, err := mc.Get("bkt1", "key1", mru)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), val)
+	keys, _ := mc.Keys("bkt1")
+	assert.Equal(t, []string{"key1", "key2", "key3"}, keys, "expected no eviction on read")
+	assert.Equal(t, Stats{Hits: 1, Sets: 3, Size: 3, BucketCount: 1, Capacity: 3, Utilization: 1}, mc.Stats())
+
+	// The next set evicts with the MRU policy, not the oldest key.
+	mc.Set("bkt1", "key4", []byte("val4"), mru)
+	keys, _ = mc.Keys("bkt1")
+	assert.Equal(t, []string{"key2", "key3", "key4"}, keys, "expected the most recently used key1 to be evicted")
+	assertOrderIntegrity(t, mc)
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	mc := NewMinervaCache(3, 0, &mockMetrics{}, WithDefaultPolicy(LRUEvictionPolicy))
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{})
+
+	// The get with empty options tracks the access as LRU, so key2 is the least recently used key.
+	mc.Get("bkt1", "key1", Options{})
+	assert.Equal(t, "key1", mostRecentItem(mc).key)
+	mc.Set("bkt1", "key4", []byte("val4"), Options{})
+	keys, _ := mc.Keys("bkt1")
+	assert.Equal(t, []string{"key1", "key3", "key4"}, keys, "expected the least recently used key2 to be evicted")
+
+	// An explicit policy still overrides the default.
+	mc.Set("bkt1", "key5", []byte("val5"), Options{EvictionPolicy: NewestEvictionPolicy})
+	keys, _ = mc.Keys("bkt1")
+	assert.Equal(t, []string{"key1", "key3", "key5"}, keys, "expected the newest key4 to be evicted")
+
+	// Without a default policy, the reads aren't tracked and the oldest key is evicted.
+	mc2 := NewMinervaCache(3, 0, &mockMetrics{})
+	defer mc2.Stop()
+	mc2.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc2.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc2.Set("bkt1", "key3", []byte("val3"), Options{})
+	mc2.Get("bkt1", "key1", Options{})
+	mc2.Set("bkt1", "key4", []byte("val4"), Options{})
+	keys, _ = mc2.Keys("bkt1")
+	assert.Equal(t, []string{"key2", "key3", "key4"}, keys, "expected the oldest key1 to be evicted")
+}
+
+func TestLFUEviction(t *testing.T) {
+	mc := NewMinervaCache(4, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{})
+
+	// Skewed access pattern: key1 is hot, key3 is warm and key2 is never read.
+	for i := 0; i < 3; i++ {
+		mc.Get("bkt1", "key1", Options{})
+	}
+	for i := 0; i < 2; i++ {
+		mc.Get("bkt1", "key3", Options{})
+	}
+
+	mc.Set("bkt1", "key4", []byte("val4"), Options{}) // Fills the cache.
+	// Both key2 and key4 have the lowest frequency, key2 is the oldest so it is evicted.
+	mc.Set("bkt1", "key5", []byte("val5"), Options{EvictionPolicy: LFUEvictionPolicy})
+
+	keys, err := mc.Keys("bkt1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key1", "key3", "key4", "key5"}, keys, "expected the least frequently used key2 to be evicted")
+
+	// key4 and key5 are now the least frequently used. key4 reached that frequency first.
+	mc.Set("bkt1", "key6", []byte("val6"), Options{EvictionPolicy: LFUEvictionPolicy})
+	keys, _ = mc.Keys("bkt1")
+	assert.Equal(t, []string{"key1", "key3", "key5", "key6"}, keys, "expected key4 to be evicted")
+}
+
+func TestLFUEviction_HotButOld(t *testing.T) {
+	// Unlike LRU, a hot key that was not accessed recently should survive eviction.
+	mc := NewMinervaCache(3, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "hot", []byte("val"), Options{})
+	mc.Set("bkt1", "hot", []byte("val"), Options{}) // Updates count as an access too.
+	mc.Set("bkt1", "hot", []byte("val"), Options{})
+	mc.Set("bkt1", "cold1", []byte("val"), Options{EvictionPolicy: LRUEvictionPolicy})
+	mc.Set("bkt1", "cold2", []byte("val"), Options{EvictionPolicy: LRUEvictionPolicy})
+	mc.Set("bkt1", "new", []byte("val"), Options{EvictionPolicy: LFUEvictionPolicy})
+
+	keys, _ := mc.Keys("bkt1")
+	assert.Equal(t, []string{"cold2", "hot", "new"}, keys, "expected the cold key to be evicted, not the hot one")
+}
+
+func TestBucketLimits(t *testing.T) {
+	mc := NewMinervaCacheWithBucketLimits(10, 3, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{})
+	mc.Set("bkt2", "key1", []byte("val1"), Options{})
+	mc.Set("bkt2", "key2", []byte("val2"), Options{})
+
+	// bkt1 is at its per-bucket cap, so inserting into it only evicts within bkt1 even though the cache is not full.
+	mc.Set("bkt1", "key4", []byte("val4"), Options{})
+
+	keys, _ := mc.Keys("bkt1")
+	assert.Equal(t, []string{"key2", "key3", "key4"}, keys, "expected the oldest key of bkt1 to be evicted")
+	keys, _ = mc.Keys("bkt2")
+	assert.Equal(t, []string{"key1", "key2"}, keys, "expected bkt2 to be untouched")
+	assert.Equal(t, 5, mc.Len())
+
+	// The policy is honoured within the bucket as well.
+	mc.Set("bkt1", "key5", []byte("val5"), Options{EvictionPolicy: NewestEvictionPolicy})
+	keys, _ = mc.Keys("bkt1")
+	assert.Equal(t, []string{"key2", "key3", "key5"}, keys, "expected the newest key of bkt1 to be evicted")
+
+	// Updating an existing key of a full bucket does not evict.
+	mc.Set("bkt1", "key2", []byte("val2-updated"), Options{})
+	n, _ := mc.BucketLen("bkt1")
+	assert.Equal(t, 3, n)
+}
+
+func TestBucketLimits_Disabled(t *testing.T) {
+	mc := NewMinervaCacheWithBucketLimits(3, 0, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt2", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{}) // Global eviction of the oldest key, which is in bkt2.
+
+	assert.Equal(t, []string{"bkt1"}, mc.Buckets(), "expected the global capacity to apply across buckets")
+}
+
+func TestBucketLimits_SingleKeyBucket(t *testing.T) {
+	// Evicting the only key of a bucket removes the bucket, the new key must still land in the cache.
+	mc := NewMinervaCacheWithBucketLimits(10, 1, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+
+	keys, err := mc.Keys("bkt1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"key2"}, keys)
+}
+
+func TestMetricsHooks_SetAndDelete(t *testing.T) {
+	cm := &countingMetrics{}
+	mc := NewMinervaCache(10, 0, cm)
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt1", "key1", []byte("val1-updated"), Options{})
+	assert.Equal(t, 2, cm.sets, "expected 2 sets of new keys")
+	assert.Equal(t, 1, cm.setExists, "expected 1 set of an existing key")
+
+	mc.Delete("bkt1", "key1")
+	mc.Delete("bkt1", "key1")    // Key is gone now.
+	mc.Delete("missing", "key1") // Bucket does not exist.
+	assert.Equal(t, 1, cm.deletes, "expected 1 delete")
+	assert.Equal(t, 2, cm.notFound, "expected 2 not found deletes")
+	assert.Equal(t, 0, cm.misses, "expected deletes of missing keys not to count as misses")
+}
+
+func TestMetricsHooks_Get(t *testing.T) {
+	cm := &countingMetrics{}
+	mc := NewMinervaCache(10, 0, cm)
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{TTL: time.Millisecond})
+
+	mc.Get("bkt1", "key1", Options{})
+	mc.Get("bkt1", "missing", Options{})
+	mc.Get("missing", "key1", Options{})
+	time.Sleep(5 * time.Millisecond)
+	mc.Get("bkt1", "key2", Options{}) // Expired inline.
+
+	assert.Equal(t, 1, cm.hits, "expected 1 hit")
+	assert.Equal(t, 3, cm.misses, "expected 3 misses")
+	assert.Equal(t, 2, cm.notFound, "expected 2 not found")
+	assert.Equal(t, 1, cm.inlineExpire, "expected 1 inline expiration")
+	assert.Equal(t, 0, cm.bgExpire, "expected no background expiration")
+}
+
+func TestMetricsHooks_Size(t *testing.T) {
+	// No background TTL check runs with a 0 interval, so the size must be tracked on every mutation.
+	cm := &countingMetrics{}
+	mc := NewMinervaCache(3, 0, cm)
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt2", "key1", []byte("val1"), Options{})
+	assert.Equal(t, 3, cm.size, "expected size 3 after 3 sets")
+
+	mc.Set("bkt1", "key1", []byte("val1-updated"), Options{})
+	assert.Equal(t, 3, cm.size, "expected size to be unchanged after an update")
+
+	mc.Delete("bkt1", "key2")
+	assert.Equal(t, 2, cm.size, "expected size 2 after a delete")
+
+	mc.Set("bkt1", "key3", []byte("val3"), Options{})
+	mc.Set("bkt1", "key4", []byte("val4"), Options{}) // Evicts the oldest key to make room.
+	assert.Equal(t, 3, cm.size, "expected size to stay at capacity after an eviction")
+
+	mc.Set("bkt3", "key1", []byte("val1"), Options{TTL: time.Millisecond}) // Evicts again.
+	time.Sleep(5 * time.Millisecond)
+	mc.checkExpiredItems()
+	assert.Equal(t, 2, cm.size, "expected size 2 after an expiration")
+}
+
+func TestMetricsHooks_EvictAndExpire(t *testing.T) {
+	cm := &countingMetrics{}
+	mc := NewMinervaCache(2, 0, cm)
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{}) // Evicts key1.
+	assert.Equal(t, 1, cm.evicts, "expected 1 eviction")
+
+	mc.Delete("bkt1", "key2")
+	mc.Set("bkt1", "key4", []byte("val4"), Options{TTL: time.Millisecond})
+	time.Sleep(5 * time.Millisecond)
+	mc.checkExpiredItems() // Run the background check directly instead of waiting for the ticker.
+
+	assert.Equal(t, 1, cm.bgExpire, "expected 1 background expiration")
+	assert.Equal(t, 0, cm.inlineExpire, "expected no inline expiration")
+}
+
+func TestShards(t *testing.T) {
+	mc := NewMinervaCache(1000, 0, &mockMetrics{})
+	defer mc.Stop()
+	assert.Len(t, mc.shards, DefaultShards, "expected the default number of shards")
+
+	for i := 0; i < 1000; i++ {
+		mc.Set(fmt.Sprintf("bkt%d", i%3), fmt.Sprintf("key%d", i), []byte("val"), Options{})
+	}
+	for i, s := range mc.shards {
+		assert.NotZero(t, s.order.Len(), "expected the keys to be spread over all the shards, shard %d is empty", i)
+	}
+	assertOrderIntegrity(t, mc)
+
+	mc = NewMinervaCache(10, 0, &mockMetrics{}, WithShards(0))
+	defer mc.Stop()
+	assert.Len(t, mc.shards, DefaultShards, "expected an invalid number of shards to be ignored")
+}
+
+// TestShards_Balance checks that structured keys, with shared prefixes and sequential ids, are spread evenly enough
+// over the shards by the default and the seeded hashes that no shard gets over twice the mean load.
+func TestShards_Balance(t *testing.T) {
+	for name, hash := range map[string]ShardHash{"fnv": FNVShardHash, "seeded": NewSeededShardHash()} {
+		t.Run(name, func(t *testing.T) {
+			mc := NewMinervaCache(0, 0, &mockMetrics{}, WithShardHash(hash))
+			defer mc.Stop()
+
+			loads := make([]int, len(mc.shards))
+			n := 0
+			for _, bucket := range []string{"users", "sessions", "orders"} {
+				for id := 0; id < 2000; id++ {
+					for _, field := range []string{"profile", "settings", "avatar"} {
+						loads[mc.shardIndex(bucket, fmt.Sprintf("user:%d:%s", id, field))]++
+						n++
+					}
+				}
+			}
+
+			mean := float64(n) / float64(len(loads))
+			for i, load := range loads {
+				assert.LessOrEqual(t, float64(load), 2*mean, "expected shard %d not to be over twice the mean load", i)
+			}
+		})
+	}
+}
+
+func TestShards_Hash(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithShardHash(func(bucket, key string) uint64 { return 3 }))
+	defer mc.Stop()
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt2", "key2", []byte("val2"), Options{})
+	assert.Equal(t, 2, mc.shards[3].order.Len(), "expected the keys in the shard picked by the hash")
+
+	assert.NotEqual(t, FNVShardHash("ab", "c"), FNVShardHash("a", "bc"), "expected the bucket to be separated from the key")
+	assert.Equal(t, uint64(0xaf63bd4c8601b7df), FNVShardHash("", ""), "expected the FNV-1a hash of a single 0 byte")
+}
+
+// TestShards_GlobalEviction replays the same operations on a sharded and a single shard cache, checking that the
+// eviction policies still pick the victim across the whole cache rather than within a shard.
+func TestShards_GlobalEviction(t *testing.T) {
+	policies := []EvictionPolicy{OldestEvictionPolicy, NewestEvictionPolicy, LRUEvictionPolicy, MRUEvictionPolicy, LFUEvictionPolicy}
+	for _, policy := range policies {
+		t.Run(fmt.Sprint(policy), func(t *testing.T) {
+			sharded := NewMinervaCacheWithBucketLimits(20, 8, 0, &mockMetrics{}, WithShards(8))
+			defer sharded.Stop()
+			single := NewMinervaCacheWithBucketLimits(20, 8, 0, &mockMetrics{}, WithShards(1))
+			defer single.Stop()
+
+			for i := 0; i < 500; i++ {
+				bucket, key := fmt.Sprintf("bkt%d", i%4), fmt.Sprintf("key%d", (i*7)%60)
+				for _, mc := range []*MinervaCache{sharded, single} {
+					if i%3 == 0 {
+						mc.Get(bucket, key, Options{EvictionPolicy: policy})
+					} else {
+						mc.Set(bucket, key, []byte("val"), Options{EvictionPolicy: policy})
+					}
+				}
+			}
+
+			assert.Equal(t, single.Buckets(), sharded.Buckets())
+			for _, bucket := range single.Buckets() {
+				want, _ := single.Keys(bucket)
+				got, _ := sharded.Keys(bucket)
+				assert.Equal(t, want, got, "expected the same keys to be evicted from %s", bucket)
+			}
+			assertOrderIntegrity(t, sharded)
+		})
+	}
+}
+
+func TestShards_ConcurrentCapacity(t *testing.T) {
+	mc := NewMinervaCacheWithBucketLimits(100, 40, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	const goroutines, keys = 16, 500
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				bucket, key := fmt.Sprintf("bkt%d", i%4), fmt.Sprintf("key%d-%d", g, i)
+				mc.Set(bucket, key, []byte("val"), Options{EvictionPolicy: EvictionPolicy(i%5 + 1)})
+				mc.Get(bucket, key, Options{})
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, mc.Len(), 100, "expected the capacity to be respected across all the shards")
+	assertOrderIntegrity(t, mc)
+}
+
+// TODO: Add more tests for different eviction policies and edge cases.
+
+// batchSize is the number of keys read or written per iteration in the batch benchmarks.
+// The benchmarks run in parallel, so GetMulti shows the amortization of locking each shard once per batch under contention.
+const batchSize = 50
+
+func benchmarkKeys() []string {
+	keys := make([]string, batchSize)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	return keys
+}
+
+func TestMaxBytes(t *testing.T) {
+	mc := NewMinervaCacheBytes(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	assert.NoError(t, mc.Set("bkt", "a", []byte("1234"), Options{EvictionPolicy: OldestEvictionPolicy}))
+	assert.NoError(t, mc.Set("bkt", "b", []byte("12"), Options{EvictionPolicy: OldestEvictionPolicy}))
+	assert.NoError(t, mc.Set("bkt", "c", []byte("1234"), Options{EvictionPolicy: OldestEvictionPolicy}))
+	assert.Equal(t, int64(10), mc.SizeBytes())
+	assert.Equal(t, 3, mc.Len())
+
+	// Only 3 keys are cached, but the budget is in bytes, so both a and b are evicted to fit d.
+	assert.NoError(t, mc.Set("bkt", "d", []byte("123456"), Options{EvictionPolicy: OldestEvictionPolicy}))
+	assert.Equal(t, int64(10), mc.SizeBytes())
+	assert.Equal(t, 2, mc.Len())
+	_, err := mc.Get("bkt", "a", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = mc.Get("bkt", "b", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assertOrderIntegrity(t, mc)
+
+	// Many tiny values fit where a few large ones didn't.
+	mc.FlushAll()
+	assert.Equal(t, int64(0), mc.SizeBytes())
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, mc.Set("bkt", fmt.Sprintf("key%d", i), []byte("x"), Options{}))
+	}
+	assert.Equal(t, 10, mc.Len())
+
+	// A value larger than the whole budget can never fit.
+	assert.ErrorIs(t, mc.Set("bkt", "big", make([]byte, 11), Options{}), ErrValueTooLarge)
+	assert.Equal(t, 10, mc.Len())
+	assertOrderIntegrity(t, mc)
+}
+
+func TestMaxBytes_Update(t *testing.T) {
+	mc := NewMinervaCacheBytes(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	assert.NoError(t, mc.Set("bkt", "a", []byte("1234"), Options{}))
+	assert.NoError(t, mc.Set("bkt", "b", []byte("1234"), Options{}))
+
+	// Growing b past the budget evicts the oldest key.
+	assert.NoError(t, mc.Set("bkt", "b", []byte("12345678"), Options{EvictionPolicy: OldestEvictionPolicy}))
+	assert.Equal(t, int64(8), mc.SizeBytes())
+	_, err := mc.Get("bkt", "a", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// Shrinking it gives the bytes back.
+	assert.NoError(t, mc.Set("bkt", "b", []byte("1"), Options{}))
+	assert.Equal(t, int64(1), mc.SizeBytes())
+
+	// Increment and delete keep the accounting up to date too.
+	_, err = mc.Increment("bkt", "b", 99, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), mc.SizeBytes())
+	assert.NoError(t, mc.Delete("bkt", "b"))
+	assert.Equal(t, int64(0), mc.SizeBytes())
+	assertOrderIntegrity(t, mc)
+}
+
+func TestMaxBytes_Expiry(t *testing.T) {
+	mc := NewMinervaCacheBytes(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	assert.NoError(t, mc.Set("bkt", "a", []byte("1234"), Options{TTL: time.Millisecond}))
+	assert.NoError(t, mc.Set("bkt", "b", []byte("1234"), Options{}))
+	time.Sleep(5 * time.Millisecond)
+
+	mc.checkExpiredItems()
+	assert.Equal(t, int64(4), mc.SizeBytes(), "expected the expired value to be released")
+	assertOrderIntegrity(t, mc)
+}
+
+func TestExport(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+	assert.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	assert.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{TTL: time.Minute}))
+	assert.NoError(t, mc.Set("bkt1", "expired", []byte("val3"), Options{TTL: time.Millisecond}))
+	assert.NoError(t, mc.Set("bkt2", "key1", []byte("val4"), Options{}))
+	time.Sleep(5 * time.Millisecond)
+	recent := mostRecentItem(mc)
+
+	entries, err := mc.Export("bkt1")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2, "expected the expired key to be skipped")
+	assert.Equal(t, []byte("val1"), entries["key1"].Value)
+	assert.Zero(t, entries["key1"].Meta.TTLRemaining)
+	assert.Equal(t, []byte("val2"), entries["key2"].Value)
+	assert.InDelta(t, time.Minute, entries["key2"].Meta.TTLRemaining, float64(time.Second))
+	assert.Same(t, recent, mostRecentItem(mc), "expected exporting not to touch the keys")
+
+	_, err = mc.Export("missing")
+	assert.ErrorIs(t, err, ErrBucketNotFound)
+}
+
+func TestStats(t *testing.T) {
+	mc := NewMinervaCacheWithBucketLimits(10, 2, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	mc.Set("bkt1", "key1", []byte("val1-updated"), Options{}) // Existing keys count as sets too.
+	mc.Set("bkt1", "key2", []byte("val2"), Options{})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{EvictionPolicy: OldestEvictionPolicy}) // Evicts key1.
+	mc.Set("bkt2", "key1", []byte("val1"), Options{})
+
+	mc.Get("bkt1", "key2", Options{})
+	mc.Get("bkt1", "key1", Options{})
+	mc.Delete("bkt1", "key2")
+	mc.Delete("bkt1", "missing") // Not counted as a delete.
+
+	mc.Set("bkt3", "key1", []byte("val1"), Options{TTL: time.Millisecond})
+	time.Sleep(5 * time.Millisecond)
+	mc.Get("bkt3", "key1", Options{}) // Expired inline, also a miss.
+
+	assert.Equal(t, Stats{
+		Hits:        1,
+		Misses:      2,
+		Sets:        6,
+		Deletes:     1,
+		Evicts:      1,
+		Expires:     1,
+		Size:        2,
+		BucketCount: 2,
+		Capacity:    10,
+		Utilization: 0.2,
+	}, mc.Stats())
+}
+
+func TestStats_Utilization(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+	for i := 0; i < 5; i++ {
+		mc.Set("bkt1", fmt.Sprintf("key%d", i), []byte("val"), Options{})
+	}
+	assert.Equal(t, 10, mc.Capacity())
+	stats := mc.Stats()
+	assert.Equal(t, 10, stats.Capacity)
+	assert.Equal(t, 0.5, stats.Utilization, "expected a half full cache")
+
+	bytes := NewMinervaCacheBytes(100, 0, &mockMetrics{})
+	defer bytes.Stop()
+	bytes.Set("bkt1", "key1", make([]byte, 30), Options{})
+	bytes.Set("bkt1", "key2", make([]byte, 20), Options{})
+	stats = bytes.Stats()
+	assert.Zero(t, stats.Capacity, "expected no item capacity in the byte mode")
+	assert.Equal(t, int64(100), stats.MaxBytes)
+	assert.Equal(t, int64(50), stats.UsedBytes)
+	assert.Equal(t, 0.5, stats.Utilization, "expected half of the bytes to be used")
+
+	disabled := NewMinervaCache(0, 0, &mockMetrics{})
+	defer disabled.Stop()
+	assert.Zero(t, disabled.Stats().Utilization, "expected no utilization for a disabled cache")
+}
+
+func TestStats_Concurrent(t *testing.T) {
+	mc := NewMinervaCache(50, 0, &mockMetrics{}, WithDefaultPolicy(LRUEvictionPolicy))
+	defer mc.Stop()
+
+	const goroutines, ops = 8, 500
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				bucket, key := fmt.Sprintf("bkt%d", i%5), fmt.Sprintf("key%d", i%80)
+				mc.Set(bucket, key, []byte("val"), Options{})
+				mc.Get(bucket, key, Options{})
+				if i%7 == 0 {
+					mc.Delete(bucket, key)
+				}
+			}
+		}(g)
+	}
+
+	// Read the stats while the operations run, run with -race to check they are read without data races.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	var prev Stats
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		stats := mc.Stats()
+		assert.GreaterOrEqual(t, stats.Hits, prev.Hits, "expected the hits never to decrease")
+		assert.GreaterOrEqual(t, stats.Misses, prev.Misses)
+		assert.GreaterOrEqual(t, stats.Sets, prev.Sets)
+		assert.GreaterOrEqual(t, stats.Deletes, prev.Deletes)
+		assert.GreaterOrEqual(t, stats.Evicts, prev.Evicts)
+		assert.LessOrEqual(t, stats.BucketCount, 5)
+		prev = stats
+	}
+
+	stats := mc.Stats()
+	assert.Equal(t, uint64(goroutines*ops), stats.Sets)
+	assert.Equal(t, mc.Len(), stats.Size)
+	assertOrderIntegrity(t, mc)
+}
+
+func TestContext_Canceled(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+	assert.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.ErrorIs(t, mc.SetCtx(ctx, "bkt1", "key1", []byte("updated"), Options{}), context.Canceled)
+	assert.ErrorIs(t, mc.SetCtx(ctx, "bkt1", "key2", []byte("val2"), Options{}), context.Canceled)
+	_, err := mc.GetCtx(ctx, "bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, context.Canceled)
+	_, _, err = mc.GetWithMetaCtx(ctx, "bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, mc.DeleteCtx(ctx, "bkt1", "key1"), context.Canceled)
+
+	value, err := mc.Get("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), value, "expected the value not to be updated")
+	assert.Equal(t, 1, mc.Len(), "expected no key to be added or deleted")
+	assert.Equal(t, Stats{Sets: 1, Hits: 1, Size: 1, BucketCount: 1, Capacity: 10, Utilization: 0.1}, mc.Stats(), "expected only the plain operations to be counted")
+	assertOrderIntegrity(t, mc)
+}
+
+func TestContext_CanceledWhileEvicting(t *testing.T) {
+	mc := NewMinervaCache(2, 0, &mockMetrics{})
+	defer mc.Stop()
+	assert.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	assert.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{}))
+
+	// The cache is full, so reserving a slot for a new key stops at the first eviction without leaking the slot.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, mc.reserve(ctx, "bkt1", 4, OldestEvictionPolicy), context.Canceled)
+	assert.Equal(t, uint64(0), mc.Stats().Evicts, "expected nothing to be evicted")
+	assertOrderIntegrity(t, mc)
+}
+
+func TestMinervaCache_CheckExpiredItems(t *testing.T) {
+	mc := NewMinervaCache(2000, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	for i := 0; i < 1000; i++ {
+		mc.Set("bkt1", fmt.Sprintf("forever%d", i), []byte("val"), Options{})
+	}
+	for i := 0; i < 10; i++ {
+		mc.Set("bkt2", fmt.Sprintf("short%d", i), []byte("val"), Options{TTL: time.Millisecond})
+	}
+	mc.Set("bkt2", "long", []byte("val"), Options{TTL: time.Hour})
+	assert.Equal(t, 11, expiriesLen(mc), "expected only the items with a TTL to be tracked, so the others are never scanned")
+
+	time.Sleep(5 * time.Millisecond)
+	mc.checkExpiredItems()
+
+	assert.Equal(t, 1001, mc.Len(), "expected all the expired items to be removed by a single check")
+	keys, _ := mc.Keys("bkt2")
+	assert.Equal(t, []string{"long"}, keys, "expected the unexpired item to be kept")
+	assertOrderIntegrity(t, mc)
+}
+
+func TestMinervaCache_ExpiriesConsistency(t *testing.T) {
+	mc := NewMinervaCache(3, 0, &mockMetrics{}, WithShards(1)) // A single shard, so all the items are in one heap.
+	defer mc.Stop()
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: time.Hour})
+	mc.Set("bkt1", "key2", []byte("val2"), Options{TTL: time.Minute})
+	mc.Set("bkt1", "key3", []byte("val3"), Options{})
+	assertOrderIntegrity(t, mc)
+
+	// Re-setting re-positions the item, and removing the TTL stops tracking it.
+	mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: time.Second})
+	assert.Same(t, mc.shards[0].buckets["bkt1"]["key1"], mc.shards[0].expiries.next(), "expected the shorter TTL to be next")
+	mc.Set("bkt1", "key1", []byte("val1"), Options{})
+	assertOrderIntegrity(t, mc)
+
+	mc.Set("bkt1", "key3", []byte("val3"), Options{TTL: time.Minute})
+	assertOrderIntegrity(t, mc)
+
+	mc.Delete("bkt1", "key2")
+	assertOrderIntegrity(t, mc)
+
+	mc.Set("bkt1", "key4", []byte("val4"), Options{TTL: time.Minute})
+	mc.Set("bkt1", "key5", []byte("val5"), Options{TTL: time.Minute, EvictionPolicy: OldestEvictionPolicy}) // Evicts key1.
+	assertOrderIntegrity(t, mc)
+
+	mc.Clear("bkt1")
+	assertOrderIntegrity(t, mc)
+
+	mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: time.Minute})
+	mc.FlushAll()
+	assert.Zero(t, expiriesLen(mc), "expected the heap to be reset by FlushAll")
+}
+
+// BenchmarkCheckExpiredItems compares the cost of a background sweep of 100k items, of which 1% have expired,
+// using the expiries heap against the full scan of all the buckets it replaced.
+func BenchmarkCheckExpiredItems(b *testing.B) {
+	const items = 100_000
+
+	setup := func(b *testing.B) *MinervaCache {
+		mc := NewMinervaCache(items, 0, &mockMetrics{})
+		for i := 0; i < items; i++ {
+			opts := Options{}
+			if i%100 == 0 {
+				opts.TTL = time.Nanosecond
+			}
+			mc.Set(fmt.Sprintf("bkt%d", i%10), fmt.Sprintf("key%d", i), []byte("val"), opts)
+		}
+		return mc
+	}
+
+	b.Run("heap", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			mc := setup(b)
+			b.StartTimer()
+			mc.checkExpiredItems()
+			b.StopTimer()
+			mc.Stop()
+		}
+	})
+
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			mc := setup(b)
+			b.StartTimer()
+			now := time.Now()
+			for _, s := range mc.shards {
+				s.mutex.Lock()
+				for _, mcb := range s.buckets {
+					for _, el := range mcb {
+						if el.Value.(*cacheItem).expired(now) {
+							mc.deleteAndRemoveFromInsertOrder(s, el)
+						}
+					}
+				}
+				s.mutex.Unlock()
+			}
+			b.StopTimer()
+			mc.Stop()
+		}
+	})
+}
+
+func BenchmarkGetLoop(b *testing.B) {
+	mc := NewMinervaCache(1000, 0, &mockMetrics{})
+	defer mc.Stop()
+	keys := benchmarkKeys()
+	for _, key := range keys {
+		mc.Set("bkt1", key, []byte("val"), Options{})
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for _, key := range keys {
+				mc.Get("bkt1", key, Options{})
+			}
+		}
+	})
+}
+
+func BenchmarkGetMulti(b *testing.B) {
+	mc := NewMinervaCache(1000, 0, &mockMetrics{})
+	defer mc.Stop()
+	keys := benchmarkKeys()
+	for _, key := range keys {
+		mc.Set("bkt1", key, []byte("val"), Options{})
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mc.GetMulti("bkt1", keys, Options{})
+		}
+	})
+}
+
+func BenchmarkSetLoop(b *testing.B) {
+	mc := NewMinervaCache(1000, 0, &mockMetrics{})
+	defer mc.Stop()
+	keys := benchmarkKeys()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for _, key := range keys {
+				mc.Set("bkt1", key, []byte("val"), Options{})
+			}
+		}
+	})
+}
+
+func BenchmarkSetMulti(b *testing.B) {
+	mc := NewMinervaCache(1000, 0, &mockMetrics{})
+	defer mc.Stop()
+	items := make(map[string][]byte, batchSize)
+	for _, key := range benchmarkKeys() {
+		items[key] = []byte("val")
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mc.SetMulti("bkt1", items, Options{})
+		}
+	})
+}
+
+// BenchmarkShards compares the throughput of a mixed Get/Set workload run in parallel on a single shard, which is
+// equivalent to a single global mutex, and on the default number of shards.
+// NB: The difference only shows with GOMAXPROCS > 1, e.g. go test -bench Shards -cpu 1,4,8.
+func BenchmarkShards(b *testing.B) {
+	for _, shards := range []int{1, DefaultShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			mc := NewMinervaCache(1000, 0, &mockMetrics{}, WithShards(shards))
+			defer mc.Stop()
+			keys := benchmarkKeys()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					if i%4 == 0 {
+						mc.Set("bkt1", key, []byte("val"), Options{})
+					} else {
+						mc.Get("bkt1", key, Options{})
+					}
+					i++
+				}
+			})
+		})
+	}
+}
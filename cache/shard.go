@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultShards is the number of shards a MinervaCache is split into unless configured with [WithShards].
+const DefaultShards = 16
+
+// ShardHash hashes a bucket and a key to pick the shard holding the key, see [WithShardHash].
+// It must always return the same hash for the same bucket and key, and be safe for concurrent use.
+type ShardHash func(bucket, key string) uint64
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// FNVShardHash is the default [ShardHash], the 64-bit FNV-1a hash of the bucket, a 0 byte and the key. The 0 byte
+// separates the bucket from the key, so ("ab", "c") and ("a", "bc") hash differently.
+// It is inlined rather than using hash/fnv, so hashing doesn't allocate.
+func FNVShardHash(bucket, key string) uint64 {
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(bucket); i++ {
+		h = (h ^ uint64(bucket[i])) * fnvPrime64
+	}
+	h *= fnvPrime64 // h ^ 0
+	for i := 0; i < len(key); i++ {
+		h = (h ^ uint64(key[i])) * fnvPrime64
+	}
+	return h
+}
+
+// NewSeededShardHash returns a [ShardHash] with a random seed, so the clients can't predict the shard of a key, e.g.
+// to pile up their keys in a single shard on purpose. Unlike FNVShardHash, the keys are spread differently by each
+// process.
+func NewSeededShardHash() ShardHash {
+	seed := maphash.MakeSeed()
+	return func(bucket, key string) uint64 {
+		var h maphash.Hash
+		h.SetSeed(seed)
+		h.WriteString(bucket)
+		h.WriteByte(0)
+		h.WriteString(key)
+		return h.Sum64()
+	}
+}
+
+// shard holds a subset of the cache items, routed by a hash of their bucket and key, behind its own mutex.
+// Each shard keeps its own order list, frequency list and expiries heap, so operations on different shards don't
+// contend with each other. The items are ranked across shards with global sequence numbers, see [rank].
+type shard struct {
+	// mutex locks the buckets, the order list, the frequency list and the expiries heap of the shard.
+	mutex sync.Mutex
+	// buckets is a map of the buckets with at least one key in this shard, where each bucket is a map of key-value pairs.
+	// The value is set in a Value field of a list.Element and stored in the bucket as a pointer to the element in the order list.
+	buckets map[string]map[string]*list.Element
+	// order is a doubly linked list that maintains the order of the keys of the shard based on the eviction policy.
+	// The items are always pushed or moved to the back with a new global sequence number, so it is sorted by seq.
+	order *list.List
+	// freqs groups the items of the shard by access frequency. Used to evict the least frequently used keys.
+	freqs *freqList
+	// expiries tracks the items of the shard with a TTL by expiration time.
+	expiries *expiryHeap
+	// negatives are the expiration times of the tombstones of the keys cached as missing, by bucket and key joined
+	// with a 0 byte. A key has no tombstone while it is in the shard, see [MinervaCache.SetNegative].
+	negatives map[string]time.Time
+	// seq is the global sequence shared by all the shards of the cache.
+	seq *atomic.Uint64
+}
+
+func newShard(seq *atomic.Uint64) *shard {
+	return &shard{
+		buckets:   make(map[string]map[string]*list.Element),
+		order:     list.New(),
+		freqs:     newFreqList(seq),
+		expiries:  &expiryHeap{},
+		negatives: make(map[string]time.Time),
+		seq:       seq,
+	}
+}
+
+// negativeID returns the key of the tombstone of the key of the bucket in the negatives of its shard.
+func negativeID(bucket, key string) string {
+	return bucket + "\x00" + key
+}
+
+// pushBack adds the item to the back of the order list with a new sequence number.
+func (s *shard) pushBack(item *cacheItem) *list.Element {
+	item.seq = s.seq.Add(1)
+	return s.order.PushBack(item)
+}
+
+// moveToBack moves the item to the back of the order list with a new sequence number, marking it as the most recent.
+func (s *shard) moveToBack(el *list.Element) {
+	el.Value.(*cacheItem).seq = s.seq.Add(1)
+	s.order.MoveToBack(el)
+}
+
+// getBucket returns the bucket for the given key. If the bucket doesn't exist in the shard, it creates a new one.
+func (s *shard) getBucket(bucket string) map[string]*list.Element {
+	mcb, ok := s.buckets[bucket]
+	if !ok {
+		mcb = make(map[string]*list.Element)
+		s.buckets[bucket] = mcb
+	}
+	return mcb
+}
+
+// victim returns the element to evict from this shard based on the eviction policy, only considering the items
+// accepted by the filter. A nil filter accepts all items, which makes this O(1). With a filter, the order
+// (or frequency) list is walked from the eviction end until an accepted item is found.
+func (s *shard) victim(policy EvictionPolicy, filter func(item *cacheItem) bool) *list.Element {
+	accept := func(el *list.Element) bool { return filter == nil || filter(el.Value.(*cacheItem)) }
+
+	switch policy {
+	case MRUEvictionPolicy, NewestEvictionPolicy:
+		for el := s.order.Back(); el != nil; el = el.Prev() { // MRU or Newest item
+			if accept(el) {
+				return el
+			}
+		}
+	case LFUEvictionPolicy:
+		if filter == nil {
+			return s.freqs.victim() // Least frequently used item, ties broken by the oldest.
+		}
+		for node := s.freqs.freqs.Front(); node != nil; node = node.Next() {
+			for fel := node.Value.(*freqNode).items.Front(); fel != nil; fel = fel.Next() {
+				if el := fel.Value.(*list.Element); accept(el) {
+					return el
+				}
+			}
+		}
+	default:
+		for el := s.order.Front(); el != nil; el = el.Next() { // LRU or Oldest item or When no policy is set (None).
+			if accept(el) {
+				return el
+			}
+		}
+	}
+
+	return nil
+}
+
+// rank is a snapshot of the position of an item in the eviction order, taken with the shard mutex locked, so the
+// victims of different shards can be compared without holding all the locks at once.
+type rank struct {
+	seq     uint64 // Position in the order list.
+	freq    int    // Access frequency.
+	freqSeq uint64 // Position within the frequency node, to break frequency ties.
+}
+
+func rankOf(el *list.Element) rank {
+	item := el.Value.(*cacheItem)
+	return rank{seq: item.seq, freq: item.freqNode.Value.(*freqNode).freq, freqSeq: item.freqSeq}
+}
+
+// evictsBefore reports whether an item with rank a should be evicted before an item with rank b under the policy.
+func (a rank) evictsBefore(b rank, policy EvictionPolicy) bool {
+	switch policy {
+	case MRUEvictionPolicy, NewestEvictionPolicy:
+		return a.seq > b.seq
+	case LFUEvictionPolicy:
+		return a.freq < b.freq || (a.freq == b.freq && a.freqSeq < b.freqSeq)
+	default:
+		return a.seq < b.seq
+	}
+}
@@ -0,0 +1,27 @@
+package cache
+
+import "time"
+
+// WithEvictionSink spills the keys evicted from the cache to the sink instead of dropping them, e.g. to a slower
+// store holding the keys that don't fit in memory. Only the evictions are spilled: the keys removed by Delete, Flush or
+// their expiration are not, nor are the expired keys evicted before they were removed.
+// If the sink also implements Loader and no loader is set with [WithLoader], the reads missing a key restore it from
+// the sink through the read-through, so the sink should then remove the keys it returns.
+// The sink is called with the shard of the key locked, so the spills of a key are in the order of its evictions, and
+// a failed spill is logged and the key dropped.
+func WithEvictionSink(sink EvictionSink) CacheOption {
+	return func(mc *MinervaCache) {
+		mc.evictionSink = sink
+	}
+}
+
+// spill passes the evicted item to the eviction sink, if any, unless it has expired.
+// Must be called with the shard mutex locked in the caller.
+func (mc *MinervaCache) spill(item *cacheItem) {
+	if mc.evictionSink == nil || item.expired(time.Now()) {
+		return
+	}
+	if err := mc.evictionSink.Spill(item.bucket, item.key, item.value, item.expiresAt); err != nil {
+		mc.logger.Error("minervacache: failed to spill the evicted key", "bucket", item.bucket, "key", item.key, "err", err)
+	}
+}
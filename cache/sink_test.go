@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// spilledItem is a key held by a memorySink.
+type spilledItem struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// memorySink is an EvictionSink backed by a map of "bucket/key", which also restores the keys as a Loader.
+type memorySink struct {
+	mutex sync.Mutex
+	items map[string]spilledItem
+}
+
+func newMemorySink() *memorySink {
+	return &memorySink{items: make(map[string]spilledItem)}
+}
+
+func (s *memorySink) Spill(bucket, key string, value []byte, expiresAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.items[bucket+"/"+key] = spilledItem{value: value, expiresAt: expiresAt}
+	return nil
+}
+
+// Load restores the spilled key with its remaining TTL, removing it from the sink.
+func (s *memorySink) Load(bucket, key string) ([]byte, time.Duration, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	item, ok := s.items[bucket+"/"+key]
+	if !ok {
+		return nil, 0, ErrKeyNotFound
+	}
+	delete(s.items, bucket+"/"+key)
+
+	var ttl time.Duration
+	if !item.expiresAt.IsZero() {
+		ttl = time.Until(item.expiresAt)
+	}
+	return item.value, ttl, nil
+}
+
+func (s *memorySink) has(bucket, key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.items[bucket+"/"+key]
+	return ok
+}
+
+func TestEvictionSink(t *testing.T) {
+	sink := newMemorySink()
+	mc := NewMinervaCache(2, 0, &mockMetrics{}, WithEvictionSink(sink), WithDefaultPolicy(LRUEvictionPolicy))
+	defer mc.Stop()
+
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{TTL: time.Hour}))
+	require.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{}))
+	require.NoError(t, mc.Set("bkt1", "key3", []byte("val3"), Options{}))
+	assert.True(t, sink.has("bkt1", "key1"), "expected the evicted key to be spilled")
+	assert.False(t, sink.has("bkt1", "key2"))
+
+	// The miss restores key1 from the sink, with its TTL, which evicts and spills key2 in turn.
+	value, meta, err := mc.GetWithMeta("bkt1", "key1", Options{})
+	require.NoError(t, err, "expected the spilled key to be restored")
+	assert.Equal(t, []byte("val1"), value)
+	assert.InDelta(t, time.Hour, meta.TTLRemaining, float64(time.Minute))
+	assert.False(t, sink.has("bkt1", "key1"))
+	assert.True(t, sink.has("bkt1", "key2"))
+	assert.Equal(t, 2, mc.Len())
+
+	// Neither the deletes nor the expirations are spilled.
+	require.NoError(t, mc.Delete("bkt1", "key3"))
+	require.NoError(t, mc.Set("bkt1", "key4", []byte("val4"), Options{TTL: time.Millisecond}))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, 1, mc.CollectExpired())
+	assert.False(t, sink.has("bkt1", "key3"))
+	assert.False(t, sink.has("bkt1", "key4"))
+
+	_, err = mc.Get("bkt1", "key5", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound, "expected a key never spilled to miss")
+}
+
+func TestEvictionSink_NoRestore(t *testing.T) {
+	sink := &fakeSpiller{}
+	mc := NewMinervaCache(1, 0, &mockMetrics{}, WithEvictionSink(sink), WithDefaultPolicy(LRUEvictionPolicy))
+	defer mc.Stop()
+
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	require.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{}))
+	assert.Equal(t, []string{"bkt1/key1"}, sink.spilled)
+
+	// The sink is not a Loader, so the spilled key is missed.
+	_, err := mc.Get("bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+// fakeSpiller is an EvictionSink recording the spilled keys, without restoring them.
+type fakeSpiller struct {
+	spilled []string
+}
+
+func (s *fakeSpiller) Spill(bucket, key string, _ []byte, _ time.Time) error {
+	s.spilled = append(s.spilled, bucket+"/"+key)
+	return nil
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// snapshotVersion is written at the start of every snapshot, so the format can change without misreading old files.
+const snapshotVersion = 1
+
+// snapshotItem is the gob encoded form of a cache item in a snapshot.
+type snapshotItem struct {
+	Bucket    string
+	Key       string
+	Value     []byte
+	ExpiresAt time.Time // Absolute, so the remaining TTL keeps running while the cache is down. Zero if no TTL.
+	CreatedAt time.Time
+}
+
+// SaveSnapshot writes all the items of the cache to w, in their order, with their absolute expiration time.
+// All the shards are locked while the items are collected so the snapshot is consistent, but not while it is written.
+func (mc *MinervaCache) SaveSnapshot(w io.Writer) error {
+	mc.lockShards()
+	items := mc.snapshotItems()
+	mc.unlockShards()
+
+	return writeSnapshot(w, items)
+}
+
+// writeSnapshot encodes the items to w in the snapshot format.
+func writeSnapshot(w io.Writer, items []snapshotItem) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(snapshotVersion); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotItems returns all the items of the cache in their global order.
+// Must be called with all the shard mutexes locked, see lockShards.
+func (mc *MinervaCache) snapshotItems() []snapshotItem {
+	var items []*cacheItem
+	for _, s := range mc.shards {
+		for el := s.order.Front(); el != nil; el = el.Next() {
+			items = append(items, el.Value.(*cacheItem))
+		}
+	}
+	// Merge the order lists of the shards, so the items are loaded back in the same global order.
+	sort.Slice(items, func(i, j int) bool { return items[i].seq < items[j].seq })
+
+	snapshot := make([]snapshotItem, len(items))
+	for i, item := range items {
+		snapshot[i] = snapshotItem{
+			Bucket:    item.bucket,
+			Key:       item.key,
+			Value:     item.value,
+			ExpiresAt: item.expiresAt,
+			CreatedAt: item.createdAt,
+		}
+	}
+	return snapshot
+}
+
+// LoadSnapshot reads the items written by SaveSnapshot from r and sets them in the cache in their saved order.
+// Items that have expired since the snapshot was saved are skipped. Existing keys are overwritten, and the cache
+// evicts based on the policy if the snapshot doesn't fit.
+func (mc *MinervaCache) LoadSnapshot(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+
+	var version int
+	if err := dec.Decode(&version); err != nil {
+		return fmt.Errorf("reading snapshot version: %w", err)
+	}
+	if version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	now := time.Now()
+	for {
+		var item snapshotItem
+		if err := dec.Decode(&item); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading snapshot item: %w", err)
+		}
+
+		if !item.ExpiresAt.IsZero() && now.After(item.ExpiresAt) {
+			continue
+		}
+		if _, err := mc.put(context.Background(), item.Bucket, item.Key, item.Value, item.ExpiresAt, item.CreatedAt, Options{}, false); err != nil {
+			return fmt.Errorf("loading %s/%s: %w", item.Bucket, item.Key, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	require.NoError(t, mc.Set("bkt2", "key1", []byte("val2"), Options{TTL: time.Hour}))
+	require.NoError(t, mc.Set("bkt1", "key2", []byte("val3"), Options{}))
+	require.NoError(t, mc.Set("bkt3", "key1", []byte("val4"), Options{}))
+	require.NoError(t, mc.Clear("bkt3")) // Emptied buckets are not saved.
+	_, wantMeta, err := mc.GetWithMeta("bkt2", "key1", Options{})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, mc.SaveSnapshot(&buf))
+
+	loaded := NewMinervaCache(10, 0, &mockMetrics{})
+	defer loaded.Stop()
+	require.NoError(t, loaded.LoadSnapshot(&buf))
+
+	assert.Equal(t, 3, loaded.Len())
+	assert.ElementsMatch(t, []string{"bkt1", "bkt2"}, loaded.Buckets())
+
+	value, meta, err := loaded.GetWithMeta("bkt2", "key1", Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("val2"), value)
+	assert.True(t, wantMeta.ExpiresAt.Equal(meta.ExpiresAt), "expected the absolute expiration time to be kept")
+	assert.True(t, wantMeta.CreatedAt.Equal(meta.CreatedAt), "expected the creation time to be kept")
+	assertOrderIntegrity(t, loaded)
+
+	// The keys are loaded in their original order, so the oldest one is still evicted first.
+	for _, key := range []string{"key3", "key4", "key5", "key6", "key7", "key8", "key9"} {
+		require.NoError(t, loaded.Set("bkt4", key, []byte(key), Options{}))
+	}
+	require.NoError(t, loaded.Set("bkt4", "key10", []byte("key10"), Options{EvictionPolicy: OldestEvictionPolicy}))
+	_, err = loaded.Get("bkt1", "key1", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound, "expected the first saved key to be evicted first")
+}
+
+func TestSnapshot_SkipsExpired(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+	require.NoError(t, mc.Set("bkt1", "short", []byte("val1"), Options{TTL: 10 * time.Millisecond}))
+	require.NoError(t, mc.Set("bkt1", "long", []byte("val2"), Options{TTL: time.Hour}))
+
+	var buf bytes.Buffer
+	require.NoError(t, mc.SaveSnapshot(&buf))
+	time.Sleep(20 * time.Millisecond) // The short TTL expires while the cache is "down".
+
+	loaded := NewMinervaCache(10, 0, &mockMetrics{})
+	defer loaded.Stop()
+	require.NoError(t, loaded.LoadSnapshot(&buf))
+
+	assert.Equal(t, 1, loaded.Len())
+	_, err := loaded.Get("bkt1", "long", Options{})
+	assert.NoError(t, err)
+}
+
+func TestSnapshot_Empty(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	var buf bytes.Buffer
+	require.NoError(t, mc.SaveSnapshot(&buf))
+
+	loaded := NewMinervaCache(10, 0, &mockMetrics{})
+	defer loaded.Stop()
+	require.NoError(t, loaded.LoadSnapshot(&buf))
+	assert.Equal(t, 0, loaded.Len())
+}
+
+func TestSnapshot_Invalid(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	assert.Error(t, mc.LoadSnapshot(bytes.NewReader([]byte("not a snapshot"))))
+}
@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultWALCompactionInterval is how often the write-ahead log is compacted into a snapshot.
+const DefaultWALCompactionInterval = 5 * time.Minute
+
+// walOp is the kind of write recorded in the write-ahead log.
+type walOp uint8
+
+const (
+	walSet walOp = iota + 1
+	walDelete
+	walClear
+	walFlush
+)
+
+// walRecord is the gob encoded form of a write in the write-ahead log. The expiration time is absolute, like in
+// snapshots, so replaying a record never extends a TTL.
+type walRecord struct {
+	Op        walOp
+	Bucket    string
+	Key       string
+	Value     []byte
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// wal is an append-only log of the writes (sets, deletes, clears and flushes) applied to the cache since the last
+// snapshot, so they survive a crash. Evictions and expirations are not logged, they happen again on replay.
+// The records are written to the file as they are applied but not synced, so they survive a crash of the process,
+// not of the machine.
+//
+// The log is compacted by saving a snapshot of the cache next to it, at path + ".snapshot", and truncating it.
+// The log is also compacted on startup after it is replayed, so each log file is a single gob stream.
+type wal struct {
+	path string
+	// gate is read locked by the writes while they are applied and logged, and write locked by the compaction, so a
+	// write is always either in the snapshot or in the log. It is locked before any shard mutex.
+	gate sync.RWMutex
+	// mutex locks the file and the encoder. It may be locked while holding a shard mutex.
+	mutex sync.Mutex
+	file  *os.File
+	enc   *gob.Encoder // nil once the log is closed.
+}
+
+// WithWAL enables the write-ahead log at path. On creation, the cache loads the last compacted snapshot and replays the
+// log, then it logs every write and compacts the log every [DefaultWALCompactionInterval].
+// If the log can't be opened or replayed, the error is logged and the cache runs without it.
+func WithWAL(path string) CacheOption {
+	return func(mc *MinervaCache) {
+		mc.walPath = path
+	}
+}
+
+// openWAL restores the cache from the snapshot and log at path, then starts logging the writes to it.
+func (mc *MinervaCache) openWAL(path string) error {
+	if err := mc.loadWALSnapshot(path + ".snapshot"); err != nil {
+		return err
+	}
+	if err := mc.replayWAL(path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	mc.wal = &wal{path: path, file: file, enc: gob.NewEncoder(file)}
+
+	// Start a fresh log, the replayed writes are in the snapshot now.
+	return mc.compactWAL()
+}
+
+// loadWALSnapshot loads the compacted snapshot of the log. A missing snapshot is not an error, e.g. on first start.
+func (mc *MinervaCache) loadWALSnapshot(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return mc.LoadSnapshot(bufio.NewReader(f))
+}
+
+// replayWAL applies the records of the log at path to the cache. It must be called before the log is opened for
+// writing, so the replayed writes are not logged again.
+func (mc *MinervaCache) replayWAL(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	for {
+		var rec walRecord
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil // A crash may have cut the last record short, the writes before it are kept.
+		} else if err != nil {
+			return err
+		}
+
+		switch rec.Op {
+		case walSet:
+			if !rec.ExpiresAt.IsZero() && time.Now().After(rec.ExpiresAt) {
+				continue
+			}
+			_, err = mc.put(context.Background(), rec.Bucket, rec.Key, rec.Value, rec.ExpiresAt, rec.CreatedAt, Options{}, false)
+		case walDelete:
+			err = mc.Delete(rec.Bucket, rec.Key)
+		case walClear:
+			err = mc.Clear(rec.Bucket)
+		case walFlush:
+			mc.FlushAll()
+		}
+		// The key or bucket may already be gone if it was evicted or expired before it was deleted.
+		if err != nil && !errors.Is(err, ErrKeyNotFound) && !errors.Is(err, ErrBucketNotFound) {
+			return err
+		}
+	}
+}
+
+// compactWAL saves a snapshot of the cache next to the log and truncates the log.
+// Writes are blocked while the snapshot is written.
+func (mc *MinervaCache) compactWAL() error {
+	w := mc.wal
+	w.gate.Lock()
+	defer w.gate.Unlock()
+
+	mc.lockShards()
+	items := mc.snapshotItems()
+	mc.unlockShards()
+
+	// Replace the snapshot atomically, so a crash while writing it keeps the previous snapshot and log.
+	tmp := w.path + ".snapshot.tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	err = writeSnapshot(bw, items)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, w.path+".snapshot")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.enc == nil {
+		return nil // Closed while compacting.
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	w.enc = gob.NewEncoder(w.file) // The new stream needs the type definitions again.
+	return nil
+}
+
+// startWALCompaction compacts the log periodically until the cache is stopped.
+func (mc *MinervaCache) startWALCompaction() {
+	ticker := time.NewTicker(DefaultWALCompactionInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := mc.compactWAL(); err != nil {
+					mc.logger.Error("minervacache: failed to compact the write-ahead log", "err", err)
+				}
+			case <-mc.stop:
+				return
+			}
+		}
+	}()
+}
+
+// lockWAL read locks the gate of the log around a write, so it is not compacted until the write is applied and
+// logged. It returns the function to unlock it, and does nothing without a log.
+func (mc *MinervaCache) lockWAL() func() {
+	if mc.wal == nil {
+		return func() {}
+	}
+	mc.wal.gate.RLock()
+	return mc.wal.gate.RUnlock
+}
+
+// logWAL appends the record to the log, if any. The write has already been applied, so a failure to log it is only
+// reported.
+func (mc *MinervaCache) logWAL(rec walRecord) {
+	if mc.wal == nil {
+		return
+	}
+
+	mc.wal.mutex.Lock()
+	defer mc.wal.mutex.Unlock()
+	if mc.wal.enc == nil {
+		return // Closed.
+	}
+	if err := mc.wal.enc.Encode(rec); err != nil {
+		mc.logger.Error("minervacache: failed to write to the write-ahead log", "err", err)
+	}
+}
+
+// closeWAL stops logging the writes and closes the log, if any.
+func (mc *MinervaCache) closeWAL() {
+	if mc.wal == nil {
+		return
+	}
+
+	mc.wal.mutex.Lock()
+	defer mc.wal.mutex.Unlock()
+	if mc.wal.enc != nil {
+		mc.wal.enc = nil
+		mc.wal.file.Close()
+	}
+}
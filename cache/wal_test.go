@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// walState returns the values of all the keys of the cache by bucket, to compare caches before and after a restart.
+func walState(t *testing.T, mc *MinervaCache) map[string]map[string]string {
+	t.Helper()
+
+	state := make(map[string]map[string]string)
+	for _, bucket := range mc.Buckets() {
+		keys, err := mc.Keys(bucket)
+		require.NoError(t, err)
+		state[bucket] = make(map[string]string)
+		for _, key := range keys {
+			value, ok := mc.lookup(bucket, key)
+			require.True(t, ok)
+			state[bucket][key] = string(value)
+		}
+	}
+	return state
+}
+
+func TestWAL_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithWAL(path))
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	require.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{TTL: time.Hour}))
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1-updated"), Options{}))
+	require.NoError(t, mc.Set("bkt2", "key1", []byte("val3"), Options{}))
+	require.NoError(t, mc.Set("bkt3", "key1", []byte("val4"), Options{}))
+	require.NoError(t, mc.Delete("bkt1", "key2"))
+	require.NoError(t, mc.Clear("bkt2"))
+	_, err := mc.Increment("bkt3", "counter", 5, Options{})
+	require.NoError(t, err)
+	_, err = mc.Increment("bkt3", "counter", 2, Options{})
+	require.NoError(t, err)
+	want := walState(t, mc)
+
+	// Simulate a crash: the cache is not stopped, so its log is never compacted.
+	restarted := NewMinervaCache(10, 0, &mockMetrics{}, WithWAL(path))
+	defer restarted.Stop()
+	assert.Equal(t, want, walState(t, restarted))
+	assert.Equal(t, map[string]map[string]string{
+		"bkt1": {"key1": "val1-updated"},
+		"bkt3": {"key1": "val4", "counter": "7"},
+	}, want)
+	assertOrderIntegrity(t, restarted)
+}
+
+// hookContext runs the hook on the given call of Err, to interleave another write with the one using the context.
+type hookContext struct {
+	context.Context
+	calls, on int
+	hook      func()
+}
+
+func (c *hookContext) Err() error {
+	c.calls++
+	if c.calls == c.on {
+		c.hook()
+	}
+	return c.Context.Err()
+}
+
+func TestWAL_ReplayConcurrentSets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+
+	mc := NewMinervaCache(2, 0, &mockMetrics{}, WithWAL(path))
+	require.NoError(t, mc.Set("bkt0", "key1", []byte("val0"), Options{}))
+	require.NoError(t, mc.Set("bkt0", "key2", []byte("val0"), Options{}))
+
+	// The cache is full, so the second check of the context is while the Set makes room for the new key, after finding
+	// it missing. Another Set creates the key meanwhile, so the first one updates it instead of inserting it.
+	ctx := &hookContext{Context: context.Background(), on: 2, hook: func() {
+		require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	}}
+	require.NoError(t, mc.SetCtx(ctx, "bkt1", "key1", []byte("val2"), Options{}))
+	require.Equal(t, 2, ctx.calls)
+	value, ok := mc.lookup("bkt1", "key1")
+	require.True(t, ok)
+	require.Equal(t, "val2", string(value))
+
+	restarted := NewMinervaCache(2, 0, &mockMetrics{}, WithWAL(path))
+	defer restarted.Stop()
+	value, ok = restarted.lookup("bkt1", "key1")
+	require.True(t, ok)
+	assert.Equal(t, "val2", string(value), "expected the replay to end with the value of the last Set")
+}
+
+func TestWAL_ReplayFlushAndExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithWAL(path))
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	mc.FlushAll()
+	require.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{}))
+	require.NoError(t, mc.Set("bkt1", "short", []byte("val3"), Options{TTL: 10 * time.Millisecond}))
+	time.Sleep(20 * time.Millisecond) // The short TTL expires while the cache is "down".
+
+	restarted := NewMinervaCache(10, 0, &mockMetrics{}, WithWAL(path))
+	defer restarted.Stop()
+	assert.Equal(t, map[string]map[string]string{"bkt1": {"key2": "val2"}}, walState(t, restarted))
+}
+
+func TestWAL_Compaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithWAL(path))
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	require.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{}))
+
+	require.NoError(t, mc.compactWAL())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "expected the log to be truncated")
+	_, err = os.Stat(path + ".snapshot")
+	assert.NoError(t, err, "expected the snapshot to be written")
+
+	// The writes after the compaction are in the new log, replayed on top of the snapshot.
+	require.NoError(t, mc.Delete("bkt1", "key1"))
+	require.NoError(t, mc.Set("bkt2", "key1", []byte("val3"), Options{}))
+	want := walState(t, mc)
+	mc.Stop()
+
+	restarted := NewMinervaCache(10, 0, &mockMetrics{}, WithWAL(path))
+	defer restarted.Stop()
+	assert.Equal(t, want, walState(t, restarted))
+	assert.Equal(t, map[string]map[string]string{"bkt1": {"key2": "val2"}, "bkt2": {"key1": "val3"}}, want)
+}
+
+func TestWAL_TornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.wal")
+
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithWAL(path))
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	require.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{}))
+	mc.Stop()
+
+	// A crash in the middle of the last write leaves a partial record at the end of the log.
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+
+	restarted := NewMinervaCache(10, 0, &mockMetrics{}, WithWAL(path))
+	defer restarted.Stop()
+	assert.Equal(t, map[string]map[string]string{"bkt1": {"key1": "val1"}}, walState(t, restarted))
+}
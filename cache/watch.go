@@ -0,0 +1,118 @@
+package cache
+
+// DefaultWatchBuffer is the number of events a watcher can fall behind by before it is dropped.
+const DefaultWatchBuffer = 256
+
+// EventType is the kind of change reported to the watchers of a bucket.
+type EventType int
+
+const (
+	EventSet      EventType = iota + 1 // The key was set, the event holds its new value.
+	EventDelete                        // The key was deleted, cleared, flushed or evicted.
+	EventExpire                        // The key expired.
+	EventOverflow                      // The watcher fell behind and was dropped, this is its last event.
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventOverflow:
+		return "overflow"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a change to a key of a watched bucket.
+type Event struct {
+	Type   EventType
+	Bucket string
+	Key    string
+	Value  []byte // Only set for EventSet.
+}
+
+// watcher is a subscriber to the events of a bucket.
+type watcher struct {
+	bucket string
+	ch     chan Event
+}
+
+// Watch subscribes to the changes of the keys in the bucket, including the keys set after it is called. The events
+// of a key are received in the order they are applied. The returned function unsubscribes and must be called once
+// the events are no longer received.
+// The events are sent without blocking the cache: a watcher that falls behind by [DefaultWatchBuffer] events gets an
+// EventOverflow and its channel is closed. The channel is also closed when the cache is stopped.
+func (mc *MinervaCache) Watch(bucket string) (<-chan Event, func()) {
+	w := &watcher{bucket: bucket, ch: make(chan Event, DefaultWatchBuffer)}
+
+	mc.watchMutex.Lock()
+	defer mc.watchMutex.Unlock()
+	if mc.watchers == nil {
+		close(w.ch) // Stopped.
+		return w.ch, func() {}
+	}
+	if mc.watchers[bucket] == nil {
+		mc.watchers[bucket] = make(map[*watcher]struct{})
+	}
+	mc.watchers[bucket][w] = struct{}{}
+	mc.watcherCount.Add(1)
+
+	return w.ch, func() {
+		mc.watchMutex.Lock()
+		defer mc.watchMutex.Unlock()
+		mc.removeWatcher(w)
+	}
+}
+
+// publish sends the event to the watchers of its bucket, dropping the ones that fell behind.
+// It is called with the shard mutex of the key locked, so the events of a key are sent in order.
+func (mc *MinervaCache) publish(ev Event) {
+	if mc.watcherCount.Load() == 0 {
+		return // Nobody is watching, don't contend on the mutex.
+	}
+
+	mc.watchMutex.Lock()
+	defer mc.watchMutex.Unlock()
+	for w := range mc.watchers[ev.Bucket] {
+		// Only publish sends on the channel, under the mutex, so the buffer can't fill up between the check and the
+		// send. The last slot is kept for the overflow event.
+		if len(w.ch) < cap(w.ch)-1 {
+			w.ch <- ev
+			continue
+		}
+		w.ch <- Event{Type: EventOverflow, Bucket: ev.Bucket}
+		mc.removeWatcher(w)
+	}
+}
+
+// removeWatcher unsubscribes the watcher and closes its channel, if not already done.
+// Must be called with the watchMutex locked.
+func (mc *MinervaCache) removeWatcher(w *watcher) {
+	if _, ok := mc.watchers[w.bucket][w]; !ok {
+		return
+	}
+
+	delete(mc.watchers[w.bucket], w)
+	if len(mc.watchers[w.bucket]) == 0 {
+		delete(mc.watchers, w.bucket)
+	}
+	mc.watcherCount.Add(-1)
+	close(w.ch)
+}
+
+// closeWatchers unsubscribes all the watchers, closing their channels, and rejects new ones.
+func (mc *MinervaCache) closeWatchers() {
+	mc.watchMutex.Lock()
+	defer mc.watchMutex.Unlock()
+	for _, ws := range mc.watchers {
+		for w := range ws {
+			mc.removeWatcher(w)
+		}
+	}
+	mc.watchers = nil
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receive returns the next n events of the channel, failing the test if they don't arrive in time.
+func receive(t *testing.T, events <-chan Event, n int) []Event {
+	t.Helper()
+
+	var got []Event
+	for len(got) < n {
+		select {
+		case ev, ok := <-events:
+			require.True(t, ok, "expected the channel to be open")
+			got = append(got, ev)
+		case <-time.After(time.Second):
+			require.FailNow(t, "timed out waiting for events", "got %v", got)
+		}
+	}
+	return got
+}
+
+func TestWatch(t *testing.T) {
+	mc := NewMinervaCacheWithBucketLimits(10, 2, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	events, unwatch := mc.Watch("bkt1")
+	defer unwatch()
+
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	require.NoError(t, mc.Set("bkt2", "key1", []byte("other"), Options{})) // Not watched.
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val2"), Options{}))
+	require.NoError(t, mc.Set("bkt1", "key2", []byte("val3"), Options{TTL: time.Millisecond}))
+	require.NoError(t, mc.Delete("bkt1", "key1"))
+	time.Sleep(5 * time.Millisecond)
+	mc.checkExpiredItems()
+	require.NoError(t, mc.Set("bkt1", "key3", []byte("val4"), Options{}))
+	require.NoError(t, mc.Set("bkt1", "key4", []byte("val5"), Options{}))
+	require.NoError(t, mc.Set("bkt1", "key5", []byte("val6"), Options{EvictionPolicy: OldestEvictionPolicy})) // Evicts key3.
+	require.NoError(t, mc.Clear("bkt1"))
+
+	assert.Equal(t, []Event{
+		{Type: EventSet, Bucket: "bkt1", Key: "key1", Value: []byte("val1")},
+		{Type: EventSet, Bucket: "bkt1", Key: "key1", Value: []byte("val2")},
+		{Type: EventSet, Bucket: "bkt1", Key: "key2", Value: []byte("val3")},
+		{Type: EventDelete, Bucket: "bkt1", Key: "key1"},
+		{Type: EventExpire, Bucket: "bkt1", Key: "key2"},
+		{Type: EventSet, Bucket: "bkt1", Key: "key3", Value: []byte("val4")},
+		{Type: EventSet, Bucket: "bkt1", Key: "key4", Value: []byte("val5")},
+		{Type: EventDelete, Bucket: "bkt1", Key: "key3"},
+		{Type: EventSet, Bucket: "bkt1", Key: "key5", Value: []byte("val6")},
+	}, receive(t, events, 9))
+
+	// The cleared keys are in different shards, so their order is not defined.
+	assert.ElementsMatch(t, []Event{
+		{Type: EventDelete, Bucket: "bkt1", Key: "key4"},
+		{Type: EventDelete, Bucket: "bkt1", Key: "key5"},
+	}, receive(t, events, 2))
+}
+
+func TestWatch_Unwatch(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	events, unwatch := mc.Watch("bkt1")
+	unwatch()
+	unwatch() // Idempotent.
+
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	_, ok := <-events
+	assert.False(t, ok, "expected the channel to be closed without events")
+	assert.Equal(t, int32(0), mc.watcherCount.Load())
+}
+
+func TestWatch_Overflow(t *testing.T) {
+	mc := NewMinervaCache(DefaultWatchBuffer*2, 0, &mockMetrics{})
+	defer mc.Stop()
+
+	slow, unwatchSlow := mc.Watch("bkt1")
+	defer unwatchSlow()
+
+	// The slow watcher never reads, so it is dropped once its buffer is full without blocking the sets.
+	for i := 0; i < DefaultWatchBuffer; i++ {
+		require.NoError(t, mc.Set("bkt1", fmt.Sprintf("key%d", i), []byte("val"), Options{}))
+	}
+
+	var got []Event
+	for ev := range slow {
+		got = append(got, ev)
+	}
+	require.Len(t, got, DefaultWatchBuffer)
+	assert.Equal(t, Event{Type: EventOverflow, Bucket: "bkt1"}, got[len(got)-1])
+	assert.Equal(t, Event{Type: EventSet, Bucket: "bkt1", Key: "key0", Value: []byte("val")}, got[0])
+}
+
+func TestWatch_Stop(t *testing.T) {
+	mc := NewMinervaCache(10, 0, &mockMetrics{})
+	events, unwatch := mc.Watch("bkt1")
+	defer unwatch()
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	mc.Stop()
+
+	// Stopping flushes the cache, but the watchers are closed first.
+	assert.Equal(t, []Event{{Type: EventSet, Bucket: "bkt1", Key: "key1", Value: []byte("val1")}}, receive(t, events, 1))
+	_, ok := <-events
+	assert.False(t, ok, "expected the channel to be closed")
+
+	events, _ = mc.Watch("bkt1")
+	_, ok = <-events
+	assert.False(t, ok, "expected watching a stopped cache to return a closed channel")
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+)
+
+// WriteMode selects how the writes of the cache are mirrored to its Writer, see [WithWriter].
+type WriteMode int
+
+const (
+	// WriteThrough writes each value to the sink before it is applied to the cache, and fails the write if the sink
+	// fails, so the cache never holds a value the sink doesn't have.
+	WriteThrough WriteMode = iota
+	// WriteBehind queues the values once applied to the cache and writes them to the sink in batches in the
+	// background, so the writes don't wait for the sink. The sink failures are retried, then logged and dropped.
+	WriteBehind
+)
+
+const (
+	// DefaultWriteBehindBuffer is the number of writes queued for the sink before the writes of the cache wait for it.
+	DefaultWriteBehindBuffer = 1024
+	// DefaultWriteBehindBatch is the maximum number of queued writes flushed to the sink together.
+	DefaultWriteBehindBatch = 100
+	// writeBehindRetries is the number of attempts of a write to the sink before it is dropped.
+	writeBehindRetries = 4
+	// writeBehindBackoff is the delay before the first retry of a failed write, doubled before each next one.
+	writeBehindBackoff = 100 * time.Millisecond
+)
+
+// pendingWrite is a write queued for the sink in the write-behind mode.
+type pendingWrite struct {
+	bucket, key string
+	value       []byte
+}
+
+// writeBehind is the queue of the writes for the sink and the goroutine flushing them, see [WriteBehind].
+type writeBehind struct {
+	queue chan pendingWrite
+	// done is closed once the queue is flushed on stop.
+	done chan struct{}
+}
+
+// WithWriter mirrors the writes of the cache, i.e. the values set by Set, SetCtx, SetMulti, GetOrSet and Increment,
+// to the writer in the given mode. The deletes, evictions and expirations are not mirrored, nor are the values
+// loaded by the read-through of [WithLoader], the snapshot or the write-ahead log.
+// In the write-through mode, the writer is called with the shard of the key locked, so the sink receives the writes to
+// a key in the order they are applied.
+func WithWriter(writer Writer, mode WriteMode) CacheOption {
+	return func(mc *MinervaCache) {
+		mc.writer = writer
+		mc.writeMode = mode
+	}
+}
+
+// mirror writes the value applied to the key to the sink in the write-through mode, or queues it in the write-behind
+// mode. It does nothing without a writer.
+// Must be called with the shard mutex locked in the caller, before the value is applied, so it isn't applied if the
+// write-through fails.
+func (mc *MinervaCache) mirror(bucket, key string, value []byte) error {
+	switch {
+	case mc.writer == nil:
+		return nil
+	case mc.writeBehind != nil:
+		select {
+		case mc.writeBehind.queue <- pendingWrite{bucket: bucket, key: key, value: value}:
+		case <-mc.stop: // Not flushed anymore.
+		}
+		return nil
+	}
+
+	if err := mc.writer.Write(bucket, key, value); err != nil {
+		return fmt.Errorf("writing through %s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// startWriteBehind starts the goroutine flushing the queued writes to the sink in the write-behind mode.
+// It flushes the writes queued before the cache is stopped, then closes writeBehind.done.
+func (mc *MinervaCache) startWriteBehind() {
+	if mc.writer == nil || mc.writeMode != WriteBehind {
+		return
+	}
+
+	mc.writeBehind = &writeBehind{
+		queue: make(chan pendingWrite, DefaultWriteBehindBuffer),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(mc.writeBehind.done)
+		for {
+			select {
+			case w := <-mc.writeBehind.queue:
+				mc.flushWrites(mc.nextWriteBatch(w))
+			case <-mc.stop:
+				for len(mc.writeBehind.queue) > 0 {
+					mc.flushWrites(mc.nextWriteBatch(<-mc.writeBehind.queue))
+				}
+				return
+			}
+		}
+	}()
+}
+
+// nextWriteBatch returns the first write with the ones queued after it, up to [DefaultWriteBehindBatch] writes.
+func (mc *MinervaCache) nextWriteBatch(first pendingWrite) []pendingWrite {
+	batch := []pendingWrite{first}
+	for len(batch) < DefaultWriteBehindBatch {
+		select {
+		case w := <-mc.writeBehind.queue:
+			batch = append(batch, w)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// flushWrites writes the batch to the sink. Only the last write of each key is written, since it overwrites the
+// previous ones.
+func (mc *MinervaCache) flushWrites(batch []pendingWrite) {
+	last := make(map[string]int, len(batch))
+	for i, w := range batch {
+		last[w.bucket+"\x00"+w.key] = i
+	}
+	for i, w := range batch {
+		if last[w.bucket+"\x00"+w.key] == i {
+			mc.writeWithRetry(w)
+		}
+	}
+}
+
+// writeWithRetry writes to the sink, retrying with an exponential backoff on failure. The write is dropped and logged
+// after [writeBehindRetries] attempts.
+func (mc *MinervaCache) writeWithRetry(w pendingWrite) {
+	backoff := writeBehindBackoff
+	for attempt := 1; ; attempt++ {
+		err := mc.writer.Write(w.bucket, w.key, w.value)
+		if err == nil {
+			return
+		}
+		if attempt == writeBehindRetries {
+			mc.logger.Error("minervacache: dropping the write-behind", "bucket", w.bucket, "key", w.key, "attempts", attempt, "err", err)
+			return
+		}
+
+		mc.logger.Warn("minervacache: failed to write behind, retrying", "bucket", w.bucket, "key", w.key, "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// stopWriteBehind waits for the writes queued before the cache was stopped to be flushed, if any.
+func (mc *MinervaCache) stopWriteBehind() {
+	if mc.writeBehind != nil {
+		<-mc.writeBehind.done
+	}
+}
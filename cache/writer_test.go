@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWriter is a Writer backed by a map of "bucket/key" to value, failing the next failures writes.
+type fakeWriter struct {
+	mutex    sync.Mutex
+	values   map[string]string
+	writes   int
+	failures int
+}
+
+var errSinkDown = errors.New("sink is down")
+
+func newFakeWriter() *fakeWriter {
+	return &fakeWriter{values: make(map[string]string)}
+}
+
+func (w *fakeWriter) Write(bucket, key string, value []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.writes++
+	if w.failures > 0 {
+		w.failures--
+		return errSinkDown
+	}
+	w.values[bucket+"/"+key] = string(value)
+	return nil
+}
+
+func (w *fakeWriter) get(bucket, key string) (string, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	value, ok := w.values[bucket+"/"+key]
+	return value, ok
+}
+
+func TestWriter_WriteThrough(t *testing.T) {
+	writer := newFakeWriter()
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithWriter(writer, WriteThrough))
+	defer mc.Stop()
+
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	value, ok := writer.get("bkt1", "key1")
+	assert.True(t, ok, "expected the set to be written through")
+	assert.Equal(t, "val1", value)
+
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val2"), Options{}))
+	value, _ = writer.get("bkt1", "key1")
+	assert.Equal(t, "val2", value, "expected the update to be written through")
+
+	_, err := mc.Increment("bkt1", "counter", 2, Options{})
+	require.NoError(t, err)
+	_, err = mc.Increment("bkt1", "counter", 3, Options{})
+	require.NoError(t, err)
+	value, _ = writer.get("bkt1", "counter")
+	assert.Equal(t, "5", value, "expected the increments to be written through")
+
+	// A conditional set rejected by the cache is not written.
+	assert.ErrorIs(t, mc.Set("bkt1", "key1", []byte("val3"), Options{SetMode: SetIfAbsent}), ErrKeyExists)
+	value, _ = writer.get("bkt1", "key1")
+	assert.Equal(t, "val2", value)
+}
+
+func TestWriter_WriteThroughFailure(t *testing.T) {
+	writer := newFakeWriter()
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithWriter(writer, WriteThrough))
+	defer mc.Stop()
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+
+	writer.failures = 3
+	assert.ErrorIs(t, mc.Set("bkt1", "key2", []byte("val2"), Options{}), errSinkDown)
+	assert.ErrorIs(t, mc.Set("bkt1", "key1", []byte("val2"), Options{}), errSinkDown)
+	_, err := mc.Increment("bkt1", "key1", 1, Options{})
+	assert.ErrorIs(t, err, ErrNotInteger)
+
+	// The failed writes are not applied to the cache.
+	_, err = mc.Get("bkt1", "key2", Options{})
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	value, err := mc.Get("bkt1", "key1", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), value)
+	assert.Equal(t, 1, mc.Len(), "expected the reserved slot to be released")
+	assert.Equal(t, int64(len("val1")), mc.SizeBytes())
+}
+
+func TestWriter_WriteBehind(t *testing.T) {
+	writer := newFakeWriter()
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithWriter(writer, WriteBehind))
+
+	writer.failures = 2 // Retried in the background.
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}))
+	assert.Eventually(t, func() bool {
+		value, _ := writer.get("bkt1", "key1")
+		return value == "val1"
+	}, 2*time.Second, 10*time.Millisecond, "expected the write to be retried until it succeeds")
+
+	for _, value := range []string{"a", "b", "c"} {
+		require.NoError(t, mc.Set("bkt1", "key2", []byte(value), Options{}))
+	}
+	require.NoError(t, mc.SetMulti("bkt2", map[string][]byte{"key1": []byte("val1"), "key2": []byte("val2")}, Options{}))
+
+	// Stop flushes the queued writes.
+	mc.Stop()
+	value, _ := writer.get("bkt1", "key2")
+	assert.Equal(t, "c", value, "expected the last write of the key")
+	value, _ = writer.get("bkt2", "key2")
+	assert.Equal(t, "val2", value)
+}
+
+func TestWriter_WriteBehindDropsAfterRetries(t *testing.T) {
+	writer := newFakeWriter()
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithWriter(writer, WriteBehind))
+
+	writer.failures = writeBehindRetries
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), Options{}), "expected the sink failure not to fail the set")
+	require.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), Options{}))
+	mc.Stop()
+
+	_, ok := writer.get("bkt1", "key1")
+	assert.False(t, ok, "expected the write to be dropped after the retries")
+	value, _ := writer.get("bkt1", "key2")
+	assert.Equal(t, "val2", value, "expected the next writes to be written")
+}
+
+func TestWriter_NotMirrored(t *testing.T) {
+	writer := newFakeWriter()
+	loader := &fakeLoader{values: map[string]string{"bkt1/key1": "val1"}}
+	mc := NewMinervaCache(10, 0, &mockMetrics{}, WithWriter(writer, WriteThrough), WithLoader(loader))
+	defer mc.Stop()
+
+	_, err := mc.Get("bkt1", "key1", Options{})
+	require.NoError(t, err)
+	_, err = mc.GetOrSet("bkt1", "key2", Options{}, func() ([]byte, error) { return []byte("val2"), nil })
+	require.NoError(t, err)
+
+	_, ok := writer.get("bkt1", "key1")
+	assert.False(t, ok, "expected the read-through not to be written back")
+	value, _ := writer.get("bkt1", "key2")
+	assert.Equal(t, "val2", value, "expected the GetOrSet value to be written")
+}
@@ -0,0 +1,246 @@
+// Package client implements Go clients of the minervacache gRPC servers: Client for a single server, with a pool of
+// connections and retries, and ShardedClient sharding the keys across several servers with consistent hashing.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/jattoabdul/minervacache/cache"
+	"github.com/jattoabdul/minervacache/proto"
+)
+
+// DefaultRetryAttempts and DefaultRetryBackoff are the number of attempts of a call failing with Unavailable, and the
+// wait before the first retry, doubled after each one, unless configured with [WithRetry].
+const (
+	DefaultRetryAttempts = 3
+	DefaultRetryBackoff  = 50 * time.Millisecond
+)
+
+// MaxTTL is the longest TTL of SetWithTTL, about 24.8 days, since the ttl_ms of the gRPC API is an int32.
+const MaxTTL = math.MaxInt32 * time.Millisecond
+
+// Option configures a Client or a ShardedClient on creation.
+type Option func(o *options)
+
+type options struct {
+	// replicas is the number of points of each node on the ring of a ShardedClient.
+	replicas int
+	// dialOptions are used to connect to each server.
+	dialOptions []grpc.DialOption
+	// poolSize is the number of connections to each server.
+	poolSize int
+	// retryAttempts is the number of attempts of a call failing with Unavailable, 1 to disable the retries, and
+	// retryBackoff the wait before the first retry.
+	retryAttempts int
+	retryBackoff  time.Duration
+}
+
+// WithReplicas sets the number of points of each node on the hash ring of a ShardedClient. The default is
+// [DefaultReplicas].
+func WithReplicas(n int) Option {
+	return func(o *options) {
+		o.replicas = n
+	}
+}
+
+// WithDialOptions sets the options used to connect to each server, e.g. grpc.WithTransportCredentials for TLS.
+// The default is a plaintext connection.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOptions = opts
+	}
+}
+
+// WithPoolSize sets the number of connections to each server, used in turn by the calls, so the concurrent calls are
+// spread over several HTTP/2 connections. The default is a single connection.
+func WithPoolSize(n int) Option {
+	return func(o *options) {
+		o.poolSize = n
+	}
+}
+
+// WithRetry sets the number of attempts of the calls failing with Unavailable, e.g. while a server restarts, and the
+// wait before the first retry, doubled after each one. 1 attempt disables the retries. The other errors are never
+// retried. The default is [DefaultRetryAttempts] and [DefaultRetryBackoff].
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retryAttempts = attempts
+		o.retryBackoff = backoff
+	}
+}
+
+// newOptions applies the given options over the defaults.
+func newOptions(opts []Option) options {
+	o := options{
+		replicas:      DefaultReplicas,
+		dialOptions:   []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		poolSize:      1,
+		retryAttempts: DefaultRetryAttempts,
+		retryBackoff:  DefaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Client is a client of a minervacache gRPC server. It is safe for concurrent use.
+type Client struct {
+	options options
+	conns   []*grpc.ClientConn
+	clients []proto.MinervaCacheClient
+	next    atomic.Uint64 // The number of calls, picking the connection of the next one.
+}
+
+// NewClient returns a client of the server at addr, e.g. "localhost:8080". The connections are established lazily,
+// on the first calls.
+func NewClient(addr string, opts ...Option) (*Client, error) {
+	c := &Client{options: newOptions(opts)}
+	for i := 0; i < max(c.options.poolSize, 1); i++ {
+		conn, err := grpc.NewClient(addr, c.options.dialOptions...)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+		}
+		c.conns = append(c.conns, conn)
+		c.clients = append(c.clients, proto.NewMinervaCacheClient(conn))
+	}
+	return c, nil
+}
+
+// Get returns the value of the key in the bucket. An error wrapping cache.ErrKeyNotFound is returned if the key or
+// the bucket doesn't exist.
+func (c *Client) Get(ctx context.Context, bucket, key string) ([]byte, error) {
+	var resp *proto.GetResponse
+	err := c.call(ctx, func(client proto.MinervaCacheClient) (err error) {
+		resp, err = client.Get(ctx, &proto.GetRequest{Bucket: bucket, Key: key})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+// Set sets the value of the key in the bucket, without expiration.
+func (c *Client) Set(ctx context.Context, bucket, key string, value []byte) error {
+	return c.set(ctx, &proto.SetRequest{Bucket: bucket, Key: key, Value: value})
+}
+
+// SetWithTTL sets the value of the key in the bucket, expiring after the ttl, with a millisecond precision: a ttl
+// under 1ms is rounded up to 1ms. An error wrapping cache.ErrInvalidTTL is returned, without calling the server, if the
+// ttl is not positive or longer than [MaxTTL].
+func (c *Client) SetWithTTL(ctx context.Context, bucket, key string, value []byte, ttl time.Duration) error {
+	ttlMs, err := ttlMillis(ttl)
+	if err != nil {
+		return err
+	}
+	return c.set(ctx, &proto.SetRequest{Bucket: bucket, Key: key, Value: value, TtlMs: ttlMs})
+}
+
+// set runs the Set call of the request.
+func (c *Client) set(ctx context.Context, req *proto.SetRequest) error {
+	return c.call(ctx, func(client proto.MinervaCacheClient) error {
+		_, err := client.Set(ctx, req)
+		return err
+	})
+}
+
+// ttlMillis converts the ttl of SetWithTTL to the ttl_ms of a request, rounding a positive ttl under 1ms up so it
+// doesn't become 0, i.e. no expiration.
+func ttlMillis(ttl time.Duration) (int32, error) {
+	if ttl <= 0 || ttl.Milliseconds() > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: %s is not within (0, %s]", cache.ErrInvalidTTL, ttl, MaxTTL)
+	}
+	return int32(max(ttl.Milliseconds(), 1)), nil
+}
+
+// Delete removes the key from the bucket. An error wrapping cache.ErrKeyNotFound is returned if the key or the bucket
+// doesn't exist.
+func (c *Client) Delete(ctx context.Context, bucket, key string) error {
+	return c.call(ctx, func(client proto.MinervaCacheClient) error {
+		_, err := client.Delete(ctx, &proto.DeleteRequest{Bucket: bucket, Key: key})
+		return err
+	})
+}
+
+// Stats returns a snapshot of the counters of the cache of the server.
+func (c *Client) Stats(ctx context.Context) (*proto.StatsResponse, error) {
+	var resp *proto.StatsResponse
+	err := c.call(ctx, func(client proto.MinervaCacheClient) (err error) {
+		resp, err = client.Stats(ctx, &proto.StatsRequest{})
+		return err
+	})
+	return resp, err
+}
+
+// Scan streams the unexpired keys of the bucket, or of all the buckets if bucket is empty, to fn, sorted by bucket and
+// key. It stops at the first error returned by fn, which is returned. Unlike the other calls, it is not retried.
+func (c *Client) Scan(ctx context.Context, bucket string, fn func(resp *proto.ScanResponse) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Ends the stream if fn fails.
+
+	stream, err := c.clients[c.next.Add(1)%uint64(len(c.clients))].Scan(ctx, &proto.ScanRequest{Bucket: bucket})
+	if err != nil {
+		return errFromStatus(err)
+	}
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return errFromStatus(err)
+		}
+		if err := fn(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the connections to the server.
+func (c *Client) Close() error {
+	var errs []error
+	for _, conn := range c.conns {
+		errs = append(errs, conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// call runs the call on the next connection of the pool, retrying it with an exponential backoff while it fails with
+// Unavailable, see [WithRetry]. The error of the last attempt is returned, mapped by errFromStatus.
+func (c *Client) call(ctx context.Context, call func(client proto.MinervaCacheClient) error) error {
+	backoff := c.options.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := call(c.clients[c.next.Add(1)%uint64(len(c.clients))])
+		if status.Code(err) != codes.Unavailable || attempt >= c.options.retryAttempts {
+			return errFromStatus(err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return errFromStatus(err)
+		}
+		backoff *= 2
+	}
+}
+
+// errFromStatus maps the NotFound status of a server to cache.ErrKeyNotFound, so the misses can be told apart with
+// errors.Is. The other errors are returned as is.
+func errFromStatus(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("%w: %s", cache.ErrKeyNotFound, status.Convert(err).Message())
+	}
+	return err
+}
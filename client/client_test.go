@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/jattoabdul/minervacache/cache"
+	"github.com/jattoabdul/minervacache/proto"
+	"github.com/jattoabdul/minervacache/server"
+)
+
+// startFlakyServer starts an in-memory gRPC server failing its first calls, up to failures, with Unavailable, and returns its
+// cache, a client of it and the number of calls it received.
+func startFlakyServer(t *testing.T, failures int64, opts ...Option) (*cache.MinervaCache, *Client, *atomic.Int64) {
+	t.Helper()
+
+	metrics := cache.NewPmMetrics()
+	mc := cache.NewMinervaCache(1000, 0, metrics)
+	t.Cleanup(mc.Stop)
+
+	calls := &atomic.Int64{}
+	interceptor := func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if calls.Add(1) <= failures {
+			return nil, status.Error(codes.Unavailable, "restarting")
+		}
+		return handler(ctx, req)
+	}
+
+	listener := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(interceptor))
+	proto.RegisterMinervaCacheServer(srv, server.NewGRPCServer(mc, metrics).(proto.MinervaCacheServer))
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	opts = append([]Option{WithDialOptions(
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+	)}, opts...)
+	c, err := NewClient("passthrough:///flaky", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return mc, c, calls
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	mc, c, _ := startFlakyServer(t, 0, WithPoolSize(3))
+	assert.Len(t, c.conns, 3)
+
+	require.NoError(t, c.Set(ctx, "bkt1", "key1", []byte("val1")))
+	require.NoError(t, c.SetWithTTL(ctx, "bkt1", "key2", []byte("val2"), time.Minute))
+	for i := 0; i < 3; i++ { // Once on each connection of the pool.
+		value, err := c.Get(ctx, "bkt1", "key1")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("val1"), value)
+	}
+
+	_, meta, err := mc.GetWithMeta("bkt1", "key2", cache.Options{})
+	require.NoError(t, err)
+	assert.NotZero(t, meta.ExpiresAt, "expected the ttl to be set")
+
+	stats, err := c.Stats(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, stats.Size)
+
+	require.NoError(t, c.Delete(ctx, "bkt1", "key1"))
+	_, err = c.Get(ctx, "bkt1", "key1")
+	assert.ErrorIs(t, err, cache.ErrKeyNotFound)
+	assert.ErrorIs(t, c.Delete(ctx, "bkt1", "key1"), cache.ErrKeyNotFound)
+}
+
+func TestClient_SetWithTTL(t *testing.T) {
+	ctx := context.Background()
+	_, c, calls := startFlakyServer(t, 0)
+
+	for _, ttl := range []time.Duration{-time.Second, 0, MaxTTL + time.Millisecond} {
+		err := c.SetWithTTL(ctx, "bkt1", "key1", []byte("val1"), ttl)
+		assert.ErrorIs(t, err, cache.ErrInvalidTTL, "ttl %s", ttl)
+	}
+	assert.EqualValues(t, 0, calls.Load(), "expected the invalid ttls not to be sent")
+
+	for ttl, want := range map[time.Duration]int32{
+		time.Nanosecond:               1,
+		time.Millisecond:              1,
+		1500 * time.Microsecond:       1,
+		time.Minute:                   60000,
+		MaxTTL + 999*time.Microsecond: math.MaxInt32,
+	} {
+		ttlMs, err := ttlMillis(ttl)
+		require.NoError(t, err, "ttl %s", ttl)
+		assert.Equal(t, want, ttlMs, "ttl %s", ttl)
+	}
+}
+
+func TestClient_Retry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("transient failure", func(t *testing.T) {
+		mc, c, calls := startFlakyServer(t, 1, WithRetry(3, time.Millisecond))
+		require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), cache.Options{}))
+
+		value, err := c.Get(ctx, "bkt1", "key1")
+		require.NoError(t, err, "expected the retry to succeed")
+		assert.Equal(t, []byte("val1"), value)
+		assert.EqualValues(t, 2, calls.Load())
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		_, c, calls := startFlakyServer(t, 10, WithRetry(3, time.Millisecond))
+
+		_, err := c.Get(ctx, "bkt1", "key1")
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.EqualValues(t, 3, calls.Load())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		_, c, calls := startFlakyServer(t, 1, WithRetry(1, time.Millisecond))
+
+		assert.Equal(t, codes.Unavailable, status.Code(c.Set(ctx, "bkt1", "key1", []byte("val1"))))
+		assert.EqualValues(t, 1, calls.Load())
+	})
+
+	t.Run("other errors", func(t *testing.T) {
+		_, c, calls := startFlakyServer(t, 0, WithRetry(3, time.Millisecond))
+
+		_, err := c.Get(ctx, "bkt1", "key1")
+		assert.ErrorIs(t, err, cache.ErrKeyNotFound)
+		assert.EqualValues(t, 1, calls.Load(), "expected a miss not to be retried")
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		_, c, calls := startFlakyServer(t, 10, WithRetry(3, time.Hour))
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		_, err := c.Get(ctx, "bkt1", "key1")
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.EqualValues(t, 1, calls.Load(), "expected no retry after the context is done")
+	})
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/jattoabdul/minervacache/cache"
+)
+
+// DefaultReplicas is the number of points of each node on the ring unless configured with [WithReplicas]. The more
+// points, the more evenly the keys are spread across the nodes.
+const DefaultReplicas = 160
+
+// ring is a consistent-hash ring of nodes. Each node is hashed to replicas points on the ring, and a key belongs to
+// the node of the first point at or after its hash, wrapping around. Adding or removing a node only moves the keys
+// between its points and the previous ones, about 1/n of the keys, instead of reshuffling them all.
+// No locking is done here, the ShardedClient locks around it.
+type ring struct {
+	replicas int
+	points   []uint64          // The hashes of the points of all the nodes, sorted.
+	owners   map[uint64]string // The node of each point.
+}
+
+func newRing(replicas int) *ring {
+	return &ring{replicas: max(replicas, 1), owners: make(map[uint64]string)}
+}
+
+// add adds the points of the node to the ring. Adding a node twice is a no-op.
+func (r *ring) add(node string) {
+	for i := 0; i < r.replicas; i++ {
+		h := hash(node, strconv.Itoa(i))
+		if _, ok := r.owners[h]; ok {
+			continue // Taken by the node already, or by another one in the unlikely case of a collision.
+		}
+		r.owners[h] = node
+		r.points = append(r.points, h)
+	}
+	slices.Sort(r.points)
+}
+
+// remove removes the points of the node from the ring.
+func (r *ring) remove(node string) {
+	points := r.points[:0]
+	for _, h := range r.points {
+		if r.owners[h] == node {
+			delete(r.owners, h)
+			continue
+		}
+		points = append(points, h)
+	}
+	r.points = points
+}
+
+// get returns the node the key of the bucket belongs to, or an empty string if the ring has no node.
+func (r *ring) get(bucket, key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	h := hash(bucket, key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0 // Wrap around to the first point.
+	}
+	return r.owners[r.points[i]]
+}
+
+// hash returns the position of a key of a bucket, or of a point of a node, on the ring: the FNV-1a hash of the cache
+// shards, mixed with the splitmix64 finalizer, since FNV alone places similar strings like the points of a node
+// close together.
+func hash(a, b string) uint64 {
+	h := cache.FNVShardHash(a, b)
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	return h ^ (h >> 31)
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing_Stability(t *testing.T) {
+	r := newRing(DefaultReplicas)
+	for _, node := range []string{"node1", "node2", "node3"} {
+		r.add(node)
+	}
+
+	const keys = 10000
+	before := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key%d", i)
+		before[key] = r.get("bkt1", key)
+	}
+
+	// Adding a node only moves keys to it, about 1/4 of them.
+	r.add("node4")
+	moved := 0
+	for key, node := range before {
+		if now := r.get("bkt1", key); now != node {
+			assert.Equal(t, "node4", now, "expected %s to only move to the new node", key)
+			moved++
+		}
+	}
+	assert.InDelta(t, keys/4, moved, keys/10, "expected about a quarter of the keys to move")
+
+	// Removing it moves them back, and nothing else.
+	r.remove("node4")
+	for key, node := range before {
+		assert.Equal(t, node, r.get("bkt1", key), "expected %s back on its node", key)
+	}
+
+	r.add("node1") // Adding a node twice is a no-op.
+	assert.Len(t, r.points, 3*DefaultReplicas)
+}
+
+func TestRing_Distribution(t *testing.T) {
+	r := newRing(DefaultReplicas)
+	nodes := []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080", "10.0.0.4:8080", "10.0.0.5:8080"}
+	for _, node := range nodes {
+		r.add(node)
+	}
+
+	const keys = 100000
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		counts[r.get(fmt.Sprintf("bkt%d", i%10), fmt.Sprintf("user:%d", i))]++
+	}
+
+	mean := keys / len(nodes)
+	for _, node := range nodes {
+		assert.InDelta(t, mean, counts[node], float64(mean)/5, "expected %s to hold about 1/%d of the keys", node, len(nodes))
+	}
+}
+
+func TestRing_Empty(t *testing.T) {
+	r := newRing(DefaultReplicas)
+	assert.Empty(t, r.get("bkt1", "key1"))
+
+	r.add("node1")
+	assert.Equal(t, "node1", r.get("bkt1", "key1"))
+	r.remove("node1")
+	assert.Empty(t, r.get("bkt1", "key1"))
+	assert.Empty(t, r.owners)
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoNodes is returned for the operations of a ShardedClient without any node.
+var ErrNoNodes = errors.New("no nodes")
+
+// ShardedClient routes the operations on each key to one of several minervacache gRPC servers, picked with a
+// consistent-hash ring over the bucket and key, so each key is always on the same server, and adding or removing a
+// server only moves about 1/n of the keys. It is safe for concurrent use.
+type ShardedClient struct {
+	opts []Option // Used for the Client of each server.
+
+	mutex sync.RWMutex
+	ring  *ring
+	nodes map[string]*Client
+}
+
+// NewShardedClient returns a client sharding the keys across the servers at the given addresses, e.g.
+// "cache1:8080". Each server has its own Client, with the pool and retries of the options. The connections are
+// established lazily, on the first operation on each server.
+func NewShardedClient(addrs []string, opts ...Option) (*ShardedClient, error) {
+	o := newOptions(opts)
+	c := &ShardedClient{opts: opts, ring: newRing(o.replicas), nodes: make(map[string]*Client)}
+	for _, addr := range addrs {
+		if err := c.AddNode(addr); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// AddNode adds the server at addr to the ring. The keys it now owns are not copied to it: they are missed until set
+// again. Adding a server twice is a no-op.
+func (c *ShardedClient) AddNode(addr string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.nodes[addr]; ok {
+		return nil
+	}
+
+	n, err := NewClient(addr, c.opts...)
+	if err != nil {
+		return err
+	}
+	c.nodes[addr] = n
+	c.ring.add(addr)
+	return nil
+}
+
+// RemoveNode removes the server at addr from the ring and closes its connection. Its keys are spread over the other
+// servers, where they are missed until set again. Removing an unknown server is a no-op.
+func (c *ShardedClient) RemoveNode(addr string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n, ok := c.nodes[addr]
+	if !ok {
+		return nil
+	}
+
+	c.ring.remove(addr)
+	delete(c.nodes, addr)
+	return n.Close()
+}
+
+// Nodes returns the addresses of the servers of the ring.
+func (c *ShardedClient) Nodes() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	addrs := make([]string, 0, len(c.nodes))
+	for addr := range c.nodes {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// NodeFor returns the address of the server the key of the bucket is routed to, or an empty string without servers.
+func (c *ShardedClient) NodeFor(bucket, key string) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.ring.get(bucket, key)
+}
+
+// clientFor returns the client of the server the key of the bucket is routed to.
+func (c *ShardedClient) clientFor(bucket, key string) (*Client, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	addr := c.ring.get(bucket, key)
+	if addr == "" {
+		return nil, ErrNoNodes
+	}
+	return c.nodes[addr], nil
+}
+
+// Get returns the value of the key in the bucket from its server. An error wrapping cache.ErrKeyNotFound is returned
+// if the key or the bucket doesn't exist.
+func (c *ShardedClient) Get(ctx context.Context, bucket, key string) ([]byte, error) {
+	client, err := c.clientFor(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return client.Get(ctx, bucket, key)
+}
+
+// Set sets the value of the key in the bucket on its server, without expiration.
+func (c *ShardedClient) Set(ctx context.Context, bucket, key string, value []byte) error {
+	client, err := c.clientFor(bucket, key)
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, bucket, key, value)
+}
+
+// SetWithTTL sets the value of the key in the bucket on its server, expiring after the ttl, see [Client.SetWithTTL].
+func (c *ShardedClient) SetWithTTL(ctx context.Context, bucket, key string, value []byte, ttl time.Duration) error {
+	client, err := c.clientFor(bucket, key)
+	if err != nil {
+		return err
+	}
+	return client.SetWithTTL(ctx, bucket, key, value, ttl)
+}
+
+// Delete removes the key from the bucket on its server. An error wrapping cache.ErrKeyNotFound is returned if the key
+// or the bucket doesn't exist.
+func (c *ShardedClient) Delete(ctx context.Context, bucket, key string) error {
+	client, err := c.clientFor(bucket, key)
+	if err != nil {
+		return err
+	}
+	return client.Delete(ctx, bucket, key)
+}
+
+// Close closes the connections to all the servers.
+func (c *ShardedClient) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var errs []error
+	for addr, n := range c.nodes {
+		errs = append(errs, n.Close())
+		c.ring.remove(addr)
+	}
+	clear(c.nodes)
+	return errors.Join(errs...)
+}
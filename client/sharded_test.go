@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/jattoabdul/minervacache/cache"
+	"github.com/jattoabdul/minervacache/proto"
+	"github.com/jattoabdul/minervacache/server"
+)
+
+// startTestCluster starts in-memory gRPC servers, each with its own cache, and returns their caches by address along
+// with the dial option connecting to them.
+func startTestCluster(t *testing.T, addrs ...string) (map[string]*cache.MinervaCache, grpc.DialOption) {
+	t.Helper()
+
+	caches := make(map[string]*cache.MinervaCache)
+	listeners := make(map[string]*bufconn.Listener)
+	for _, addr := range addrs {
+		metrics := cache.NewPmMetrics()
+		mc := cache.NewMinervaCache(1000, 0, metrics)
+		t.Cleanup(mc.Stop)
+
+		listener := bufconn.Listen(1024 * 1024)
+		srv := grpc.NewServer()
+		proto.RegisterMinervaCacheServer(srv, server.NewGRPCServer(mc, metrics).(proto.MinervaCacheServer))
+		go srv.Serve(listener)
+		t.Cleanup(srv.Stop)
+
+		caches[addr], listeners[addr] = mc, listener
+	}
+
+	dialer := grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		return listeners[addr].DialContext(ctx)
+	})
+	return caches, dialer
+}
+
+func TestShardedClient(t *testing.T) {
+	addrs := []string{"passthrough:///node1", "passthrough:///node2", "passthrough:///node3"}
+	caches, dialer := startTestCluster(t, "node1", "node2", "node3")
+	c, err := NewShardedClient(addrs, WithDialOptions(dialer, grpc.WithTransportCredentials(insecure.NewCredentials())))
+	require.NoError(t, err)
+	defer c.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 30; i++ {
+		require.NoError(t, c.SetWithTTL(ctx, "bkt1", fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("val%d", i)), time.Minute))
+	}
+
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key%d", i)
+		value, err := c.Get(ctx, "bkt1", key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("val%d", i)), value)
+
+		// The key is only on the server it is routed to.
+		owner := c.NodeFor("bkt1", key)
+		for addr, mc := range caches {
+			exists, _ := mc.Exists("bkt1", key)
+			assert.Equal(t, "passthrough:///"+addr == owner, exists, "expected %s on %s only", key, owner)
+		}
+	}
+	for addr, mc := range caches {
+		assert.NotZero(t, mc.Len(), "expected %s to hold some of the keys", addr)
+	}
+
+	require.NoError(t, c.Delete(ctx, "bkt1", "key1"))
+	_, err = c.Get(ctx, "bkt1", "key1")
+	assert.ErrorIs(t, err, cache.ErrKeyNotFound)
+	assert.ErrorIs(t, c.Delete(ctx, "bkt1", "key1"), cache.ErrKeyNotFound)
+}
+
+func TestShardedClient_Nodes(t *testing.T) {
+	c, err := NewShardedClient([]string{"cache1:8080", "cache2:8080"})
+	require.NoError(t, err)
+	defer c.Close()
+
+	assert.ElementsMatch(t, []string{"cache1:8080", "cache2:8080"}, c.Nodes())
+	owner := c.NodeFor("bkt1", "key1")
+	require.NoError(t, c.AddNode("cache1:8080"), "expected adding a node twice to be a no-op")
+	assert.Len(t, c.Nodes(), 2)
+
+	other := "cache1:8080"
+	if owner == other {
+		other = "cache2:8080"
+	}
+	require.NoError(t, c.RemoveNode(other))
+	assert.Equal(t, owner, c.NodeFor("bkt1", "key1"), "expected the keys of the remaining node not to move")
+	require.NoError(t, c.RemoveNode(owner))
+	assert.Empty(t, c.NodeFor("bkt1", "key1"))
+
+	_, err = c.Get(context.Background(), "bkt1", "key1")
+	assert.ErrorIs(t, err, ErrNoNodes)
+	assert.ErrorIs(t, c.Set(context.Background(), "bkt1", "key1", nil), ErrNoNodes)
+}
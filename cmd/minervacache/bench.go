@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/jattoabdul/minervacache/proto"
+)
+
+// benchOp is an operation run by the benchmark.
+type benchOp int
+
+const (
+	benchGet benchOp = iota
+	benchSet
+	benchDelete
+)
+
+var benchOpNames = [...]string{benchGet: "get", benchSet: "set", benchDelete: "delete"}
+
+// benchConfig is the load run by the bench command.
+type benchConfig struct {
+	ops         int
+	concurrency int
+	keyspace    int
+	// weights are the relative frequencies of the operations, indexed by benchOp.
+	weights   [len(benchOpNames)]int
+	valueSize int
+	bucket    string
+}
+
+// benchClient runs the operations of the benchmark against a server. A missing key is not an error.
+type benchClient interface {
+	get(ctx context.Context, bucket, key string) error
+	set(ctx context.Context, bucket, key string, value []byte) error
+	delete(ctx context.Context, bucket, key string) error
+}
+
+// benchResult is the outcome of a benchmark.
+type benchResult struct {
+	counts    [len(benchOpNames)]int
+	errors    int
+	elapsed   time.Duration
+	latencies []time.Duration // Sorted.
+	firstErr  error
+}
+
+// throughput returns the number of operations completed per second.
+func (r benchResult) throughput() float64 {
+	if r.elapsed <= 0 {
+		return 0
+	}
+	return float64(len(r.latencies)) / r.elapsed.Seconds()
+}
+
+// percentile returns the latency under which the fraction p of the operations completed.
+func (r benchResult) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	return r.latencies[int(p*float64(len(r.latencies)-1))]
+}
+
+// runBench runs the bench command against the HTTP server, or the gRPC server with --grpc.
+func runBench(cmd *cobra.Command, args []string) error {
+	weights, err := parseBenchMix(benchMix)
+	if err != nil {
+		return err
+	}
+	bench.weights = weights
+	if bench.ops <= 0 || bench.concurrency <= 0 || bench.keyspace <= 0 {
+		return fmt.Errorf("--ops, --concurrency and --keyspace must be positive")
+	}
+
+	addr := fmt.Sprintf("%s:%d", gRPCHost, gRPCPort)
+	var client benchClient
+	if useGRPC {
+		conn, err := dialGRPC(addr)
+		if err != nil {
+			return fmt.Errorf("connecting to %s: %w", addr, err)
+		}
+		defer conn.Close()
+		client = grpcBenchClient{client: proto.NewMinervaCacheClient(conn)}
+	} else {
+		client, err = newHTTPBenchClient(addr, bench.concurrency)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Running %d operations with %d clients against %s\n", bench.ops, bench.concurrency, addr)
+	result := runBenchLoad(cmd.Context(), client, bench)
+	printBenchResult(os.Stdout, result)
+	return nil
+}
+
+// parseBenchMix parses the weights of the operations, e.g. "get=80,set=15,delete=5". The operations left out have a
+// weight of 0.
+func parseBenchMix(mix string) ([len(benchOpNames)]int, error) {
+	var weights [len(benchOpNames)]int
+	total := 0
+	for _, part := range strings.Split(mix, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		op := slices.Index(benchOpNames[:], name)
+		weight, err := strconv.Atoi(value)
+		if !ok || op < 0 || err != nil || weight < 0 {
+			return weights, fmt.Errorf("invalid --mix %q: expected comma-separated get, set or delete=<weight>", mix)
+		}
+		weights[op] = weight
+		total += weight
+	}
+	if total == 0 {
+		return weights, fmt.Errorf("invalid --mix %q: at least one weight must be positive", mix)
+	}
+	return weights, nil
+}
+
+// runBenchLoad runs the operations of the config with its number of concurrent clients, each picking an operation by
+// weight and a random key of the keyspace, until all the operations are run or the context is done.
+func runBenchLoad(ctx context.Context, client benchClient, config benchConfig) benchResult {
+	total := 0
+	for _, weight := range config.weights {
+		total += weight
+	}
+	value := bytes.Repeat([]byte("x"), config.valueSize)
+
+	var (
+		next   atomic.Int64
+		mutex  sync.Mutex
+		result benchResult
+		wg     sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < config.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var counts [len(benchOpNames)]int
+			var latencies []time.Duration
+			var errs int
+			var firstErr error
+			for next.Add(1) <= int64(config.ops) && ctx.Err() == nil {
+				op := pickBenchOp(config.weights, rand.IntN(total))
+				key := "key-" + strconv.Itoa(rand.IntN(config.keyspace))
+
+				opStart := time.Now()
+				var err error
+				switch op {
+				case benchGet:
+					err = client.get(ctx, config.bucket, key)
+				case benchSet:
+					err = client.set(ctx, config.bucket, key, value)
+				case benchDelete:
+					err = client.delete(ctx, config.bucket, key)
+				}
+				latencies = append(latencies, time.Since(opStart))
+				counts[op]++
+				if err != nil {
+					errs++
+					if firstErr == nil {
+						firstErr = fmt.Errorf("%s %s: %w", benchOpNames[op], key, err)
+					}
+				}
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			for op, n := range counts {
+				result.counts[op] += n
+			}
+			result.latencies = append(result.latencies, latencies...)
+			result.errors += errs
+			if result.firstErr == nil {
+				result.firstErr = firstErr
+			}
+		}()
+	}
+	wg.Wait()
+
+	result.elapsed = time.Since(start)
+	slices.Sort(result.latencies)
+	return result
+}
+
+// pickBenchOp returns the operation of the weights covering n, from 0 to the sum of the weights.
+func pickBenchOp(weights [len(benchOpNames)]int, n int) benchOp {
+	for op, weight := range weights {
+		if n < weight {
+			return benchOp(op)
+		}
+		n -= weight
+	}
+	return benchGet // Unreachable with n below the sum of the weights.
+}
+
+// printBenchResult prints the operations run, the throughput and the p50 and p99 latencies of the benchmark.
+func printBenchResult(w io.Writer, r benchResult) {
+	fmt.Fprintf(w, "Operations: %d (get %d, set %d, delete %d), errors: %d\n",
+		len(r.latencies), r.counts[benchGet], r.counts[benchSet], r.counts[benchDelete], r.errors)
+	if r.firstErr != nil {
+		fmt.Fprintf(w, "First error: %v\n", r.firstErr)
+	}
+	fmt.Fprintf(w, "Duration: %v\n", r.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Throughput: %.0f ops/s\n", r.throughput())
+	fmt.Fprintf(w, "Latency: p50 %v, p99 %v\n", r.percentile(0.5), r.percentile(0.99))
+}
+
+// grpcBenchClient runs the operations of the benchmark with the gRPC client.
+type grpcBenchClient struct {
+	client proto.MinervaCacheClient
+}
+
+func (c grpcBenchClient) get(ctx context.Context, bucket, key string) error {
+	_, err := c.client.Get(ctx, &proto.GetRequest{Bucket: bucket, Key: key})
+	return ignoreNotFound(err)
+}
+
+func (c grpcBenchClient) set(ctx context.Context, bucket, key string, value []byte) error {
+	_, err := c.client.Set(ctx, &proto.SetRequest{Bucket: bucket, Key: key, Value: value})
+	return err
+}
+
+func (c grpcBenchClient) delete(ctx context.Context, bucket, key string) error {
+	_, err := c.client.Delete(ctx, &proto.DeleteRequest{Bucket: bucket, Key: key})
+	return ignoreNotFound(err)
+}
+
+// ignoreNotFound returns nil for the NotFound status of a missing key, and the other errors as is.
+func ignoreNotFound(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
+}
+
+// httpBenchClient runs the operations of the benchmark with an HTTP client.
+type httpBenchClient struct {
+	client  *http.Client
+	baseURL string
+}
+
+// newHTTPBenchClient returns a client of the HTTP server at addr, keeping a connection open for each of the
+// concurrent clients, over TLS verified with the --tls-ca certificate if set.
+func newHTTPBenchClient(addr string, concurrency int) (*httpBenchClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	scheme := "http"
+	if tlsCAFile != "" {
+		pem, err := os.ReadFile(tlsCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading the TLS CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("loading the TLS CA certificate: no certificate found in %s", tlsCAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		scheme = "https"
+	}
+	return &httpBenchClient{client: &http.Client{Transport: transport}, baseURL: scheme + "://" + addr}, nil
+}
+
+func (c *httpBenchClient) get(ctx context.Context, bucket, key string) error {
+	return c.do(ctx, http.MethodGet, bucket, key, nil)
+}
+
+func (c *httpBenchClient) set(ctx context.Context, bucket, key string, value []byte) error {
+	return c.do(ctx, http.MethodPut, bucket, key, value)
+}
+
+func (c *httpBenchClient) delete(ctx context.Context, bucket, key string) error {
+	return c.do(ctx, http.MethodDelete, bucket, key, nil)
+}
+
+// do sends the request for the key and drains the response, so the connection is reused. A 404 Not Found is not an
+// error, the other statuses from 400 are.
+func (c *httpBenchClient) do(ctx context.Context, method, bucket, key string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/cache/"+bucket+"/"+key, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jattoabdul/minervacache/cache"
+	"github.com/jattoabdul/minervacache/proto"
+	"github.com/jattoabdul/minervacache/server"
+)
+
+// freePort returns a local port free to listen on.
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// waitListening waits for a server to accept connections on addr.
+func waitListening(t *testing.T, addr string) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond, "expected the server to start")
+}
+
+// startBenchServer starts the server on a free local port, and returns its address once it accepts connections.
+func startBenchServer(t *testing.T, newServer func(cache.Cache, cache.MetricsExporter, ...server.Option) server.Server) string {
+	t.Helper()
+
+	port := freePort(t)
+	mc := cache.NewMinervaCache(100, 0, cache.NewPmMetrics(), cache.WithDefaultPolicy(cache.LRUEvictionPolicy))
+	s := newServer(mc, cache.NewPmMetrics())
+	go s.Start(context.Background(), "127.0.0.1", port)
+	t.Cleanup(func() {
+		s.Stop(context.Background())
+		mc.Stop()
+	})
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	waitListening(t, addr)
+	return addr
+}
+
+func TestRunBenchLoad(t *testing.T) {
+	config := benchConfig{ops: 200, concurrency: 4, keyspace: 10, weights: [3]int{5, 3, 2}, valueSize: 8, bucket: "bench"}
+
+	t.Run("http", func(t *testing.T) {
+		client, err := newHTTPBenchClient(startBenchServer(t, server.NewHTTPServer), config.concurrency)
+		require.NoError(t, err)
+
+		result := runBenchLoad(context.Background(), client, config)
+		assert.Len(t, result.latencies, config.ops, "expected all the operations to complete")
+		assert.Zero(t, result.errors, "expected no error, got %v", result.firstErr)
+		assert.Positive(t, result.throughput())
+		assert.Positive(t, result.counts[benchGet])
+		assert.Positive(t, result.counts[benchSet])
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		conn, err := dialGRPC(startBenchServer(t, server.NewGRPCServer))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		result := runBenchLoad(context.Background(), grpcBenchClient{client: proto.NewMinervaCacheClient(conn)}, config)
+		assert.Len(t, result.latencies, config.ops)
+		assert.Zero(t, result.errors, "expected no error, got %v", result.firstErr)
+		assert.Positive(t, result.throughput())
+		assert.LessOrEqual(t, result.percentile(0.5), result.percentile(0.99))
+
+		var out strings.Builder
+		printBenchResult(&out, result)
+		assert.Contains(t, out.String(), "Operations: 200 (")
+		assert.Contains(t, out.String(), "Latency: p50 ")
+	})
+}
+
+func TestParseBenchMix(t *testing.T) {
+	weights, err := parseBenchMix("get=80, set=15,delete=5")
+	require.NoError(t, err)
+	assert.Equal(t, [3]int{80, 15, 5}, weights)
+
+	weights, err = parseBenchMix("set=1")
+	require.NoError(t, err)
+	assert.Equal(t, [3]int{0, 1, 0}, weights, "expected the operations left out to be skipped")
+	assert.Equal(t, benchSet, pickBenchOp(weights, 0))
+
+	for _, mix := range []string{"", "get", "get=-1", "put=1", "get=0,set=0"} {
+		_, err := parseBenchMix(mix)
+		assert.ErrorContains(t, err, "invalid --mix", "expected %q to be rejected", mix)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	"github.com/jattoabdul/minervacache/client"
+	"github.com/jattoabdul/minervacache/proto"
+)
+
+// dumpEntry is a key printed by the dump command, as a JSON line. The value is base64 encoded in JSON.
+type dumpEntry struct {
+	Bucket         string `json:"bucket"`
+	Key            string `json:"key"`
+	Value          []byte `json:"value"`
+	TTLRemainingMs int64  `json:"ttl_remaining_ms,omitempty"`
+}
+
+// runDump prints the keys of a running server to stdout as JSON lines, streamed with the Scan RPC of the gRPC server.
+func runDump(cmd *cobra.Command, args []string) error {
+	if !useGRPC {
+		return errors.New("dump is only supported by the gRPC server, use --grpc")
+	}
+
+	addr := fmt.Sprintf("%s:%d", gRPCHost, gRPCPort)
+	creds, err := grpcCredentials()
+	if err != nil {
+		return err
+	}
+	c, err := client.NewClient(addr, client.WithDialOptions(grpc.WithTransportCredentials(creds)))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return writeDump(cmd.Context(), os.Stdout, c, dumpBucket)
+}
+
+// writeDump writes the keys of the bucket, or of all the buckets if empty, to w as JSON lines, sorted by bucket and
+// key.
+func writeDump(ctx context.Context, w io.Writer, c *client.Client, bucket string) error {
+	enc := json.NewEncoder(w)
+	return c.Scan(ctx, bucket, func(resp *proto.ScanResponse) error {
+		return enc.Encode(dumpEntry{Bucket: resp.Bucket, Key: resp.Key, Value: resp.Value, TTLRemainingMs: resp.TtlRemainingMs})
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/jattoabdul/minervacache/cache"
+	"github.com/jattoabdul/minervacache/client"
+	"github.com/jattoabdul/minervacache/proto"
+	"github.com/jattoabdul/minervacache/server"
+)
+
+func TestWriteDump(t *testing.T) {
+	metrics := cache.NewPmMetrics()
+	mc := cache.NewMinervaCache(10, 0, metrics)
+	defer mc.Stop()
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), cache.Options{}))
+	require.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), cache.Options{TTL: time.Minute}))
+	require.NoError(t, mc.Set("bkt2", "key1", []byte("val3"), cache.Options{}))
+
+	listener := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	proto.RegisterMinervaCacheServer(srv, server.NewGRPCServer(mc, metrics).(proto.MinervaCacheServer))
+	go srv.Serve(listener)
+	defer srv.Stop()
+
+	c, err := client.NewClient("passthrough:///bufnet", client.WithDialOptions(
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+	))
+	require.NoError(t, err)
+	defer c.Close()
+
+	var buf bytes.Buffer
+	require.NoError(t, writeDump(context.Background(), &buf, c, ""))
+
+	var got []dumpEntry
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry dumpEntry
+		require.NoError(t, dec.Decode(&entry))
+		got = append(got, entry)
+	}
+	require.Len(t, got, 3)
+	assert.Equal(t, dumpEntry{Bucket: "bkt1", Key: "key1", Value: []byte("val1")}, got[0])
+	assert.Equal(t, "key2", got[1].Key)
+	assert.InDelta(t, time.Minute.Milliseconds(), got[1].TTLRemainingMs, 1000)
+	assert.Equal(t, dumpEntry{Bucket: "bkt2", Key: "key1", Value: []byte("val3")}, got[2])
+
+	buf.Reset()
+	require.NoError(t, writeDump(context.Background(), &buf, c, "bkt2"))
+	assert.JSONEq(t, `{"bucket": "bkt2", "key": "key1", "value": "dmFsMw=="}`, buf.String())
+	assert.ErrorIs(t, writeDump(context.Background(), &buf, c, "missing"), cache.ErrKeyNotFound)
+}
@@ -3,34 +3,73 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/jattoabdul/minervacache/cache"
-	"github.com/jattoabdul/minervacache/proto"
+	"github.com/jattoabdul/minervacache/client"
 	"github.com/jattoabdul/minervacache/server"
 )
 
 var (
 	// server flags
-	useGRPC bool
-
-	port int
-	host string
+	useGRPC       bool
+	useRESP       bool
+	useMemcached  bool
+	defaultBucket string
+
+	port             int
+	host             string
+	capacity         int
+	maxConns         int
+	maxStreams       int
+	maxMessageSize   int
+	rateLimit        int
+	rateBurst        int
+	gzipMinSize      int
+	corsOrigins      []string
+	shutdownTimeout  time.Duration
+	requestTimeout   time.Duration
+	cleanupInterval  time.Duration
+	expiryTimers     time.Duration
+	maxValueBytes    int
+	maxBuckets       int
+	snapshotPath     string
+	snapshotInterval time.Duration
+	walPath          string
+	seedFile         string
+	bucketMetrics    bool
+	tlsCertFile      string
+	tlsKeyFile       string
+	logLevel         string
+	logFormat        string
 
 	// client flags
-	gRPCPort int
-	gRPCHost string
+	gRPCPort  int
+	gRPCHost  string
+	tlsCAFile string
+
+	// bench flags
+	bench    benchConfig
+	benchMix string
+
+	// dump flags
+	dumpBucket string
 )
 
 func main() {
@@ -42,7 +81,10 @@ func main() {
 	serverCommand := &cobra.Command{
 		Use:   "server",
 		Short: "Start the cache server",
-		Run:   runServer,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return validateServerFlags()
+		},
+		Run: runServer,
 	}
 
 	grpcClientCommand := &cobra.Command{
@@ -51,16 +93,76 @@ func main() {
 		Run:   runGRPCClient,
 	}
 
+	benchCommand := &cobra.Command{
+		Use:   "bench",
+		Short: "Run a load of random operations against a server and report the throughput and latency",
+		RunE:  runBench,
+	}
+
+	dumpCommand := &cobra.Command{
+		Use:   "dump",
+		Short: "Print all the keys of a running server to stdout as JSON lines",
+		RunE:  runDump,
+	}
+
 	// Flags for server command
 	serverCommand.Flags().BoolVar(&useGRPC, "grpc", false, "Use the gRPC server not the default HTTP server")
+	serverCommand.Flags().BoolVar(&useRESP, "resp", false, "Use the RESP (Redis protocol) server not the default HTTP server")
+	serverCommand.Flags().BoolVar(&useMemcached, "memcached", false, "Use the memcached text protocol server not the default HTTP server")
+	serverCommand.Flags().StringVar(&defaultBucket, "default-bucket", server.DefaultBucket, "Bucket the RESP and memcached servers map all the keys to")
+	serverCommand.MarkFlagsMutuallyExclusive("grpc", "resp", "memcached")
 	serverCommand.Flags().IntVar(&port, "port", 8080, "Port our server listens on")
 	serverCommand.Flags().StringVar(&host, "host", "0.0.0.0", "Host address our server binds to")
+	serverCommand.Flags().IntVar(&capacity, "capacity", cache.MaxCacheSize, "Maximum number of keys the cache can hold, must be positive")
+	serverCommand.Flags().IntVar(&maxValueBytes, "max-value-bytes", 0, "Maximum size of a value in bytes, 0 for unlimited")
+	serverCommand.Flags().IntVar(&maxBuckets, "max-buckets", 0, "Maximum number of buckets, keys creating more are rejected, 0 for unlimited")
+	serverCommand.Flags().StringVar(&snapshotPath, "snapshot-path", "", "File the cache is loaded from on start and saved to on shutdown, empty to disable")
+	serverCommand.Flags().DurationVar(&snapshotInterval, "snapshot-interval", 0, "How often the cache is also saved to --snapshot-path while serving, 0 to only save it on shutdown")
+	serverCommand.Flags().StringVar(&walPath, "wal-path", "", "Write-ahead log file replayed on start to recover the writes lost by a crash, empty to disable")
+	serverCommand.Flags().StringVar(&seedFile, "seed-file", "", "File of \"<bucket> <key> <value>\" lines set in the cache before serving, empty to disable")
+	serverCommand.Flags().BoolVar(&bucketMetrics, "bucket-metrics", false, "Label the hit, miss, set, delete, evict and not found metrics by bucket, only for a bounded number of buckets")
+	serverCommand.Flags().StringVar(&tlsCertFile, "tls-cert", "", "PEM certificate file to serve over TLS, requires --tls-key")
+	serverCommand.Flags().StringVar(&tlsKeyFile, "tls-key", "", "PEM private key file to serve over TLS, requires --tls-cert")
+	serverCommand.MarkFlagsRequiredTogether("tls-cert", "tls-key")
+	serverCommand.Flags().DurationVar(&cleanupInterval, "cleanup-interval", cache.DefaultCleanupInterval, "How often expired keys are removed in the background, 0 to only remove them when read")
+	serverCommand.Flags().DurationVar(&expiryTimers, "expiry-timers", 0, "Longest TTL of the keys removed by a timer as soon as they expire instead of by the cleanup, 0 to disable")
+	serverCommand.Flags().IntVar(&maxConns, "max-conns", 0, "Maximum number of simultaneous connections, the ones beyond are closed, 0 for unlimited")
+	serverCommand.Flags().IntVar(&maxStreams, "max-streams", 0, "Maximum number of concurrent RPCs per gRPC connection, 0 for the gRPC default")
+	serverCommand.Flags().IntVar(&maxMessageSize, "max-message-size", 0, "Maximum size in bytes of the gRPC messages, 0 for the gRPC default of 4MB")
+	serverCommand.Flags().IntVar(&rateLimit, "rate-limit", 0, "Maximum number of HTTP requests per second per client IP, 0 for unlimited")
+	serverCommand.Flags().IntVar(&rateBurst, "rate-burst", 0, "Number of HTTP requests a client can send at once within --rate-limit, defaults to --rate-limit")
+	serverCommand.Flags().StringSliceVar(&corsOrigins, "cors-origins", nil, "Origins allowed to call the HTTP server from a browser, e.g. https://app.example.com or *, empty to disable CORS")
+	serverCommand.Flags().IntVar(&gzipMinSize, "gzip-min-size", server.DefaultGzipMinSize, "Minimum size in bytes of the HTTP responses gzipped for the clients accepting it, 0 to disable")
+	serverCommand.Flags().DurationVar(&requestTimeout, "request-timeout", server.DefaultRequestTimeout, "How long the HTTP get, set and delete requests can take before failing with 504, 0 for no timeout")
+	serverCommand.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", server.DefaultShutdownTimeout, "How long to wait for in-flight requests on shutdown")
+	serverCommand.Flags().StringVar(&logLevel, "log-level", "info", "Minimum level of the logs: debug, info, warn or error")
+	serverCommand.Flags().StringVar(&logFormat, "log-format", "text", "Format of the logs: text or json")
 
 	// Flags for gRPC client command
 	grpcClientCommand.Flags().StringVar(&gRPCHost, "host", "localhost", "Server host to connect to")
 	grpcClientCommand.Flags().IntVar(&gRPCPort, "port", 8080, "Server port to connect to")
-
-	rootCommand.AddCommand(serverCommand, grpcClientCommand)
+	grpcClientCommand.Flags().StringVar(&tlsCAFile, "tls-ca", "", "PEM CA certificate file to connect over TLS and verify the server with")
+
+	// Flags for bench command, sharing the connection flags of the client
+	benchCommand.Flags().BoolVar(&useGRPC, "grpc", false, "Benchmark the gRPC server not the default HTTP server")
+	benchCommand.Flags().StringVar(&gRPCHost, "host", "localhost", "Server host to connect to")
+	benchCommand.Flags().IntVar(&gRPCPort, "port", 8080, "Server port to connect to")
+	benchCommand.Flags().StringVar(&tlsCAFile, "tls-ca", "", "PEM CA certificate file to connect over TLS and verify the server with")
+	benchCommand.Flags().IntVar(&bench.ops, "ops", 100000, "Total number of operations to run")
+	benchCommand.Flags().IntVar(&bench.concurrency, "concurrency", 50, "Number of concurrent clients running the operations")
+	benchCommand.Flags().IntVar(&bench.keyspace, "keyspace", 1000, "Number of distinct keys the operations pick from at random")
+	benchCommand.Flags().StringVar(&benchMix, "mix", "get=80,set=15,delete=5", "Weights of the get, set and delete operations")
+	benchCommand.Flags().IntVar(&bench.valueSize, "value-size", 64, "Size in bytes of the values set")
+	benchCommand.Flags().StringVar(&bench.bucket, "bucket", "bench", "Bucket the keys are set in")
+
+	// Flags for dump command, sharing the connection flags of the client
+	dumpCommand.Flags().BoolVar(&useGRPC, "grpc", false, "Dump the keys of the gRPC server, the only one supported")
+	dumpCommand.Flags().StringVar(&gRPCHost, "host", "localhost", "Server host to connect to")
+	dumpCommand.Flags().IntVar(&gRPCPort, "port", 8080, "Server port to connect to")
+	dumpCommand.Flags().StringVar(&tlsCAFile, "tls-ca", "", "PEM CA certificate file to connect over TLS and verify the server with")
+	dumpCommand.Flags().StringVar(&dumpBucket, "bucket", "", "Only dump the keys of this bucket, all the buckets if empty")
+
+	rootCommand.AddCommand(serverCommand, grpcClientCommand, benchCommand, dumpCommand)
 
 	if err := rootCommand.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error Occured: %v\n", err)
@@ -68,64 +170,398 @@ func main() {
 	}
 }
 
-// runServer starts the cache server with the specified host and port.
-// If useGRPC is true, it starts a gRPC server; otherwise, it starts an HTTP server.
+// validateServerFlags checks the server flags before starting, so an invalid value is reported instead of
+// starting a cache that can't hold any key.
+func validateServerFlags() error {
+	if capacity <= 0 {
+		return fmt.Errorf("invalid --capacity %d: must be positive", capacity)
+	}
+	if maxConns < 0 {
+		return fmt.Errorf("invalid --max-conns %d: must not be negative", maxConns)
+	}
+	if maxStreams < 0 {
+		return fmt.Errorf("invalid --max-streams %d: must not be negative", maxStreams)
+	}
+	if rateLimit < 0 || rateBurst < 0 {
+		return fmt.Errorf("invalid --rate-limit %d or --rate-burst %d: must not be negative", rateLimit, rateBurst)
+	}
+	if expiryTimers < 0 {
+		return fmt.Errorf("invalid --expiry-timers %v: must not be negative", expiryTimers)
+	}
+	if maxBuckets < 0 {
+		return fmt.Errorf("invalid --max-buckets %d: must not be negative", maxBuckets)
+	}
+	if maxMessageSize < 0 {
+		return fmt.Errorf("invalid --max-message-size %d: must not be negative", maxMessageSize)
+	}
+	if snapshotInterval > 0 && snapshotPath == "" {
+		return fmt.Errorf("invalid --snapshot-interval %v: requires --snapshot-path", snapshotInterval)
+	}
+	if _, err := newLogger(io.Discard, logLevel, logFormat); err != nil {
+		return err
+	}
+	return nil
+}
+
+// newLogger returns a logger writing to w the logs of the given level (debug, info, warn or error) and above, in the
+// given format (text or json). An empty level or format is info or text.
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var minLevel slog.Level
+	if level != "" {
+		if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid --log-level %q: must be debug, info, warn or error", level)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: minLevel}
+	switch format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid --log-format %q: must be text or json", format)
+	}
+}
+
+// runServer starts the cache server with the specified host and port, and shuts it down gracefully on SIGINT or
+// SIGTERM.
 func runServer(cmd *cobra.Command, args []string) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	if err := serve(sigCh); err != nil {
+		slog.Error("Failed to run server", "err", err)
+		os.Exit(1)
+	}
+}
+
+// serve runs the cache server until a signal is received on sigCh, then shuts it down gracefully.
+// If useGRPC, useRESP or useMemcached is true, it starts a gRPC, RESP or memcached server; otherwise, an HTTP server.
+// With --snapshot-path, the cache is loaded from the snapshot on start, saved every --snapshot-interval if set, and
+// saved on shutdown.
+// An error is returned if the server fails to start, e.g. if the port is already in use, once the cache is stopped.
+func serve(sigCh <-chan os.Signal) error {
+	logger, err := newLogger(os.Stderr, logLevel, logFormat)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger) // Also used by the log package, e.g. of the dependencies.
+
 	//Init prometheus metrics
 	metrics := cache.NewPmMetrics()
+	if bucketMetrics {
+		metrics = cache.NewPmMetricsWithBucketLabels()
+	}
 
 	// Create a new cache instance
-	mCache := cache.NewMinervaCache(cache.MaxCacheSize, cache.DefaultCleanupInterval, metrics)
+	// The RESP and memcached commands have no policy, so they use LRU like the HTTP and gRPC requests without one.
+	cacheOpts := []cache.CacheOption{
+		cache.WithMaxValueBytes(maxValueBytes),
+		cache.WithMaxBuckets(maxBuckets),
+		cache.WithExpiryTimers(expiryTimers),
+		cache.WithDefaultPolicy(cache.LRUEvictionPolicy),
+		cache.WithLogger(logger),
+	}
+	if walPath != "" {
+		cacheOpts = append(cacheOpts, cache.WithWAL(walPath))
+	}
+	mCache := cache.NewMinervaCache(capacity, cleanupInterval, metrics, cacheOpts...)
+	var snapshots *snapshotter
+	if snapshotPath != "" {
+		if err := loadSnapshot(mCache, snapshotPath); err != nil {
+			return fmt.Errorf("loading snapshot: %w", err)
+		}
+		snapshots = newSnapshotter(mCache, snapshotPath, snapshotInterval)
+	}
+	if seedFile != "" {
+		if err := loadSeedFile(mCache, seedFile); err != nil {
+			return fmt.Errorf("loading seed file: %w", err)
+		}
+	}
 
 	// Create a new server instance based on the useGRPC flag
+	serverOpts := []server.Option{
+		server.WithShutdownTimeout(shutdownTimeout),
+		server.WithRequestTimeout(requestTimeout),
+		server.WithDefaultBucket(defaultBucket),
+		server.WithMaxConns(maxConns),
+		server.WithMaxStreams(maxStreams),
+		server.WithMaxMessageSize(maxMessageSize),
+		server.WithGzipMinSize(gzipMinSize),
+		server.WithMaxBodyBytes(int64(maxValueBytes)),
+		server.WithLogger(logger),
+	}
+	if rateLimit > 0 {
+		burst := rateBurst
+		if burst == 0 {
+			burst = rateLimit
+		}
+		serverOpts = append(serverOpts, server.WithRateLimit(rateLimit, burst))
+	}
+	if tlsCertFile != "" {
+		serverOpts = append(serverOpts, server.WithTLS(tlsCertFile, tlsKeyFile))
+	}
+	if len(corsOrigins) > 0 {
+		serverOpts = append(serverOpts, server.WithCORS(corsOrigins))
+	}
+
 	var mServer server.Server
 	serverType := "HTTP"
-	if useGRPC {
+	switch {
+	case useGRPC:
 		serverType = "gRPC"
-		mServer = server.NewGRPCServer(mCache, metrics)
-	} else {
-		mServer = server.NewHTTPServer(mCache, metrics)
+		mServer = server.NewGRPCServer(mCache, metrics, serverOpts...)
+	case useRESP:
+		serverType = "RESP"
+		mServer = server.NewRESPServer(mCache, metrics, serverOpts...)
+	case useMemcached:
+		serverType = "memcached"
+		mServer = server.NewMemcachedServer(mCache, metrics, serverOpts...)
+	default:
+		mServer = server.NewHTTPServer(mCache, metrics, serverOpts...)
 	}
 	//mServer.server
 
-	// Setup graceful shutdown
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-	// Start the server in a goroutine
-	log.Printf("Starting minervacache %s server on port %s:%d\n", serverType, host, port)
+	// Start the server in a goroutine, Start only returns early if it fails, e.g. to bind the port.
+	logger.Info("Starting minervacache server", "type", serverType, "host", host, "port", port)
+	startErr := make(chan error, 1)
 	go func() {
-		if err := mServer.Start(context.Background(), host, port); err != nil {
-			log.Printf("Failed to start server with error: %v\n", err)
-		}
+		startErr <- mServer.Start(context.Background(), host, port)
 	}()
 
-	// Wait for termination signal
-	sig := <-sigCh
-	log.Printf("Received signal %v, shutting down gracefully...\n", sig)
+	// Wait for termination signal, or for the server to fail
+	select {
+	case sig := <-sigCh:
+		logger.Info("Received signal, shutting down gracefully", "signal", sig)
+
+		// Stop the server
+		if err := mServer.Stop(context.Background()); err != nil {
+			logger.Error("Failed to stop server", "err", err)
+		} else {
+			logger.Info("Server stopped successfully")
+		}
+	case err = <-startErr:
+		if err == nil {
+			err = errors.New("stopped unexpectedly")
+		}
+		err = fmt.Errorf("starting %s server: %w", serverType, err)
+	}
+
+	// Save the cache once the server no longer writes to it, and before stopping it flushes it.
+	if snapshots != nil {
+		if err := snapshots.stop(); err != nil {
+			logger.Error("Failed to save snapshot", "err", err)
+		} else {
+			logger.Info("Snapshot saved", "path", snapshotPath)
+		}
+	}
 
 	// Stop the cache
 	mCache.Stop()
-	log.Printf("Cache stopped successfully\n")
+	logger.Info("Cache stopped successfully")
+	return err
+}
 
-	// Stop the server
-	if err := mServer.Stop(context.Background()); err != nil {
-		log.Printf("Failed to stop server with error: %v\n", err)
-	} else {
-		log.Printf("Server stopped successfully\n")
+// loadSnapshot loads the cache from the snapshot file at path. A missing file is not an error, e.g. on first start.
+func loadSnapshot(mCache *cache.MinervaCache, path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return mCache.LoadSnapshot(bufio.NewReader(f))
+}
+
+// snapshotter saves the cache to the snapshot file every interval, and once more when it is stopped.
+type snapshotter struct {
+	mCache *cache.MinervaCache
+	path   string
+	// mutex serializes the saves, so the periodic save and the one on shutdown never write the file concurrently.
+	mutex sync.Mutex
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// newSnapshotter returns a snapshotter of the cache to path, saving it every interval in the background if positive.
+func newSnapshotter(mCache *cache.MinervaCache, path string, interval time.Duration) *snapshotter {
+	s := &snapshotter{mCache: mCache, path: path, done: make(chan struct{})}
+	if interval <= 0 {
+		return s
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.save(); err != nil {
+					slog.Error("Failed to save periodic snapshot", "err", err)
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// save saves the cache to the snapshot file, waiting for a save in progress to complete first.
+func (s *snapshotter) save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return saveSnapshot(s.mCache, s.path)
+}
+
+// stop stops the periodic saves, then saves the cache one last time.
+func (s *snapshotter) stop() error {
+	close(s.done)
+	s.wg.Wait()
+	return s.save()
+}
+
+// saveSnapshot saves the cache to the snapshot file at path. It writes to a temporary file first and renames it, so
+// a failed save doesn't corrupt the previous snapshot.
+func saveSnapshot(mCache *cache.MinervaCache, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	err = mCache.SaveSnapshot(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// seedEntry is a key and value set in the cache on start, see [parseSeed].
+type seedEntry struct {
+	bucket, key string
+	value       []byte
+}
+
+// loadSeedFile sets the entries of the seed file at path in the cache, stopping at the first invalid line.
+func loadSeedFile(mCache *cache.MinervaCache, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
+
+	entries, err := parseSeed(f)
+	if err != nil {
+		return fmt.Errorf("%s:%w", path, err)
+	}
+	for _, e := range entries {
+		if err := mCache.Set(e.bucket, e.key, e.value, cache.Options{}); err != nil {
+			return fmt.Errorf("setting %s/%s: %w", e.bucket, e.key, err)
+		}
+	}
+	slog.Info("Seeded the cache", "keys", len(entries), "path", path)
+	return nil
+}
+
+// parseSeed parses the newline-delimited "<bucket> <key> <value>" entries of a seed file. Blank lines and lines
+// starting with # are skipped. A value with spaces must be double-quoted, with the Go escapes, e.g. "hello\tworld".
+// An error is returned for the first malformed line, prefixed with its line number.
+func parseSeed(r io.Reader) ([]seedEntry, error) {
+	var entries []seedEntry
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry, err := parseSeedLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %w", n, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// parseSeedLine parses a "<bucket> <key> <value>" line with no surrounding spaces.
+func parseSeedLine(line string) (seedEntry, error) {
+	bucket, rest := cutField(line)
+	key, value := cutField(rest)
+	if bucket == "" || key == "" || value == "" {
+		return seedEntry{}, errors.New("expected \"<bucket> <key> <value>\"")
+	}
+
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return seedEntry{}, fmt.Errorf("invalid quoted value %s", value)
+		}
+		value = unquoted
+	} else if strings.ContainsAny(value, " \t") {
+		return seedEntry{}, errors.New("values with spaces must be quoted")
+	}
+	return seedEntry{bucket: bucket, key: key, value: []byte(value)}, nil
+}
+
+// cutField returns the first whitespace-separated field of s and the rest, without its leading whitespace.
+func cutField(s string) (string, string) {
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimLeft(s[i:], " \t")
+}
+
+// grpcCredentials returns the credentials of the connections to the gRPC server: TLS verified with the --tls-ca
+// certificate if set, plaintext otherwise.
+func grpcCredentials() (credentials.TransportCredentials, error) {
+	if tlsCAFile == "" {
+		return insecure.NewCredentials(), nil
+	}
+	creds, err := credentials.NewClientTLSFromFile(tlsCAFile, "")
+	if err != nil {
+		return nil, fmt.Errorf("loading the TLS CA certificate: %w", err)
+	}
+	return creds, nil
+}
+
+// dialGRPC connects to the gRPC server at addr, see grpcCredentials.
+func dialGRPC(addr string) (*grpc.ClientConn, error) {
+	creds, err := grpcCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Dial(addr, grpc.WithTransportCredentials(creds))
 }
 
 // runGRPCClient starts an interactive gRPC client to test the gRPC server.
 func runGRPCClient(cmd *cobra.Command, args []string) {
 	addr := fmt.Sprintf("%s:%d", gRPCHost, gRPCPort)
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	creds, err := grpcCredentials()
 	if err != nil {
 		log.Fatalf("gRPC Clint failed to connect to server: %v", err)
 	}
-	defer conn.Close()
-
-	client := proto.NewMinervaCacheClient(conn)
+	c, err := client.NewClient(addr, client.WithDialOptions(grpc.WithTransportCredentials(creds)))
+	if err != nil {
+		log.Fatalf("gRPC Clint failed to connect to server: %v", err)
+	}
+	defer c.Close()
 
 	reader := bufio.NewReader(os.Stdin)
 
@@ -158,7 +594,7 @@ func runGRPCClient(cmd *cobra.Command, args []string) {
 				fmt.Println("Usage: get <bucket> <key>")
 				continue
 			}
-			handleGet(client, args[1], args[2])
+			handleGet(c, args[1], args[2])
 		case "set":
 			if len(args) < 4 {
 				fmt.Println("Usage: set <bucket> <key> <value> [ttl_ms]")
@@ -174,14 +610,16 @@ func runGRPCClient(cmd *cobra.Command, args []string) {
 				}
 			}
 
-			handleSet(client, args[1], args[2], args[3], ttl)
+			handleSet(c, args[1], args[2], args[3], ttl)
 		case "del", "delete":
 			if len(args) != 3 {
 				fmt.Println("Usage: del <bucket> <key>")
 				continue
 			}
 
-			handleDelete(client, args[1], args[2])
+			handleDelete(c, args[1], args[2])
+		case "stats":
+			handleStats(c)
 		default:
 			fmt.Printf("Unknown command: %s\n", cmd)
 			printHelp()
@@ -189,84 +627,91 @@ func runGRPCClient(cmd *cobra.Command, args []string) {
 	}
 }
 
-// parseTTL parses the TTL value from a string to an int64.
+// parseTTL parses the TTL value from a string to an int64 of milliseconds, 0 for no expiration and at most
+// client.MaxTTL.
 func parseTTL(ttlStr string) (int64, error) {
 	ttl, err := strconv.ParseInt(ttlStr, 10, 64)
 	if err != nil {
 		return 0, fmt.Errorf("invalid TTL: %v", err)
 	}
+	if ttl < 0 || ttl > client.MaxTTL.Milliseconds() {
+		return 0, fmt.Errorf("invalid TTL: %d is not within [0, %d]", ttl, client.MaxTTL.Milliseconds())
+	}
 	return ttl, nil
 }
 
 // handleGet processes a get request
-func handleGet(client proto.MinervaCacheClient, bucket, key string) {
+func handleGet(c *client.Client, bucket, key string) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	req := &proto.GetRequest{
-		Bucket: bucket,
-		Key:    key,
+	value, err := c.Get(ctx, bucket, key)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		fmt.Println("Error Occurred: Key not found")
+		return
 	}
-	resp, err := client.Get(ctx, req)
-
 	if err != nil {
 		fmt.Printf("Error getting value: %v\n", err)
 		return
 	}
-
-	if resp.Value != nil {
-		fmt.Printf("Value: %s\n", string(resp.Value))
-	} else {
-		fmt.Println("Error Occurred: Key not found")
-	}
+	fmt.Printf("Value: %s\n", string(value))
 }
 
 // handleSet processes a set request
-func handleSet(client proto.MinervaCacheClient, bucket, key, value string, ttl int64) {
+func handleSet(c *client.Client, bucket, key, value string, ttl int64) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	req := &proto.SetRequest{
-		Bucket: bucket,
-		Key:    key,
-		Value:  []byte(value),
-		TtlMs:  int32(ttl),
+	var err error
+	if ttl > 0 {
+		err = c.SetWithTTL(ctx, bucket, key, []byte(value), time.Duration(ttl)*time.Millisecond)
+	} else {
+		err = c.Set(ctx, bucket, key, []byte(value))
 	}
-	resp, err := client.Set(ctx, req)
-
 	if err != nil {
 		fmt.Printf("Error setting value: %v\n", err)
 		return
 	}
-
-	if resp != nil {
-		fmt.Println("Value set successfully")
-	} else {
-		fmt.Println("Error Occurred: Value not set")
-	}
+	fmt.Println("Value set successfully")
 }
 
 // handleDelete processes a delete request
-func handleDelete(client proto.MinervaCacheClient, bucket, key string) {
+func handleDelete(c *client.Client, bucket, key string) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	req := &proto.DeleteRequest{
-		Bucket: bucket,
-		Key:    key,
+	if err := c.Delete(ctx, bucket, key); err != nil {
+		fmt.Printf("Error deleting value: %v\n", err)
+		return
 	}
-	resp, err := client.Delete(ctx, req)
+	fmt.Println("Value deleted successfully")
+}
 
+// handleStats processes a stats request
+func handleStats(c *client.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, err := c.Stats(ctx)
 	if err != nil {
-		fmt.Printf("Error deleting value: %v\n", err)
+		fmt.Printf("Error getting stats: %v\n", err)
 		return
 	}
 
-	if resp != nil {
-		fmt.Println("Value deleted successfully")
+	fmt.Printf("Hits: %d\n", resp.Hits)
+	fmt.Printf("Misses: %d\n", resp.Misses)
+	fmt.Printf("Sets: %d\n", resp.Sets)
+	fmt.Printf("Deletes: %d\n", resp.Deletes)
+	fmt.Printf("Evicts: %d\n", resp.Evicts)
+	fmt.Printf("Expires: %d\n", resp.Expires)
+	fmt.Printf("Size: %d\n", resp.Size)
+	fmt.Printf("Buckets: %d\n", resp.BucketCount)
+	if resp.MaxBytes > 0 {
+		fmt.Printf("Bytes: %d/%d\n", resp.UsedBytes, resp.MaxBytes)
 	} else {
-		fmt.Println("Error Occurred: Value not deleted")
+		fmt.Printf("Capacity: %d\n", resp.Capacity)
 	}
+	fmt.Printf("Utilization: %.1f%%\n", resp.Utilization*100)
 }
 
 func printHelp() {
@@ -274,6 +719,7 @@ func printHelp() {
 	fmt.Println("  get <bucket> <key>                    Get value by bucket and key")
 	fmt.Println("  set <bucket> <key> <value> [ttl_ms]  Set value with optional TTL in milliseconds")
 	fmt.Println("  del <bucket> <key>                    Delete value by bucket and key")
+	fmt.Println("  stats                                 Show the cache statistics")
 	fmt.Println("  help                                  Show this help message")
 	fmt.Println("  exit                                  Exit the client")
 }
@@ -1,9 +1,190 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jattoabdul/minervacache/cache"
+)
 
 // TestGRPCIntegration tests the gRPC integration of the cache.
 func TestGRPCIntegration(t *testing.T) {}
 
 // TestHTTPIntegration tests the HTTP integration of the cache.
 func TestHTTPIntegration(t *testing.T) {}
+
+func TestValidateServerFlags(t *testing.T) {
+	defer func(c int) { capacity = c }(capacity)
+
+	for _, c := range []int{0, -1} {
+		capacity = c
+		assert.ErrorContains(t, validateServerFlags(), "invalid --capacity", "expected capacity %d to be rejected", c)
+	}
+
+	capacity = 1
+	assert.NoError(t, validateServerFlags())
+
+	defer func(c, s int) { maxConns, maxStreams = c, s }(maxConns, maxStreams)
+	maxConns = -1
+	assert.ErrorContains(t, validateServerFlags(), "invalid --max-conns")
+	maxConns, maxStreams = 0, -1
+	assert.ErrorContains(t, validateServerFlags(), "invalid --max-streams")
+
+	defer func(m int) { maxMessageSize = m }(maxMessageSize)
+	maxStreams, maxMessageSize = 0, -1
+	assert.ErrorContains(t, validateServerFlags(), "invalid --max-message-size")
+
+	defer func(l, b int) { rateLimit, rateBurst = l, b }(rateLimit, rateBurst)
+	maxMessageSize, rateBurst = 0, -1
+	assert.ErrorContains(t, validateServerFlags(), "invalid --rate-limit")
+
+	defer func(i time.Duration) { snapshotInterval = i }(snapshotInterval)
+	rateBurst, snapshotInterval = 0, time.Minute
+	assert.ErrorContains(t, validateServerFlags(), "invalid --snapshot-interval", "expected the interval to require a path")
+
+	defer func(b int) { maxBuckets = b }(maxBuckets)
+	snapshotInterval, maxBuckets = 0, -1
+	assert.ErrorContains(t, validateServerFlags(), "invalid --max-buckets")
+
+	defer func(e time.Duration) { expiryTimers = e }(expiryTimers)
+	maxBuckets, expiryTimers = 0, -time.Second
+	assert.ErrorContains(t, validateServerFlags(), "invalid --expiry-timers")
+
+	defer func(l, f string) { logLevel, logFormat = l, f }(logLevel, logFormat)
+	expiryTimers, logLevel = 0, "verbose"
+	assert.ErrorContains(t, validateServerFlags(), "invalid --log-level")
+	logLevel, logFormat = "debug", "xml"
+	assert.ErrorContains(t, validateServerFlags(), "invalid --log-format")
+}
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "warn", "json")
+	require.NoError(t, err)
+	logger.Info("hidden")
+	logger.Warn("shown", "key", "key1")
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line), "expected a single JSON line at the warn level")
+	assert.Equal(t, "WARN", line["level"])
+	assert.Equal(t, "shown", line["msg"])
+	assert.Equal(t, "key1", line["key"])
+}
+
+func TestServe_BindFailure(t *testing.T) {
+	defer func(c, p int, h string, g bool) { capacity, port, host, useGRPC = c, p, h, g }(capacity, port, host, useGRPC)
+	capacity, port, host, useGRPC = 10, freePort(t), "127.0.0.1", true
+
+	sigCh := make(chan os.Signal, 1)
+	errCh := make(chan error, 1)
+	go func() { errCh <- serve(sigCh) }()
+	waitListening(t, fmt.Sprintf("%s:%d", host, port))
+
+	// The second server on the same port fails to bind, and serve returns instead of waiting for a signal.
+	secondErr := make(chan error, 1)
+	go func() { secondErr <- serve(make(chan os.Signal)) }()
+	select {
+	case err := <-secondErr:
+		assert.ErrorContains(t, err, "starting gRPC server")
+		assert.ErrorIs(t, err, syscall.EADDRINUSE)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the second server to report the bind failure")
+	}
+
+	sigCh <- syscall.SIGTERM
+	require.NoError(t, <-errCh)
+}
+
+func TestServe_Snapshot(t *testing.T) {
+	defer func(c, p int, h, path string, i, s time.Duration) {
+		capacity, port, host, snapshotPath, snapshotInterval, shutdownTimeout = c, p, h, path, i, s
+	}(capacity, port, host, snapshotPath, snapshotInterval, shutdownTimeout)
+	capacity, port, host = 10, freePort(t), "127.0.0.1"
+	snapshotPath = filepath.Join(t.TempDir(), "snapshot.gob")
+	snapshotInterval, shutdownTimeout = 20*time.Millisecond, time.Second
+
+	sigCh := make(chan os.Signal, 1)
+	errCh := make(chan error, 1)
+	go func() { errCh <- serve(sigCh) }()
+	addr := fmt.Sprintf("%s:%d", host, port)
+	waitListening(t, addr)
+
+	set := func(key, value string) {
+		req, err := http.NewRequest(http.MethodPut, "http://"+addr+"/cache/bkt1/"+key, strings.NewReader(value))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	set("key1", "val1")
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(snapshotPath)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond, "expected a periodic snapshot to be saved")
+
+	// The keys set after the last periodic snapshot are saved on shutdown.
+	set("key2", "val2")
+	sigCh <- syscall.SIGTERM
+	require.NoError(t, <-errCh)
+
+	mc := cache.NewMinervaCache(10, 0, cache.NewPmMetrics())
+	defer mc.Stop()
+	require.NoError(t, loadSnapshot(mc, snapshotPath))
+	values, err := mc.GetMulti("bkt1", []string{"key1", "key2"}, cache.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"key1": []byte("val1"), "key2": []byte("val2")}, values)
+}
+
+func TestParseSeed(t *testing.T) {
+	entries, err := parseSeed(strings.NewReader(`# users
+users  alice	admin
+
+users bob "hello world"
+  sessions s1 "tab\there"
+sessions s2 ""
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []seedEntry{
+		{bucket: "users", key: "alice", value: []byte("admin")},
+		{bucket: "users", key: "bob", value: []byte("hello world")},
+		{bucket: "sessions", key: "s1", value: []byte("tab\there")},
+		{bucket: "sessions", key: "s2", value: []byte("")},
+	}, entries)
+
+	for input, want := range map[string]string{
+		"users alice":                         "1: expected",
+		"# comment\nusers alice admin\nusers": "3: expected",
+		"users alice hello world":             "1: values with spaces must be quoted",
+		`users alice "hello`:                  "1: invalid quoted value",
+		`users alice "a" b`:                   "1: invalid quoted value",
+	} {
+		_, err := parseSeed(strings.NewReader(input))
+		assert.ErrorContains(t, err, want, "input %q", input)
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	for input, want := range map[string]int64{"0": 0, "60000": 60000, "2147483647": 2147483647} {
+		ttl, err := parseTTL(input)
+		require.NoError(t, err, "input %q", input)
+		assert.Equal(t, want, ttl, "input %q", input)
+	}
+
+	// Out of range, including a ttl that would overflow a time.Duration of milliseconds.
+	for _, input := range []string{"-1", "2147483648", "9223372036854775807", "1s"} {
+		_, err := parseTTL(input)
+		assert.ErrorContains(t, err, "invalid TTL", "input %q", input)
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// connServer is the accept loop and graceful stop shared by the servers of the text protocols, e.g. RESP and
+// memcached, which serve each connection with a handler reading the commands and writing their replies.
+type connServer struct {
+	// name is the protocol name used in the logs.
+	name    string
+	options options
+	// handle serves the connection until the client disconnects or the server stops, see [connServer.stopping].
+	handle func(conn net.Conn)
+
+	listener net.Listener
+	// stopping is closed when the server starts stopping, so the accept loop returns and the handlers return once
+	// their in-flight command is replied to.
+	stopping chan struct{}
+
+	// mutex locks the listener and the open connections.
+	mutex sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func newConnServer(name string, opts []Option, handle func(conn net.Conn)) *connServer {
+	return &connServer{
+		name:     name,
+		options:  newOptions(opts),
+		handle:   handle,
+		stopping: make(chan struct{}),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// Start starts the server on the given address and port.
+func (s *connServer) Start(ctx context.Context, addr string, port int) error {
+	addr = fmt.Sprintf("%s:%d", addr, port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.serve(listener)
+}
+
+// serve accepts the connections on the given listener and serves each of them in its own goroutine.
+// It blocks until the server is stopped.
+func (s *connServer) serve(listener net.Listener) error {
+	listener = newLimitListener(listener, s.options.maxConns)
+	if s.options.tlsCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.options.tlsCertFile, s.options.tlsKeyFile)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("loading the TLS certificate: %w", err)
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.stopping:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn serves the connection with the handler, tracking it so Stop can wait for it, and closes it once served.
+func (s *connServer) serveConn(conn net.Conn) {
+	s.mutex.Lock()
+	select {
+	case <-s.stopping:
+		s.mutex.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	s.mutex.Unlock()
+
+	defer func() {
+		conn.Close()
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		s.wg.Done()
+	}()
+
+	s.handle(conn)
+}
+
+// isStopping reports whether the server is stopping, so the handlers return instead of reading the next command.
+func (s *connServer) isStopping() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop gracefully stops the server. The connections are closed once their in-flight command is replied to and the
+// remaining ones are force-closed once the shutdown timeout (or the context) expires.
+func (s *connServer) Stop(ctx context.Context) error {
+	s.mutex.Lock()
+	if s.listener == nil {
+		s.mutex.Unlock()
+		return nil
+	}
+
+	close(s.stopping)
+	err := s.listener.Close()
+	s.listener = nil // Stopped, so another Stop does nothing.
+	// Unblock the connections waiting for their next command, the ones executing a command still reply to it.
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, s.options.shutdownTimeout)
+	defer cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.options.logger.Warn("Server shutdown timed out, closing the remaining connections", "server", s.name)
+		s.mutex.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mutex.Unlock()
+	}
+	return err
+}
+
+// readLine reads a line terminated by CRLF (or a bare LF, as typed in a telnet session), without the terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// maxDataLen returns the maximum size of the data block of a command, i.e. a value, the limit of the protocol or the
+// one of [WithMaxBodyBytes] if lower.
+func (s *connServer) maxDataLen(limit int) int {
+	if s.options.maxBodyBytes > 0 && s.options.maxBodyBytes < int64(limit) {
+		return int(s.options.maxBodyBytes)
+	}
+	return limit
+}
+
+// readData reads a data block of the given size followed by CRLF, as sent with the values of the RESP and memcached
+// commands. The block is buffered as it arrives rather than allocated upfront, since its size is sent by the client.
+// It reports whether the block is terminated by CRLF.
+func readData(r *bufio.Reader, size int) ([]byte, bool, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(size)+2))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) < size+2 {
+		return nil, false, io.ErrUnexpectedEOF
+	}
+	return data[:size], data[size] == '\r' && data[size+1] == '\n', nil
+}
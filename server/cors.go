@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsMaxAge is how long in seconds the browsers can cache the answer to a preflight request.
+const corsMaxAge = "600"
+
+// corsExposedHeaders are the response headers the browser clients can read, besides the CORS-safelisted ones.
+var corsExposedHeaders = []string{"ETag", "X-Cache-Expires-At", "X-Cache-TTL-Remaining", "Content-Range", "Retry-After"}
+
+// cors is a middleware that lets the browser clients of the origins allowed with [WithCORS] call the server. It
+// answers the preflight requests itself, and adds the CORS headers to the responses of the other requests from an
+// allowed origin. The requests of the other origins are rejected, and the ones without an Origin are served as is.
+func (s *httpServer) cors(next http.Handler) http.Handler {
+	if len(s.options.corsOrigins) == 0 {
+		return next
+	}
+
+	anyOrigin := slices.Contains(s.options.corsOrigins, "*")
+	methods := strings.Join(s.options.corsMethods, ", ")
+	headers := strings.Join(s.options.corsHeaders, ", ")
+	exposed := strings.Join(corsExposedHeaders, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !anyOrigin && !slices.Contains(s.options.corsOrigins, origin) {
+			SendErrorResponse(w, http.StatusForbidden, "origin not allowed")
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", exposed)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jattoabdul/minervacache/cache"
+)
+
+func TestCORS(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}, WithCORS([]string{"https://app.example.com"}), WithCORSHeaders("Content-Type")).(*httpServer).routes()
+
+	// Preflight from an allowed origin.
+	r := httptest.NewRequest(http.MethodOptions, "/cache/bkt/key", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, strings.Join(DefaultCORSMethods, ", "), w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, corsMaxAge, w.Header().Get("Access-Control-Max-Age"))
+	assert.Contains(t, w.Header().Values("Vary"), "Origin")
+
+	// Actual request from an allowed origin.
+	r = httptest.NewRequest(http.MethodPut, "/cache/bkt/key", strings.NewReader("val"))
+	r.Header.Set("Origin", "https://app.example.com")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "ETag")
+
+	// Disallowed origin, both the preflight and the actual request.
+	for _, method := range []string{http.MethodOptions, http.MethodDelete} {
+		r = httptest.NewRequest(method, "/cache/bkt/key", nil)
+		r.Header.Set("Origin", "https://evil.example.com")
+		r.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusForbidden, w.Code, method)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), method)
+	}
+	assert.Equal(t, 1, mc.Len(), "expected the disallowed delete not to be served")
+
+	// Without an Origin, the request is not from a browser and is served as is.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/key", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORS_AnyOrigin(t *testing.T) {
+	handler := NewHTTPServer(&MockCache{}, &MockMetrics{}, WithCORS([]string{"*"})).(*httpServer).routes()
+
+	r := httptest.NewRequest(http.MethodOptions, "/cache/bkt/key", nil)
+	r.Header.Set("Origin", "https://any.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://any.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORS_Disabled(t *testing.T) {
+	handler := NewHTTPServer(&MockCache{}, &MockMetrics{}).(*httpServer).routes()
+
+	r := httptest.NewRequest(http.MethodOptions, "/cache/bkt/key", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code, "expected no preflight handling by default")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -2,10 +2,17 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 
 	"github.com/jattoabdul/minervacache/cache"
 	"github.com/jattoabdul/minervacache/proto"
@@ -16,15 +23,23 @@ type grpcServer struct {
 
 	cache   cache.Cache
 	metrics cache.MetricsExporter
+	options options
 	server  *grpc.Server
+	// stopping is closed when the server starts stopping, to end the Watch streams that would otherwise keep the
+	// graceful stop waiting until the shutdown timeout.
+	stopping chan struct{}
+	// stopOnce closes stopping only once, so Stop can be called again, e.g. by a signal handler and a deferred Stop.
+	stopOnce sync.Once
 }
 
-// NewGRPCServer creates a new gRPC server with the given cache and metrics exporter.
+// NewGRPCServer creates a new gRPC server with the given cache, metrics exporter and options.
 // The server will be initialized in the Start method.
-func NewGRPCServer(cache cache.Cache, metrics cache.MetricsExporter) Server {
+func NewGRPCServer(cache cache.Cache, metrics cache.MetricsExporter, opts ...Option) Server {
 	return &grpcServer{
-		cache:   cache,
-		metrics: metrics,
+		cache:    cache,
+		metrics:  metrics,
+		options:  newOptions(opts),
+		stopping: make(chan struct{}),
 	}
 }
 
@@ -36,49 +51,333 @@ func (s *grpcServer) Start(ctx context.Context, addr string, port int) error {
 		return err
 	}
 
-	s.server = grpc.NewServer()
+	return s.serve(listener)
+}
+
+// serve initializes the gRPC server, registers the cache service and serves requests on the given listener.
+// It blocks until the server is stopped.
+func (s *grpcServer) serve(listener net.Listener) error {
+	var serverOpts []grpc.ServerOption
+	if s.options.tlsCertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(s.options.tlsCertFile, s.options.tlsKeyFile)
+		if err != nil {
+			return fmt.Errorf("loading the TLS certificate: %w", err)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+	}
+	if s.options.maxStreams > 0 {
+		serverOpts = append(serverOpts, grpc.MaxConcurrentStreams(uint32(s.options.maxStreams)))
+	}
+	if s.options.maxMessageSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxRecvMsgSize(s.options.maxMessageSize), grpc.MaxSendMsgSize(s.options.maxMessageSize))
+	}
+	if s.options.tracer != nil {
+		serverOpts = append(serverOpts, grpc.UnaryInterceptor(s.traceUnary))
+	}
+
+	s.server = grpc.NewServer(serverOpts...)
 	proto.RegisterMinervaCacheServer(s.server, s)
 
-	return s.server.Serve(listener)
+	return s.server.Serve(newLimitListener(listener, s.options.maxConns))
 }
 
-// Stop stops the gRPC server.
+// Stop gracefully stops the gRPC server, waiting for the in-flight RPCs to complete.
+// The remaining connections are force-closed once the shutdown timeout (or the context) expires.
 func (s *grpcServer) Stop(ctx context.Context) error {
 	if s.server == nil {
 		return nil
 	}
-	s.server.GracefulStop()
+
+	ctx, cancel := context.WithTimeout(ctx, s.options.shutdownTimeout)
+	defer cancel()
+
+	s.stopOnce.Do(func() { close(s.stopping) })
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.options.logger.Warn("Server shutdown timed out, closing the remaining connections", "server", "gRPC")
+		s.server.Stop()
+	}
 	return nil
 }
 
 // Get handles the gRPC Get request.
 func (s *grpcServer) Get(ctx context.Context, req *proto.GetRequest) (*proto.GetResponse, error) {
-	mcb, err := s.cache.Get(req.Bucket, req.Key, cache.Options{})
+	policy, err := cache.ParseEvictionPolicy(req.Policy)
 	if err != nil {
-		return nil, err
+		return nil, grpcStatusFromErr(err)
 	}
 
-	return &proto.GetResponse{Value: mcb}, nil
+	mcb, meta, err := s.cache.GetWithMetaCtx(ctx, req.Bucket, req.Key, cache.PolicyOptions(policy))
+	if err != nil {
+		return nil, grpcStatusFromErr(err)
+	}
+
+	resp := &proto.GetResponse{
+		Value:          mcb,
+		TtlRemainingMs: meta.TTLRemaining.Milliseconds(),
+		CreatedAtMs:    meta.CreatedAt.UnixMilli(),
+	}
+	if !meta.ExpiresAt.IsZero() {
+		resp.ExpiresAtMs = meta.ExpiresAt.UnixMilli()
+	}
+	return resp, nil
 }
 
 // Set handles the gRPC Set request.
 func (s *grpcServer) Set(ctx context.Context, req *proto.SetRequest) (*proto.SetResponse, error) {
-	// Set the value in the cache
-	err := s.cache.Set(req.Bucket, req.Key, req.Value, cache.Options{})
+	opts, err := parseOptions(req.TtlMs, req.Policy)
 	if err != nil {
 		return nil, err
 	}
 
-	// Return an empty response
-	return &proto.SetResponse{}, nil
+	// Set the value in the cache
+	result, err := s.cache.SetWithResult(ctx, req.Bucket, req.Key, req.Value, opts)
+	if err != nil {
+		return nil, grpcStatusFromErr(err)
+	}
+
+	return &proto.SetResponse{Success: true, Created: result.Created}, nil
 }
 
 // Delete handles the gRPC Delete request.
 func (s *grpcServer) Delete(ctx context.Context, req *proto.DeleteRequest) (*proto.DeleteResponse, error) {
-	err := s.cache.Delete(req.Bucket, req.Key)
+	err := s.cache.DeleteCtx(ctx, req.Bucket, req.Key)
 	if err != nil {
-		return nil, err
+		return nil, grpcStatusFromErr(err)
 	}
 
 	return &proto.DeleteResponse{}, nil
 }
+
+// Increment handles the gRPC Increment request. A negative delta decrements the value.
+func (s *grpcServer) Increment(ctx context.Context, req *proto.IncrementRequest) (*proto.IncrementResponse, error) {
+	opts, err := parseOptions(req.TtlMs, req.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := s.cache.Increment(req.Bucket, req.Key, req.Delta, opts)
+	if err != nil {
+		return nil, grpcStatusFromErr(err)
+	}
+
+	return &proto.IncrementResponse{Value: value}, nil
+}
+
+// CompareAndSwap handles the gRPC CompareAndSwap request. A value that is not equal to the old value is not an error,
+// only reported as not swapped.
+func (s *grpcServer) CompareAndSwap(ctx context.Context, req *proto.CompareAndSwapRequest) (*proto.CompareAndSwapResponse, error) {
+	opts, err := parseOptions(req.TtlMs, req.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	swapped, err := s.cache.CompareAndSwap(req.Bucket, req.Key, req.OldValue, req.NewValue, opts)
+	if err != nil {
+		return nil, grpcStatusFromErr(err)
+	}
+	return &proto.CompareAndSwapResponse{Swapped: swapped}, nil
+}
+
+// BatchGet handles the gRPC BatchGet request, getting all the keys of the bucket in a single round trip.
+// The missing and expired keys are reported as not found in their result instead of failing the batch.
+func (s *grpcServer) BatchGet(ctx context.Context, req *proto.BatchGetRequest) (*proto.BatchGetResponse, error) {
+	policy, err := cache.ParseEvictionPolicy(req.Policy)
+	if err != nil {
+		return nil, grpcStatusFromErr(err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, grpcStatusFromErr(err)
+	}
+
+	values, err := s.cache.GetMulti(req.Bucket, req.Keys, cache.PolicyOptions(policy))
+	if err != nil {
+		return nil, grpcStatusFromErr(err)
+	}
+
+	resp := &proto.BatchGetResponse{Results: make([]*proto.BatchGetResult, len(req.Keys))}
+	for i, key := range req.Keys {
+		value, found := values[key]
+		resp.Results[i] = &proto.BatchGetResult{Key: key, Found: found, Value: value}
+	}
+	return resp, nil
+}
+
+// BatchSet handles the gRPC BatchSet request, setting all the items in the bucket in a single round trip.
+// Each item is set on its own, so a failed item is reported in its result without failing the others.
+func (s *grpcServer) BatchSet(ctx context.Context, req *proto.BatchSetRequest) (*proto.BatchSetResponse, error) {
+	opts, err := parseOptions(req.TtlMs, req.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &proto.BatchSetResponse{Results: make([]*proto.BatchSetResult, len(req.Items))}
+	for i, item := range req.Items {
+		result := &proto.BatchSetResult{Key: item.Key, Success: true}
+		if err := s.cache.SetCtx(ctx, req.Bucket, item.Key, item.Value, opts); err != nil {
+			if ctx.Err() != nil {
+				return nil, grpcStatusFromErr(err) // The client is gone, or out of time.
+			}
+			result.Success, result.Error = false, err.Error()
+		}
+		resp.Results[i] = result
+	}
+	return resp, nil
+}
+
+// BatchDelete handles the gRPC BatchDelete request, removing all the keys from the bucket at once.
+// The missing keys are skipped, and only the existing ones are counted in the response.
+func (s *grpcServer) BatchDelete(ctx context.Context, req *proto.BatchDeleteRequest) (*proto.BatchDeleteResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, grpcStatusFromErr(err)
+	}
+
+	deleted, err := s.cache.DeleteMulti(req.Bucket, req.Keys)
+	if err != nil {
+		return nil, grpcStatusFromErr(err)
+	}
+	return &proto.BatchDeleteResponse{Deleted: int32(deleted)}, nil
+}
+
+// Stats handles the gRPC Stats request, returning a snapshot of the cache counters.
+func (s *grpcServer) Stats(ctx context.Context, req *proto.StatsRequest) (*proto.StatsResponse, error) {
+	stats := s.cache.Stats()
+	return &proto.StatsResponse{
+		Hits:        stats.Hits,
+		Misses:      stats.Misses,
+		Sets:        stats.Sets,
+		Deletes:     stats.Deletes,
+		Evicts:      stats.Evicts,
+		Expires:     stats.Expires,
+		Size:        int64(stats.Size),
+		BucketCount: int64(stats.BucketCount),
+		Capacity:    int64(stats.Capacity),
+		MaxBytes:    stats.MaxBytes,
+		UsedBytes:   stats.UsedBytes,
+		Utilization: stats.Utilization,
+	}, nil
+}
+
+// Watch handles the gRPC Watch request, streaming the changes of the keys in the bucket until the client cancels, the
+// server stops or the watcher falls behind, in which case an OVERFLOW event is sent last.
+func (s *grpcServer) Watch(req *proto.WatchRequest, stream proto.MinervaCache_WatchServer) error {
+	events, unwatch := s.cache.Watch(req.Bucket)
+	defer unwatch()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil // Dropped after an overflow, or the cache is stopped.
+			}
+			if err := stream.Send(&proto.Event{Type: eventType(ev.Type), Key: ev.Key, Value: ev.Value}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		case <-s.stopping:
+			return nil
+		}
+	}
+}
+
+// Scan handles the gRPC Scan request, streaming the unexpired keys of the bucket, or of all the buckets if none is
+// given, with their values and remaining TTL, sorted by bucket and key. Each bucket is exported at once, so the keys
+// set while the other buckets are streamed may be missed.
+func (s *grpcServer) Scan(req *proto.ScanRequest, stream proto.MinervaCache_ScanServer) error {
+	buckets := []string{req.Bucket}
+	if req.Bucket == "" {
+		buckets = s.cache.Buckets()
+	}
+
+	for _, bucket := range buckets {
+		entries, err := s.cache.Export(bucket)
+		if errors.Is(err, cache.ErrBucketNotFound) && req.Bucket == "" {
+			continue // Emptied since it was listed.
+		} else if err != nil {
+			return grpcStatusFromErr(err)
+		}
+
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry := entries[key]
+			resp := &proto.ScanResponse{Bucket: bucket, Key: key, Value: entry.Value, TtlRemainingMs: entry.Meta.TTLRemaining.Milliseconds()}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// eventType converts a cache event type to its proto enum.
+func eventType(t cache.EventType) proto.EventType {
+	switch t {
+	case cache.EventSet:
+		return proto.EventType_SET
+	case cache.EventDelete:
+		return proto.EventType_DELETE
+	case cache.EventExpire:
+		return proto.EventType_EXPIRE
+	case cache.EventOverflow:
+		return proto.EventType_OVERFLOW
+	default:
+		return proto.EventType_EVENT_TYPE_UNSPECIFIED
+	}
+}
+
+// parseOptions converts the ttl in milliseconds and the policy name of a request to cache options.
+// The returned error is already a gRPC status error.
+func parseOptions(ttlMs int32, policyName string) (cache.Options, error) {
+	policy, err := cache.ParseEvictionPolicy(policyName)
+	if err != nil {
+		return cache.Options{}, grpcStatusFromErr(err)
+	}
+	if ttlMs < 0 {
+		return cache.Options{}, status.Errorf(codes.InvalidArgument, "ttl cannot be negative: %dms", ttlMs)
+	}
+
+	opts := cache.PolicyOptions(policy)
+	opts.TTL = time.Duration(ttlMs) * time.Millisecond // 0 means no expiration.
+	return opts, nil
+}
+
+// grpcStatusFromErr maps the cache sentinel errors to gRPC status errors with a matching code,
+// so clients get e.g. NotFound instead of Unknown. Unexpected errors are reported as Internal.
+func grpcStatusFromErr(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, cache.ErrKeyNotFound), errors.Is(err, cache.ErrBucketNotFound), errors.Is(err, cache.ErrKeyExpired),
+		errors.Is(err, cache.ErrNegativeCached):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, cache.ErrKeyExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, cache.ErrNotInteger), errors.Is(err, cache.ErrOverflow):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, cache.ErrCacheFull), errors.Is(err, cache.ErrValueTooLarge), errors.Is(err, cache.ErrTooManyBuckets):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, cache.ErrInvalidPolicy), errors.Is(err, cache.ErrInvalidSetMode), errors.Is(err, cache.ErrInvalidTTL),
+		errors.Is(err, cache.ErrInvalidName):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, cache.ErrLoaderUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,628 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/jattoabdul/minervacache/cache"
+	"github.com/jattoabdul/minervacache/proto"
+)
+
+// noopMetrics implements cache.MetricsHandler for running a real cache in the server tests.
+type noopMetrics struct{}
+
+func (n *noopMetrics) SetSize(size int)                          {}
+func (n *noopMetrics) SetBucketCount(count int)                  {}
+func (n *noopMetrics) SetAvgBucketSize(size float64)             {}
+func (n *noopMetrics) AddHit(bucket string)                      {}
+func (n *noopMetrics) AddMiss(bucket string)                     {}
+func (n *noopMetrics) AddSet(bucket string)                      {}
+func (n *noopMetrics) AddSetExists(bucket string)                {}
+func (n *noopMetrics) AddDelete(bucket string)                   {}
+func (n *noopMetrics) AddEvict(bucket string)                    {}
+func (n *noopMetrics) AddExpire(inlineCheck bool)                {}
+func (n *noopMetrics) AddNotFound(bucket string)                 {}
+func (n *noopMetrics) ObserveLatency(op string, d time.Duration) {}
+
+// startTestGRPCServer serves the given cache over an in-memory gRPC connection and returns a client for it.
+func startTestGRPCServer(t *testing.T, c cache.Cache, opts ...Option) proto.MinervaCacheClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1024 * 1024)
+	s := NewGRPCServer(c, &MockMetrics{}, opts...).(*grpcServer)
+	go s.serve(listener)
+	t.Cleanup(func() { s.Stop(context.Background()) })
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err, "expected no error dialing the in-memory server")
+	t.Cleanup(func() { conn.Close() })
+
+	return proto.NewMinervaCacheClient(conn)
+}
+
+func TestGRPCSet_TTL(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	ctx := context.Background()
+
+	_, err := client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key1", Value: []byte("val1"), TtlMs: 50})
+	require.NoError(t, err)
+
+	resp, err := client.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key1"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("val1"), resp.Value)
+
+	time.Sleep(100 * time.Millisecond) // Wait for the TTL to expire.
+
+	_, err = client.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key1"})
+	assert.Error(t, err, "expected error after TTL expiration")
+}
+
+func TestGRPCSet_Created(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	ctx := context.Background()
+
+	resp, err := client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key1", Value: []byte("val1")})
+	require.NoError(t, err)
+	assert.True(t, resp.Created, "expected the first set to create the key")
+	assert.True(t, resp.Success)
+
+	resp, err = client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key1", Value: []byte("val2")})
+	require.NoError(t, err)
+	assert.False(t, resp.Created, "expected the second set to update the key")
+
+	require.NoError(t, mc.Delete("bkt1", "key1"))
+	resp, err = client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key1", Value: []byte("val3")})
+	require.NoError(t, err)
+	assert.True(t, resp.Created, "expected a set after a delete to create the key again")
+}
+
+func TestGRPCGet_Meta(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	ctx := context.Background()
+
+	_, err := client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key1", Value: []byte("val1"), TtlMs: 1000})
+	require.NoError(t, err)
+
+	resp, err := client.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key1"})
+	require.NoError(t, err)
+	assert.True(t, resp.TtlRemainingMs > 0 && resp.TtlRemainingMs <= 1000, "expected a positive remaining ttl, got %d", resp.TtlRemainingMs)
+	assert.Equal(t, resp.CreatedAtMs+1000, resp.ExpiresAtMs, "expected the expiration to be the creation time plus the ttl")
+}
+
+func TestGRPC_CompareAndSwap(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	ctx := context.Background()
+	mc.Set("bkt1", "key1", []byte("v1"), cache.Options{})
+
+	resp, err := client.CompareAndSwap(ctx, &proto.CompareAndSwapRequest{Bucket: "bkt1", Key: "key1", OldValue: []byte("v1"), NewValue: []byte("v2")})
+	require.NoError(t, err)
+	assert.True(t, resp.Swapped)
+
+	resp, err = client.CompareAndSwap(ctx, &proto.CompareAndSwapRequest{Bucket: "bkt1", Key: "key1", OldValue: []byte("v1"), NewValue: []byte("v3")})
+	require.NoError(t, err, "expected a stale old value not to be an error")
+	assert.False(t, resp.Swapped)
+	value, _ := mc.Get("bkt1", "key1", cache.Options{})
+	assert.Equal(t, []byte("v2"), value)
+
+	_, err = client.CompareAndSwap(ctx, &proto.CompareAndSwapRequest{Bucket: "bkt1", Key: "missing", NewValue: []byte("v1")})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPC_BatchGetSet(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{}, cache.WithMaxValueBytes(4))
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	ctx := context.Background()
+
+	setResp, err := client.BatchSet(ctx, &proto.BatchSetRequest{
+		Bucket: "bkt1",
+		Items: []*proto.KeyValue{
+			{Key: "key1", Value: []byte("val1")},
+			{Key: "large", Value: []byte("too large")},
+			{Key: "key2", Value: []byte("val2")},
+		},
+		TtlMs: 60_000,
+	})
+	require.NoError(t, err, "expected a failed item not to fail the batch")
+	require.Len(t, setResp.Results, 3)
+	assert.Equal(t, "key1", setResp.Results[0].Key)
+	assert.True(t, setResp.Results[0].Success)
+	assert.False(t, setResp.Results[1].Success)
+	assert.Equal(t, cache.ErrValueTooLarge.Error(), setResp.Results[1].Error)
+	assert.True(t, setResp.Results[2].Success)
+
+	_, meta, err := mc.GetWithMeta("bkt1", "key2", cache.Options{})
+	require.NoError(t, err)
+	assert.InDelta(t, time.Minute, meta.TTLRemaining, float64(time.Second), "expected the ttl on all the items")
+
+	getResp, err := client.BatchGet(ctx, &proto.BatchGetRequest{Bucket: "bkt1", Keys: []string{"key2", "missing", "large", "key1"}})
+	require.NoError(t, err, "expected the missing keys not to fail the batch")
+	var got []string
+	for _, r := range getResp.Results {
+		got = append(got, fmt.Sprintf("%s=%t:%s", r.Key, r.Found, r.Value))
+	}
+	assert.Equal(t, []string{"key2=true:val2", "missing=false:", "large=false:", "key1=true:val1"}, got)
+
+	getResp, err = client.BatchGet(ctx, &proto.BatchGetRequest{Bucket: "missing", Keys: []string{"key1"}})
+	require.NoError(t, err)
+	assert.False(t, getResp.Results[0].Found, "expected the keys of a missing bucket not to be found")
+
+	_, err = client.BatchGet(ctx, &proto.BatchGetRequest{Bucket: "bkt1", Keys: []string{"key1"}, Policy: "bogus"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.BatchSet(ctx, &proto.BatchSetRequest{Bucket: "bkt1", TtlMs: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPC_BatchDelete(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	mc.Set("bkt1", "key1", []byte("val1"), cache.Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), cache.Options{})
+	mc.Set("bkt2", "key1", []byte("val1"), cache.Options{})
+
+	resp, err := client.BatchDelete(context.Background(), &proto.BatchDeleteRequest{Bucket: "bkt1", Keys: []string{"key1", "missing", "key2"}})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), resp.Deleted)
+	assert.Equal(t, []string{"bkt2"}, mc.Buckets())
+
+	resp, err = client.BatchDelete(context.Background(), &proto.BatchDeleteRequest{Bucket: "missing", Keys: []string{"key1"}})
+	require.NoError(t, err)
+	assert.Zero(t, resp.Deleted)
+}
+
+func TestGRPC_Stats(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	ctx := context.Background()
+
+	for _, key := range []string{"key1", "key2", "key3"} {
+		_, err := client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: key, Value: []byte("val")})
+		require.NoError(t, err)
+	}
+	_, err := client.Set(ctx, &proto.SetRequest{Bucket: "bkt2", Key: "key1", Value: []byte("val")})
+	require.NoError(t, err)
+	_, err = client.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key1"})
+	require.NoError(t, err)
+	_, err = client.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "missing"})
+	require.Error(t, err)
+	_, err = client.Delete(ctx, &proto.DeleteRequest{Bucket: "bkt2", Key: "key1"})
+	require.NoError(t, err)
+
+	resp, err := client.Stats(ctx, &proto.StatsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), resp.Hits)
+	assert.Equal(t, uint64(1), resp.Misses)
+	assert.Equal(t, uint64(4), resp.Sets)
+	assert.Equal(t, uint64(1), resp.Deletes)
+	assert.Zero(t, resp.Evicts)
+	assert.Zero(t, resp.Expires)
+	assert.Equal(t, int64(3), resp.Size)
+	assert.Equal(t, int64(1), resp.BucketCount)
+	assert.Equal(t, int64(10), resp.Capacity)
+	assert.Equal(t, 0.3, resp.Utilization)
+}
+
+func TestGRPCSet_MRUPolicy(t *testing.T) {
+	mc := cache.NewMinervaCache(3, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	ctx := context.Background()
+
+	for _, key := range []string{"key1", "key2", "key3", "key4"} { // key4 evicts the most recently used key3.
+		_, err := client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: key, Value: []byte(key), Policy: "mru"})
+		require.NoError(t, err)
+	}
+
+	_, err := client.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key4", Policy: "mru"})
+	assert.NoError(t, err, "expected key4 to be available")
+	_, err = mc.Get("bkt1", "key3", cache.Options{})
+	assert.ErrorIs(t, err, cache.ErrKeyNotFound, "expected key3 to be evicted")
+}
+
+func TestGRPC_DefaultPolicyLRU(t *testing.T) {
+	mc := cache.NewMinervaCache(3, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	ctx := context.Background()
+
+	for _, key := range []string{"key1", "key2", "key3"} {
+		_, err := client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: key, Value: []byte("val")})
+		require.NoError(t, err)
+	}
+	// Without a policy, the get tracks key1 as recently used, so the next set evicts key2 like over HTTP.
+	_, err := client.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key1"})
+	require.NoError(t, err)
+	_, err = client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key4", Value: []byte("val")})
+	require.NoError(t, err)
+
+	keys, err := mc.Keys("bkt1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key1", "key3", "key4"}, keys)
+}
+
+func TestGRPCSet_InvalidOptions(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	ctx := context.Background()
+
+	_, err := client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key1", Value: []byte("val1"), Policy: "random"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "expected InvalidArgument for an unknown policy")
+
+	_, err = client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key1", Value: []byte("val1"), TtlMs: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "expected InvalidArgument for a negative ttl")
+}
+
+func TestGRPCStatusFromErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{"nil", nil, codes.OK},
+		{"key not found", cache.ErrKeyNotFound, codes.NotFound},
+		{"bucket not found", cache.ErrBucketNotFound, codes.NotFound},
+		{"key expired", cache.ErrKeyExpired, codes.NotFound},
+		{"key exists", cache.ErrKeyExists, codes.AlreadyExists},
+		{"not integer", cache.ErrNotInteger, codes.FailedPrecondition},
+		{"cache full", cache.ErrCacheFull, codes.ResourceExhausted},
+		{"value too large", cache.ErrValueTooLarge, codes.ResourceExhausted},
+		{"invalid policy", cache.ErrInvalidPolicy, codes.InvalidArgument},
+		{"invalid set mode", cache.ErrInvalidSetMode, codes.InvalidArgument},
+		{"wrapped invalid policy", fmt.Errorf("%w: random", cache.ErrInvalidPolicy), codes.InvalidArgument},
+		{"loader unavailable", cache.ErrLoaderUnavailable, codes.Unavailable},
+		{"canceled", context.Canceled, codes.Canceled},
+		{"deadline exceeded", context.DeadlineExceeded, codes.DeadlineExceeded},
+		{"unexpected", errors.New("boom"), codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, status.Code(grpcStatusFromErr(tt.err)))
+		})
+	}
+}
+
+func TestGRPC_CanceledContext(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	s := NewGRPCServer(mc, &MockMetrics{}).(*grpcServer)
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), cache.Options{}))
+
+	// The handlers are called directly, since a client wouldn't send a request with a canceled context.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key2", Value: []byte("val2")})
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	_, err = s.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key1"})
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	_, err = s.Delete(ctx, &proto.DeleteRequest{Bucket: "bkt1", Key: "key1"})
+	assert.Equal(t, codes.Canceled, status.Code(err))
+
+	assert.Equal(t, 1, mc.Len(), "expected the cache not to be mutated")
+}
+
+func TestGRPCScan(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	ctx := context.Background()
+
+	require.NoError(t, mc.Set("bkt2", "key1", []byte("val3"), cache.Options{}))
+	require.NoError(t, mc.Set("bkt1", "key2", []byte("val2"), cache.Options{TTL: time.Minute}))
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), cache.Options{}))
+
+	scan := func(bucket string) ([]*proto.ScanResponse, error) {
+		stream, err := client.Scan(ctx, &proto.ScanRequest{Bucket: bucket})
+		require.NoError(t, err)
+		var got []*proto.ScanResponse
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return got, nil
+			} else if err != nil {
+				return got, err
+			}
+			got = append(got, resp)
+		}
+	}
+
+	got, err := scan("")
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+	assert.Equal(t, []string{"bkt1/key1", "bkt1/key2", "bkt2/key1"}, []string{got[0].Bucket + "/" + got[0].Key, got[1].Bucket + "/" + got[1].Key, got[2].Bucket + "/" + got[2].Key},
+		"expected the keys sorted by bucket and key")
+	assert.Equal(t, []byte("val1"), got[0].Value)
+	assert.Zero(t, got[0].TtlRemainingMs)
+	assert.InDelta(t, time.Minute.Milliseconds(), got[1].TtlRemainingMs, 1000)
+
+	got, err = scan("bkt2")
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, []byte("val3"), got[0].Value)
+
+	_, err = scan("missing")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPCWatch(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &proto.WatchRequest{Bucket: "bkt1"})
+	require.NoError(t, err)
+	events := make(chan *proto.Event, 100)
+	go func() {
+		for {
+			ev, err := stream.Recv()
+			if err != nil {
+				close(events)
+				return
+			}
+			events <- ev
+		}
+	}()
+
+	// The stream is established asynchronously, so set a key until its event shows the watcher is registered.
+	require.Eventually(t, func() bool {
+		require.NoError(t, mc.Set("bkt1", "ready", []byte("ready"), cache.Options{}))
+		return len(events) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, mc.Set("bkt1", "key1", []byte("val1"), cache.Options{}))
+	require.NoError(t, mc.Set("bkt2", "key1", []byte("val1"), cache.Options{}))
+	require.NoError(t, mc.Delete("bkt1", "key1"))
+
+	var got []*proto.Event
+	for ev := range events {
+		if ev.Key == "ready" {
+			continue
+		}
+		if got = append(got, ev); len(got) == 2 {
+			break
+		}
+	}
+	require.Len(t, got, 2)
+	assert.Equal(t, proto.EventType_SET, got[0].Type)
+	assert.Equal(t, "key1", got[0].Key)
+	assert.Equal(t, []byte("val1"), got[0].Value)
+	assert.Equal(t, proto.EventType_DELETE, got[1].Type)
+	assert.Equal(t, "key1", got[1].Key)
+}
+
+// writeSelfSignedCert writes a self-signed certificate for localhost and its key to PEM files in a temp directory.
+func writeSelfSignedCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true, // Self-signed, so it is also the CA the client verifies it with.
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestGRPCServer_StopTwice(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+
+	listener := bufconn.Listen(1024 * 1024)
+	s := NewGRPCServer(mc, &MockMetrics{}).(*grpcServer)
+	go s.serve(listener)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = proto.NewMinervaCacheClient(conn).Stats(context.Background(), &proto.StatsRequest{})
+	require.NoError(t, err, "expected the server to be serving")
+
+	// E.g. once on a signal and once deferred.
+	assert.NoError(t, s.Stop(context.Background()))
+	assert.NotPanics(t, func() { s.Stop(context.Background()) }, "expected a second Stop to do nothing")
+}
+
+func TestGRPC_TLS(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+
+	listener := bufconn.Listen(1024 * 1024)
+	s := NewGRPCServer(mc, &MockMetrics{}, WithTLS(certFile, keyFile)).(*grpcServer)
+	go s.serve(listener)
+	defer s.Stop(context.Background())
+
+	dial := func(creds credentials.TransportCredentials) proto.MinervaCacheClient {
+		conn, err := grpc.NewClient("passthrough:///localhost",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(creds),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return proto.NewMinervaCacheClient(conn)
+	}
+
+	creds, err := credentials.NewClientTLSFromFile(certFile, "localhost")
+	require.NoError(t, err)
+	client := dial(creds)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key1", Value: []byte("val1")})
+	require.NoError(t, err)
+	resp, err := client.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key1"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("val1"), resp.Value)
+
+	// A plaintext client can't talk to the TLS server.
+	_, err = dial(insecure.NewCredentials()).Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key1"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestGRPC_TLSInvalidCert(t *testing.T) {
+	s := NewGRPCServer(&MockCache{}, &MockMetrics{}, WithTLS("missing.pem", "missing.key")).(*grpcServer)
+	assert.Error(t, s.serve(bufconn.Listen(1024)), "expected serving to fail without the certificate")
+}
+
+func TestGRPC_MaxConns(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := NewGRPCServer(mc, &MockMetrics{}, WithMaxConns(1), WithMaxStreams(4)).(*grpcServer)
+	go s.serve(listener)
+	defer s.Stop(context.Background())
+
+	dial := func() proto.MinervaCacheClient {
+		conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return proto.NewMinervaCacheClient(conn)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := dial()
+	_, err = client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key1", Value: []byte("val1")})
+	require.NoError(t, err, "expected the first connection to be served")
+
+	// The second connection is over the limit, so it is closed as soon as it is accepted.
+	_, err = dial().Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key1"})
+	assert.Equal(t, codes.Unavailable, status.Code(err), "expected the excess connection to be refused")
+
+	resp, err := client.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key1"})
+	require.NoError(t, err, "expected the first connection to keep working")
+	assert.Equal(t, []byte("val1"), resp.Value)
+}
+
+func TestGRPC_MaxMessageSize(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+
+	start := func(opts ...Option) proto.MinervaCacheClient {
+		listener := bufconn.Listen(1024 * 1024)
+		s := NewGRPCServer(mc, &MockMetrics{}, opts...).(*grpcServer)
+		go s.serve(listener)
+		t.Cleanup(func() { s.Stop(context.Background()) })
+
+		conn, err := grpc.NewClient("passthrough:///bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(64<<20)),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return proto.NewMinervaCacheClient(conn)
+	}
+	ctx := context.Background()
+	value := bytes.Repeat([]byte("x"), 5<<20) // Above the gRPC default of 4MB.
+
+	client := start(WithMaxMessageSize(8 << 20))
+	_, err := client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key1", Value: value})
+	require.NoError(t, err, "expected the value to fit within the configured limit")
+	resp, err := client.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key1"})
+	require.NoError(t, err, "expected the response to fit within the configured limit")
+	assert.Len(t, resp.Value, len(value))
+
+	client = start(WithMaxMessageSize(1 << 20))
+	_, err = client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key2", Value: value})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "expected the value over the limit to be rejected")
+	_, err = client.Set(ctx, &proto.SetRequest{Bucket: "bkt1", Key: "key2", Value: []byte("val2")})
+	assert.NoError(t, err, "expected the connection to keep working")
+}
+
+func TestGRPCGet_NotFound(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+
+	_, err := client.Get(context.Background(), &proto.GetRequest{Bucket: "bkt1", Key: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "expected NotFound for a missing key")
+}
+
+func TestGRPCIncrement(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	client := startTestGRPCServer(t, mc)
+	ctx := context.Background()
+
+	resp, err := client.Increment(ctx, &proto.IncrementRequest{Bucket: "bkt1", Key: "counter", Delta: 5})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), resp.Value, "expected a missing counter to be initialized to the delta")
+
+	resp, err = client.Increment(ctx, &proto.IncrementRequest{Bucket: "bkt1", Key: "counter", Delta: -2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), resp.Value, "expected a negative delta to decrement")
+
+	mc.Set("bkt1", "text", []byte("abc"), cache.Options{})
+	_, err = client.Increment(ctx, &proto.IncrementRequest{Bucket: "bkt1", Key: "text", Delta: 1})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "expected FailedPrecondition for a non-integer value")
+}
@@ -1,11 +1,22 @@
 package server
 
 import (
+	"cmp"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jattoabdul/minervacache/cache"
 )
@@ -13,118 +24,1047 @@ import (
 type httpServer struct {
 	cache   cache.Cache
 	metrics cache.MetricsExporter
+	options options
 	server  *http.Server
+	// stopping is closed when the server starts stopping, to end the event streams that would otherwise keep the
+	// graceful shutdown waiting until the shutdown timeout.
+	stopping chan struct{}
+	// stopOnce closes stopping only once, so Stop can be called again, e.g. by a signal handler and a deferred Stop.
+	stopOnce sync.Once
 }
 
-// NewHTTPServer creates a new HTTP server with the given cache and metrics exporter.
+// NewHTTPServer creates a new HTTP server with the given cache, metrics exporter and options.
 // The server will be initialized in the Start method.
-func NewHTTPServer(cache cache.Cache, metrics cache.MetricsExporter) Server {
+func NewHTTPServer(cache cache.Cache, metrics cache.MetricsExporter, opts ...Option) Server {
 	return &httpServer{
-		cache:   cache,
-		metrics: metrics,
+		cache:    cache,
+		metrics:  metrics,
+		options:  newOptions(opts),
+		stopping: make(chan struct{}),
 	}
 }
 
 // Start starts the HTTP server on the given address and port.
-// It initializes the server and registers the routes.
 func (s *httpServer) Start(ctx context.Context, addr string, port int) error {
+	addr = fmt.Sprintf("%s:%d", addr, port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.options.logger.Info("Starting HTTP server", "addr", addr)
+	return s.serve(listener)
+}
+
+// serve initializes the server, registers the routes and serves requests on the given listener.
+// It blocks until the server is stopped.
+func (s *httpServer) serve(listener net.Listener) error {
+	s.server = &http.Server{
+		Handler: s.routes(),
+	}
+	listener = newLimitListener(listener, s.options.maxConns)
+
+	if s.options.tlsCertFile != "" {
+		return s.server.ServeTLS(listener, s.options.tlsCertFile, s.options.tlsKeyFile)
+	}
+	return s.server.Serve(listener)
+}
+
+// routes registers the routes with their middlewares and returns the handler serving them.
+func (s *httpServer) routes() http.Handler {
 	mux := http.NewServeMux()
 	// Register routes with middleware
 	mux.HandleFunc("GET /health", s.handleHealth)
-	mux.HandleFunc("GET /cache/{bucket}/{key}", requireBucketAndKey(s.handleGet)) // takes ?policy=lru&ttl=60s
-	mux.HandleFunc("PUT /cache/{bucket}/{key}", requireBucketAndKey(s.handleSet))
-	mux.HandleFunc("DELETE /cache/{bucket}/{key}", requireBucketAndKey(s.handleDelete))
+	mux.HandleFunc("GET /cache/{bucket}/{key}", s.existsOnHead(s.withDefault(s.requireBucketAndKey(s.handleGet, http.StatusOK)))) // takes ?policy=lru&ttl=60s&keyenc=b64&default=dmFs
+	mux.HandleFunc("PUT /cache/{bucket}/{key}", s.ifMatch(s.requireBucketAndKey(s.handleSet, http.StatusCreated)))
+	mux.HandleFunc("PATCH /cache/{bucket}/{key}", s.handlePatch)    // takes ?ttl=60s or ?persist=true
+	mux.HandleFunc("POST /cache/{bucket}/{key}/move", s.handleMove) // takes ?to_bucket=b&to_key=k&overwrite=true
+	mux.HandleFunc("DELETE /cache/{bucket}/{key}", s.requireBucketAndKey(s.handleDelete, http.StatusNoContent))
+	mux.HandleFunc("GET /cache/{bucket}/events", s.handleEvents)       // More specific than the key route, so it wins.
+	mux.HandleFunc("POST /cache/{bucket}/delete", s.handleDeleteMulti) // takes a JSON array of keys
+	mux.HandleFunc("GET /cache/{bucket}/export", s.handleExport)
+	mux.HandleFunc("POST /cache/{bucket}/import", s.handleImport) // takes ?policy=lru
+	mux.HandleFunc("GET /cache/{bucket}", s.handleScan)           // takes ?cursor=key&limit=100
+	mux.HandleFunc("GET /buckets", s.handleBuckets)
+	mux.HandleFunc("DELETE /cache/{bucket}", s.handleClear) // takes ?prefix=user:123:
+	mux.HandleFunc("DELETE /cache", s.handleFlushAll)
 	mux.Handle("GET /stats", s.metrics.HTTPHandler())
+	mux.HandleFunc("GET /debug/stats", s.handleDebugStats)
+	mux.HandleFunc("POST /admin/resize", s.handleResize) // takes ?capacity=1000
+	mux.HandleFunc("POST /admin/gc", s.handleGC)
+	mux.HandleFunc("POST /rpc", s.handleRPC)          // takes a JSON envelope of a get, set or delete
+	mux.HandleFunc("GET /validate", s.handleValidate) // takes the options of the key routes, e.g. ?ttl=60s&policy=lfu
 
-	addr = fmt.Sprintf("%s:%d", addr, port)
-	server := &http.Server{
-		Addr:    addr,
-		Handler: mux,
-	}
-	s.server = server
-
-	log.Printf("Starting HTTP server on %s", addr)
-	return server.ListenAndServe()
+	return s.logRequests(s.cors(s.limitRate(s.compress(mux))))
 }
 
-// Stop gracefully shuts down the HTTP server.
+// Stop gracefully shuts down the HTTP server, waiting for the in-flight requests to complete.
+// The remaining connections are force-closed once the shutdown timeout (or the context) expires.
 func (s *httpServer) Stop(ctx context.Context) error {
 	if s.server == nil {
 		return nil
 	}
-	return s.server.Shutdown(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, s.options.shutdownTimeout)
+	defer cancel()
+
+	s.stopOnce.Do(func() { close(s.stopping) })
+	err := s.server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		s.options.logger.Warn("Server shutdown timed out, closing the remaining connections", "server", "HTTP")
+		return s.server.Close()
+	}
+	return err
 }
 
 // HTTP Middlewares decorator functions that wrap handlers to perform common tasks
 
+// logRequests is a middleware that logs each request to the access log, once it is served.
+func (s *httpServer) logRequests(next http.Handler) http.Handler {
+	if s.options.noAccessLog {
+		return next
+	}
+	logger := cmp.Or(s.options.accessLog, s.options.logger)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// The path values are set on the request by the mux while routing it.
+		logger.InfoContext(r.Context(), "request", "method", r.Method, "path", r.URL.Path, "bucket", r.PathValue("bucket"),
+			"key", r.PathValue("key"), "status", rec.status, "bytes", rec.bytes, "duration", time.Since(start))
+	})
+}
+
+// responseRecorder wraps a ResponseWriter to capture the status code and the size of the response for the access log.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Flush lets the streaming handlers flush through the recorder.
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped ResponseWriter for http.ResponseController.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// compress is a middleware that gzips the responses larger than the configured minimum size for the clients
+// accepting it. The smaller responses, the already encoded ones (e.g. the metrics) and the streams are sent as is.
+func (s *httpServer) compress(next http.Handler) http.Handler {
+	if s.options.gzipMinSize <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: s.options.gzipMinSize, status: http.StatusOK}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header lists gzip, without a zero quality value.
+func acceptsGzip(header string) bool {
+	for _, coding := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		quality, err := strconv.ParseFloat(q, 64)
+		return err == nil && quality > 0
+	}
+	return false
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// gzipResponseWriter buffers the start of the response until it reaches the minimum size to gzip it, or until the
+// handler returns or flushes it, in which case it is sent as is.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buf     []byte
+	// decided is set once the header is written, with gz set if the body is gzipped.
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if !gw.decided {
+		gw.status = status
+	}
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if gw.decided {
+		if gw.gz != nil {
+			return gw.gz.Write(b)
+		}
+		return gw.ResponseWriter.Write(b)
+	}
+
+	gw.buf = append(gw.buf, b...)
+	if len(gw.buf) >= gw.minSize {
+		if err := gw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide writes the header and the buffered start of the body, gzipping the body from now on if compress is set and
+// the response isn't already encoded.
+func (gw *gzipResponseWriter) decide(compress bool) error {
+	gw.decided = true
+	header := gw.Header()
+	// The byte ranges are of the identity body, so a partial response isn't gzipped.
+	if compress && header.Get("Content-Encoding") == "" && header.Get("Content-Range") == "" &&
+		!strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		gw.gz = gzipWriters.Get().(*gzip.Writer)
+		gw.gz.Reset(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(gw.status)
+
+	buf := gw.buf
+	gw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := gw.Write(buf)
+	return err
+}
+
+// Flush sends the response as is if it is not large enough to be gzipped yet, e.g. for the event streams.
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.decided {
+		gw.decide(false)
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if f, ok := gw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends the rest of the response once the handler returns.
+func (gw *gzipResponseWriter) Close() error {
+	if !gw.decided {
+		return gw.decide(false)
+	}
+	if gw.gz == nil {
+		return nil
+	}
+
+	err := gw.gz.Close()
+	gzipWriters.Put(gw.gz)
+	gw.gz = nil
+	return err
+}
+
+// Unwrap returns the wrapped ResponseWriter for http.ResponseController.
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// existsOnHead is a middleware that serves the HEAD requests, which are also matched by the GET patterns, with
+// handleExists instead of the given handler. A HEAD route can't be registered next to the more specific GET ones.
+func (s *httpServer) existsOnHead(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			s.handleExists(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ifMatch is a middleware that serves the requests with an If-Match header holding the ETag of a version of the key
+// with a set of that version, see cache.SetWithVersion, instead of the given handler. If-Match: * is handled as a
+// set-if-present by the options instead.
+func (s *httpServer) ifMatch(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tag := r.Header.Get("If-Match")
+		if tag == "" || tag == "*" {
+			next(w, r)
+			return
+		}
+
+		version, err := parseETag(tag)
+		if err != nil {
+			SendErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.requireBucketAndKey(func(ctx context.Context, header http.Header, bucket, key string, body []byte, opts cache.Options) ([]byte, error) {
+			return nil, s.cache.SetWithVersion(bucket, key, body, version, opts)
+		}, http.StatusCreated)(w, r)
+	}
+}
+
+// withDefault is a middleware that serves the requests with a ?default query param, holding a base64 value, with a
+// get of the key that sets it to the default if it is missing, see cache.GetOrDefault, instead of the given handler.
+func (s *httpServer) withDefault(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.Query().Has("default") {
+			next(w, r)
+			return
+		}
+
+		def, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("default"))
+		if err != nil {
+			SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid base64 default: %v", err))
+			return
+		}
+		s.requireBucketAndKey(func(ctx context.Context, header http.Header, bucket, key string, body []byte, opts cache.Options) ([]byte, error) {
+			return s.cache.GetOrDefault(bucket, key, def, opts)
+		}, http.StatusOK)(w, r)
+	}
+}
+
+// formatETag returns the ETag of a version of a key.
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parseETag returns the version of a key from its ETag, as set by formatETag.
+func parseETag(tag string) (uint64, error) {
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, fmt.Errorf("invalid ETag %s: expected a quoted version", tag)
+	}
+	version, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ETag %s: expected a quoted version", tag)
+	}
+	return version, nil
+}
+
+// errRangeNotSatisfiable is returned by parseRange for a range starting past the end of the value.
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseRange returns the first and last offsets (inclusive) of the bytes of a value of the given size selected by a
+// Range header: bytes=start-end, bytes=start- or bytes=-suffixLength. The end is capped to the last byte.
+// Only a single range is supported, an error other than errRangeNotSatisfiable means the header should be ignored.
+func parseRange(header string, size int) (int, int, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("unsupported range %s: expected a single range of bytes", header)
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %s", header)
+	}
+
+	if first == "" { // The last bytes.
+		n, err := strconv.Atoi(last)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid range %s", header)
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		return max(size-n, 0), size - 1, nil
+	}
+
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range %s", header)
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.Atoi(last); err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid range %s", header)
+		}
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	return start, min(end, size-1), nil
+}
+
+// sendRange responds with the bytes of the value selected by the Range header, with 206 Partial Content and a
+// Content-Range header, or 416 Range Not Satisfiable if it starts past the end of the value. The selected bytes are
+// sent raw as application/octet-stream rather than in the JSON envelope, so the body matches the Content-Range and a
+// slice cutting a UTF-8 sequence is left intact. The whole value is sent with 200 in the JSON envelope if the header
+// is not a single range of bytes.
+func sendRange(w http.ResponseWriter, header string, value []byte) {
+	start, end, err := parseRange(header, len(value))
+	switch {
+	case errors.Is(err, errRangeNotSatisfiable):
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(value)))
+		SendErrorResponse(w, http.StatusRequestedRangeNotSatisfiable, err.Error())
+	case err != nil:
+		SendJSONResponse(w, http.StatusOK, valueResponse{Value: string(value)})
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(value)))
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(value[start : end+1])
+	}
+}
+
 // kvHandler is a type for handlers that operate on key-value pairs.
-type kvHandler func(bucket, key string, body []byte, opts cache.Options) ([]byte, error)
+// The header is the response header, so handlers can surface extra details about the operation.
+type kvHandler func(ctx context.Context, header http.Header, bucket, key string, body []byte, opts cache.Options) ([]byte, error)
 
 // requireBucketAndKey is a middleware that ensures the request has valid bucket and key parameters.
-func requireBucketAndKey(handler kvHandler) http.HandlerFunc {
+// On success, it responds with the given status code and the result of the handler as JSON.
+// The handler gets the request context with the request timeout, see [WithRequestTimeout], and the body read up to
+// the limit of [WithMaxBodyBytes].
+func (s *httpServer) requireBucketAndKey(handler kvHandler, statusCode int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		bucket := r.PathValue("bucket")
-		key := r.PathValue("key")
+		key, err := pathKey(r)
+		if err != nil {
+			SendErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if bucket == "" || key == "" {
-			http.Error(w, "bucket and key are required", http.StatusBadRequest)
+			SendErrorResponse(w, http.StatusBadRequest, "bucket and key are required")
 			return
 		}
 
+		if s.options.maxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, s.options.maxBodyBytes)
+		}
 		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "failed to read request body", http.StatusBadRequest)
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			SendErrorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", maxBytesErr.Limit))
+			return
+		case err != nil:
+			SendErrorResponse(w, http.StatusBadRequest, "failed to read request body")
 			return
 		}
 
 		// Parse options like ttl and policy from the request
 		opts, err := cache.ParseOptionsFromRequest(r)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("invalid options: %v", err), http.StatusBadRequest)
+			SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid options: %v", err))
 			return
 		}
 
-		result, err := handler(bucket, key, body, opts)
+		ctx := r.Context()
+		if s.options.requestTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.options.requestTimeout)
+			defer cancel()
+		}
+
+		ctx, end := startSpan(ctx, s.options.tracer, r.Pattern, bucket, key, r.Method == http.MethodGet)
+		result, err := handler(ctx, w.Header(), bucket, key, body, opts)
+		end(err, statusFromErr(err) == http.StatusNotFound)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("operation failed: %v", err), http.StatusInternalServerError)
+			SendErrorResponse(w, statusFromErr(err), err.Error())
 			return
 		}
 
-		// TODO: handle response marshalling to json, setting content type, formatting and status codes based on the operation separately.
-		w.Write(result)
+		switch {
+		case statusCode == http.StatusNoContent:
+			w.WriteHeader(statusCode)
+		case r.Method == http.MethodGet && r.Header.Get("Range") != "":
+			sendRange(w, r.Header.Get("Range"), result)
+		case result == nil:
+			// The key as sent, still encoded with ?keyenc, since a binary key can't be a JSON string.
+			SendJSONResponse(w, statusCode, keyResponse{Bucket: bucket, Key: r.PathValue("key")})
+		default:
+			SendJSONResponse(w, statusCode, valueResponse{Value: string(result)})
+		}
+	}
+}
+
+// pathKey returns the key of the path of the request, decoded from base64url (with or without padding) with
+// ?keyenc=b64, so the keys with slashes or arbitrary bytes can be sent as a single path segment.
+func pathKey(r *http.Request) (string, error) {
+	key := r.PathValue("key")
+	switch enc := r.URL.Query().Get("keyenc"); enc {
+	case "":
+		return key, nil
+	case "b64":
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key, "="))
+		if err != nil {
+			return "", fmt.Errorf("invalid base64url key: %v", err)
+		}
+		return string(decoded), nil
+	default:
+		return "", fmt.Errorf("invalid key encoding %q, only b64 is supported", enc)
+	}
+}
+
+// statusFromErr maps the cache errors returned by the handlers to an HTTP status code.
+// Errors are matched with errors.Is, so wrapped cache errors are classified too. Unknown errors are server faults.
+func statusFromErr(err error) int {
+	switch {
+	case errors.Is(err, cache.ErrKeyExists):
+		return http.StatusConflict // Set-if-absent on an existing key.
+	case errors.Is(err, cache.ErrKeyNotFound), errors.Is(err, cache.ErrBucketNotFound), errors.Is(err, cache.ErrKeyExpired),
+		errors.Is(err, cache.ErrNegativeCached):
+		return http.StatusNotFound
+	case errors.Is(err, cache.ErrVersionMismatch):
+		return http.StatusPreconditionFailed // If-Match on another version of the key.
+	case errors.Is(err, cache.ErrCacheFull), errors.Is(err, cache.ErrTooManyBuckets):
+		return http.StatusInsufficientStorage // Full without eviction, the client should back off.
+	case errors.Is(err, cache.ErrValueTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, cache.ErrInvalidPolicy), errors.Is(err, cache.ErrInvalidSetMode), errors.Is(err, cache.ErrInvalidCapacity),
+		errors.Is(err, cache.ErrInvalidTTL), errors.Is(err, cache.ErrInvalidName):
+		return http.StatusBadRequest
+	case errors.Is(err, cache.ErrLoaderUnavailable):
+		return http.StatusServiceUnavailable // The backing store is down, see cache.WithLoaderBreaker.
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout // The request timeout, see WithRequestTimeout.
+	default:
+		return http.StatusInternalServerError
 	}
 }
 
 // HTTP Handlers for cache operations
 
 // handleGet retrieves the value associated with the given key in the bucket.
-func (s *httpServer) handleGet(bucket, key string, body []byte, opts cache.Options) ([]byte, error) {
-	return s.cache.Get(bucket, key, opts)
+// The version of the key is set as the ETag header, to be sent back in If-Match for an optimistic update.
+// For keys with a TTL, the expiration time (RFC 3339) and the remaining TTL (in milliseconds) are set as headers.
+func (s *httpServer) handleGet(ctx context.Context, header http.Header, bucket, key string, body []byte, opts cache.Options) ([]byte, error) {
+	value, meta, err := s.cache.GetWithMetaCtx(ctx, bucket, key, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	header.Set("ETag", formatETag(meta.Version))
+	if !meta.ExpiresAt.IsZero() {
+		header.Set("X-Cache-Expires-At", meta.ExpiresAt.UTC().Format(time.RFC3339Nano))
+		header.Set("X-Cache-TTL-Remaining", strconv.FormatInt(meta.TTLRemaining.Milliseconds(), 10))
+	}
+	return value, nil
+}
+
+// handleExists responds with 200 if the key is in the bucket and not expired, or 404 otherwise, without a body.
+// Unlike a GET, the key is not tracked as accessed, so it doesn't change the eviction order.
+func (s *httpServer) handleExists(w http.ResponseWriter, r *http.Request) {
+	key, err := pathKey(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	exists, err := s.cache.Exists(r.PathValue("bucket"), key)
+	switch {
+	case err != nil:
+		w.WriteHeader(statusFromErr(err))
+	case !exists:
+		w.WriteHeader(http.StatusNotFound)
+	}
 }
 
 // handleSet sets the value to the provided key in the given bucket.
-func (s *httpServer) handleSet(bucket, key string, body []byte, opts cache.Options) ([]byte, error) {
-	return nil, s.cache.Set(bucket, key, body, opts)
+func (s *httpServer) handleSet(ctx context.Context, header http.Header, bucket, key string, body []byte, opts cache.Options) ([]byte, error) {
+	return nil, s.cache.SetCtx(ctx, bucket, key, body, opts)
+}
+
+// handlePatch updates the expiration of the key without changing its value: ?ttl sets a new TTL from now, and
+// ?persist=true removes it. Like a HEAD, it doesn't count as an access for the eviction policies.
+func (s *httpServer) handlePatch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	persist, ttlParam := query.Get("persist") == "true", query.Get("ttl")
+	if persist == (ttlParam != "") {
+		SendErrorResponse(w, http.StatusBadRequest, "either ttl or persist=true is required")
+		return
+	}
+	ttl, err := cache.ParseTTL(ttlParam)
+	if err != nil {
+		SendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key, err := pathKey(r)
+	if err != nil {
+		SendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	bucket := r.PathValue("bucket")
+	if persist {
+		err = s.cache.Persist(bucket, key)
+	} else {
+		err = s.cache.Touch(bucket, key, ttl)
+	}
+	if err != nil {
+		SendErrorResponse(w, statusFromErr(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleMove moves the key to ?to_bucket and ?to_key, each defaulting to the current one. The destination key is only
+// replaced with ?overwrite=true, a conflict is returned otherwise.
+func (s *httpServer) handleMove(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	key, err := pathKey(r)
+	if err != nil {
+		SendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	bucket := r.PathValue("bucket")
+	toBucket, toKey := query.Get("to_bucket"), query.Get("to_key")
+	if toBucket == "" && toKey == "" {
+		SendErrorResponse(w, http.StatusBadRequest, "to_bucket or to_key is required")
+		return
+	}
+	if toBucket == "" {
+		toBucket = bucket
+	}
+	if toKey == "" {
+		toKey = key
+	}
+
+	if err := s.cache.Move(bucket, key, toBucket, toKey, query.Get("overwrite") == "true"); err != nil {
+		SendErrorResponse(w, statusFromErr(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // handleDelete removes the key and value from the bucket.
-func (s *httpServer) handleDelete(bucket, key string, body []byte, opts cache.Options) ([]byte, error) {
-	return nil, s.cache.Delete(bucket, key)
+func (s *httpServer) handleDelete(ctx context.Context, header http.Header, bucket, key string, body []byte, opts cache.Options) ([]byte, error) {
+	return nil, s.cache.DeleteCtx(ctx, bucket, key)
+}
+
+// handleDeleteMulti removes all the keys of the JSON array body from the bucket at once, and returns how many existed.
+func (s *httpServer) handleDeleteMulti(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+		SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid keys: %v", err))
+		return
+	}
+
+	deleted, err := s.cache.DeleteMulti(r.PathValue("bucket"), keys)
+	if err != nil {
+		SendErrorResponse(w, statusFromErr(err), err.Error())
+		return
+	}
+	SendJSONResponse(w, http.StatusOK, deleteMultiResponse{Deleted: deleted})
+}
+
+// handleRPC runs the get, set or delete of the JSON envelope of the body, see rpcRequest, and responds with the
+// outcome in an rpcResponse envelope with the status code of the matching REST route.
+func (s *httpServer) handleRPC(w http.ResponseWriter, r *http.Request) {
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendJSONResponse(w, http.StatusBadRequest, rpcResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	opts, err := req.options()
+	if err != nil {
+		SendJSONResponse(w, http.StatusBadRequest, rpcResponse{Error: err.Error()})
+		return
+	}
+
+	ctx := r.Context()
+	if s.options.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.options.requestTimeout)
+		defer cancel()
+	}
+
+	ctx, end := startSpan(ctx, s.options.tracer, r.Pattern, req.Bucket, req.Key, req.Op == "get")
+	var value []byte
+	switch req.Op {
+	case "get":
+		value, err = s.cache.GetCtx(ctx, req.Bucket, req.Key, opts)
+	case "set":
+		err = s.cache.SetCtx(ctx, req.Bucket, req.Key, req.Value, opts)
+	case "delete":
+		err = s.cache.DeleteCtx(ctx, req.Bucket, req.Key)
+	}
+	end(err, statusFromErr(err) == http.StatusNotFound)
+	if err != nil {
+		SendJSONResponse(w, statusFromErr(err), rpcResponse{Error: err.Error()})
+		return
+	}
+	SendJSONResponse(w, http.StatusOK, rpcResponse{OK: true, Value: value})
+}
+
+// handleClear removes all the keys in the bucket, or only those starting with the prefix query parameter, if any.
+func (s *httpServer) handleClear(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	if bucket == "" {
+		SendErrorResponse(w, http.StatusBadRequest, "bucket is required")
+		return
+	}
+
+	if prefix := r.URL.Query().Get("prefix"); prefix != "" {
+		deleted, err := s.cache.DeletePrefix(bucket, prefix)
+		if err != nil {
+			SendErrorResponse(w, statusFromErr(err), err.Error())
+			return
+		}
+		SendJSONResponse(w, http.StatusOK, deleteMultiResponse{Deleted: deleted})
+		return
+	}
+
+	if err := s.cache.Clear(bucket); err != nil {
+		SendErrorResponse(w, statusFromErr(err), err.Error())
+		return
+	}
+}
+
+// handleExport returns all the keys of the bucket with their values and remaining TTLs.
+func (s *httpServer) handleExport(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.cache.Export(r.PathValue("bucket"))
+	if err != nil {
+		SendErrorResponse(w, statusFromErr(err), err.Error())
+		return
+	}
+
+	resp := make(map[string]exportEntry, len(entries))
+	for key, entry := range entries {
+		// Round the TTL up, so a key about to expire isn't imported without one.
+		ttlMs := int64((entry.Meta.TTLRemaining + time.Millisecond - 1) / time.Millisecond)
+		resp[key] = exportEntry{Value: entry.Value, TTLRemainingMs: ttlMs}
+	}
+	SendJSONResponse(w, http.StatusOK, resp)
 }
 
-// handleHealth checks the health of the cache server.
+// handleImport sets all the keys of an export in the bucket, each with its own TTL. The keys are set in lexicographic
+// order like SetMulti, evicting based on the policy if the cache is full. The keys set before a failure are kept.
+func (s *httpServer) handleImport(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	var entries map[string]exportEntry
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid export: %v", err))
+		return
+	}
+	opts, err := cache.ParseOptionsFromRequest(r)
+	if err != nil {
+		SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid options: %v", err))
+		return
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		entry := entries[key]
+		if entry.TTLRemainingMs < 0 {
+			SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid ttl for key %q: %dms", key, entry.TTLRemainingMs))
+			return
+		}
+		opts.TTL = time.Duration(entry.TTLRemainingMs) * time.Millisecond // 0 means no expiration.
+		if err := s.cache.Set(bucket, key, entry.Value, opts); err != nil {
+			SendErrorResponse(w, statusFromErr(err), fmt.Sprintf("importing key %q after %d keys: %v", key, i, err))
+			return
+		}
+	}
+
+	SendJSONResponse(w, http.StatusOK, importResponse{Imported: len(keys)})
+}
+
+// handleFlushAll removes all the keys in all the buckets.
+func (s *httpServer) handleFlushAll(w http.ResponseWriter, r *http.Request) {
+	s.cache.FlushAll()
+}
+
+// handleResize changes the capacity of the cache to ?capacity, evicting the excess keys if it shrinks, and responds
+// with the new capacity and the number of keys left.
+func (s *httpServer) handleResize(w http.ResponseWriter, r *http.Request) {
+	capacity, err := strconv.Atoi(r.URL.Query().Get("capacity"))
+	if err != nil {
+		SendErrorResponse(w, http.StatusBadRequest, "capacity must be an integer")
+		return
+	}
+	if err := s.cache.Resize(capacity); err != nil {
+		SendErrorResponse(w, statusFromErr(err), err.Error())
+		return
+	}
+
+	SendJSONResponse(w, http.StatusOK, resizeResponse{Capacity: capacity, Size: s.cache.Len()})
+}
+
+// handleGC removes the expired keys now and responds with how many were removed.
+func (s *httpServer) handleGC(w http.ResponseWriter, r *http.Request) {
+	SendJSONResponse(w, http.StatusOK, gcResponse{Removed: s.cache.CollectExpired()})
+}
+
+// handleValidate parses the options of the request like the key routes, and responds with them normalized, or with
+// 400 and the error if they are invalid, without touching the cache.
+func (s *httpServer) handleValidate(w http.ResponseWriter, r *http.Request) {
+	opts, err := cache.ParseOptionsFromRequest(r)
+	if err != nil {
+		SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid options: %v", err))
+		return
+	}
+
+	SendJSONResponse(w, http.StatusOK, optionsResponse{
+		TTL:       opts.TTL.String(),
+		TTLMs:     opts.TTL.Milliseconds(),
+		TTLJitter: opts.TTLJitter.String(),
+		Policy:    opts.EvictionPolicy.String(),
+		Mode:      opts.SetMode.String(),
+	})
+}
+
+// handleHealth checks the health of the cache server. It responds with 503 once the cache is stopped, so the load
+// balancers stop routing to it.
 func (s *httpServer) handleHealth(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	health := s.cache.Health()
+	if health.Stopped {
+		SendJSONResponse(w, http.StatusServiceUnavailable, statusResponse{Status: "stopped"})
 		return
 	}
 
-	//TODO: handle response marshalling to json, setting content type, formatting and correct status code separately.
-	w.Write([]byte("OK"))
+	SendJSONResponse(w, http.StatusOK, healthResponse{
+		Status:  "ok",
+		Size:    health.Size,
+		Buckets: health.BucketCount,
+		Uptime:  time.Since(health.StartedAt).Round(time.Second).String(),
+	})
 }
 
-//TODO: SendJSONResponse is a utility function to send JSON responses.
-// This will require marshalling the data to JSON and setting the content type etc.
-// func SendJSONResponse(w http.ResponseWriter, statusCode int, data interface{}) {}
+// handleEvents streams the changes of the keys in the bucket as Server-Sent Events until the client disconnects, the
+// server stops or the watcher falls behind, in which case an overflow event is sent last.
+func (s *httpServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		SendErrorResponse(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	events, unwatch := s.cache.Watch(r.PathValue("bucket"))
+	defer unwatch()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush() // Send the headers right away, so the client knows it is subscribed.
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return // Dropped after an overflow, or the cache is stopped.
+			}
+			data, err := json.Marshal(eventResponse{Key: ev.Key, Value: string(ev.Value)})
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			return
+		}
+	}
+}
+
+// handleScan returns a page of the keys of the bucket sorted after ?cursor, of at most ?limit keys (up to
+// MaxScanLimit), and the cursor of the next page, empty on the last one.
+func (s *httpServer) handleScan(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultScanLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			SendErrorResponse(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+	limit = min(limit, MaxScanLimit)
+
+	keys, next, err := s.cache.ScanKeys(r.PathValue("bucket"), r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		SendErrorResponse(w, statusFromErr(err), err.Error())
+		return
+	}
+	SendJSONResponse(w, http.StatusOK, scanResponse{Keys: keys, NextCursor: next})
+}
+
+// handleBuckets returns the buckets with their number of unexpired keys, sorted by name.
+func (s *httpServer) handleBuckets(w http.ResponseWriter, r *http.Request) {
+	sizes := s.cache.BucketSizes()
+	resp := make([]bucketResponse, 0, len(sizes))
+	for bucket, keys := range sizes {
+		resp = append(resp, bucketResponse{Bucket: bucket, Keys: keys})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Bucket < resp[j].Bucket })
+
+	SendJSONResponse(w, http.StatusOK, resp)
+}
+
+func (s *httpServer) handleDebugStats(w http.ResponseWriter, r *http.Request) {
+	SendJSONResponse(w, http.StatusOK, s.cache.Stats())
+}
+
+// HTTP response bodies
+
+// valueResponse is the body returned for operations that read a value.
+type valueResponse struct {
+	Value string `json:"value"`
+}
+
+// eventResponse is the data of a Server-Sent Event. The value is only set for set events.
+type eventResponse struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+}
 
-// TODO: SendErrorResponse is a utility function to send error responses.
-// func SendErrorResponse(w http.ResponseWriter, statusCode int, message string) {}
+// exportEntry is a key of a bucket export. The value is base64 encoded in JSON.
+type exportEntry struct {
+	Value          []byte `json:"value"`
+	TTLRemainingMs int64  `json:"ttl_remaining_ms,omitempty"` // 0 if the key has no TTL.
+}
+
+// importResponse is the body returned for a bucket import.
+type importResponse struct {
+	Imported int `json:"imported"`
+}
+
+// deleteMultiResponse is the body returned for a batch or prefix delete.
+type deleteMultiResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// rpcRequest is the envelope of an operation on a key sent to POST /rpc.
+type rpcRequest struct {
+	Op     string `json:"op"` // get, set or delete.
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Value  []byte `json:"value"`  // Base64 encoded, for a set.
+	TTL    string `json:"ttl"`    // Milliseconds or a duration, e.g. 60s, for a set, see cache.ParseTTL. Empty for no TTL.
+	Policy string `json:"policy"` // Eviction policy, for a get or a set.
+}
+
+// options validates the request and returns the cache options of its operation.
+func (req rpcRequest) options() (cache.Options, error) {
+	switch {
+	case req.Op != "get" && req.Op != "set" && req.Op != "delete":
+		return cache.Options{}, fmt.Errorf("invalid op %q: expected get, set or delete", req.Op)
+	case req.Bucket == "" || req.Key == "":
+		return cache.Options{}, errors.New("bucket and key are required")
+	}
+
+	policy, err := cache.ParseEvictionPolicy(req.Policy)
+	if err != nil {
+		return cache.Options{}, err
+	}
+	ttl, err := cache.ParseTTL(req.TTL)
+	if err != nil {
+		return cache.Options{}, err
+	}
+	opts := cache.PolicyOptions(policy)
+	opts.TTL = ttl
+	return opts, nil
+}
+
+// rpcResponse is the envelope of the outcome of an operation sent to POST /rpc.
+type rpcResponse struct {
+	OK    bool   `json:"ok"`
+	Value []byte `json:"value,omitempty"` // Base64 encoded, for a get.
+	Error string `json:"error,omitempty"`
+}
+
+// resizeResponse is the body returned for a resize of the cache.
+type resizeResponse struct {
+	Capacity int `json:"capacity"`
+	Size     int `json:"size"`
+}
+
+// optionsResponse is the body returned for a validation of the options of a request, see handleValidate.
+type optionsResponse struct {
+	TTL       string `json:"ttl"` // As a Go duration, 0s for no expiration.
+	TTLMs     int64  `json:"ttl_ms"`
+	TTLJitter string `json:"ttl_jitter"`
+	Policy    string `json:"policy"` // The eviction policy, lru if none is given.
+	Mode      string `json:"mode"`   // always, nx or xx.
+}
+
+// gcResponse is the body returned for a sweep of the expired keys.
+type gcResponse struct {
+	Removed int `json:"removed"`
+}
+
+// scanResponse is a page of the keys of a bucket.
+type scanResponse struct {
+	Keys       []string `json:"keys"`
+	NextCursor string   `json:"next_cursor,omitempty"` // Empty on the last page.
+}
+
+// bucketResponse is an item of the buckets listing.
+type bucketResponse struct {
+	Bucket string `json:"bucket"`
+	Keys   int    `json:"keys"`
+}
+
+// keyResponse is the body returned for operations that write a key without returning its value.
+type keyResponse struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+// errorResponse is the body returned for failed operations.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// healthResponse is the body returned by the health check of a healthy cache.
+type healthResponse struct {
+	Status  string `json:"status"`
+	Size    int    `json:"size"`
+	Buckets int    `json:"buckets"`
+	Uptime  string `json:"uptime"` // e.g. 1h2m3s
+}
+
+// statusResponse is the body returned by the health check of a stopped cache.
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+// SendJSONResponse is a utility function to send JSON responses.
+// It sets the content type, writes the status code and marshals the data to JSON.
+func SendJSONResponse(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		slog.Error("Failed to encode JSON response", "err", err)
+	}
+}
+
+// SendErrorResponse is a utility function to send error responses as JSON.
+func SendErrorResponse(w http.ResponseWriter, statusCode int, message string) {
+	SendJSONResponse(w, statusCode, errorResponse{Error: message})
+}
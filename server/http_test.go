@@ -1,35 +1,249 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/jattoabdul/minervacache/cache"
 )
 
 // MockCache implements cache.Cache for testing purposes
 type MockCache struct {
-	GetFunc    func(bucket, key string, opts cache.Options) ([]byte, error)
-	SetFunc    func(bucket, key string, value []byte, opts cache.Options) error
-	DeleteFunc func(bucket, key string) error
-	StopFunc   func()
+	GetFunc            func(bucket, key string, opts cache.Options) ([]byte, error)
+	GetMetaFunc        func(bucket, key string, opts cache.Options) ([]byte, cache.ItemMeta, error)
+	GetMetaCtxFunc     func(ctx context.Context, bucket, key string, opts cache.Options) ([]byte, cache.ItemMeta, error)
+	ExistsFunc         func(bucket, key string) (bool, error)
+	SetFunc            func(bucket, key string, value []byte, opts cache.Options) error
+	GetOrSetFunc       func(bucket, key string, opts cache.Options, loader func() ([]byte, error)) ([]byte, error)
+	GetOrDefaultFunc   func(bucket, key string, def []byte, opts cache.Options) ([]byte, error)
+	SetWithResultFunc  func(ctx context.Context, bucket, key string, value []byte, opts cache.Options) (cache.SetResult, error)
+	SetNegativeFunc    func(bucket, key string, ttl time.Duration) error
+	SetMultiFunc       func(bucket string, items map[string][]byte, opts cache.Options) error
+	GetMultiFunc       func(bucket string, keys []string, opts cache.Options) (map[string][]byte, error)
+	IncrementFunc      func(bucket, key string, delta int64, opts cache.Options) (int64, error)
+	DecrementFunc      func(bucket, key string, delta int64, opts cache.Options) (int64, error)
+	PersistFunc        func(bucket, key string) error
+	TouchFunc          func(bucket, key string, ttl time.Duration) error
+	CompareAndSwapFunc func(bucket, key string, oldValue, newValue []byte, opts cache.Options) (bool, error)
+	SetWithVersionFunc func(bucket, key string, value []byte, expectedVersion uint64, opts cache.Options) error
+	MoveFunc           func(srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error
+	DeleteFunc         func(bucket, key string) error
+	DeleteMultiFunc    func(bucket string, keys []string) (int, error)
+	DeletePrefixFunc   func(bucket, prefix string) (int, error)
+	ClearFunc          func(bucket string) error
+	FlushAllFunc       func()
+	LenFunc            func() int
+	BucketLenFunc      func(bucket string) (int, error)
+	BucketSizesFunc    func() map[string]int
+	BucketsFunc        func() []string
+	ScanKeysFunc       func(bucket, cursor string, limit int) ([]string, string, error)
+	ExportFunc         func(bucket string) (map[string]cache.Entry, error)
+	StatsFunc          func() cache.Stats
+	CapacityFunc       func() int
+	ResizeFunc         func(newCapacity int) error
+	CollectExpiredFunc func() int
+	HealthFunc         func() cache.Health
+	WatchFunc          func(bucket string) (<-chan cache.Event, func())
+	StopFunc           func()
 }
 
 func (m *MockCache) Get(bucket, key string, opts cache.Options) ([]byte, error) {
 	return m.GetFunc(bucket, key, opts)
 }
 
+// GetWithMeta falls back to GetFunc with empty metadata when GetMetaFunc is not set.
+func (m *MockCache) GetWithMeta(bucket, key string, opts cache.Options) ([]byte, cache.ItemMeta, error) {
+	if m.GetMetaFunc == nil {
+		value, err := m.GetFunc(bucket, key, opts)
+		return value, cache.ItemMeta{}, err
+	}
+	return m.GetMetaFunc(bucket, key, opts)
+}
+
+func (m *MockCache) Exists(bucket, key string) (bool, error) {
+	return m.ExistsFunc(bucket, key)
+}
+
 func (m *MockCache) Set(bucket, key string, value []byte, opts cache.Options) error {
 	return m.SetFunc(bucket, key, value, opts)
 }
 
+// The context variants return the context error like MinervaCache, or fall back to the plain Func fields.
+// GetWithMetaCtx uses GetMetaCtxFunc when set, to mock a context-aware operation.
+
+func (m *MockCache) GetCtx(ctx context.Context, bucket, key string, opts cache.Options) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.Get(bucket, key, opts)
+}
+
+func (m *MockCache) GetWithMetaCtx(ctx context.Context, bucket, key string, opts cache.Options) ([]byte, cache.ItemMeta, error) {
+	if m.GetMetaCtxFunc != nil {
+		return m.GetMetaCtxFunc(ctx, bucket, key, opts)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, cache.ItemMeta{}, err
+	}
+	return m.GetWithMeta(bucket, key, opts)
+}
+
+func (m *MockCache) SetCtx(ctx context.Context, bucket, key string, value []byte, opts cache.Options) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.Set(bucket, key, value, opts)
+}
+
+func (m *MockCache) DeleteCtx(ctx context.Context, bucket, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.Delete(bucket, key)
+}
+
+func (m *MockCache) GetOrSet(bucket, key string, opts cache.Options, loader func() ([]byte, error)) ([]byte, error) {
+	return m.GetOrSetFunc(bucket, key, opts, loader)
+}
+
+func (m *MockCache) SetWithResult(ctx context.Context, bucket, key string, value []byte, opts cache.Options) (cache.SetResult, error) {
+	return m.SetWithResultFunc(ctx, bucket, key, value, opts)
+}
+
+func (m *MockCache) GetOrDefault(bucket, key string, def []byte, opts cache.Options) ([]byte, error) {
+	return m.GetOrDefaultFunc(bucket, key, def, opts)
+}
+
+func (m *MockCache) SetNegative(bucket, key string, ttl time.Duration) error {
+	return m.SetNegativeFunc(bucket, key, ttl)
+}
+
+func (m *MockCache) SetMulti(bucket string, items map[string][]byte, opts cache.Options) error {
+	return m.SetMultiFunc(bucket, items, opts)
+}
+
+func (m *MockCache) GetMulti(bucket string, keys []string, opts cache.Options) (map[string][]byte, error) {
+	return m.GetMultiFunc(bucket, keys, opts)
+}
+
+func (m *MockCache) Increment(bucket, key string, delta int64, opts cache.Options) (int64, error) {
+	return m.IncrementFunc(bucket, key, delta, opts)
+}
+
+func (m *MockCache) Decrement(bucket, key string, delta int64, opts cache.Options) (int64, error) {
+	return m.DecrementFunc(bucket, key, delta, opts)
+}
+
+func (m *MockCache) Persist(bucket, key string) error {
+	return m.PersistFunc(bucket, key)
+}
+
+func (m *MockCache) Touch(bucket, key string, ttl time.Duration) error {
+	return m.TouchFunc(bucket, key, ttl)
+}
+
+func (m *MockCache) Move(srcBucket, srcKey, dstBucket, dstKey string, overwrite bool) error {
+	return m.MoveFunc(srcBucket, srcKey, dstBucket, dstKey, overwrite)
+}
+
+func (m *MockCache) CompareAndSwap(bucket, key string, oldValue, newValue []byte, opts cache.Options) (bool, error) {
+	return m.CompareAndSwapFunc(bucket, key, oldValue, newValue, opts)
+}
+
+func (m *MockCache) SetWithVersion(bucket, key string, value []byte, expectedVersion uint64, opts cache.Options) error {
+	return m.SetWithVersionFunc(bucket, key, value, expectedVersion, opts)
+}
+
 func (m *MockCache) Delete(bucket, key string) error {
 	return m.DeleteFunc(bucket, key)
 }
 
+func (m *MockCache) DeleteMulti(bucket string, keys []string) (int, error) {
+	return m.DeleteMultiFunc(bucket, keys)
+}
+
+func (m *MockCache) DeletePrefix(bucket, prefix string) (int, error) {
+	return m.DeletePrefixFunc(bucket, prefix)
+}
+
+func (m *MockCache) Clear(bucket string) error {
+	return m.ClearFunc(bucket)
+}
+
+func (m *MockCache) FlushAll() {
+	m.FlushAllFunc()
+}
+
+func (m *MockCache) Len() int {
+	return m.LenFunc()
+}
+
+func (m *MockCache) BucketLen(bucket string) (int, error) {
+	return m.BucketLenFunc(bucket)
+}
+
+func (m *MockCache) BucketSizes() map[string]int {
+	return m.BucketSizesFunc()
+}
+
+func (m *MockCache) Buckets() []string {
+	return m.BucketsFunc()
+}
+
+func (m *MockCache) ScanKeys(bucket, cursor string, limit int) ([]string, string, error) {
+	return m.ScanKeysFunc(bucket, cursor, limit)
+}
+
+func (m *MockCache) Export(bucket string) (map[string]cache.Entry, error) {
+	return m.ExportFunc(bucket)
+}
+
+func (m *MockCache) Capacity() int {
+	return m.CapacityFunc()
+}
+
+func (m *MockCache) Resize(newCapacity int) error {
+	return m.ResizeFunc(newCapacity)
+}
+
+func (m *MockCache) CollectExpired() int {
+	return m.CollectExpiredFunc()
+}
+
+func (m *MockCache) Stats() cache.Stats {
+	return m.StatsFunc()
+}
+
+func (m *MockCache) Health() cache.Health {
+	return m.HealthFunc()
+}
+
+func (m *MockCache) Watch(bucket string) (<-chan cache.Event, func()) {
+	return m.WatchFunc(bucket)
+}
+
 func (m *MockCache) Stop() {
 	m.StopFunc()
 }
@@ -63,15 +277,1117 @@ func TestHandleGet(t *testing.T) {
 
 	// You'd need to extract the handler logic and test it directly
 	// or refactor your middleware to be more testable
-	result, err := server.handleGet("test-bucket", "test-key", nil, cache.Options{})
+	result, err := server.handleGet(context.Background(), http.Header{}, "test-bucket", "test-key", nil, cache.Options{})
 	assert.NoError(t, err)
 	assert.Equal(t, []byte("test-value"), result)
 
 	// Test key not found
-	result, err = server.handleGet("test-bucket", "non-existent", nil, cache.Options{})
+	result, err = server.handleGet(context.Background(), http.Header{}, "test-bucket", "non-existent", nil, cache.Options{})
 	assert.Error(t, err, "Expected error for non-existent key")
 
 	if !errors.Is(err, cache.ErrKeyNotFound) {
 		t.Errorf("Expected ErrKeyNotFound, got %v", err)
 	}
 }
+
+func TestHTTPResponses(t *testing.T) {
+	mockCache := &MockCache{
+		GetFunc: func(bucket, key string, opts cache.Options) ([]byte, error) {
+			switch key {
+			case "test-key":
+				return []byte("test-value"), nil
+			case "expired":
+				return nil, cache.ErrKeyExpired
+			}
+			return nil, cache.ErrKeyNotFound
+		},
+		SetFunc:    func(bucket, key string, value []byte, opts cache.Options) error { return nil },
+		DeleteFunc: func(bucket, key string) error { return nil },
+	}
+	handler := NewHTTPServer(mockCache, &MockMetrics{}).(*httpServer).routes()
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		code   int
+		body   map[string]string // nil when no body is expected.
+	}{
+		{"get", http.MethodGet, "/cache/bkt/test-key", http.StatusOK, map[string]string{"value": "test-value"}},
+		{"get missing key", http.MethodGet, "/cache/bkt/missing", http.StatusNotFound, map[string]string{"error": "key not found"}},
+		{"get expired key", http.MethodGet, "/cache/bkt/expired", http.StatusNotFound, map[string]string{"error": "key expired"}},
+		{"set", http.MethodPut, "/cache/bkt/test-key", http.StatusCreated, map[string]string{"bucket": "bkt", "key": "test-key"}},
+		{"delete", http.MethodDelete, "/cache/bkt/test-key", http.StatusNoContent, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader("value")))
+			assert.Equal(t, tt.code, w.Code)
+
+			if tt.body == nil {
+				assert.Empty(t, w.Body.String(), "expected no body")
+				return
+			}
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			var body map[string]string
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), "expected a JSON body")
+			assert.Equal(t, tt.body, body)
+		})
+	}
+}
+
+func TestHandleHealth(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+	mc.Set("bkt1", "key1", []byte("val1"), cache.Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), cache.Options{})
+	mc.Set("bkt2", "key1", []byte("val1"), cache.Options{})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok","size":3,"buckets":2,"uptime":"0s"}`, w.Body.String())
+
+	mc.Stop()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"status":"stopped"}`, w.Body.String())
+}
+
+func TestHandleExists(t *testing.T) {
+	mockCache := &MockCache{
+		ExistsFunc: func(bucket, key string) (bool, error) {
+			if bucket != "bkt1" {
+				return false, cache.ErrBucketNotFound
+			}
+			return key == "key1", nil
+		},
+		GetFunc: func(bucket, key string, opts cache.Options) ([]byte, error) {
+			t.Error("expected HEAD not to get the key")
+			return nil, nil
+		},
+	}
+	handler := NewHTTPServer(mockCache, &MockMetrics{}).(*httpServer).routes()
+
+	for path, status := range map[string]int{
+		"/cache/bkt1/key1":    http.StatusOK,
+		"/cache/bkt1/missing": http.StatusNotFound,
+		"/cache/missing/key1": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodHead, path, nil))
+		assert.Equal(t, status, w.Code, path)
+		assert.Empty(t, w.Body.String(), path)
+	}
+}
+
+func TestHandlePatch_Persist(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	mc.Set("bkt1", "key1", []byte("val1"), cache.Options{TTL: time.Minute})
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	for path, status := range map[string]int{
+		"/cache/bkt1/key1":                     http.StatusBadRequest,
+		"/cache/bkt1/key1?persist=true&ttl=1m": http.StatusBadRequest,
+		"/cache/bkt1/missing?persist=true":     http.StatusNotFound,
+		"/cache/missing/key1?persist=true":     http.StatusNotFound,
+		"/cache/bkt1/key1?persist=true":        http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, path, nil))
+		assert.Equal(t, status, w.Code, path)
+	}
+
+	_, meta, err := mc.GetWithMeta("bkt1", "key1", cache.Options{})
+	assert.NoError(t, err)
+	assert.True(t, meta.ExpiresAt.IsZero(), "expected the TTL to be removed")
+}
+
+func TestHandlePatch_TTL(t *testing.T) {
+	mc := cache.NewMinervaCache(2, 0, &noopMetrics{}, cache.WithDefaultPolicy(cache.LRUEvictionPolicy))
+	defer mc.Stop()
+	mc.Set("bkt1", "key1", []byte("val1"), cache.Options{TTL: time.Second})
+	mc.Set("bkt1", "key2", []byte("val2"), cache.Options{})
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	for path, status := range map[string]int{
+		"/cache/bkt1/key1?ttl=abc":     http.StatusBadRequest,
+		"/cache/bkt1/key1?ttl=-1s":     http.StatusBadRequest,
+		"/cache/bkt1/key1?ttl=0":       http.StatusBadRequest,
+		"/cache/bkt1/missing?ttl=1h":   http.StatusNotFound,
+		"/cache/bkt1/key1?ttl=1h":      http.StatusOK, // Extends the TTL.
+		"/cache/bkt1/key2?ttl=3600000": http.StatusOK, // Adds a TTL, in ms.
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, path, nil))
+		assert.Equal(t, status, w.Code, path)
+	}
+
+	for _, key := range []string{"key1", "key2"} {
+		value, meta, err := mc.GetWithMeta("bkt1", key, cache.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, "val"+key[3:], string(value), "expected the value to be kept")
+		assert.Equal(t, uint64(1), meta.Version, "expected the version to be kept")
+		assert.InDelta(t, time.Hour, meta.TTLRemaining, float64(time.Minute))
+	}
+
+	// The PATCH is not an access: key2, read last, is still the most recently used, so key1 is evicted.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/cache/bkt1/key1?ttl=2h", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, mc.Set("bkt1", "key3", []byte("val3"), cache.Options{}))
+	exists, err := mc.Exists("bkt1", "key1")
+	require.NoError(t, err)
+	assert.False(t, exists, "expected the patched key not to be tracked as accessed")
+}
+
+func TestHandleMove(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	mc.Set("bkt1", "key1", []byte("val1"), cache.Options{})
+	mc.Set("bkt2", "key1", []byte("val2"), cache.Options{})
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{"/cache/bkt1/key1/move", http.StatusBadRequest},
+		{"/cache/bkt1/missing/move?to_key=key2", http.StatusNotFound},
+		{"/cache/bkt1/key1/move?to_key=key2", http.StatusNoContent},
+		{"/cache/bkt1/key2/move?to_bucket=bkt2&to_key=key1", http.StatusConflict},
+		{"/cache/bkt1/key2/move?to_bucket=bkt2&to_key=key1&overwrite=true", http.StatusNoContent},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, nil))
+		assert.Equal(t, tc.status, w.Code, tc.path)
+	}
+
+	value, err := mc.Get("bkt2", "key1", cache.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), value)
+	assert.Equal(t, 1, mc.Len())
+}
+
+func TestHandleDeleteMulti(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	mc.Set("bkt1", "key1", []byte("val1"), cache.Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), cache.Options{})
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/cache/bkt1/delete", strings.NewReader(`["key1","missing","key2"]`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"deleted":2}`, w.Body.String())
+	assert.Empty(t, mc.Buckets(), "expected the emptied bucket to be deleted")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/cache/bkt1/delete", strings.NewReader(`{"key1":true}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code, "expected the keys to be a JSON array")
+}
+
+func TestHandleClear_Prefix(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	mc.Set("bkt1", "user:1:name", []byte("val"), cache.Options{})
+	mc.Set("bkt1", "user:1:email", []byte("val"), cache.Options{})
+	mc.Set("bkt1", "user:2:name", []byte("val"), cache.Options{})
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/cache/bkt1?prefix=user:1:", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"deleted":2}`, w.Body.String())
+	keys, _ := mc.Keys("bkt1")
+	assert.Equal(t, []string{"user:2:name"}, keys)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/cache/missing?prefix=user:", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleResize(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{}, cache.WithDefaultPolicy(cache.OldestEvictionPolicy))
+	defer mc.Stop()
+	mc.Set("bkt1", "key1", []byte("val1"), cache.Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), cache.Options{})
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/resize?capacity=1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"capacity":1,"size":1}`, w.Body.String())
+	_, err := mc.Get("bkt1", "key1", cache.Options{})
+	assert.ErrorIs(t, err, cache.ErrKeyNotFound, "expected the oldest key to be evicted")
+
+	for _, capacity := range []string{"0", "-5", "many", ""} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/resize?capacity="+capacity, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, "expected capacity %q to be rejected", capacity)
+	}
+	assert.Equal(t, 1, mc.Capacity())
+}
+
+func TestHandleGC(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{}) // No background TTL check.
+	defer mc.Stop()
+	mc.Set("bkt1", "key1", []byte("val1"), cache.Options{TTL: time.Millisecond})
+	mc.Set("bkt1", "key2", []byte("val2"), cache.Options{TTL: time.Millisecond})
+	mc.Set("bkt1", "key3", []byte("val3"), cache.Options{})
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+	time.Sleep(5 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/gc", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"removed":2}`, w.Body.String())
+	assert.Equal(t, 1, mc.Len(), "expected the expired keys to be removed")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/gc", nil))
+	assert.JSONEq(t, `{"removed":0}`, w.Body.String())
+}
+
+func TestHandleValidate(t *testing.T) {
+	mockCache := &MockCache{} // Any call to the cache panics.
+	handler := NewHTTPServer(mockCache, &MockMetrics{}).(*httpServer).routes()
+
+	tests := []struct {
+		name   string
+		target string
+		header string
+		code   int
+		want   string
+	}{
+		{"defaults", "/validate", "", http.StatusOK,
+			`{"ttl":"0s","ttl_ms":0,"ttl_jitter":"0s","policy":"lru","mode":"always"}`},
+		{"valid", "/validate?ttl=1m30s&jitter=5000&policy=lfu&mode=nx", "", http.StatusOK,
+			`{"ttl":"1m30s","ttl_ms":90000,"ttl_jitter":"5s","policy":"lfu","mode":"nx"}`},
+		{"millisecond ttl", "/validate?ttl=1500&policy=none", "", http.StatusOK,
+			`{"ttl":"1.5s","ttl_ms":1500,"ttl_jitter":"0s","policy":"none","mode":"always"}`},
+		{"header mode", "/validate", "*", http.StatusOK,
+			`{"ttl":"0s","ttl_ms":0,"ttl_jitter":"0s","policy":"lru","mode":"xx"}`},
+		{"invalid ttl", "/validate?ttl=soon", "", http.StatusBadRequest, ""},
+		{"negative ttl", "/validate?ttl=-5s", "", http.StatusBadRequest, ""},
+		{"invalid jitter", "/validate?jitter=abc", "", http.StatusBadRequest, ""},
+		{"invalid policy", "/validate?policy=random", "", http.StatusBadRequest, ""},
+		{"invalid mode", "/validate?mode=sometimes", "", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("If-Match", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.want != "" {
+				assert.JSONEq(t, tt.want, w.Body.String())
+			} else {
+				assert.Contains(t, w.Body.String(), "invalid options")
+			}
+		})
+	}
+}
+
+func TestHandleRPC(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	tests := []struct {
+		name string
+		body string
+		code int
+		want string
+	}{
+		{"set", `{"op":"set","bucket":"bkt1","key":"key1","value":"dmFsMQ==","ttl":"1m"}`, http.StatusOK, `{"ok":true}`},
+		{"get", `{"op":"get","bucket":"bkt1","key":"key1","policy":"lru"}`, http.StatusOK, `{"ok":true,"value":"dmFsMQ=="}`},
+		{"delete", `{"op":"delete","bucket":"bkt1","key":"key1"}`, http.StatusOK, `{"ok":true}`},
+		{"get missing", `{"op":"get","bucket":"bkt1","key":"key1"}`, http.StatusNotFound, `{"ok":false,"error":"bucket not found"}`},
+		{"missing bucket", `{"op":"get","key":"key1"}`, http.StatusBadRequest, `{"ok":false,"error":"bucket and key are required"}`},
+		{"invalid op", `{"op":"put","bucket":"bkt1","key":"key1"}`, http.StatusBadRequest, `{"ok":false,"error":"invalid op \"put\": expected get, set or delete"}`},
+		{"invalid policy", `{"op":"get","bucket":"bkt1","key":"key1","policy":"fifo"}`, http.StatusBadRequest, ""},
+		{"negative ttl", `{"op":"set","bucket":"bkt1","key":"key1","ttl":"-1s"}`, http.StatusBadRequest, ""},
+		{"invalid value", `{"op":"set","bucket":"bkt1","key":"key1","value":"not base64"}`, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.code, w.Code)
+			if tt.want != "" {
+				assert.JSONEq(t, tt.want, w.Body.String())
+			} else {
+				assert.Contains(t, w.Body.String(), `"ok":false`)
+			}
+		})
+	}
+}
+
+func TestHandleSet_NoEvictionPolicy(t *testing.T) {
+	mc := cache.NewMinervaCache(2, 0, &noopMetrics{}, cache.WithDefaultPolicy(cache.LRUEvictionPolicy))
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	put := func(target string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, target, strings.NewReader("value")))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusCreated, put("/cache/bkt1/key1?policy=none"))
+	assert.Equal(t, http.StatusCreated, put("/cache/bkt1/key2?policy=none"))
+	assert.Equal(t, http.StatusInsufficientStorage, put("/cache/bkt1/key3?policy=none"), "expected a new key of a full cache to be rejected")
+	assert.Equal(t, http.StatusCreated, put("/cache/bkt1/key1?policy=none"), "expected an existing key to be updated")
+	keys, _ := mc.Keys("bkt1")
+	assert.ElementsMatch(t, []string{"key1", "key2"}, keys)
+
+	assert.Equal(t, http.StatusCreated, put("/cache/bkt1/key3"), "expected the cache default policy to evict")
+	assert.Equal(t, 2, mc.Len())
+}
+
+// countingReader counts the bytes read from an endless stream of "x".
+type countingReader struct {
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	r.n += len(p)
+	return len(p), nil
+}
+
+func TestHandleSet_MaxBodyBytes(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}, WithMaxBodyBytes(1024)).(*httpServer).routes()
+
+	body := &countingReader{}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/cache/bkt1/key1", body))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.LessOrEqual(t, body.n, 64*1024, "expected the body to be read no further than the limit")
+	assert.Zero(t, mc.Len())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/cache/bkt1/key1", strings.NewReader(strings.Repeat("x", 1024))))
+	assert.Equal(t, http.StatusCreated, w.Code, "expected a body at the limit to be accepted")
+}
+
+func TestHandleGet_MetaHeaders(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+	mc.Set("bkt", "ttl", []byte("value"), cache.Options{TTL: time.Second})
+	mc.Set("bkt", "no-ttl", []byte("value"), cache.Options{})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/ttl", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err := time.Parse(time.RFC3339Nano, w.Header().Get("X-Cache-Expires-At"))
+	assert.NoError(t, err, "expected an RFC 3339 expiration header")
+	remaining, err := strconv.Atoi(w.Header().Get("X-Cache-TTL-Remaining"))
+	assert.NoError(t, err)
+	assert.True(t, remaining > 0 && remaining <= 1000, "expected a remaining TTL in ms, got %d", remaining)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/no-ttl", nil))
+	assert.Empty(t, w.Header().Get("X-Cache-Expires-At"), "expected no expiration header without a TTL")
+}
+
+func TestHandleGet_EmptyValue(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/cache/bkt/key", nil))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/key", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"value":""}`, w.Body.String(), "expected an empty value, not a miss")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleGet_Range(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+	mc.Set("bkt", "key", []byte("0123456789"), cache.Options{})
+	mc.Set("bkt", "utf8", []byte("héllo"), cache.Options{})
+
+	tests := []struct {
+		name         string
+		key          string
+		rangeHeader  string
+		code         int
+		value        string
+		contentRange string
+	}{
+		{"full get", "key", "", http.StatusOK, "0123456789", ""},
+		{"range", "key", "bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"open-ended range", "key", "bytes=7-", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"suffix range", "key", "bytes=-3", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"end capped", "key", "bytes=8-100", http.StatusPartialContent, "89", "bytes 8-9/10"},
+		{"out of bounds", "key", "bytes=10-20", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"multiple ranges ignored", "key", "bytes=0-1,4-5", http.StatusOK, "0123456789", ""},
+		{"invalid range ignored", "key", "bytes=5-2", http.StatusOK, "0123456789", ""},
+		{"utf-8 sequence cut", "utf8", "bytes=0-1", http.StatusPartialContent, "h\xc3", "bytes 0-1/6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/cache/bkt/"+tt.key, nil)
+			if tt.rangeHeader != "" {
+				r.Header.Set("Range", tt.rangeHeader)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, tt.contentRange, w.Header().Get("Content-Range"))
+			switch tt.code {
+			case http.StatusPartialContent:
+				// The raw bytes of the range, so the body length matches the Content-Range.
+				assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+				assert.Equal(t, tt.value, w.Body.String())
+			case http.StatusOK:
+				var resp valueResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				assert.Equal(t, tt.value, resp.Value)
+			}
+		})
+	}
+
+	// A large range isn't gzipped, since its offsets are of the identity body.
+	mc.Set("bkt", "large", bytes.Repeat([]byte("a"), 4096), cache.Options{})
+	r := httptest.NewRequest(http.MethodGet, "/cache/bkt/large", nil)
+	r.Header.Set("Range", "bytes=0-2047")
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, 2048, w.Body.Len())
+}
+
+func TestHandleGet_Default(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+	def := url.QueryEscape(base64.StdEncoding.EncodeToString([]byte("def/+")))
+
+	// Miss: the default is stored and returned.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/key1?ttl=60s&default="+def, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"value":"def/+"}`, w.Body.String())
+	value, meta, err := mc.GetWithMeta("bkt", "key1", cache.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("def/+"), value, "expected the default to be stored")
+	assert.False(t, meta.ExpiresAt.IsZero(), "expected the ttl to apply")
+
+	// Hit: the default is ignored.
+	mc.Set("bkt", "key2", []byte("val2"), cache.Options{})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/key2?default="+def, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"value":"val2"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/key3?default=not*base64", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 2, mc.Len())
+}
+
+func TestHandleKeyEncoding(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	key := "user/42\x00\xff?#"
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(key))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/cache/bkt/"+encoded+"?keyenc=b64", strings.NewReader("val")))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"bucket":"bkt","key":"`+encoded+`"}`, w.Body.String(), "expected the key as sent")
+
+	value, err := mc.Get("bkt", key, cache.Options{})
+	require.NoError(t, err, "expected the decoded key to be set")
+	assert.Equal(t, []byte("val"), value)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/"+encoded+"?keyenc=b64", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"value":"val"}`, w.Body.String())
+
+	// Padded too.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/cache/bkt/"+base64.URLEncoding.EncodeToString([]byte(key))+"?keyenc=b64", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/"+encoded, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "expected the key not to be decoded without keyenc")
+
+	for _, target := range []string{"/cache/bkt/not*base64?keyenc=b64", "/cache/bkt/a2V5?keyenc=hex"} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, target)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/cache/bkt/"+encoded+"?keyenc=b64", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Zero(t, mc.Len())
+}
+
+func TestHandleSet_IfMatch(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+	mc.Set("bkt", "key", []byte("v1"), cache.Options{})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/key", nil))
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, `"1"`, etag)
+
+	put := func(key, ifMatch string) int {
+		r := httptest.NewRequest(http.MethodPut, "/cache/bkt/"+key, strings.NewReader("v2"))
+		r.Header.Set("If-Match", ifMatch)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusCreated, put("key", etag))
+	assert.Equal(t, http.StatusPreconditionFailed, put("key", etag), "expected the stale ETag to be rejected")
+	assert.Equal(t, http.StatusPreconditionFailed, put("missing", `"1"`))
+	assert.Equal(t, http.StatusBadRequest, put("key", "1"), "expected an unquoted ETag to be rejected")
+	assert.Equal(t, http.StatusNotFound, put("missing", "*"), "expected If-Match: * to require the key")
+	assert.Equal(t, http.StatusCreated, put("key", "*"))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/key", nil))
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+}
+
+func TestStatusFromErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"key not found", cache.ErrKeyNotFound, http.StatusNotFound},
+		{"bucket not found", cache.ErrBucketNotFound, http.StatusNotFound},
+		{"key expired", cache.ErrKeyExpired, http.StatusNotFound},
+		{"key exists", cache.ErrKeyExists, http.StatusConflict},
+		{"cache full", cache.ErrCacheFull, http.StatusInsufficientStorage},
+		{"value too large", cache.ErrValueTooLarge, http.StatusRequestEntityTooLarge},
+		{"version mismatch", cache.ErrVersionMismatch, http.StatusPreconditionFailed},
+		{"invalid capacity", cache.ErrInvalidCapacity, http.StatusBadRequest},
+		{"negative cached", cache.ErrNegativeCached, http.StatusNotFound},
+		{"invalid policy", cache.ErrInvalidPolicy, http.StatusBadRequest},
+		{"invalid set mode", cache.ErrInvalidSetMode, http.StatusBadRequest},
+		{"invalid name", cache.ErrInvalidName, http.StatusBadRequest},
+		{"too many buckets", cache.ErrTooManyBuckets, http.StatusInsufficientStorage},
+		{"loader unavailable", cache.ErrLoaderUnavailable, http.StatusServiceUnavailable},
+		{"wrapped", fmt.Errorf("get failed: %w", cache.ErrKeyNotFound), http.StatusNotFound},
+		{"unexpected", errors.New("boom"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, statusFromErr(tt.err))
+		})
+	}
+}
+
+func TestRequireBucketAndKey_NotFound(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "expected a missing key to be a 404 rather than a 500")
+}
+
+func TestHandleEvents(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	var watchers atomic.Int32
+	mockCache := &MockCache{
+		WatchFunc: func(bucket string) (<-chan cache.Event, func()) {
+			watchers.Add(1)
+			events, unwatch := mc.Watch(bucket)
+			return events, func() {
+				unwatch()
+				watchers.Add(-1)
+			}
+		},
+	}
+	ts := httptest.NewServer(NewHTTPServer(mockCache, &MockMetrics{}).(*httpServer).routes())
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/cache/bkt/events", nil)
+	assert.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, int32(1), watchers.Load(), "expected the headers to be sent once subscribed")
+
+	assert.NoError(t, mc.Set("bkt", "key", []byte("value"), cache.Options{}))
+	assert.NoError(t, mc.Delete("bkt", "key"))
+
+	reader := bufio.NewReader(resp.Body)
+	var frames string
+	for strings.Count(frames, "\n\n") < 2 {
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		frames += line
+	}
+	assert.Equal(t, "event: set\ndata: {\"key\":\"key\",\"value\":\"value\"}\n\nevent: delete\ndata: {\"key\":\"key\"}\n\n", frames)
+
+	// Disconnecting unsubscribes the handler.
+	cancel()
+	assert.Eventually(t, func() bool { return watchers.Load() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestHTTPServer_TLS(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+	mockCache := &MockCache{
+		GetFunc: func(bucket, key string, opts cache.Options) ([]byte, error) {
+			return []byte("secret"), nil
+		},
+	}
+	s := NewHTTPServer(mockCache, &MockMetrics{}, WithTLS(certFile, keyFile)).(*httpServer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go s.serve(listener)
+	defer s.Stop(context.Background())
+
+	// Trust the self-signed certificate as the CA.
+	pemCert, err := os.ReadFile(certFile)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM(pemCert))
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	resp, err := client.Get("https://localhost:" + port + "/cache/bkt/key")
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"value":"secret"}`, string(body))
+	}
+
+	// Plain HTTP is not served.
+	resp, err = http.Get("http://localhost:" + port + "/cache/bkt/key")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "expected the TLS server to reject a plain HTTP request")
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	mockCache := &MockCache{
+		GetFunc: func(bucket, key string, opts cache.Options) ([]byte, error) {
+			if key == "missing" {
+				return nil, cache.ErrKeyNotFound
+			}
+			return []byte("value"), nil
+		},
+	}
+	var buf bytes.Buffer
+	handler := NewHTTPServer(mockCache, &MockMetrics{}, WithAccessLog(slog.New(slog.NewTextHandler(&buf, nil)))).(*httpServer).routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/key", nil))
+	line := buf.String()
+	assert.Contains(t, line, "level=INFO msg=request method=GET")
+	assert.Contains(t, line, "path=/cache/bkt/key")
+	assert.Contains(t, line, "bucket=bkt key=key")
+	assert.Contains(t, line, "status=200")
+	assert.Contains(t, line, fmt.Sprintf("bytes=%d", w.Body.Len()))
+	assert.Contains(t, line, "duration=")
+
+	buf.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cache/bkt/missing", nil))
+	assert.Contains(t, buf.String(), "status=404")
+
+	// The server logger by default, e.g. as JSON.
+	buf.Reset()
+	handler = NewHTTPServer(mockCache, &MockMetrics{}, WithLogger(slog.New(slog.NewJSONHandler(&buf, nil)))).(*httpServer).routes()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cache/bkt/key", nil))
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "request", entry["msg"])
+	assert.Equal(t, "bkt", entry["bucket"])
+	assert.EqualValues(t, 200, entry["status"])
+
+	// Disabled with a nil logger.
+	handler = NewHTTPServer(mockCache, &MockMetrics{}, WithAccessLog(nil)).(*httpServer).routes()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/key", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHandleExportImport(t *testing.T) {
+	src := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer src.Stop()
+	assert.NoError(t, src.Set("bkt", "key1", []byte("val1"), cache.Options{}))
+	assert.NoError(t, src.Set("bkt", "key2", []byte{0xff, 0x00}, cache.Options{TTL: time.Minute}))
+	assert.NoError(t, src.Set("other", "key3", []byte("val3"), cache.Options{}))
+
+	w := httptest.NewRecorder()
+	NewHTTPServer(src, &MockMetrics{}).(*httpServer).routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/export", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	var export map[string]map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(body), &export))
+	assert.Len(t, export, 2, "expected only the keys of the bucket")
+	assert.Equal(t, "dmFsMQ==", export["key1"]["value"], "expected the values to be base64 encoded")
+	assert.NotContains(t, export["key1"], "ttl_remaining_ms", "expected no ttl for a key without one")
+
+	dst := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer dst.Stop()
+	w = httptest.NewRecorder()
+	NewHTTPServer(dst, &MockMetrics{}).(*httpServer).routes().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/cache/bkt/import", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"imported":2}`, w.Body.String())
+
+	value, meta, err := dst.GetWithMeta("bkt", "key1", cache.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), value)
+	assert.True(t, meta.ExpiresAt.IsZero())
+	value, meta, err = dst.GetWithMeta("bkt", "key2", cache.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0x00}, value)
+	assert.InDelta(t, time.Minute, meta.TTLRemaining, float64(time.Second), "expected the ttl to round-trip")
+}
+
+func TestHandleImport_Invalid(t *testing.T) {
+	handler := NewHTTPServer(&MockCache{}, &MockMetrics{}).(*httpServer).routes()
+
+	for _, body := range []string{`not json`, `{"key":{"value":"dmFs","ttl_remaining_ms":-1}}`} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/cache/bkt/import", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, "expected %s to be rejected", body)
+	}
+
+	w := httptest.NewRecorder()
+	handler = NewHTTPServer(&MockCache{
+		ExportFunc: func(bucket string) (map[string]cache.Entry, error) { return nil, cache.ErrBucketNotFound },
+	}, &MockMetrics{}).(*httpServer).routes()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/missing/export", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleDebugStats(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	assert.NoError(t, mc.Set("bkt", "key", []byte("value"), cache.Options{}))
+	_, _ = mc.Get("bkt", "key", cache.Options{})
+	_, _ = mc.Get("bkt", "missing", cache.Options{})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/stats", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"hits":1,"misses":1,"sets":1,"deletes":0,"evicts":0,"expires":0,"size":1,"bucket_count":1,"capacity":10,"utilization":0.1}`, w.Body.String())
+}
+
+func TestHandleBuckets(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/buckets", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	mc.Set("bkt2", "key1", []byte("val1"), cache.Options{})
+	mc.Set("bkt1", "key1", []byte("val1"), cache.Options{})
+	mc.Set("bkt1", "key2", []byte("val2"), cache.Options{})
+	mc.Set("bkt1", "key3", []byte("val3"), cache.Options{TTL: time.Millisecond})
+	time.Sleep(5 * time.Millisecond) // key3 is expired but not collected yet.
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/buckets", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `[{"bucket":"bkt1","keys":2},{"bucket":"bkt2","keys":1}]`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestHandleScan(t *testing.T) {
+	mc := cache.NewMinervaCache(100, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}).(*httpServer).routes()
+	for i := 0; i < 25; i++ {
+		mc.Set("bkt", fmt.Sprintf("key%02d", i), []byte("value"), cache.Options{})
+	}
+
+	var keys []string
+	cursor := ""
+	for page := 1; ; page++ {
+		require.LessOrEqual(t, page, 3, "expected 3 pages")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt?limit=10&cursor="+url.QueryEscape(cursor), nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp scanResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+		keys = append(keys, resp.Keys...)
+		if resp.NextCursor == "" {
+			assert.Len(t, resp.Keys, 5, "expected the last page to hold the remaining keys")
+			break
+		}
+		assert.Len(t, resp.Keys, 10)
+		cursor = resp.NextCursor
+	}
+
+	want, err := mc.Keys("bkt")
+	require.NoError(t, err)
+	assert.Equal(t, want, keys, "expected all the keys in order, without duplicates or gaps")
+
+	for path, status := range map[string]int{
+		"/cache/missing":         http.StatusNotFound,
+		"/cache/bkt?limit=0":     http.StatusBadRequest,
+		"/cache/bkt?limit=abc":   http.StatusBadRequest,
+		"/cache/bkt?limit=10000": http.StatusOK, // Capped to the maximum.
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, status, w.Code, path)
+	}
+}
+
+func TestHTTPServer_RequestTimeout(t *testing.T) {
+	mockCache := &MockCache{
+		GetMetaCtxFunc: func(ctx context.Context, bucket, key string, opts cache.Options) ([]byte, cache.ItemMeta, error) {
+			<-ctx.Done() // Block until the request times out.
+			return nil, cache.ItemMeta{}, ctx.Err()
+		},
+	}
+	handler := NewHTTPServer(mockCache, &MockMetrics{}, WithRequestTimeout(50*time.Millisecond)).(*httpServer).routes()
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/bkt/key", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Less(t, time.Since(start), time.Second, "expected the request to time out")
+}
+
+func TestHTTPServer_StopTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	mockCache := &MockCache{
+		GetFunc: func(bucket, key string, opts cache.Options) ([]byte, error) {
+			close(started)
+			<-release // Hang until the end of the test.
+			return []byte("slow"), nil
+		},
+	}
+	s := NewHTTPServer(mockCache, &MockMetrics{}, WithShutdownTimeout(100*time.Millisecond)).(*httpServer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- s.serve(listener) }()
+
+	requested := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/cache/bkt/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+		requested <- err
+	}()
+	<-started
+
+	begin := time.Now()
+	assert.NoError(t, s.Stop(context.Background()), "expected the remaining connections to be closed")
+	elapsed := time.Since(begin)
+	assert.GreaterOrEqual(t, elapsed, 100*time.Millisecond, "expected the in-flight request to be waited for")
+	assert.Less(t, elapsed, time.Second, "expected the shutdown to complete within the timeout window")
+
+	assert.ErrorIs(t, <-served, http.ErrServerClosed)
+	assert.Error(t, <-requested, "expected the hung request to be cut off")
+
+	assert.NotPanics(t, func() { s.Stop(context.Background()) }, "expected a second Stop to do nothing")
+}
+
+func TestCompress(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	large := `{"items": [` + strings.Repeat(`{"id": 1, "name": "item"},`, 100) + `{}]}`
+	mc.Set("bkt1", "large", []byte(large), cache.Options{})
+	mc.Set("bkt1", "small", []byte(`{"id": 1}`), cache.Options{})
+
+	metrics := cache.NewPmMetrics()
+	handler := NewHTTPServer(mc, metrics, WithAccessLog(nil)).(*httpServer).routes()
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	decodeValue := func(body io.Reader) string {
+		var resp valueResponse
+		assert.NoError(t, json.NewDecoder(body).Decode(&resp))
+		return resp.Value
+	}
+
+	w := get("/cache/bkt1/large", "deflate, gzip")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"), "expected the large value to be gzipped")
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Less(t, w.Body.Len(), len(large))
+	gz, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, large, decodeValue(gz))
+
+	for _, acceptEncoding := range []string{"", "deflate", "gzip;q=0"} {
+		w = get("/cache/bkt1/large", acceptEncoding)
+		assert.Empty(t, w.Header().Get("Content-Encoding"), "expected no gzip for %q", acceptEncoding)
+		assert.Equal(t, large, decodeValue(w.Body))
+	}
+
+	// The responses under the minimum size are sent as is.
+	w = get("/cache/bkt1/small", "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, `{"id": 1}`, decodeValue(w.Body))
+
+	w = get("/health", "gzip")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Contains(t, w.Body.String(), `"status":"ok"`)
+
+	// The metrics are gzipped by their own handler, and not a second time.
+	metrics.AddHit("bkt1")
+	w = get("/stats", "gzip")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	gz, err = gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	stats, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Contains(t, string(stats), "cache_hit 1")
+
+	// The compression can be disabled.
+	handler = NewHTTPServer(mc, metrics, WithAccessLog(nil), WithGzipMinSize(0)).(*httpServer).routes()
+	w = get("/cache/bkt1/large", "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, large, decodeValue(w.Body))
+}
+
+func TestHandleClear(t *testing.T) {
+	var cleared string
+	mockCache := &MockCache{
+		ClearFunc: func(bucket string) error {
+			if bucket != "test-bucket" {
+				return cache.ErrBucketNotFound
+			}
+			cleared = bucket
+			return nil
+		},
+	}
+	handler := NewHTTPServer(mockCache, &MockMetrics{}).(*httpServer).routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/cache/test-bucket", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "test-bucket", cleared, "expected the bucket to be cleared")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/cache/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "expected an error for a missing bucket")
+}
+
+func TestHandleFlushAll(t *testing.T) {
+	flushed := false
+	mockCache := &MockCache{
+		FlushAllFunc: func() { flushed = true },
+	}
+	handler := NewHTTPServer(mockCache, &MockMetrics{}).(*httpServer).routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/cache", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, flushed, "expected the cache to be flushed")
+}
+
+func TestHandleSet_Modes(t *testing.T) {
+	stored := map[string][]byte{"existing": []byte("value")}
+	mockCache := &MockCache{
+		SetFunc: func(bucket, key string, value []byte, opts cache.Options) error {
+			_, exists := stored[key]
+			switch {
+			case exists && opts.SetMode == cache.SetIfAbsent:
+				return cache.ErrKeyExists
+			case !exists && opts.SetMode == cache.SetIfPresent:
+				return cache.ErrKeyNotFound
+			}
+			stored[key] = value
+			return nil
+		},
+	}
+	handler := NewHTTPServer(mockCache, &MockMetrics{}).(*httpServer).routes()
+
+	tests := []struct {
+		name   string
+		target string
+		header string
+		code   int
+	}{
+		{"nx on existing key", "/cache/bkt/existing?mode=nx", "", http.StatusConflict},
+		{"if-none-match on existing key", "/cache/bkt/existing", "*", http.StatusConflict},
+		{"nx on new key", "/cache/bkt/new?mode=nx", "", http.StatusCreated},
+		{"xx on missing key", "/cache/bkt/missing?mode=xx", "", http.StatusNotFound},
+		{"xx on existing key", "/cache/bkt/existing?mode=xx", "", http.StatusCreated},
+		{"invalid mode", "/cache/bkt/existing?mode=yy", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, tt.target, strings.NewReader("value"))
+			if tt.header != "" {
+				r.Header.Set("If-None-Match", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
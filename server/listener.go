@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// limitListener wraps a listener to close the connections accepted beyond a limit of simultaneous connections
+// instead of serving them, so a client storm can't make the server spawn an unbounded number of goroutines.
+type limitListener struct {
+	net.Listener
+	limit  int64
+	active atomic.Int64
+}
+
+// newLimitListener limits the simultaneous connections served from the listener, 0 or less for no limit.
+func newLimitListener(listener net.Listener, limit int) net.Listener {
+	if limit <= 0 {
+		return listener
+	}
+	return &limitListener{Listener: listener, limit: int64(limit)}
+}
+
+// Accept waits for the next connection under the limit, closing the ones accepted over it.
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if l.active.Add(1) > l.limit {
+			l.active.Add(-1)
+			conn.Close()
+			continue
+		}
+		return &limitConn{Conn: conn, active: &l.active}, nil
+	}
+}
+
+// limitConn releases its slot of the limit when it is closed.
+type limitConn struct {
+	net.Conn
+	active *atomic.Int64
+	once   sync.Once
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.active.Add(-1) })
+	return err
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := newLimitListener(inner, 1)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	dial()
+	first := <-accepted
+
+	// The second connection is closed by the listener without being returned.
+	second := dial()
+	_, err = second.Read(make([]byte, 1))
+	assert.Error(t, err, "expected the connection over the limit to be closed")
+
+	// Closing the first connection (twice) releases a single slot for the next one.
+	first.Close()
+	first.Close()
+	dial()
+	third := <-accepted
+	assert.NotNil(t, third)
+	assert.Equal(t, int64(1), listener.(*limitListener).active.Load())
+
+	assert.Same(t, inner, newLimitListener(inner, 0), "expected no limit for 0")
+}
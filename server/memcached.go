@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jattoabdul/minervacache/cache"
+)
+
+const (
+	// memcachedMaxKeyLen is the maximum length of a key, as in memcached.
+	memcachedMaxKeyLen = 250
+	// memcachedMaxValueLen is the maximum size of a data block, the same as a RESP bulk string.
+	memcachedMaxValueLen = respMaxBulkLen
+	// memcachedMaxRelativeExptime is the largest exptime taken as a number of seconds, the larger ones are absolute
+	// unix timestamps, as in memcached (30 days).
+	memcachedMaxRelativeExptime = 60 * 60 * 24 * 30
+)
+
+// memcachedServer serves the cache over the memcached text protocol, so the memcached clients can talk to it.
+// Only the get, set, delete and quit commands are supported. The client flags are not stored, so they are always
+// returned as 0. The protocol has no bucket concept, so all the keys are mapped to a single bucket, see
+// [WithDefaultBucket].
+type memcachedServer struct {
+	*connServer
+	cache   cache.Cache
+	metrics cache.MetricsExporter
+}
+
+// NewMemcachedServer creates a new memcached server with the given cache, metrics exporter and options.
+// The server will be initialized in the Start method.
+func NewMemcachedServer(cache cache.Cache, metrics cache.MetricsExporter, opts ...Option) Server {
+	s := &memcachedServer{
+		cache:   cache,
+		metrics: metrics,
+	}
+	s.connServer = newConnServer("memcached", opts, s.handle)
+	return s
+}
+
+// handle reads the commands of the connection and writes their replies until the client disconnects, quits or
+// sends a malformed storage command. The replies of pipelined commands are flushed together.
+func (s *memcachedServer) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return // Disconnected or stopping.
+		}
+
+		closing := s.execute(r, w, strings.Fields(line))
+		if r.Buffered() == 0 || closing || s.isStopping() {
+			if err := w.Flush(); err != nil || closing || s.isStopping() {
+				return
+			}
+		}
+	}
+}
+
+// execute runs the command and writes its reply, reading the data block of the storage commands from r.
+// It returns true if the connection must be closed, when the client quits or the data block can't be read.
+func (s *memcachedServer) execute(r *bufio.Reader, w *bufio.Writer, args []string) bool {
+	if len(args) == 0 {
+		w.WriteString("ERROR\r\n")
+		return false
+	}
+
+	name, args := args[0], args[1:]
+	switch name {
+	case "get":
+		if len(args) == 0 {
+			w.WriteString("ERROR\r\n")
+			break
+		}
+		for _, key := range args {
+			value, err := s.cache.Get(s.options.defaultBucket, key, cache.Options{})
+			if isNotFound(err) {
+				continue
+			} else if err != nil {
+				w.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+				return false
+			}
+			w.WriteString("VALUE " + key + " 0 " + strconv.Itoa(len(value)) + "\r\n")
+			w.Write(value)
+			w.WriteString("\r\n")
+		}
+		w.WriteString("END\r\n")
+	case "set":
+		return s.set(r, w, args)
+	case "delete":
+		noreply := len(args) == 2 && args[1] == "noreply"
+		if len(args) != 1 && !noreply {
+			w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			break
+		}
+		err := s.cache.Delete(s.options.defaultBucket, args[0])
+		switch {
+		case noreply:
+		case isNotFound(err):
+			w.WriteString("NOT_FOUND\r\n")
+		case err != nil:
+			w.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+		default:
+			w.WriteString("DELETED\r\n")
+		}
+	case "quit":
+		return true
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+	return false
+}
+
+// set handles the "set <key> <flags> <exptime> <bytes> [noreply]" command followed by the data block.
+// A malformed command line closes the connection, since the data block that follows can't be skipped reliably.
+// A data block larger than the max body bytes is rejected before it is read, and discarded as it arrives.
+func (s *memcachedServer) set(r *bufio.Reader, w *bufio.Writer, args []string) bool {
+	noreply := len(args) == 5 && args[4] == "noreply"
+	if len(args) != 4 && !noreply {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return true
+	}
+
+	key := args[0]
+	_, flagsErr := strconv.ParseUint(args[1], 10, 32)
+	exptime, exptimeErr := strconv.ParseInt(args[2], 10, 64)
+	size, sizeErr := strconv.Atoi(args[3])
+	if len(key) > memcachedMaxKeyLen || flagsErr != nil || exptimeErr != nil || sizeErr != nil ||
+		size < 0 || size > memcachedMaxValueLen {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return true
+	}
+
+	if size > s.maxDataLen(memcachedMaxValueLen) {
+		if !noreply {
+			w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		}
+		_, err := io.CopyN(io.Discard, r, int64(size)+2)
+		return err != nil
+	}
+
+	data, ok, err := readData(r, size)
+	if err != nil {
+		return true
+	}
+	if !ok {
+		w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return true
+	}
+
+	if ttl, expired := memcachedTTL(exptime, time.Now()); expired {
+		// The item expires immediately, so the set only removes the previous value.
+		err = s.cache.Delete(s.options.defaultBucket, key)
+		if isNotFound(err) {
+			err = nil
+		}
+	} else {
+		err = s.cache.Set(s.options.defaultBucket, key, data, cache.Options{TTL: ttl})
+	}
+
+	switch {
+	case noreply:
+	case errors.Is(err, cache.ErrValueTooLarge):
+		w.WriteString("SERVER_ERROR object too large for cache\r\n")
+	case errors.Is(err, cache.ErrCacheFull):
+		w.WriteString("SERVER_ERROR out of memory storing object\r\n")
+	case err != nil:
+		w.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+	default:
+		w.WriteString("STORED\r\n")
+	}
+	return false
+}
+
+// memcachedTTL converts the exptime of a storage command to a TTL, 0 for no expiration. The exptime is a number of
+// seconds up to 30 days, and an absolute unix timestamp beyond. It reports whether the item is already expired,
+// for a negative exptime or a timestamp in the past.
+func memcachedTTL(exptime int64, now time.Time) (time.Duration, bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= memcachedMaxRelativeExptime:
+		return time.Duration(exptime) * time.Second, false
+	}
+
+	ttl := time.Unix(exptime, 0).Sub(now)
+	return ttl, ttl <= 0
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jattoabdul/minervacache/cache"
+)
+
+// connectTestMemcachedServer serves the given cache over the memcached protocol on one end of an in-memory pipe
+// and returns the other end.
+func connectTestMemcachedServer(t *testing.T, c cache.Cache, opts ...Option) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	client, conn := net.Pipe()
+	s := NewMemcachedServer(c, &MockMetrics{}, opts...).(*memcachedServer)
+	go s.serveConn(conn)
+	t.Cleanup(func() { client.Close() })
+
+	return client, bufio.NewReader(client)
+}
+
+func TestMemcachedServer_Commands(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	conn, r := connectTestMemcachedServer(t, mc)
+
+	roundTrip(t, conn, r, "get key1\r\n", "END\r\n")
+	roundTrip(t, conn, r, "set key1 0 0 6\r\nval\r\n1\r\n", "STORED\r\n")
+	roundTrip(t, conn, r, "set key2 5 0 0\r\n\r\n", "STORED\r\n")
+	roundTrip(t, conn, r, "get key1 missing key2\r\n", "VALUE key1 0 6\r\nval\r\n1\r\nVALUE key2 0 0\r\n\r\nEND\r\n")
+
+	// The keys are mapped to the default bucket.
+	value, err := mc.Get(DefaultBucket, "key1", cache.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("val\r\n1"), value)
+
+	roundTrip(t, conn, r, "delete key1\r\n", "DELETED\r\n")
+	roundTrip(t, conn, r, "delete key1\r\n", "NOT_FOUND\r\n")
+	roundTrip(t, conn, r, "get key1\r\n", "END\r\n")
+
+	// The noreply commands have no reply, so the next reply is the one of the get.
+	roundTrip(t, conn, r, "set key3 0 0 4 noreply\r\nval3\r\ndelete key2 noreply\r\nget key2 key3\r\n", "VALUE key3 0 4\r\nval3\r\nEND\r\n")
+
+	roundTrip(t, conn, r, "\r\n", "ERROR\r\n")
+	roundTrip(t, conn, r, "flush_all\r\n", "ERROR\r\n")
+	roundTrip(t, conn, r, "delete\r\n", "CLIENT_ERROR bad command line format\r\n")
+}
+
+func TestMemcachedServer_SetExptime(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	conn, r := connectTestMemcachedServer(t, mc, WithDefaultBucket("memcached"))
+
+	roundTrip(t, conn, r, "set key1 0 60 4\r\nval1\r\n", "STORED\r\n")
+	_, meta, err := mc.GetWithMeta("memcached", "key1", cache.Options{})
+	require.NoError(t, err, "expected the key in the configured bucket")
+	assert.InDelta(t, time.Minute, meta.TTLRemaining, float64(time.Second))
+
+	// An exptime beyond 30 days is an absolute unix timestamp.
+	exptime := time.Now().Add(time.Hour).Unix()
+	roundTrip(t, conn, r, "set key2 0 "+strconv.FormatInt(exptime, 10)+" 4\r\nval2\r\n", "STORED\r\n")
+	_, meta, err = mc.GetWithMeta("memcached", "key2", cache.Options{})
+	require.NoError(t, err)
+	assert.InDelta(t, time.Hour, meta.TTLRemaining, float64(2*time.Second))
+
+	// A negative exptime (or a timestamp in the past) expires the key immediately.
+	roundTrip(t, conn, r, "set key1 0 -1 4\r\nval1\r\n", "STORED\r\n")
+	roundTrip(t, conn, r, "get key1\r\n", "END\r\n")
+}
+
+func TestMemcachedServer_Errors(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{}, cache.WithMaxValueBytes(4))
+	defer mc.Stop()
+
+	conn, r := connectTestMemcachedServer(t, mc)
+	roundTrip(t, conn, r, "set key1 0 0 5\r\nval10\r\n", "SERVER_ERROR object too large for cache\r\n")
+	roundTrip(t, conn, r, "set key1 0 0 4\r\nval10\r\n", "CLIENT_ERROR bad data chunk\r\n")
+	_, err := r.ReadByte()
+	assert.Error(t, err, "expected the server to close the connection on a bad data chunk")
+
+	disabled := cache.NewMinervaCache(0, 0, &noopMetrics{})
+	defer disabled.Stop()
+	conn, r = connectTestMemcachedServer(t, disabled)
+	roundTrip(t, conn, r, "set key1 0 0 4\r\nval1\r\n", "SERVER_ERROR out of memory storing object\r\n") // Never any room.
+
+	// The size is rejected before the data block is read, which is discarded so the connection can still be used.
+	conn, r = connectTestMemcachedServer(t, mc, WithMaxBodyBytes(4))
+	roundTrip(t, conn, r, "set key1 0 0 5\r\nval10\r\n", "SERVER_ERROR object too large for cache\r\n")
+	roundTrip(t, conn, r, "set key1 0 0 5 noreply\r\nval10\r\nset key1 0 0 4\r\nval1\r\n", "STORED\r\n")
+
+	conn, r = connectTestMemcachedServer(t, mc)
+	roundTrip(t, conn, r, "set key1 0 0\r\n", "CLIENT_ERROR bad command line format\r\n")
+	_, err = r.ReadByte()
+	assert.Error(t, err, "expected the server to close the connection on a bad command line")
+
+	conn, r = connectTestMemcachedServer(t, mc)
+	_, err = conn.Write([]byte("quit\r\n"))
+	require.NoError(t, err)
+	_, err = r.ReadByte()
+	assert.Error(t, err, "expected the server to close the connection on quit")
+}
+
+func TestMemcachedTTL(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	for _, tc := range []struct {
+		exptime int64
+		ttl     time.Duration
+		expired bool
+	}{
+		{0, 0, false},
+		{-1, 0, true},
+		{60, time.Minute, false},
+		{memcachedMaxRelativeExptime, 30 * 24 * time.Hour, false},
+		{now.Unix() + 90, 90 * time.Second, false},
+		{now.Unix(), 0, true},
+		{now.Unix() - 90, -90 * time.Second, true},
+	} {
+		ttl, expired := memcachedTTL(tc.exptime, now)
+		assert.Equal(t, tc.ttl, ttl, "exptime %d", tc.exptime)
+		assert.Equal(t, tc.expired, expired, "exptime %d", tc.exptime)
+	}
+}
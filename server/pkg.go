@@ -1,7 +1,38 @@
-// Package server implements HTTP server for accessing the cache over the network.
+// Package server implements HTTP, gRPC, RESP and memcached servers for accessing the cache over the network.
 package server
 
-import "context"
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long Stop waits for the in-flight requests to complete before closing them.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// DefaultRequestTimeout is how long the HTTP key operations can take before failing with 504 Gateway Timeout unless
+// configured with [WithRequestTimeout].
+const DefaultRequestTimeout = 5 * time.Second
+
+// DefaultGzipMinSize is the minimum size of the HTTP responses gzipped unless configured with [WithGzipMinSize].
+const DefaultGzipMinSize = 1024
+
+// DefaultScanLimit and MaxScanLimit are the default and maximum number of keys in a page of the HTTP bucket scan.
+const (
+	DefaultScanLimit = 100
+	MaxScanLimit     = 1000
+)
+
+// DefaultCORSMethods and DefaultCORSHeaders are the methods and request headers allowed to the browser clients of the
+// origins allowed with [WithCORS], unless configured with [WithCORSMethods] and [WithCORSHeaders].
+var (
+	DefaultCORSMethods = []string{"GET", "HEAD", "PUT", "PATCH", "POST", "DELETE"}
+	DefaultCORSHeaders = []string{"Content-Type", "If-Match", "If-None-Match", "Range", "X-API-Key"}
+)
+
+// DefaultBucket is the bucket the servers of the protocols without a bucket concept, e.g. RESP and memcached, map
+// all the keys to unless configured with [WithDefaultBucket].
+const DefaultBucket = "default"
 
 // Server is an interface for the cache server.
 // Defines methods to start and stop the server and can be implemented by different server protocols e.g. HTTP, gRPC, etc.
@@ -9,3 +40,213 @@ type Server interface {
 	Start(ctx context.Context, addr string, port int) error
 	Stop(ctx context.Context) error
 }
+
+// Option configures a server on creation. The options apply to all the server protocols.
+type Option func(o *options)
+
+type options struct {
+	shutdownTimeout time.Duration
+	// requestTimeout is the deadline of the context of the HTTP key operations, 0 for none.
+	requestTimeout time.Duration
+	// tlsCertFile and tlsKeyFile are the PEM encoded certificate and key files to serve over TLS, empty for plaintext.
+	tlsCertFile string
+	tlsKeyFile  string
+	// logger is where the servers log their lifecycle and failures. accessLog is where the HTTP server logs the
+	// requests, the logger if nil, unless noAccessLog disables it.
+	logger      *slog.Logger
+	accessLog   *slog.Logger
+	noAccessLog bool
+	// defaultBucket is the bucket the RESP and memcached servers map all the keys to, since they have no bucket concept.
+	defaultBucket string
+	// gzipMinSize is the minimum size of the HTTP responses gzipped for the clients accepting it, 0 to disable.
+	gzipMinSize int
+	// maxConns is the maximum number of simultaneous connections, 0 for unlimited.
+	maxConns int
+	// maxStreams is the maximum number of concurrent streams per gRPC connection, 0 for the gRPC default.
+	maxStreams int
+	// maxMessageSize is the maximum size in bytes of the gRPC messages received and sent, 0 for the gRPC default.
+	maxMessageSize int
+	// rateLimit is the number of HTTP requests per second allowed per client, 0 for unlimited, with bursts of up to
+	// rateBurst requests.
+	rateLimit int
+	rateBurst int
+	// validAPIKey reports whether the X-API-Key of a request is a known key, so the rate limit applies to it rather than
+	// to the IP of the client, nil to only limit by IP.
+	validAPIKey func(key string) bool
+	// maxBodyBytes is the maximum size of the body of the HTTP key requests, 0 for unlimited.
+	maxBodyBytes int64
+	// tracer starts a span for each operation served, nil to disable tracing.
+	tracer Tracer
+	// corsOrigins are the origins allowed to call the HTTP server from a browser, none to disable CORS, with the
+	// corsMethods and corsHeaders.
+	corsOrigins []string
+	corsMethods []string
+	corsHeaders []string
+}
+
+// WithShutdownTimeout sets how long Stop waits for the in-flight requests to drain before force-closing the remaining
+// connections. The default is [DefaultShutdownTimeout].
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.shutdownTimeout = timeout
+	}
+}
+
+// WithRequestTimeout sets how long the HTTP key operations can take before failing with 504 Gateway Timeout,
+// 0 for no timeout. The default is [DefaultRequestTimeout].
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = timeout
+	}
+}
+
+// WithTLS serves over TLS with the PEM encoded certificate and private key in the given files, instead of plaintext.
+// The files are loaded when the server starts, which fails if they can't be read.
+func WithTLS(certFile, keyFile string) Option {
+	return func(o *options) {
+		o.tlsCertFile = certFile
+		o.tlsKeyFile = keyFile
+	}
+}
+
+// WithLogger sets the logger of the servers, e.g. to log at another level or as JSON. The HTTP server also logs its
+// access log to it, unless set separately, see [WithAccessLog]. The default is the default slog logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithAccessLog sets the logger the HTTP server logs each request to, with the method, path, bucket, key, status,
+// bytes and duration attributes, nil to disable the access log. The default is the logger of the servers, see
+// [WithLogger].
+func WithAccessLog(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.accessLog = logger
+		o.noAccessLog = logger == nil
+	}
+}
+
+// WithDefaultBucket sets the bucket the RESP and memcached servers map all the keys to. The default is [DefaultBucket].
+func WithDefaultBucket(bucket string) Option {
+	return func(o *options) {
+		o.defaultBucket = bucket
+	}
+}
+
+// WithGzipMinSize sets the minimum size in bytes of the HTTP responses gzipped for the clients accepting it,
+// 0 to disable the compression. The default is [DefaultGzipMinSize].
+func WithGzipMinSize(size int) Option {
+	return func(o *options) {
+		o.gzipMinSize = size
+	}
+}
+
+// WithMaxConns limits the number of simultaneous connections, 0 for unlimited. The connections beyond the limit are
+// closed as soon as they are accepted.
+func WithMaxConns(n int) Option {
+	return func(o *options) {
+		o.maxConns = n
+	}
+}
+
+// WithMaxStreams limits the number of concurrent streams, i.e. in-flight RPCs, per gRPC connection, 0 for the gRPC
+// default. The other protocols serve one request at a time per connection, so it only applies to the gRPC server.
+func WithMaxStreams(n int) Option {
+	return func(o *options) {
+		o.maxStreams = n
+	}
+}
+
+// WithMaxMessageSize sets the maximum size in bytes of the messages the gRPC server receives and sends, 0 for the
+// gRPC default of 4MB. A request over the limit fails with ResourceExhausted before reaching the cache. It should
+// leave room above the cache limit on the size of a value, see cache.WithMaxValueBytes, for the rest of the message,
+// e.g. the bucket and the key, and a batch of values is a single message. It only applies to the gRPC server.
+func WithMaxMessageSize(bytes int) Option {
+	return func(o *options) {
+		o.maxMessageSize = bytes
+	}
+}
+
+// WithRateLimit limits each client of the HTTP server to rps requests per second, with bursts of up to burst requests,
+// 0 for unlimited. The requests over the limit get 429 Too Many Requests with a Retry-After header. The clients are
+// identified by their IP address, or by their X-API-Key header if it is valid, see [WithAPIKeyValidator]. It only
+// applies to the HTTP server.
+func WithRateLimit(rps, burst int) Option {
+	return func(o *options) {
+		o.rateLimit = rps
+		o.rateBurst = burst
+	}
+}
+
+// WithAPIKeyValidator makes the rate limit of the HTTP server apply to each API key rather than to each IP, for the
+// requests with an X-API-Key header valid according to validate. The other requests are limited by IP, since a client
+// choosing its own keys could otherwise get a new limit for each of them. By default the API keys are ignored.
+func WithAPIKeyValidator(validate func(key string) bool) Option {
+	return func(o *options) {
+		o.validAPIKey = validate
+	}
+}
+
+// WithMaxBodyBytes limits the size of the body of the HTTP key requests, i.e. the value of a set, 0 for unlimited.
+// The body is read up to the limit, so a larger one fails with 413 Payload Too Large as soon as the limit is reached
+// instead of being buffered whole. It should match the value size limit of the cache, see cache.WithMaxValueBytes.
+// It also limits the bulk strings of the RESP server, a larger one being a protocol error, and the data blocks of the
+// memcached server, a larger one being rejected. It doesn't apply to gRPC, see [WithMaxMessageSize].
+func WithMaxBodyBytes(n int64) Option {
+	return func(o *options) {
+		o.maxBodyBytes = n
+	}
+}
+
+// WithTracer starts a span for each key operation of the HTTP server and each unary RPC of the gRPC server with the
+// tracer, with the bucket and key attributes, the hit attribute for a get, and the error the operation failed with.
+// Tracing is disabled by default.
+func WithTracer(tracer Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithCORS lets the browser clients of the given origins, e.g. "https://app.example.com", or of any origin with "*",
+// call the HTTP server: the preflight OPTIONS requests are answered with the allowed methods and headers, and the
+// responses get the Access-Control-Allow-Origin header. The requests of the other origins are rejected with 403
+// Forbidden. The requests without an Origin header, i.e. not from a browser, are served as is. CORS is disabled by
+// default. It only applies to the HTTP server.
+func WithCORS(origins []string) Option {
+	return func(o *options) {
+		o.corsOrigins = origins
+	}
+}
+
+// WithCORSMethods sets the methods the origins allowed with [WithCORS] can use. The default is [DefaultCORSMethods].
+func WithCORSMethods(methods ...string) Option {
+	return func(o *options) {
+		o.corsMethods = methods
+	}
+}
+
+// WithCORSHeaders sets the request headers the origins allowed with [WithCORS] can send. The default is
+// [DefaultCORSHeaders].
+func WithCORSHeaders(headers ...string) Option {
+	return func(o *options) {
+		o.corsHeaders = headers
+	}
+}
+
+// newOptions applies the given options over the defaults.
+func newOptions(opts []Option) options {
+	o := options{
+		shutdownTimeout: DefaultShutdownTimeout,
+		requestTimeout:  DefaultRequestTimeout,
+		logger:          slog.Default(),
+		defaultBucket:   DefaultBucket,
+		gzipMinSize:     DefaultGzipMinSize,
+		corsMethods:     DefaultCORSMethods,
+		corsHeaders:     DefaultCORSHeaders,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
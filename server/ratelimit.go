@@ -0,0 +1,109 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepInterval is how often the rate limiter forgets the clients idle long enough to have a full bucket.
+const rateLimitSweepInterval = time.Minute
+
+// rateLimiter limits the rate of requests of each client with a token bucket: a client can send burst requests at
+// once, then rate requests per second.
+type rateLimiter struct {
+	rate  float64 // Tokens added per second.
+	burst float64 // Capacity of the buckets.
+
+	mutex     sync.Mutex
+	clients   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket is the state of the bucket of a client, refilled lazily when it is used.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // When the tokens were last refilled.
+}
+
+// newRateLimiter returns a limiter of rate requests per second per client, with bursts of up to burst requests.
+// A burst below 1 allows a single request at once.
+func newRateLimiter(rate, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(rate),
+		burst:   float64(max(burst, 1)),
+		clients: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from the bucket of the client at the given time and reports whether there was one.
+// If not, it returns how long the client should wait for the next token.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes the buckets that have refilled since their last use, which are the same as new ones, so the memory
+// held is bounded by the clients active within the sweep interval.
+// Must be called with the mutex locked.
+func (l *rateLimiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.clients {
+		if now.Sub(b.last) >= full {
+			delete(l.clients, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// clientID identifies the client of a request for rate limiting: its X-API-Key header if set and valid, its IP
+// otherwise. Without a validator, the key is never trusted.
+func clientID(r *http.Request, validAPIKey func(key string) bool) string {
+	if key := r.Header.Get("X-API-Key"); key != "" && validAPIKey != nil && validAPIKey(key) {
+		return "key:" + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// limitRate is a middleware that responds with 429 Too Many Requests and a Retry-After header (in seconds) to the
+// requests of the clients over the rate limit, see [WithRateLimit].
+func (s *httpServer) limitRate(next http.Handler) http.Handler {
+	if s.options.rateLimit <= 0 {
+		return next
+	}
+
+	limiter := newRateLimiter(s.options.rateLimit, s.options.rateBurst)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := limiter.allow(clientID(r, s.options.validAPIKey), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			SendErrorResponse(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
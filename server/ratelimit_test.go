@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jattoabdul/minervacache/cache"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(10, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.allow("client1", now)
+		assert.True(t, ok, "expected request %d of the burst to be allowed", i)
+	}
+	ok, wait := limiter.allow("client1", now)
+	assert.False(t, ok, "expected the request past the burst to be limited")
+	assert.Equal(t, 100*time.Millisecond, wait)
+
+	ok, _ = limiter.allow("client2", now)
+	assert.True(t, ok, "expected the other clients to have their own bucket")
+
+	ok, _ = limiter.allow("client1", now.Add(100*time.Millisecond))
+	assert.True(t, ok, "expected a token to be refilled")
+
+	// The idle clients with a full bucket are forgotten by the sweep.
+	limiter.allow("client3", now.Add(rateLimitSweepInterval))
+	assert.Len(t, limiter.clients, 1)
+}
+
+func TestLimitRate(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	handler := NewHTTPServer(mc, &MockMetrics{}, WithRateLimit(20, 2), WithAPIKeyValidator(func(key string) bool {
+		return key == "key1"
+	})).(*httpServer).routes()
+
+	get := func(remoteAddr, apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/health", nil)
+		r.RemoteAddr = remoteAddr
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1234", "").Code)
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1235", "").Code, "expected the burst to be allowed")
+	w := get("10.0.0.1:1236", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "expected the IP to be limited across connections")
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, get("10.0.0.2:1234", "").Code, "expected the other IPs not to be limited")
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1234", "key1").Code, "expected the API key to be limited on its own")
+	assert.Equal(t, http.StatusTooManyRequests, get("10.0.0.1:1234", "key2").Code, "expected an unknown key to be limited by IP")
+
+	time.Sleep(100 * time.Millisecond) // Refills 2 tokens.
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1234", "").Code, "expected the client to recover after waiting")
+}
+
+func TestClientID(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/health", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-API-Key", "key1")
+	assert.Equal(t, "ip:10.0.0.1", clientID(r, nil), "expected the key to be ignored without a validator")
+	assert.Equal(t, "key:key1", clientID(r, func(key string) bool { return true }))
+	assert.Equal(t, "ip:10.0.0.1", clientID(r, func(key string) bool { return false }))
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jattoabdul/minervacache/cache"
+)
+
+const (
+	// respMaxArgs is the maximum number of arguments of a command, as in Redis.
+	respMaxArgs = 1024 * 1024
+	// respMaxBulkLen is the maximum size of a bulk string argument, as the default proto-max-bulk-len of Redis.
+	respMaxBulkLen = 512 * 1024 * 1024
+)
+
+// errRESPProtocol is returned when a client sends a malformed command, after which its connection is closed.
+var errRESPProtocol = errors.New("protocol error")
+
+// respServer serves the cache over the Redis serialization protocol (RESP), so redis-cli and the Redis clients can
+// talk to it. Only a minimal subset of the commands is supported: PING, GET, SET (with EX), DEL and QUIT.
+// RESP has no bucket concept, so all the keys are mapped to a single bucket, see [WithDefaultBucket].
+type respServer struct {
+	*connServer
+	cache   cache.Cache
+	metrics cache.MetricsExporter
+}
+
+// NewRESPServer creates a new RESP server with the given cache, metrics exporter and options.
+// The server will be initialized in the Start method.
+func NewRESPServer(cache cache.Cache, metrics cache.MetricsExporter, opts ...Option) Server {
+	s := &respServer{
+		cache:   cache,
+		metrics: metrics,
+	}
+	s.connServer = newConnServer("RESP", opts, s.handle)
+	return s
+}
+
+// handle reads the commands of the connection and writes their replies until the client disconnects, quits or
+// sends a malformed command. The replies of pipelined commands are flushed together.
+func (s *respServer) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readRESPCommand(r, s.maxDataLen(respMaxBulkLen))
+		if errors.Is(err, errRESPProtocol) {
+			writeRESPError(w, err.Error())
+			w.Flush()
+			return
+		} else if err != nil {
+			return // Disconnected or stopping.
+		}
+
+		quit := len(args) > 0 && s.execute(w, args)
+		if r.Buffered() == 0 || quit || s.isStopping() {
+			if err := w.Flush(); err != nil || quit || s.isStopping() {
+				return
+			}
+		}
+	}
+}
+
+// execute runs the command and writes its reply. It returns true if the client quits.
+func (s *respServer) execute(w *bufio.Writer, args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	args = args[1:]
+
+	switch name {
+	case "ping":
+		switch len(args) {
+		case 0:
+			writeRESPSimple(w, "PONG")
+		case 1:
+			writeRESPBulk(w, args[0])
+		default:
+			writeRESPArgsError(w, name)
+		}
+	case "get":
+		if len(args) != 1 {
+			writeRESPArgsError(w, name)
+			break
+		}
+		value, err := s.cache.Get(s.options.defaultBucket, string(args[0]), cache.Options{})
+		if isNotFound(err) {
+			writeRESPNil(w)
+		} else if err != nil {
+			writeRESPError(w, err.Error())
+		} else {
+			writeRESPBulk(w, value)
+		}
+	case "set":
+		if len(args) != 2 && len(args) != 4 {
+			writeRESPArgsError(w, name)
+			break
+		}
+		var opts cache.Options
+		if len(args) == 4 {
+			if !strings.EqualFold(string(args[2]), "ex") {
+				writeRESPError(w, "syntax error")
+				break
+			}
+			seconds, err := strconv.ParseInt(string(args[3]), 10, 64)
+			if err != nil || seconds <= 0 || seconds > math.MaxInt64/int64(time.Second) {
+				writeRESPError(w, "invalid expire time in 'set' command")
+				break
+			}
+			opts.TTL = time.Duration(seconds) * time.Second
+		}
+		if err := s.cache.Set(s.options.defaultBucket, string(args[0]), args[1], opts); err != nil {
+			writeRESPError(w, err.Error())
+			break
+		}
+		writeRESPSimple(w, "OK")
+	case "del":
+		if len(args) == 0 {
+			writeRESPArgsError(w, name)
+			break
+		}
+		deleted := 0
+		for _, key := range args {
+			if err := s.cache.Delete(s.options.defaultBucket, string(key)); err == nil {
+				deleted++
+			}
+		}
+		writeRESPInt(w, deleted)
+	case "quit":
+		writeRESPSimple(w, "OK")
+		return true
+	default:
+		writeRESPError(w, fmt.Sprintf("unknown command '%s'", truncate(name)))
+	}
+	return false
+}
+
+// truncate truncates a command name echoed back in an error, so a client can't make the server echo large payloads.
+func truncate(name string) string {
+	if len(name) > 64 {
+		return name[:64] + "..."
+	}
+	return name
+}
+
+// isNotFound reports whether the error is a missing (or expired) key, replied to with the nil bulk string.
+// A key cached as missing is one too.
+func isNotFound(err error) bool {
+	return errors.Is(err, cache.ErrKeyNotFound) || errors.Is(err, cache.ErrBucketNotFound) ||
+		errors.Is(err, cache.ErrKeyExpired) || errors.Is(err, cache.ErrNegativeCached)
+}
+
+// readRESPCommand reads a command, either as an array of bulk strings as sent by the Redis clients, or as an inline
+// command of space separated arguments as typed in a telnet session. An empty inline command has no arguments.
+// A bulk string larger than maxBulkLen is a protocol error. The arguments are buffered as they arrive, rather than
+// allocated upfront from the lengths sent by the client.
+func readRESPCommand(r *bufio.Reader, maxBulkLen int) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		var args [][]byte
+		for _, field := range strings.Fields(line) {
+			args = append(args, []byte(field))
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > respMaxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errRESPProtocol)
+	}
+
+	var args [][]byte
+	for range n {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("%w: expected '$', got '%s'", errRESPProtocol, truncate(line))
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, fmt.Errorf("%w: invalid bulk length", errRESPProtocol)
+		}
+
+		arg, ok, err := readData(r, size)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid bulk terminator", errRESPProtocol)
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func writeRESPSimple(w *bufio.Writer, s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func writeRESPError(w *bufio.Writer, msg string) {
+	w.WriteString("-ERR " + msg + "\r\n")
+}
+
+func writeRESPArgsError(w *bufio.Writer, name string) {
+	writeRESPError(w, fmt.Sprintf("wrong number of arguments for '%s' command", name))
+}
+
+func writeRESPInt(w *bufio.Writer, n int) {
+	w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+// writeRESPNil writes the nil bulk string, the reply for a missing key.
+func writeRESPNil(w *bufio.Writer) {
+	w.WriteString("$-1\r\n")
+}
+
+func writeRESPBulk(w *bufio.Writer, value []byte) {
+	w.WriteString("$" + strconv.Itoa(len(value)) + "\r\n")
+	w.Write(value)
+	w.WriteString("\r\n")
+}
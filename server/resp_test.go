@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jattoabdul/minervacache/cache"
+)
+
+// startTestRESPServer serves the given cache over RESP on a local port and returns the address to connect to.
+func startTestRESPServer(t *testing.T, c cache.Cache, opts ...Option) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := NewRESPServer(c, &MockMetrics{}, opts...).(*respServer)
+	go s.serve(listener)
+	t.Cleanup(func() { s.Stop(context.Background()) })
+
+	return listener.Addr().String()
+}
+
+// respCommand encodes the arguments as a RESP array of bulk strings, the way the Redis clients send commands.
+func respCommand(args ...string) string {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	return b.String()
+}
+
+// roundTrip writes the raw request to the connection and reads exactly len(want) bytes of replies.
+func roundTrip(t *testing.T, conn net.Conn, r *bufio.Reader, request, want string) {
+	t.Helper()
+
+	_, err := conn.Write([]byte(request))
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, len(want))
+	_, err = io.ReadFull(r, got)
+	require.NoError(t, err, "expected the reply %q", want)
+	assert.Equal(t, want, string(got))
+}
+
+func TestRESPServer_Commands(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	addr := startTestRESPServer(t, mc)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	roundTrip(t, conn, r, respCommand("PING"), "+PONG\r\n")
+	roundTrip(t, conn, r, respCommand("ping", "hello"), "$5\r\nhello\r\n")
+	roundTrip(t, conn, r, respCommand("GET", "key1"), "$-1\r\n")
+	roundTrip(t, conn, r, respCommand("SET", "key1", "val\r\n1"), "+OK\r\n")
+	roundTrip(t, conn, r, respCommand("GET", "key1"), "$6\r\nval\r\n1\r\n")
+	roundTrip(t, conn, r, respCommand("SET", "key2", ""), "+OK\r\n")
+	roundTrip(t, conn, r, respCommand("GET", "key2"), "$0\r\n\r\n")
+	roundTrip(t, conn, r, respCommand("DEL", "key1", "key2", "missing"), ":2\r\n")
+	roundTrip(t, conn, r, respCommand("GET", "key1"), "$-1\r\n")
+
+	// Errors.
+	roundTrip(t, conn, r, respCommand("GET"), "-ERR wrong number of arguments for 'get' command\r\n")
+	roundTrip(t, conn, r, respCommand("SET", "key1", "val1", "PX", "10"), "-ERR syntax error\r\n")
+	roundTrip(t, conn, r, respCommand("SET", "key1", "val1", "EX", "0"), "-ERR invalid expire time in 'set' command\r\n")
+	roundTrip(t, conn, r, respCommand("FLUSHALL"), "-ERR unknown command 'flushall'\r\n")
+
+	// Pipelined and inline commands.
+	roundTrip(t, conn, r, respCommand("SET", "key1", "val1")+respCommand("GET", "key1")+"PING\r\n", "+OK\r\n$4\r\nval1\r\n+PONG\r\n")
+
+	// The keys are mapped to the default bucket.
+	value, err := mc.Get(DefaultBucket, "key1", cache.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("val1"), value)
+
+	roundTrip(t, conn, r, respCommand("QUIT"), "+OK\r\n")
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF, "expected the server to close the connection on quit")
+}
+
+func TestRESPServer_SetEX(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	addr := startTestRESPServer(t, mc, WithDefaultBucket("redis"))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	roundTrip(t, conn, r, respCommand("SET", "key1", "val1", "ex", "60"), "+OK\r\n")
+
+	_, meta, err := mc.GetWithMeta("redis", "key1", cache.Options{})
+	require.NoError(t, err, "expected the key in the configured bucket")
+	assert.InDelta(t, time.Minute, meta.TTLRemaining, float64(time.Second))
+}
+
+func TestRESPServer_ProtocolError(t *testing.T) {
+	addr := startTestRESPServer(t, &MockCache{})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	roundTrip(t, conn, r, "*1\r\n+PING\r\n", "-ERR protocol error: expected '$', got '+PING'\r\n")
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF, "expected the server to close the connection on a protocol error")
+}
+
+func TestRESPServer_MaxBodyBytes(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	addr := startTestRESPServer(t, mc, WithMaxBodyBytes(4))
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	roundTrip(t, conn, r, respCommand("SET", "key1", "val1"), "+OK\r\n")
+	// The length is rejected before the value is read, so a client can't make the server allocate it upfront.
+	roundTrip(t, conn, r, "*3\r\n$3\r\nSET\r\n$4\r\nkey2\r\n$536870912\r\n", "-ERR protocol error: invalid bulk length\r\n")
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF, "expected the server to close the connection on a protocol error")
+}
+
+func TestRESPServer_Stop(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := NewRESPServer(&MockCache{}, &MockMetrics{}, WithShutdownTimeout(time.Second)).(*respServer)
+	served := make(chan error, 1)
+	go func() { served <- s.serve(listener) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	roundTrip(t, conn, r, respCommand("PING"), "+PONG\r\n")
+
+	// The idle connection doesn't keep the server waiting until the shutdown timeout.
+	start := time.Now()
+	require.NoError(t, s.Stop(context.Background()))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.NoError(t, <-served)
+
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF, "expected the server to close the idle connection")
+
+	assert.NotPanics(t, func() { s.Stop(context.Background()) }, "expected a second Stop to do nothing")
+}
+
+func TestRESPServer_TLS(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	addr := startTestRESPServer(t, mc, WithTLS(certFile, keyFile))
+
+	pem, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(pem))
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	roundTrip(t, conn, bufio.NewReader(conn), respCommand("PING"), "+PONG\r\n")
+}
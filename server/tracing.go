@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/jattoabdul/minervacache/proto"
+)
+
+// Tracer starts the spans of the operations served, see [WithTracer]. It is a subset of the OpenTelemetry tracer
+// API, so the servers don't depend on a tracing library: an OpenTelemetry tracer can be plugged in with a small
+// adapter.
+type Tracer interface {
+	// Start starts a span with the given name as a child of the span of the context, if any, and returns a context
+	// holding the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced operation.
+type Span interface {
+	// SetAttribute sets an attribute of the operation. The value is a string or a bool.
+	SetAttribute(key string, value any)
+	// RecordError records the error the operation failed with.
+	RecordError(err error)
+	// End completes the span.
+	End()
+}
+
+// startSpan starts a span of an operation on the key of the bucket with the tracer, if not nil, with the bucket and
+// key attributes. The returned function ends it with the outcome of the operation: the hit attribute for a get, or
+// the error it failed with. A miss is not an error.
+func startSpan(ctx context.Context, tracer Tracer, name, bucket, key string, get bool) (context.Context, func(err error, miss bool)) {
+	if tracer == nil {
+		return ctx, func(error, bool) {}
+	}
+
+	ctx, span := tracer.Start(ctx, name)
+	span.SetAttribute("bucket", bucket)
+	if key != "" {
+		span.SetAttribute("key", key)
+	}
+	return ctx, func(err error, miss bool) {
+		if get {
+			span.SetAttribute("hit", err == nil)
+		}
+		if err != nil && !(get && miss) {
+			span.RecordError(err)
+		}
+		span.End()
+	}
+}
+
+// traceUnary is a gRPC interceptor starting a span named after the method for each unary RPC, with the bucket and the
+// key of the request if it has them.
+func (s *grpcServer) traceUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var bucket, key string
+	if r, ok := req.(interface{ GetBucket() string }); ok {
+		bucket = r.GetBucket()
+	}
+	if r, ok := req.(interface{ GetKey() string }); ok {
+		key = r.GetKey()
+	}
+
+	ctx, end := startSpan(ctx, s.options.tracer, info.FullMethod, bucket, key, info.FullMethod == proto.MinervaCache_Get_FullMethodName)
+	resp, err := handler(ctx, req)
+	end(err, status.Code(err) == codes.NotFound)
+	return resp, err
+}
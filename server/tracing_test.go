@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jattoabdul/minervacache/cache"
+	"github.com/jattoabdul/minervacache/proto"
+)
+
+// recordingTracer records the spans ended, in memory.
+type recordingTracer struct {
+	mutex sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	tracer     *recordingTracer
+	name       string
+	attributes map[string]any
+	err        error
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, &recordedSpan{tracer: t, name: name, attributes: make(map[string]any)}
+}
+
+// ended returns the spans ended so far.
+func (t *recordingTracer) ended() []*recordedSpan {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]*recordedSpan(nil), t.spans...)
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) { s.attributes[key] = value }
+func (s *recordedSpan) RecordError(err error)              { s.err = err }
+
+func (s *recordedSpan) End() {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s)
+}
+
+func TestHTTPTracing(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	mc.Set("bkt1", "key1", []byte("val1"), cache.Options{})
+	tracer := &recordingTracer{}
+	handler := NewHTTPServer(mc, &MockMetrics{}, WithTracer(tracer)).(*httpServer).routes()
+
+	for _, target := range []string{"/cache/bkt1/key1", "/cache/bkt1/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	spans := tracer.ended()
+	require.Len(t, spans, 2, "expected a span for each key operation only")
+	assert.Equal(t, "GET /cache/{bucket}/{key}", spans[0].name)
+	assert.Equal(t, map[string]any{"bucket": "bkt1", "key": "key1", "hit": true}, spans[0].attributes)
+	assert.NoError(t, spans[0].err)
+	assert.Equal(t, map[string]any{"bucket": "bkt1", "key": "missing", "hit": false}, spans[1].attributes)
+	assert.NoError(t, spans[1].err, "expected a miss not to be recorded as an error")
+}
+
+func TestHTTPTracing_Error(t *testing.T) {
+	mockCache := &MockCache{
+		SetFunc: func(bucket, key string, value []byte, opts cache.Options) error {
+			return errors.New("boom")
+		},
+	}
+	tracer := &recordingTracer{}
+	handler := NewHTTPServer(mockCache, &MockMetrics{}, WithTracer(tracer)).(*httpServer).routes()
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/cache/bkt1/key1", nil))
+	spans := tracer.ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "PUT /cache/{bucket}/{key}", spans[0].name)
+	assert.NotContains(t, spans[0].attributes, "hit", "expected no hit attribute for a set")
+	assert.EqualError(t, spans[0].err, "boom")
+}
+
+func TestGRPCTracing(t *testing.T) {
+	mc := cache.NewMinervaCache(10, 0, &noopMetrics{})
+	defer mc.Stop()
+	mc.Set("bkt1", "key1", []byte("val1"), cache.Options{})
+	tracer := &recordingTracer{}
+	client := startTestGRPCServer(t, mc, WithTracer(tracer))
+	ctx := context.Background()
+
+	_, err := client.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "key1"})
+	require.NoError(t, err)
+	_, err = client.Get(ctx, &proto.GetRequest{Bucket: "bkt1", Key: "missing"})
+	require.Error(t, err)
+	_, err = client.BatchGet(ctx, &proto.BatchGetRequest{Bucket: "bkt1", Keys: []string{"key1"}})
+	require.NoError(t, err)
+
+	spans := tracer.ended()
+	require.Len(t, spans, 3)
+	assert.Equal(t, proto.MinervaCache_Get_FullMethodName, spans[0].name)
+	assert.Equal(t, map[string]any{"bucket": "bkt1", "key": "key1", "hit": true}, spans[0].attributes)
+	assert.Equal(t, map[string]any{"bucket": "bkt1", "key": "missing", "hit": false}, spans[1].attributes)
+	assert.NoError(t, spans[1].err, "expected a miss not to be recorded as an error")
+	assert.Equal(t, proto.MinervaCache_BatchGet_FullMethodName, spans[2].name)
+	assert.Equal(t, map[string]any{"bucket": "bkt1"}, spans[2].attributes)
+}